@@ -4,6 +4,7 @@ const (
 	ChannelNone          = 0
 	ChannelGshellType    = 1
 	ChannelWebsocketType = 2
+	ChannelLongPollType  = 3
 )
 
 type OnReceiveMsg func(Msg string, ChannelType int) string
@@ -20,12 +21,15 @@ type IChannel interface {
 	StartChannel() error
 	//Stop channel
 	StopChannel() error
+	//Get health statistics of channel
+	GetHealth() *ChannelHealth
 }
 
 type Channel struct {
 	CallBack    OnReceiveMsg
 	ChannelType int
 	Working     bool
+	health      ChannelHealth
 }
 
 func (c *Channel) IsWorking() bool {
@@ -36,12 +40,18 @@ func (c *Channel) GetChannelType() int {
 	return c.ChannelType
 }
 
+func (c *Channel) GetHealth() *ChannelHealth {
+	return &c.health
+}
+
 func ChannelTypeStr(channelType int) string {
 	switch (channelType) {
 	case ChannelGshellType:
 		return "gshell"
 	case ChannelWebsocketType:
 		return "websocket"
+	case ChannelLongPollType:
+		return "longpoll"
 	default:
 		return "unknown"
 	}
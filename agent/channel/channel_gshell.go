@@ -15,12 +15,25 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 )
 
+const (
+	// Server pushes messages via gshell only when there is something to do,
+	// thus whether server still reaches the instance via gshell is probed
+	// periodically instead of keepalive, as often as checking whether gshell
+	// channel is available again
+	gshellProbeInterval = channelCheckInterval
+	// gshell channel is stalled if probing never succeeded for the time,
+	// i.e., failed twice in a row
+	gshellStallTimeout = 2*gshellProbeInterval + 10*time.Second
+)
+
 type GshellChannel struct {
 	*Channel
 	hGshell         *os.File
 	StopChanelEvent chan struct{}
 	WaitCheckDone   sync.WaitGroup
 	lock            sync.Mutex
+	// probeStop is closed to stop probing when channel stops
+	probeStop chan struct{}
 }
 
 type gshellStatus struct {
@@ -43,6 +56,11 @@ func (c *GshellChannel) IsSupported() bool {
 		}
 	}
 	c.lock.Unlock()
+	return checkGshellSupport()
+}
+
+// checkGshellSupport asks server whether it reaches the instance via gshell
+func checkGshellSupport() bool {
 	url := util.GetGshellCheckService()
 	resp, err := util.HttpPost(url, "", "text")
 	if err != nil {
@@ -117,11 +135,13 @@ func (c *GshellChannel) startChannelUnsafe() error {
 			case <-tick.C:
 				n, err := c.hGshell.Read(buf)
 				if err == nil && n > 0 {
+					c.GetHealth().RecordMessage()
 					retStr := c.CallBack(string(buf[:n]), ChannelGshellType)
 					if len(retStr) > 0 {
 						log.GetLogger().Infoln("write:", retStr)
 						_, err = c.hGshell.Write([]byte(retStr + "\n"))
 						if err != nil {
+							c.GetHealth().RecordError()
 							log.GetLogger().Errorln("write error:", err)
 							report := clientreport.ClientReport{
 								ReportType: "switch_channel_in_gshell",
@@ -135,13 +155,44 @@ func (c *GshellChannel) startChannelUnsafe() error {
 			}
 		}
 	}()
+	c.probeStop = make(chan struct{})
+	c.WaitCheckDone.Add(1)
+	go func(stop chan struct{}) {
+		defer c.WaitCheckDone.Done()
+		c.probe(stop)
+	}(c.probeStop)
 	c.Working = true
 	return nil
 }
 
+// probe records result of checking gshell support periodically in health of
+// channel, which tells stall of channel
+func (c *GshellChannel) probe(stop chan struct{}) {
+	tick := time.NewTicker(gshellProbeInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			if checkGshellSupport() {
+				// Round-trip time of HTTP is not that of gshell
+				c.GetHealth().RecordPing(0)
+			} else {
+				c.GetHealth().RecordError()
+			}
+		}
+	}
+}
+
 func (c *GshellChannel) StopChannel() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	// Nobody would receive stop event if channel is not working
+	if !c.Working {
+		return nil
+	}
+	close(c.probeStop)
 	c.StopChanelEvent <- struct{}{}
 	c.WaitCheckDone.Wait()
 	c.hGshell.Close()
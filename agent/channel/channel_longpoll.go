@@ -0,0 +1,219 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/clientreport"
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+	"github.com/aliyun/aliyun_assist_client/agent/util/wrapgo"
+)
+
+// LONGPOLL_SERVER holds a poll request until a message for the instance
+// arrives or the wait expires, and accepts replies of messages by POST. Not
+// all regions serve it yet, thus the channel is used only if enabled in
+// agent configuration.
+const LONGPOLL_SERVER = "/luban/notify_poll"
+
+const (
+	// Seconds server holds a poll request when no message available
+	longPollWaitSeconds = 55
+	// Timeout of poll request, which must be longer than the wait
+	longPollTimeoutSeconds = 70
+	// Minimum interval between two polls, avoiding busy polling when server
+	// returns immediately
+	longPollMinInterval = time.Second
+)
+
+// LongPollChannel receives messages by HTTP long-polling, which works
+// through proxies breaking websocket upgrades. Messages and replies are in
+// the same format as websocket channel.
+type LongPollChannel struct {
+	*Channel
+	lock sync.Mutex
+	// stopped is closed when channel stopped, and recreated when started
+	stopped chan struct{}
+}
+
+func longPollURL(waitSeconds int) string {
+	return fmt.Sprintf("https://%s%s?wait=%d", util.GetServerHost(), LONGPOLL_SERVER, waitSeconds)
+}
+
+func (c *LongPollChannel) IsSupported() bool {
+	if !config.GetConfig().Channel.LongPollEnabled {
+		return false
+	}
+	host := util.GetServerHost()
+	if host == "" {
+		metrics.GetChannelFailEvent(
+			metrics.EVENT_SUBCATEGORY_CHANNEL_LONGPOLL,
+			"errormsg", "longpoll channel not supported",
+			"type", ChannelTypeStr(c.ChannelType),
+		).ReportEvent()
+		log.GetLogger().Error("longpoll channel not supported")
+		return false
+	}
+	return true
+}
+
+func (c *LongPollChannel) StartChannel() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.Working {
+		return nil
+	}
+	if util.GetServerHost() == "" {
+		metrics.GetChannelFailEvent(
+			metrics.EVENT_SUBCATEGORY_CHANNEL_LONGPOLL,
+			"errmsg", "No available host",
+			"type", ChannelTypeStr(c.ChannelType),
+		).ReportEvent()
+		return errors.New("No available host")
+	}
+
+	// Poll without waiting to make sure the server is reachable
+	pollStartTime := time.Now()
+	message, err := c.poll(0)
+	if err != nil {
+		c.GetHealth().RecordError()
+		metrics.GetChannelFailEvent(
+			metrics.EVENT_SUBCATEGORY_CHANNEL_LONGPOLL,
+			"errmsg", fmt.Sprintf("poll longpoll channel error:%s", err.Error()),
+			"type", ChannelTypeStr(c.ChannelType),
+		).ReportEvent()
+		log.GetLogger().WithError(err).Errorln("Failed to start longpoll channel")
+		return err
+	}
+	c.GetHealth().RecordPing(time.Since(pollStartTime))
+	log.GetLogger().Infoln("Start longpoll channel ok! url:", longPollURL(longPollWaitSeconds))
+	c.Working = true
+	stopped := make(chan struct{})
+	c.stopped = stopped
+	wrapgo.GoWithDefaultPanicHandler(func() {
+		if message != "" {
+			c.handleMessage(message)
+		}
+		c.pollLoop(stopped)
+	})
+	return nil
+}
+
+func (c *LongPollChannel) poll(waitSeconds int) (string, error) {
+	err, content := util.HttpGetWithTimeout(longPollURL(waitSeconds), longPollTimeoutSeconds, true)
+	return content, err
+}
+
+func (c *LongPollChannel) pollLoop(stopped chan struct{}) {
+	retryCount := 0
+	for {
+		select {
+		case <-stopped:
+			log.GetLogger().Infoln("longpoll channel is closed")
+			return
+		default:
+		}
+
+		pollStartTime := time.Now()
+		message, err := c.poll(longPollWaitSeconds)
+		// Channel may be stopped during polling, message polled is dropped
+		// and would be delivered by other channel
+		select {
+		case <-stopped:
+			log.GetLogger().Infoln("longpoll channel is closed")
+			return
+		default:
+		}
+		if err != nil {
+			c.GetHealth().RecordError()
+			retryCount++
+			if retryCount >= MAX_RETRY_COUNT {
+				c.lock.Lock()
+				if c.stopped == stopped {
+					c.Working = false
+					close(stopped)
+					c.stopped = nil
+				}
+				c.lock.Unlock()
+				log.GetLogger().Errorf("Reach the retry limit for polling messages. Error: %v", err.Error())
+				report := clientreport.ClientReport{
+					ReportType: "switch_channel_in_longpoll",
+					Info:       fmt.Sprintf("start:" + err.Error()),
+				}
+				clientreport.SendReport(report)
+				go c.SwitchChannel()
+				return
+			}
+			log.GetLogger().Errorf("An error happened when polling the message. Retried times: %d, Error: %s", retryCount, err.Error())
+			c.sleep(stopped, time.Duration(retryCount)*time.Second)
+			continue
+		}
+		retryCount = 0
+		// Server holds the request, thus round-trip time is not measurable
+		c.GetHealth().RecordPing(0)
+		if message != "" {
+			c.handleMessage(message)
+		} else if elapsed := time.Since(pollStartTime); elapsed < longPollMinInterval {
+			c.sleep(stopped, longPollMinInterval-elapsed)
+		}
+	}
+}
+
+func (c *LongPollChannel) sleep(stopped chan struct{}, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+	}
+}
+
+func (c *LongPollChannel) handleMessage(message string) {
+	log.GetLogger().Infof("longpoll recv: %s", message)
+	c.GetHealth().RecordMessage()
+	content := c.CallBack(message, ChannelLongPollType)
+	if content == "" {
+		return
+	}
+	url := fmt.Sprintf("https://%s%s", util.GetServerHost(), LONGPOLL_SERVER)
+	if _, err := util.HttpPost(url, content, "text"); err != nil {
+		c.GetHealth().RecordError()
+		metrics.GetChannelFailEvent(
+			metrics.EVENT_SUBCATEGORY_CHANNEL_LONGPOLL,
+			"errormsg", fmt.Sprintf("longpoll replying err:%s, content=%s", err.Error(), content),
+			"type", ChannelTypeStr(c.ChannelType),
+		).ReportEvent()
+	}
+}
+
+func (c *LongPollChannel) SwitchChannel() error {
+	return switchToAvailableChannel("switch_channel_in_longpoll")
+}
+
+func (c *LongPollChannel) StopChannel() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.Working == true {
+		c.Working = false
+		log.GetLogger().Println("close longpoll channel")
+		if c.stopped != nil {
+			close(c.stopped)
+			c.stopped = nil
+		}
+	}
+	return nil
+}
+
+func NewLongPollChannel(CallBack OnReceiveMsg) IChannel {
+	return &LongPollChannel{
+		Channel: &Channel{
+			CallBack:    CallBack,
+			ChannelType: ChannelLongPollType,
+			Working:     false,
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func TestLongPollChannel(t *testing.T) {
+	httpmock.Activate()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+	const mockRegion = "cn-test100"
+	util.MockMetaServer(mockRegion)
+
+	httpmock.RegisterResponder("POST",
+		fmt.Sprintf("https://%s.axt.aliyun.com/luban/api/metrics", mockRegion),
+		func(h *http.Request) (*http.Response, error) {
+			return httpmock.NewStringResponse(200, "success"), nil
+		})
+	var lock sync.Mutex
+	polls := 0
+	var replies []string
+	httpmock.RegisterResponder("GET",
+		fmt.Sprintf("https://%s.axt.aliyun.com%s", mockRegion, LONGPOLL_SERVER),
+		func(h *http.Request) (*http.Response, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			polls++
+			if polls == 2 {
+				return httpmock.NewStringResponse(200, "kick_vm"), nil
+			}
+			return httpmock.NewStringResponse(204, ""), nil
+		})
+	httpmock.RegisterResponder("POST",
+		fmt.Sprintf("https://%s.axt.aliyun.com%s", mockRegion, LONGPOLL_SERVER),
+		func(h *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(h.Body)
+			lock.Lock()
+			defer lock.Unlock()
+			replies = append(replies, string(body))
+			return httpmock.NewStringResponse(200, "success"), nil
+		})
+
+	var received []string
+	channel := NewLongPollChannel(func(msg string, channelType int) string {
+		assert.Equal(t, ChannelLongPollType, channelType)
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, msg)
+		return "accept:" + msg
+	})
+	// Long-poll endpoint is used only if enabled
+	assert.False(t, channel.IsSupported())
+	config.GetConfig().Channel.LongPollEnabled = true
+	defer func() { config.GetConfig().Channel.LongPollEnabled = false }()
+	assert.True(t, channel.IsSupported())
+	assert.NoError(t, channel.StartChannel())
+	assert.True(t, channel.IsWorking())
+	time.Sleep(time.Duration(500) * time.Millisecond)
+	assert.NoError(t, channel.StopChannel())
+	assert.False(t, channel.IsWorking())
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"kick_vm"}, received)
+	assert.Equal(t, []string{"accept:kick_vm"}, replies)
+	assert.Equal(t, "longpoll", ChannelTypeStr(ChannelLongPollType))
+}
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 
 	"github.com/aliyun/aliyun_assist_client/agent/clientreport"
@@ -15,6 +15,7 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/kickvmhandle"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
 	"github.com/aliyun/aliyun_assist_client/agent/update"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
@@ -23,6 +24,29 @@ import (
 
 var _gshellChannel IChannel = nil
 
+const (
+	// Interval of checking whether gshell channel available again
+	channelCheckInterval = 1800 * time.Second
+	// Interval of checking health of active channel
+	healthCheckInterval = 5 * time.Second
+	// Active channel scoring below it is unhealthy, and failover happens after
+	// it is unhealthy in consecutive checks
+	failoverScore  = 40
+	failoverChecks = 2
+	// Working channel takes over only when scoring higher than active one by
+	// the margin
+	switchScoreMargin = 20
+	// Channel switched to is kept at least for the time, and failover would
+	// not be retried more frequently, avoiding flapping between channels.
+	//
+	// Thus failover starts within failoverChecks*healthCheckInterval, i.e.,
+	// 10 seconds, after active channel becomes unhealthy, e.g., stalled for
+	// stallTimeoutOf it. Only when the channel was switched to within
+	// minDwellTime, failover is deferred until the dwell time elapsed, which
+	// is at most 40 seconds after the previous switch.
+	minDwellTime = 30 * time.Second
+)
+
 //manage all channels
 type ChannelMgr struct {
 	ActiveChannel   IChannel   //current used channel
@@ -30,6 +54,17 @@ type ChannelMgr struct {
 	StopChanelEvent chan struct{}
 	WaitCheckDone   sync.WaitGroup
 	ChannelSetLock  sync.Mutex
+	stopOnce        sync.Once
+
+	// Following fields are protected by ChannelSetLock
+	lastSwitchTime  time.Time
+	unhealthyChecks int
+	// starting is the channel being started by failover without the lock
+	// held, which others must not start meanwhile
+	starting IChannel
+	// stopping is the channel being stopped by failover without the lock
+	// held, which others must not switch to meanwhile
+	stopping IChannel
 }
 
 //new
@@ -43,53 +78,131 @@ func (m *ChannelMgr) checkChannelWorker() bool {
 	m.ChannelSetLock.Lock()
 	defer m.ChannelSetLock.Unlock()
 	if m.AllChannel[0].IsSupported() && m.AllChannel[0].IsWorking() {
-		if m.AllChannel[1].IsWorking() {
-			m.AllChannel[1].StopChannel()
+		for _, item := range m.AllChannel[1:] {
+			if item.IsWorking() {
+				item.StopChannel()
+			}
 		}
-		m.ActiveChannel = m.AllChannel[0]
+		m.setActiveChannel(m.AllChannel[0])
 		return true
 	}
 	return false
 }
 
+// setActiveChannel switches active channel and counts the switch, which must
+// be called with ChannelSetLock held
+func (m *ChannelMgr) setActiveChannel(item IChannel) {
+	if m.ActiveChannel != nil && m.ActiveChannel.GetChannelType() != item.GetChannelType() {
+		openmetrics.ChannelSwitches.Inc(ChannelTypeStr(item.GetChannelType()))
+	}
+	if m.ActiveChannel == nil || m.ActiveChannel.GetChannelType() != item.GetChannelType() {
+		item.GetHealth().markActivated()
+		m.lastSwitchTime = time.Now()
+		m.unhealthyChecks = 0
+	}
+	m.ActiveChannel = item
+}
+
+// stallTimeoutOf returns how long a working channel may show no activity
+// before considered stalled, or 0 if the channel has no keepalive mechanism
+func stallTimeoutOf(channelType int) time.Duration {
+	switch channelType {
+	case ChannelWebsocketType:
+		return wsStallTimeout
+	case ChannelLongPollType:
+		return time.Duration(longPollTimeoutSeconds)*time.Second + longPollMinInterval
+	case ChannelGshellType:
+		return gshellStallTimeout
+	default:
+		return 0
+	}
+}
+
+func healthOf(item IChannel, now time.Time) HealthSnapshot {
+	return item.GetHealth().Snapshot(item.IsWorking(), stallTimeoutOf(item.GetChannelType()), now)
+}
+
 func (m *ChannelMgr) SelectAvailableChannel(currentChannel int) error {
 	log.GetLogger().Infoln("SelectAvailableChannel")
 	m.ChannelSetLock.Lock()
 	defer m.ChannelSetLock.Unlock()
+	return m.selectAvailableChannelUnsafe(currentChannel, failoverScore, true)
+}
 
+// selectAvailableChannelUnsafe switches to the healthiest working channel
+// scoring not less than minScore, or starts a supported channel in order of
+// preference. When no channel could be started, the healthiest working one is
+// used regardless of its score if fallbackToWorking is true. It MUST be called
+// with ChannelSetLock held.
+func (m *ChannelMgr) selectAvailableChannelUnsafe(currentChannel int, minScore int, fallbackToWorking bool) error {
+	healthiest, healthiestScore := m.healthiestWorkingUnsafe(currentChannel)
+	if healthiest != nil && healthiestScore >= minScore && healthiest.IsSupported() {
+		m.setActiveChannel(healthiest)
+		return nil
+	}
+	for _, item := range m.stoppedChannelsUnsafe(currentChannel) {
+		if item.IsSupported() {
+			if e := item.StartChannel(); e == nil {
+				m.setActiveChannel(item)
+				return nil
+			}
+		}
+	}
+	if fallbackToWorking && healthiest != nil && healthiest.IsSupported() {
+		m.setActiveChannel(healthiest)
+		return nil
+	}
+	return errors.New("No available channel")
+}
+
+// healthiestWorkingUnsafe returns the healthiest working channel other than
+// currentChannel and its score. It MUST be called with ChannelSetLock held.
+func (m *ChannelMgr) healthiestWorkingUnsafe(currentChannel int) (IChannel, int) {
+	now := time.Now()
+	var healthiest IChannel
+	healthiestScore := -1
 	for _, item := range m.AllChannel {
-		if currentChannel == item.GetChannelType() {
+		if currentChannel == item.GetChannelType() || !item.IsWorking() || item == m.stopping {
 			continue
 		}
-		if item.IsSupported() && item.IsWorking() {
-			return nil
+		if score := healthOf(item, now).Score; score > healthiestScore {
+			healthiest = item
+			healthiestScore = score
 		}
 	}
+	return healthiest, healthiestScore
+}
+
+// stoppedChannelsUnsafe returns channels other than currentChannel which are
+// not working and not being started, in order of preference. It MUST be
+// called with ChannelSetLock held.
+func (m *ChannelMgr) stoppedChannelsUnsafe(currentChannel int) []IChannel {
+	var stopped []IChannel
 	for _, item := range m.AllChannel {
-		if currentChannel == item.GetChannelType() {
+		if currentChannel == item.GetChannelType() || item.IsWorking() || item == m.starting || item == m.stopping {
 			continue
 		}
-		if item.IsSupported() {
-			if e := item.StartChannel(); e == nil {
-				m.ActiveChannel = item
-				return nil
-			}
-		}
+		stopped = append(stopped, item)
 	}
-	return errors.New("No available channel")
+	return stopped
 }
 
 func (m *ChannelMgr) Init(CallBack OnReceiveMsg) error {
 	m.WaitCheckDone.Add(1)
 	go func() {
 		defer m.WaitCheckDone.Done()
-		tick := time.NewTicker(time.Duration(1800) * time.Second)
+		tick := time.NewTicker(channelCheckInterval)
 		defer tick.Stop()
+		healthTick := time.NewTicker(healthCheckInterval)
+		defer healthTick.Stop()
 		for {
 			select {
 			case <-m.StopChanelEvent:
 				return
+			case <-healthTick.C:
+				m.checkActiveChannelHealth()
 			case <-tick.C:
+				go m.reportHealth("timer")
 				if m.checkChannelWorker() == false {
 					err := m.SelectAvailableChannel(ChannelNone)
 					var report clientreport.ClientReport
@@ -116,11 +229,11 @@ func (m *ChannelMgr) Init(CallBack OnReceiveMsg) error {
 	}()
 	m.ChannelSetLock.Lock()
 	defer m.ChannelSetLock.Unlock()
-	m.AllChannel = append(m.AllChannel, _gshellChannel, NewWebsocketChannel(CallBack))
+	m.AllChannel = append(m.AllChannel, _gshellChannel, NewWebsocketChannel(CallBack), NewLongPollChannel(CallBack))
 	for _, item := range m.AllChannel {
 		if item.IsSupported() {
 			if e := item.StartChannel(); e == nil {
-				m.ActiveChannel = item
+				m.setActiveChannel(item)
 				return nil
 			} else {
 				fmt.Println(e.Error())
@@ -130,17 +243,201 @@ func (m *ChannelMgr) Init(CallBack OnReceiveMsg) error {
 	return errors.New("No available channel")
 }
 
+// checkActiveChannelHealth fails over to another channel when active channel
+// stays unhealthy. Checking, starting and stopping channels may take long,
+// e.g., checking gshell channel or the first poll of long-poll channel is a
+// request to server, thus they are done without ChannelSetLock held and only
+// active channel is swapped with the lock.
+func (m *ChannelMgr) checkActiveChannelHealth() {
+	m.ChannelSetLock.Lock()
+	active := m.ActiveChannel
+	if active == nil {
+		m.ChannelSetLock.Unlock()
+		return
+	}
+	snapshot := healthOf(active, time.Now())
+	if snapshot.Score >= failoverScore {
+		m.unhealthyChecks = 0
+		m.ChannelSetLock.Unlock()
+		return
+	}
+	m.unhealthyChecks++
+	if m.unhealthyChecks < failoverChecks || time.Since(m.lastSwitchTime) < minDwellTime {
+		m.ChannelSetLock.Unlock()
+		return
+	}
+	m.unhealthyChecks = 0
+	// Failed failover would not be retried before minDwellTime elapsed too
+	m.lastSwitchTime = time.Now()
+	activeType := active.GetChannelType()
+	log.GetLogger().WithFields(logrus.Fields{
+		"channel":      ChannelTypeStr(activeType),
+		"score":        snapshot.Score,
+		"pingRTT":      snapshot.PingRTT.String(),
+		"lastActivity": snapshot.LastActivity.Format(time.RFC3339),
+		"recentErrors": snapshot.RecentErrors,
+	}).Warningln("Active channel is unhealthy, try to fail over")
+
+	minScore := snapshot.Score + switchScoreMargin
+	if minScore < failoverScore {
+		minScore = failoverScore
+	}
+	healthiest, score := m.healthiestWorkingUnsafe(activeType)
+	candidates := m.stoppedChannelsUnsafe(activeType)
+	m.ChannelSetLock.Unlock()
+
+	var switchTo, started IChannel
+	if healthiest != nil && score >= minScore && healthiest.IsSupported() {
+		switchTo = healthiest
+	} else {
+		started = m.startAnyChannel(candidates)
+		switchTo = started
+	}
+
+	var err error
+	var toStop IChannel
+	m.ChannelSetLock.Lock()
+	switch {
+	case switchTo == nil:
+		err = errors.New("No available channel")
+	case m.ActiveChannel != active:
+		// Active channel has been switched by others meanwhile
+		if started != nil && m.ActiveChannel != started {
+			toStop = started
+		}
+		err = errors.New("Active channel switched during failover")
+	case !switchTo.IsWorking():
+		err = errors.New("Channel stopped during failover")
+	default:
+		m.setActiveChannel(switchTo)
+		// Stalled channel may still deliver messages, which are duplicated
+		toStop = active
+	}
+	m.stopping = toStop
+	m.ChannelSetLock.Unlock()
+	if toStop != nil {
+		toStop.StopChannel()
+		m.ChannelSetLock.Lock()
+		m.stopping = nil
+		m.ChannelSetLock.Unlock()
+	}
+
+	report := clientreport.ClientReport{
+		ReportType: "switch_channel_in_health_check",
+	}
+	if err == nil {
+		report.Info = fmt.Sprintf("success: Current channel is %d", m.GetCurrentChannelType())
+	} else {
+		report.Info = fmt.Sprintf("fail:" + err.Error())
+	}
+	metrics.GetChannelSwitchEvent(
+		"type", ChannelTypeStr(m.GetCurrentChannelType()),
+		"reportType", report.ReportType,
+		"info", report.Info,
+	).ReportEvent()
+	// Sent synchronously without the lock held, thus reports of successive
+	// failovers never overlap
+	clientreport.SendReport(report)
+	m.reportHealth("failover")
+}
+
+// startAnyChannel starts the first supported one of channels, which is
+// marked as starting so that others would not start it meanwhile. It MUST be
+// called without ChannelSetLock held.
+func (m *ChannelMgr) startAnyChannel(channels []IChannel) IChannel {
+	for _, item := range channels {
+		m.ChannelSetLock.Lock()
+		if item.IsWorking() || m.starting != nil {
+			m.ChannelSetLock.Unlock()
+			continue
+		}
+		m.starting = item
+		m.ChannelSetLock.Unlock()
+
+		err := errors.New("channel not supported")
+		if item.IsSupported() {
+			err = item.StartChannel()
+		}
+
+		m.ChannelSetLock.Lock()
+		m.starting = nil
+		m.ChannelSetLock.Unlock()
+		if err == nil {
+			return item
+		}
+	}
+	return nil
+}
+
+// HealthScores returns health of all channels
+func (m *ChannelMgr) HealthScores() []clientreport.ChannelHealthScore {
+	m.ChannelSetLock.Lock()
+	defer m.ChannelSetLock.Unlock()
+	return m.healthScoresUnsafe()
+}
+
+func (m *ChannelMgr) healthScoresUnsafe() []clientreport.ChannelHealthScore {
+	now := time.Now()
+	scores := make([]clientreport.ChannelHealthScore, 0, len(m.AllChannel))
+	for _, item := range m.AllChannel {
+		if item == nil {
+			continue
+		}
+		snapshot := healthOf(item, now)
+		score := clientreport.ChannelHealthScore{
+			Channel:       ChannelTypeStr(item.GetChannelType()),
+			Working:       item.IsWorking(),
+			Score:         snapshot.Score,
+			PingRTTMillis: snapshot.PingRTT.Milliseconds(),
+			IdleSeconds:   -1,
+			RecentErrors:  snapshot.RecentErrors,
+		}
+		if !snapshot.LastActivity.IsZero() {
+			score.IdleSeconds = int64(now.Sub(snapshot.LastActivity).Seconds())
+		}
+		scores = append(scores, score)
+	}
+	return scores
+}
+
+func (m *ChannelMgr) reportHealth(reason string) {
+	m.ChannelSetLock.Lock()
+	report := clientreport.ChannelHealthReport{
+		Reason:        reason,
+		ActiveChannel: ChannelTypeStr(ChannelNone),
+		Channels:      m.healthScoresUnsafe(),
+	}
+	if m.ActiveChannel != nil {
+		report.ActiveChannel = ChannelTypeStr(m.ActiveChannel.GetChannelType())
+	}
+	m.ChannelSetLock.Unlock()
+	if _, err := clientreport.ReportChannelHealth(report); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to report health of channels")
+	}
+}
+
+// Uninit stops checking worker and closes all channels, which is safe to be
+// called even if Init has not been called or failed
 func (m *ChannelMgr) Uninit() {
-	m.StopChanelEvent <- struct{}{}
+	m.stopOnce.Do(func() {
+		close(m.StopChanelEvent)
+	})
 	m.WaitCheckDone.Wait()
 	m.ChannelSetLock.Lock()
 	defer m.ChannelSetLock.Unlock()
-	m.ActiveChannel.StopChannel()
+	for _, item := range m.AllChannel {
+		if item != nil {
+			item.StopChannel()
+		}
+	}
 }
 
 func (m *ChannelMgr) GetCurrentChannelType() int {
 	m.ChannelSetLock.Lock()
 	defer m.ChannelSetLock.Unlock()
+	if m.ActiveChannel == nil {
+		return ChannelNone
+	}
 	return m.ActiveChannel.GetChannelType()
 }
 
@@ -157,6 +454,10 @@ func GetCurrentChannelType() int {
 	return G_ChannelMgr.GetCurrentChannelType()
 }
 
+func GetHealthScores() []clientreport.ChannelHealthScore {
+	return G_ChannelMgr.HealthScores()
+}
+
 func TryStartGshellChannel() {
 	_gshellChannel = NewGshellChannel(OnRecvMsg)
 	if util.IsHybrid() == false {
@@ -197,10 +498,18 @@ type GshellCheckReply struct {
 	Return int64 `json:"return"`
 }
 
+// Authentication fields of kick_vm command, see kickvmhandle.SignedKick
+type GshellKickAuth struct {
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
 type GshellCmd struct {
 	Execute   string `json:"execute"`
 	Arguments struct {
 		Cmd string `json:"cmd"`
+		GshellKickAuth
 	} `json:"arguments"`
 }
 
@@ -210,6 +519,8 @@ type GshellCmdReply struct {
 		// netcheck field would not presented when no diagnostic result available
 		Netcheck *NetcheckReply `json:"netcheck,omitempty"`
 		Result    int    `json:"result"`
+		// ActionId identifies result of kick_vm action reported later
+		ActionId string `json:"action_id,omitempty"`
 	} `json:"return"`
 }
 
@@ -217,9 +528,19 @@ type GshellShutdown struct {
 	Execute   string `json:"execute"`
 	Arguments struct {
 		Mode string `json:"mode"`
+		GshellKickAuth
 	} `json:"arguments"`
 }
 
+func (a *GshellKickAuth) signedKick(command string) kickvmhandle.SignedKick {
+	return kickvmhandle.SignedKick{
+		Command:   command,
+		Timestamp: a.Timestamp,
+		Nonce:     a.Nonce,
+		Signature: a.Signature,
+	}
+}
+
 func BuildInvalidRet(desc string) string {
 	InvalidRet := GshellInvalid{}
 	InvalidRet.Error.Class = "GenericError"
@@ -231,31 +552,45 @@ func BuildInvalidRet(desc string) string {
 func OnRecvMsg(Msg string, ChannelType int) string {
 	log.GetLogger().Infoln("kick msg:", Msg)
 
-	// legacy code for websocket kick data proc.
-	if ChannelType == ChannelWebsocketType {
+	// legacy code for websocket kick data proc, which is also used by longpoll
+	// channel
+	if ChannelType == ChannelWebsocketType || ChannelType == ChannelLongPollType {
 		if update.IsCriticalActionRunning() {
 			return "reject:" + Msg
 		}
-		if Msg == "kick_vm" {
+		kick := kickvmhandle.ParseSignedKick(Msg)
+		// Command is parsed once, thus what is authorized is exactly what is
+		// executed
+		handle := kickvmhandle.ParseOption(kick.Command)
+		if err := kickvmhandle.AuthorizeKick(kick, kickvmhandle.RequiresSignature(handle), ChannelTypeStr(ChannelType)); err != nil {
+			return "reject:" + Msg
+		}
+		if kick.Command == "kick_vm" {
 			go func() {
 				taskengine.Fetch(true, "", taskengine.NormalTaskType, false)			}()
 			return "accept:" + Msg
-		} else if strings.Contains(Msg, "kick_vm agent deregister") {
+		} else if agentHandle, ok := handle.(*kickvmhandle.AgentHandle); ok && agentHandle.IsDeregister() {
 			hybrid.UnRegister(true)
 		}
 
-		handle := kickvmhandle.ParseOption(Msg)
 		valid_cmd := false
+		actionId := ""
+		// Server not sending action id would not expect it in reply either
+		replyActionId := kick.Nonce != ""
 		if handle != nil {
 			if handle.CheckAction() == true {
 				valid_cmd = true
-				go func() {
-					handle.DoAction()
-				}()
+				// Result of action is reported to server when finished
+				actionId = kick.ActionId()
+				go kickvmhandle.RunAction(handle, kick)
 			}
 		}
 		if valid_cmd == false {
 			return "unknow:" + Msg
+		} else if replyActionId {
+			// Action id follows the echoed message in a new line, which never
+			// appears in kick message
+			return "accept:" + Msg + "\naction_id:" + actionId
 		} else {
 			return "accept:" + Msg
 		}
@@ -290,6 +625,15 @@ func OnRecvMsg(Msg string, ChannelType int) string {
 			retStr, _ := json.Marshal(gshellCmdReply)
 			return string(retStr)
 		}
+		kick := gshellCmd.Arguments.signedKick(gshellCmd.Arguments.Cmd)
+		handle := kickvmhandle.ParseOption(kick.Command)
+		if err := kickvmhandle.AuthorizeKick(kick, kickvmhandle.RequiresSignature(handle), ChannelTypeStr(ChannelType)); err != nil {
+			gshellCmdReply := GshellCmdReply{}
+			gshellCmdReply.Return.Result = 6
+			gshellCmdReply.Return.CmdOutput = "unauthorized command: " + err.Error()
+			retStr, _ := json.Marshal(gshellCmdReply)
+			return string(retStr)
+		}
 		if gshellCmd.Arguments.Cmd == "kick_vm" {
 			go func() {
 				taskengine.Fetch(true, "", taskengine.NormalTaskType, false)			}()
@@ -300,14 +644,13 @@ func OnRecvMsg(Msg string, ChannelType int) string {
 			retStr, _ := json.Marshal(gshellCmdReply)
 			return string(retStr)
 		} else {
-			handle := kickvmhandle.ParseOption(gshellCmd.Arguments.Cmd)
 			valid_cmd := false
+			actionId := ""
 			if handle != nil {
 				if handle.CheckAction() == true {
 					valid_cmd = true
-					go func() {
-						handle.DoAction()
-					}()
+					actionId = kick.ActionId()
+					go kickvmhandle.RunAction(handle, kick)
 				}
 			}
 			if valid_cmd == false {
@@ -321,6 +664,7 @@ func OnRecvMsg(Msg string, ChannelType int) string {
 				gshellCmdReply.Return.Result = 8
 				gshellCmdReply.Return.CmdOutput = "execute kick_vm success"
 				gshellCmdReply.Return.Netcheck = LastNetcheckReply()
+				gshellCmdReply.Return.ActionId = actionId
 				retStr, _ := json.Marshal(gshellCmdReply)
 				return string(retStr)
 			}
@@ -331,6 +675,10 @@ func OnRecvMsg(Msg string, ChannelType int) string {
 		if err != nil {
 			return BuildInvalidRet("invalid guest-shutdown command: " + err.Error())
 		}
+		kick := gshellShutdown.Arguments.signedKick("guest-shutdown " + gshellShutdown.Arguments.Mode)
+		if err := kickvmhandle.AuthorizeKick(kick, true, ChannelTypeStr(ChannelType)); err != nil {
+			return BuildInvalidRet("unauthorized guest-shutdown command: " + err.Error())
+		}
 		gshellCmdReply := GshellCmdReply{}
 		gshellCmdReply.Return.Result = 8
 		gshellCmdReply.Return.CmdOutput = "execute command success"
@@ -351,3 +699,38 @@ func OnRecvMsg(Msg string, ChannelType int) string {
 	}
 	return BuildInvalidRet("invalid command")
 }
+
+// switchToAvailableChannel selects another channel after active one broken,
+// which is retried for a while. Result is reported with reportType.
+func switchToAvailableChannel(reportType string) error {
+	time.Sleep(time.Duration(1) * time.Second)
+	for i := 0; i < 5; i++ {
+		if G_ChannelMgr.SelectAvailableChannel(ChannelNone) == nil {
+			metrics.GetChannelSwitchEvent(
+				"type", ChannelTypeStr(G_ChannelMgr.GetCurrentChannelType()),
+				"reportType", reportType,
+				"info", fmt.Sprintf("success: Current channel is %d", G_ChannelMgr.GetCurrentChannelType()),
+			).ReportEvent()
+
+			report := clientreport.ClientReport{
+				ReportType: reportType,
+				Info:       fmt.Sprintf("success: Current channel is %d", G_ChannelMgr.GetCurrentChannelType()),
+			}
+			clientreport.SendReport(report)
+			return nil
+		}
+		time.Sleep(time.Duration(5) * time.Second)
+	}
+	metrics.GetChannelSwitchEvent(
+		"type", ChannelTypeStr(G_ChannelMgr.GetCurrentChannelType()),
+		"reportType", reportType,
+		"info", fmt.Sprintf("fail: no available channel"),
+	).ReportEvent()
+
+	report := clientreport.ClientReport{
+		ReportType: reportType,
+		Info:       fmt.Sprintf("fail: no available channel"),
+	}
+	clientreport.SendReport(report)
+	return errors.New("no available channel")
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"bou.ke/monkey"
+	"github.com/stretchr/testify/assert"
 	"github.com/aliyun/aliyun_assist_client/agent/kickvmhandle"
 	"github.com/aliyun/aliyun_assist_client/agent/update"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
@@ -141,3 +142,21 @@ func TestOnRecvMsg(t *testing.T) {
 		})
 	}
 }
+
+func TestOnRecvMsgActionId(t *testing.T) {
+	guard := monkey.Patch(update.IsCriticalActionRunning, func() bool { return false })
+	defer guard.Unpatch()
+	guard = monkey.Patch(kickvmhandle.AuthorizeKick, func(kickvmhandle.SignedKick, bool, string) error { return nil })
+	defer guard.Unpatch()
+	guard = monkey.Patch(kickvmhandle.RunAction, func(kickvmhandle.KickHandle, kickvmhandle.SignedKick) *kickvmhandle.ActionResult { return nil })
+	defer guard.Unpatch()
+	var a *kickvmhandle.AgentHandle
+	guard = monkey.PatchInstanceMethod(reflect.TypeOf(a), "CheckAction", func(*kickvmhandle.AgentHandle) bool { return true })
+	defer guard.Unpatch()
+
+	// Action id is only replied to server sending it
+	msg := "kick_vm agent stop"
+	assert.Equal(t, "accept:"+msg, OnRecvMsg(msg, ChannelWebsocketType))
+	msg = "kick_vm agent stop|1600000000|action-1|c2ln"
+	assert.Equal(t, "accept:"+msg+"\naction_id:action-1", OnRecvMsg(msg, ChannelWebsocketType))
+}
@@ -4,31 +4,39 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"runtime/debug"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"github.com/aliyun/aliyun_assist_client/agent/clientreport"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/metrics"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
-	"github.com/aliyun/aliyun_assist_client/agent/util/timetool"
 )
 
 const WEBSOCKET_SERVER = "/luban/notify_server"
 const MAX_RETRY_COUNT = 5
 
+const (
+	// Interval of websocket pings, whose pongs are expected within timeout
+	wsPingInterval = 60 * time.Second
+	wsPongTimeout  = 10 * time.Second
+	// Websocket channel is stalled if pongs of two pings in a row are missing,
+	// thus one slow pong does not cause failover
+	wsStallTimeout = 2*wsPingInterval + wsPongTimeout
+)
+
 type WebSocketChannel struct {
 	*Channel
 	wskConn   *websocket.Conn
 	lock      sync.Mutex
 	writeLock sync.Mutex
+	// Time when the latest ping sent, which is accessed atomically
+	pingSentAt int64
 }
 
 func (c *WebSocketChannel) IsSupported() bool {
@@ -48,6 +56,9 @@ func (c *WebSocketChannel) IsSupported() bool {
 func (c *WebSocketChannel) StartChannel() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	if c.Working {
+		return nil
+	}
 	errmsg := ""
 	defer func() {
 		if len(errmsg) > 0 {
@@ -72,34 +83,14 @@ func (c *WebSocketChannel) StartChannel() error {
 	}
 
 	if util.IsHybrid() {
-		u4 := uuid.New()
-		str_request_id := u4.String()
-
-		timestamp := timetool.GetAccurateTime()
-		str_timestamp := strconv.FormatInt(timestamp, 10)
-
-		var instance_id string
-		path := ""
-		if util.IsSelfHosted() {
-			path, _ = util.GetSelfhostedPath()
-		} else {
-			path, _ = util.GetHybridPath()
+		authHeaders, err := util.HybridAuthHeaders()
+		if err != nil {
+			errmsg = err.Error()
+			return err
+		}
+		for key, value := range authHeaders {
+			header.Add(key, value)
 		}
-
-		content, _ := ioutil.ReadFile(path + "/instance-id")
-		instance_id = string(content)
-
-		mid, _ := util.GetMachineID()
-
-		input := instance_id + mid + str_timestamp + str_request_id
-		pri_key, _ := ioutil.ReadFile(path + "/pri-key")
-		output := util.RsaSign(input, string(pri_key))
-		log.GetLogger().Infoln(input, output)
-
-		header.Add("x-acs-instance-id", instance_id)
-		header.Add("x-acs-timestamp", str_timestamp)
-		header.Add("x-acs-request-id", str_request_id)
-		header.Add("x-acs-signature", output)
 	}
 
 	var MyDialer = &websocket.Dialer{
@@ -117,9 +108,16 @@ func (c *WebSocketChannel) StartChannel() error {
 		return err
 	}
 	c.wskConn = conn
+	c.wskConn.SetPongHandler(func(string) error {
+		if sentAt := atomic.LoadInt64(&c.pingSentAt); sentAt > 0 {
+			c.GetHealth().RecordPing(time.Since(time.Unix(0, sentAt)))
+		}
+		return nil
+	})
 	log.GetLogger().Infoln("Start websocket channel ok! url:", url)
+	c.GetHealth().RecordPing(0)
 	c.Working = true
-	c.StartPings(time.Second * 60)
+	c.StartPings(wsPingInterval)
 	go func() {
 		defer func() {
 			if msg := recover(); msg != nil {
@@ -135,6 +133,7 @@ func (c *WebSocketChannel) StartChannel() error {
 			}
 			messageType, message, err := c.wskConn.ReadMessage()
 			if err != nil {
+				c.GetHealth().RecordError()
 				time.Sleep(time.Duration(1) * time.Second)
 				retryCount++
 				if retryCount >= MAX_RETRY_COUNT {
@@ -161,6 +160,7 @@ func (c *WebSocketChannel) StartChannel() error {
 
 			} else {
 				log.GetLogger().Infof("wsk recv: %s", string(message))
+				c.GetHealth().RecordMessage()
 
 				content := c.CallBack(string(message), ChannelWebsocketType)
 				if content != "" {
@@ -168,6 +168,7 @@ func (c *WebSocketChannel) StartChannel() error {
 					err := c.wskConn.WriteMessage(websocket.TextMessage, []byte(content))
 					c.writeLock.Unlock()
 					if err != nil {
+						c.GetHealth().RecordError()
 						metrics.GetChannelFailEvent(
 							metrics.EVENT_SUBCATEGORY_CHANNEL_WS,
 							"errormsg", fmt.Sprintf("websocket writing err:%s, content=%s", err.Error(), content),
@@ -184,36 +185,7 @@ func (c *WebSocketChannel) StartChannel() error {
 }
 
 func (c *WebSocketChannel) SwitchChannel() error {
-	time.Sleep(time.Duration(1) * time.Second)
-	for i := 0; i < 5; i++ {
-		if G_ChannelMgr.SelectAvailableChannel(ChannelNone) == nil {
-			metrics.GetChannelSwitchEvent(
-				"type", ChannelTypeStr(G_ChannelMgr.GetCurrentChannelType()),
-				"reportType", "switch_channel_in_wsk",
-				"info", fmt.Sprintf("success: Current channel is %d", G_ChannelMgr.GetCurrentChannelType()),
-			).ReportEvent()
-
-			report := clientreport.ClientReport{
-				ReportType: "switch_channel_in_wsk",
-				Info:       fmt.Sprintf("success: Current channel is %d", G_ChannelMgr.GetCurrentChannelType()),
-			}
-			clientreport.SendReport(report)
-			return nil
-		}
-		time.Sleep(time.Duration(5) * time.Second)
-	}
-	metrics.GetChannelSwitchEvent(
-		"type", ChannelTypeStr(G_ChannelMgr.GetCurrentChannelType()),
-		"reportType", "switch_channel_in_wsk",
-		"info", fmt.Sprintf("fail: no available channel"),
-	).ReportEvent()
-
-	report := clientreport.ClientReport{
-		ReportType: "switch_channel_in_wsk",
-		Info:       fmt.Sprintf("fail: no available channel"),
-	}
-	clientreport.SendReport(report)
-	return errors.New("no available channel")
+	return switchToAvailableChannel("switch_channel_in_wsk")
 }
 
 func (c *WebSocketChannel) StopChannel() error {
@@ -244,9 +216,11 @@ func (c *WebSocketChannel) StartPings(pingInterval time.Duration) {
 			}
 			log.GetLogger().Infoln("WebsocketChannel: ping...")
 			c.writeLock.Lock()
+			atomic.StoreInt64(&c.pingSentAt, time.Now().UnixNano())
 			err := c.wskConn.WriteMessage(websocket.PingMessage, []byte("keepalive"))
 			c.writeLock.Unlock()
 			if err != nil {
+				c.GetHealth().RecordError()
 				metrics.GetChannelFailEvent(
 					metrics.EVENT_SUBCATEGORY_CHANNEL_WS,
 					"errormsg", fmt.Sprintf("Error while sending websocket ping: %s", err.Error()),
@@ -0,0 +1,122 @@
+package channel
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	MaxHealthScore = 100
+
+	// Score penalty for each second of ping round-trip time, and the upper
+	// bound of it
+	rttPenaltyPerSecond = 20
+	maxRTTPenalty       = 40
+	// Score penalty for each error within the window, and the upper bound
+	errorPenalty    = 25
+	maxErrorPenalty = 75
+	errorWindow     = 2 * time.Minute
+	// Errors kept for scoring are bounded
+	maxRecentErrors = 16
+)
+
+// ChannelHealth records round-trip time, activity and errors of a channel, from
+// which health score of the channel is calculated
+type ChannelHealth struct {
+	lock         sync.Mutex
+	pingRTT      time.Duration
+	lastPing     time.Time
+	lastMessage  time.Time
+	activatedAt  time.Time
+	recentErrors []time.Time
+}
+
+// HealthSnapshot is health of channel at specified time
+type HealthSnapshot struct {
+	Score        int
+	PingRTT      time.Duration
+	LastActivity time.Time
+	LastMessage  time.Time
+	RecentErrors int
+}
+
+// RecordMessage records a message received from server
+func (h *ChannelHealth) RecordMessage() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lastMessage = time.Now()
+}
+
+// RecordPing records a successful keepalive round trip. Round-trip time is
+// ignored when not positive, i.e., not measurable.
+func (h *ChannelHealth) RecordPing(rtt time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lastPing = time.Now()
+	if rtt > 0 {
+		h.pingRTT = rtt
+	}
+}
+
+// RecordError records an error happened when receiving, replying or pinging
+func (h *ChannelHealth) RecordError() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.recentErrors = append(h.recentErrors, time.Now())
+	if len(h.recentErrors) > maxRecentErrors {
+		h.recentErrors = h.recentErrors[len(h.recentErrors)-maxRecentErrors:]
+	}
+}
+
+// markActivated makes stall of channel measured since it becomes active
+func (h *ChannelHealth) markActivated() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.activatedAt = time.Now()
+}
+
+// Snapshot calculates health score of channel at now. Channel expected to
+// show activity every stallTimeout is considered stalled when no message or
+// ping observed for longer, and stallTimeout of 0 disables the detection.
+func (h *ChannelHealth) Snapshot(working bool, stallTimeout time.Duration, now time.Time) HealthSnapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	snapshot := HealthSnapshot{
+		PingRTT:     h.pingRTT,
+		LastMessage: h.lastMessage,
+	}
+	for _, t := range []time.Time{h.lastPing, h.lastMessage, h.activatedAt} {
+		if t.After(snapshot.LastActivity) {
+			snapshot.LastActivity = t
+		}
+	}
+	for _, t := range h.recentErrors {
+		if now.Sub(t) < errorWindow {
+			snapshot.RecentErrors++
+		}
+	}
+	if !working {
+		return snapshot
+	}
+
+	score := MaxHealthScore
+	rttPenalty := int(h.pingRTT.Seconds() * rttPenaltyPerSecond)
+	if rttPenalty > maxRTTPenalty {
+		rttPenalty = maxRTTPenalty
+	}
+	score -= rttPenalty
+	errPenalty := snapshot.RecentErrors * errorPenalty
+	if errPenalty > maxErrorPenalty {
+		errPenalty = maxErrorPenalty
+	}
+	score -= errPenalty
+	// Channel never observed is not considered stalled
+	if stallTimeout > 0 && !snapshot.LastActivity.IsZero() && now.Sub(snapshot.LastActivity) > stallTimeout {
+		score = 0
+	}
+	if score < 0 {
+		score = 0
+	}
+	snapshot.Score = score
+	return snapshot
+}
@@ -0,0 +1,206 @@
+package channel
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+type fakeChannel struct {
+	*Channel
+	supported bool
+	startErr  error
+	// started blocks starting until closed if not nil
+	started chan struct{}
+}
+
+func (c *fakeChannel) IsSupported() bool {
+	return c.supported
+}
+
+func (c *fakeChannel) StartChannel() error {
+	if c.started != nil {
+		<-c.started
+	}
+	if c.startErr != nil {
+		return c.startErr
+	}
+	c.Working = true
+	return nil
+}
+
+func (c *fakeChannel) StopChannel() error {
+	c.Working = false
+	return nil
+}
+
+func newFakeChannel(channelType int, working bool) *fakeChannel {
+	return &fakeChannel{
+		Channel: &Channel{
+			ChannelType: channelType,
+			Working:     working,
+		},
+		supported: true,
+	}
+}
+
+func TestHealthSnapshot(t *testing.T) {
+	now := time.Now()
+	health := &ChannelHealth{}
+	assert.Equal(t, 0, health.Snapshot(false, 0, now).Score)
+
+	health.markActivated()
+	assert.Equal(t, MaxHealthScore, health.Snapshot(true, time.Minute, now).Score)
+
+	health.RecordPing(time.Second)
+	assert.Equal(t, MaxHealthScore-rttPenaltyPerSecond, health.Snapshot(true, time.Minute, now).Score)
+	health.RecordPing(0)
+	assert.Equal(t, time.Second, health.Snapshot(true, time.Minute, now).PingRTT)
+
+	health.RecordError()
+	health.RecordError()
+	snapshot := health.Snapshot(true, time.Minute, time.Now())
+	assert.Equal(t, 2, snapshot.RecentErrors)
+	assert.Equal(t, MaxHealthScore-rttPenaltyPerSecond-2*errorPenalty, snapshot.Score)
+	// Errors out of window are not counted
+	assert.Equal(t, 0, health.Snapshot(true, 0, time.Now().Add(errorWindow)).RecentErrors)
+
+	// Stalled channel scores 0
+	assert.Equal(t, 0, health.Snapshot(true, time.Minute, time.Now().Add(2*time.Minute)).Score)
+	// Channel without keepalive mechanism is never considered stalled
+	assert.NotEqual(t, 0, health.Snapshot(true, 0, time.Now().Add(time.Hour)).Score)
+}
+
+func TestHealthFailover(t *testing.T) {
+	httpmock.Activate()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+	util.MockMetaServer("cn-test100")
+
+	gshell := newFakeChannel(ChannelGshellType, false)
+	gshell.supported = false
+	websocket := newFakeChannel(ChannelWebsocketType, true)
+	longpoll := newFakeChannel(ChannelLongPollType, false)
+	m := &ChannelMgr{
+		AllChannel: []IChannel{gshell, websocket, longpoll},
+	}
+	m.setActiveChannel(websocket)
+
+	// Healthy active channel is kept
+	m.checkActiveChannelHealth()
+	assert.Equal(t, ChannelWebsocketType, m.GetCurrentChannelType())
+
+	// Unhealthy active channel is kept within minimum dwell time
+	for i := 0; i < 3; i++ {
+		websocket.GetHealth().RecordError()
+	}
+	m.checkActiveChannelHealth()
+	m.checkActiveChannelHealth()
+	assert.Equal(t, ChannelWebsocketType, m.GetCurrentChannelType())
+
+	// Failover happens after consecutive unhealthy checks
+	m.lastSwitchTime = time.Now().Add(-minDwellTime)
+	m.unhealthyChecks = 0
+	m.checkActiveChannelHealth()
+	assert.Equal(t, ChannelWebsocketType, m.GetCurrentChannelType())
+	m.checkActiveChannelHealth()
+	assert.Equal(t, ChannelLongPollType, m.GetCurrentChannelType())
+	assert.True(t, longpoll.IsWorking())
+	assert.False(t, websocket.IsWorking())
+
+	// Failed failover keeps active channel and is not retried immediately
+	m.lastSwitchTime = time.Now().Add(-minDwellTime)
+	for i := 0; i < 3; i++ {
+		longpoll.GetHealth().RecordError()
+	}
+	websocket.startErr = errors.New("unavailable")
+	m.checkActiveChannelHealth()
+	m.checkActiveChannelHealth()
+	assert.Equal(t, ChannelLongPollType, m.GetCurrentChannelType())
+	assert.True(t, time.Since(m.lastSwitchTime) < minDwellTime)
+
+	scores := m.HealthScores()
+	assert.Equal(t, 3, len(scores))
+	assert.Equal(t, "longpoll", scores[2].Channel)
+	assert.Equal(t, 3, scores[2].RecentErrors)
+}
+
+func TestFailoverWithoutLock(t *testing.T) {
+	httpmock.Activate()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+	util.MockMetaServer("cn-test100")
+
+	gshell := newFakeChannel(ChannelGshellType, false)
+	gshell.supported = false
+	websocket := newFakeChannel(ChannelWebsocketType, true)
+	longpoll := newFakeChannel(ChannelLongPollType, false)
+	longpoll.started = make(chan struct{})
+	m := &ChannelMgr{
+		AllChannel: []IChannel{gshell, websocket, longpoll},
+	}
+	m.setActiveChannel(websocket)
+	m.lastSwitchTime = time.Now().Add(-minDwellTime)
+	m.unhealthyChecks = failoverChecks
+	for i := 0; i < 3; i++ {
+		websocket.GetHealth().RecordError()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.checkActiveChannelHealth()
+		close(done)
+	}()
+	// Channel manager is usable while starting channel, which is not started
+	// by others meanwhile
+	assert.Eventually(t, func() bool {
+		m.ChannelSetLock.Lock()
+		defer m.ChannelSetLock.Unlock()
+		return m.starting == longpoll
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, ChannelWebsocketType, m.GetCurrentChannelType())
+	assert.Equal(t, 3, len(m.HealthScores()))
+	assert.Error(t, m.SelectAvailableChannel(ChannelWebsocketType))
+
+	close(longpoll.started)
+	<-done
+	assert.Equal(t, ChannelLongPollType, m.GetCurrentChannelType())
+	assert.False(t, websocket.IsWorking())
+	assert.Nil(t, m.starting)
+}
+
+func TestGshellStall(t *testing.T) {
+	gshell := newFakeChannel(ChannelGshellType, true)
+	gshell.GetHealth().markActivated()
+	now := time.Now()
+	assert.Equal(t, MaxHealthScore, healthOf(gshell, now).Score)
+	assert.Equal(t, MaxHealthScore, healthOf(gshell, now.Add(gshellProbeInterval)).Score)
+	// Probing never succeeded twice in a row
+	assert.Equal(t, 0, healthOf(gshell, now.Add(gshellStallTimeout+time.Second)).Score)
+}
+
+func TestSelectAvailableChannelByHealth(t *testing.T) {
+	gshell := newFakeChannel(ChannelGshellType, true)
+	websocket := newFakeChannel(ChannelWebsocketType, true)
+	longpoll := newFakeChannel(ChannelLongPollType, false)
+	m := &ChannelMgr{
+		AllChannel: []IChannel{gshell, websocket, longpoll},
+	}
+	for i := 0; i < 3; i++ {
+		gshell.GetHealth().RecordError()
+	}
+	assert.NoError(t, m.SelectAvailableChannel(ChannelNone))
+	assert.Equal(t, ChannelWebsocketType, m.GetCurrentChannelType())
+
+	// Unhealthy working channel is used when no other channel available
+	longpoll.startErr = errors.New("unavailable")
+	assert.NoError(t, m.SelectAvailableChannel(ChannelWebsocketType))
+	assert.Equal(t, ChannelGshellType, m.GetCurrentChannelType())
+}
@@ -145,6 +145,14 @@ func RecentReport() *CheckReport {
 	return reportPtr
 }
 
+// LastReport returns the most recent network diagnostic report, or nil pointer
+// if the report has not been generated. Unlike RecentReport, it neither
+// consumes force-to-report responses nor refreshes outdated report.
+func LastReport() *CheckReport {
+	reportPtr, _ := _neverDirectRW_atomic_lastReportPtr.Load().(*CheckReport)
+	return reportPtr
+}
+
 // DeclareNetworkCategory sets the network category in cache of this module,
 // which is used to specify the network environment when running netcheck
 // program.
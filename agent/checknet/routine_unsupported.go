@@ -21,6 +21,13 @@ func RecentReport() *CheckReport {
 	return nil
 }
 
+// LastReport is currently not supported on this operating system, and simply
+// returns nil pointer. On supported OSes, it would return the most recent
+// network diagnostic report without refreshing it.
+func LastReport() *CheckReport {
+	return nil
+}
+
 // DeclareNetworkCategory is currently not supported on this operating system,
 // and is simply an empty function. On supported OSes, it would set the network
 // category in cache of this module, which is used to specify the network
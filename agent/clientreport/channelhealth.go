@@ -0,0 +1,36 @@
+package clientreport
+
+import "encoding/json"
+
+const (
+	_channelHealthReportType = "AgentChannelHealth"
+)
+
+// ChannelHealthScore is health of a channel observed by agent
+type ChannelHealthScore struct {
+	Channel       string `json:"channel"`
+	Working       bool   `json:"working"`
+	Score         int    `json:"score"`
+	PingRTTMillis int64  `json:"pingRttMillis"`
+	// Seconds since the latest message or ping, -1 if never active
+	IdleSeconds  int64 `json:"idleSeconds"`
+	RecentErrors int   `json:"recentErrors"`
+}
+
+type ChannelHealthReport struct {
+	Reason        string               `json:"reason"`
+	ActiveChannel string               `json:"activeChannel"`
+	Channels      []ChannelHealthScore `json:"channels"`
+}
+
+func ReportChannelHealth(report ChannelHealthReport) (string, error) {
+	reportJSONBytes, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+
+	return SendReport(ClientReport{
+		ReportType: _channelHealthReportType,
+		Info:       string(reportJSONBytes),
+	})
+}
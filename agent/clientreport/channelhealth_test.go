@@ -0,0 +1,56 @@
+package clientreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func TestReportChannelHealth(t *testing.T) {
+	httpmock.Activate()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+
+	const mockRegion = "cn-test100"
+	util.MockMetaServer(mockRegion)
+
+	var requestBody []byte
+	httpmock.RegisterResponder("POST",
+		fmt.Sprintf("https://%s.axt.aliyun.com/luban/api/v1/exception/client_report", mockRegion),
+		func(h *http.Request) (*http.Response, error) {
+			readRequestBody, err := ioutil.ReadAll(h.Body)
+			if err != nil {
+				return nil, err
+			}
+			requestBody = readRequestBody
+			return httpmock.NewStringResponse(200, "success"), nil
+		})
+
+	_, err := ReportChannelHealth(ChannelHealthReport{
+		Reason:        "failover",
+		ActiveChannel: "websocket",
+		Channels: []ChannelHealthScore{
+			{Channel: "gshell", Working: false, IdleSeconds: -1},
+			{Channel: "websocket", Working: true, Score: 15, PingRTTMillis: 120, IdleSeconds: 42, RecentErrors: 3},
+		},
+	})
+	assert.NoError(t, err)
+
+	var sendedReport ClientReport
+	assert.NoError(t, json.Unmarshal(requestBody, &sendedReport))
+	assert.Exactly(t, "AgentChannelHealth", sendedReport.ReportType)
+	var sendedHealth ChannelHealthReport
+	assert.NoError(t, json.Unmarshal([]byte(sendedReport.Info), &sendedHealth))
+	assert.Exactly(t, "failover", sendedHealth.Reason)
+	assert.Equal(t, 2, len(sendedHealth.Channels))
+	assert.Exactly(t, 15, sendedHealth.Channels[1].Score)
+	assert.Exactly(t, int64(120), sendedHealth.Channels[1].PingRTTMillis)
+}
@@ -0,0 +1,356 @@
+// Package config loads agent configuration from agent_config.json in the
+// configuration directory of current version, or the one across all installed
+// versions. Items not specified fall back to defaults.
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	configFilename = "agent_config.json"
+
+	DefaultMaxRunningTasks           = 10
+	DefaultMaxPendingTasks           = 50
+	DefaultReservedHighPriorityTasks = 2
+	DefaultShortTaskTimeoutSeconds   = 60
+
+	DefaultShutdownGracePeriodSeconds = 20
+
+	// KickAuthModeAuto requires sensitive kick_vm commands to be signed only
+	// on hybrid or self-hosted instances with server public key pinned, while
+	// KickAuthModeEnforce requires it on every instance
+	KickAuthModeAuto     = "auto"
+	KickAuthModeEnforce  = "enforce"
+	KickAuthModeDisabled = "disabled"
+
+	DefaultKickMaxClockSkewSeconds = 300
+
+	DefaultMetricsExporterListenAddress = "127.0.0.1:9798"
+
+	MetricsEventSinkHTTP   = "http"
+	MetricsEventSinkFile   = "file"
+	MetricsEventSinkSyslog = "syslog"
+
+	DefaultMetricsEventSpoolMaxBytes      = 4 * 1024 * 1024
+	DefaultMetricsEventAuditFileMaxBytes  = 16 * 1024 * 1024
+	DefaultMetricsEventDedupWindowSeconds = 600
+
+	DefaultSessionIdleTimeoutSeconds   = 180
+	DefaultSessionMaxDurationSeconds   = 3 * 3600
+	DefaultSessionWarningPeriodSeconds = 60
+
+	DefaultSessionRecordingMaxBytesPerSession = 64 * 1024 * 1024
+	DefaultSessionRecordingMaxTotalBytes      = 1024 * 1024 * 1024
+	DefaultSessionRecordingRetentionDays      = 30
+)
+
+// TaskPoolConfig limits concurrency of tasks
+type TaskPoolConfig struct {
+	// MaxRunningTasks is the number of tasks running concurrently
+	MaxRunningTasks int `json:"maxRunningTasks"`
+	// MaxPendingTasks is the number of tasks waiting in queue, tasks
+	// exceeding it are rejected
+	MaxPendingTasks int `json:"maxPendingTasks"`
+	// ReservedHighPriorityTasks is the number of extra workers which only
+	// run high priority tasks, e.g., testing tasks
+	ReservedHighPriorityTasks int `json:"reservedHighPriorityTasks"`
+	// ShortTaskTimeoutSeconds classifies tasks whose timeout is not longer
+	// than it as high priority, 0 disables the classification
+	ShortTaskTimeoutSeconds int `json:"shortTaskTimeoutSeconds"`
+	// MaxConcurrencyPerCommand limits the number of running tasks of the
+	// same command, 0 means unlimited
+	MaxConcurrencyPerCommand int `json:"maxConcurrencyPerCommand"`
+}
+
+// ShutdownConfig controls how agent stops
+type ShutdownConfig struct {
+	// GracePeriodSeconds is how long running tasks and sessions are waited
+	// for before canceled when agent stops, 0 cancels them immediately
+	GracePeriodSeconds int `json:"gracePeriodSeconds"`
+}
+
+// KickAuthConfig controls verification of signed kick_vm commands
+type KickAuthConfig struct {
+	// Mode is one of auto, enforce and disabled
+	Mode string `json:"mode"`
+	// MaxClockSkewSeconds is the maximum difference between timestamp of
+	// command and local clock
+	MaxClockSkewSeconds int `json:"maxClockSkewSeconds"`
+	// ServerPublicKeyFile overrides the server public key pinned at
+	// registration
+	ServerPublicKeyFile string `json:"serverPublicKeyFile"`
+}
+
+// MetricsExporterConfig controls the HTTP endpoint exposing metrics of agent
+// internals for scraping
+type MetricsExporterConfig struct {
+	// Enabled starts the endpoint, which is disabled by default
+	Enabled bool `json:"enabled"`
+	// ListenAddress is in host:port format, and listening on non-loopback
+	// address allows monitoring systems to scrape remotely
+	ListenAddress string `json:"listenAddress"`
+}
+
+// MetricsEventsConfig controls delivery of metrics events
+type MetricsEventsConfig struct {
+	// Sinks are where events are delivered to, any of http, file and syslog
+	Sinks []string `json:"sinks"`
+	// SpoolMaxBytes bounds size of the spool keeping undelivered events, the
+	// oldest events are dropped when exceeded
+	SpoolMaxBytes int64 `json:"spoolMaxBytes"`
+	// AuditFileMaxBytes bounds size of the file written by file sink, which
+	// is rotated when exceeded
+	AuditFileMaxBytes int64 `json:"auditFileMaxBytes"`
+	// DedupWindowSeconds is how long events with the same keywords are
+	// reported only once, 0 disables deduplication
+	DedupWindowSeconds int `json:"dedupWindowSeconds"`
+}
+
+// SessionLimitsConfig bounds lifetime of sessions of one type
+type SessionLimitsConfig struct {
+	// IdleTimeoutSeconds is how long session is kept without input
+	IdleTimeoutSeconds int `json:"idleTimeoutSeconds"`
+	// MaxDurationSeconds is how long session lasts at most
+	MaxDurationSeconds int `json:"maxDurationSeconds"`
+}
+
+// SessionConfig controls sessions, whose limits are upper bounds of those
+// specified by server for each session
+type SessionConfig struct {
+	Shell       SessionLimitsConfig `json:"shell"`
+	PortForward SessionLimitsConfig `json:"portForward"`
+	// WarningPeriodSeconds is how long before closing session by limits the
+	// user is warned
+	WarningPeriodSeconds int `json:"warningPeriodSeconds"`
+	// PortForwardAllowlist lists hosts other than the instance itself which
+	// port forwarding sessions could connect to, in CIDR notation or
+	// hostname. Hostname starting with "*." matches its subdomains. Empty
+	// list disables port forwarding to remote hosts.
+	PortForwardAllowlist []string `json:"portForwardAllowlist"`
+}
+
+// SessionRecordingConfig controls recording of interactive shell sessions in
+// asciicast v2 format
+type SessionRecordingConfig struct {
+	// Enabled records every shell session, which is disabled by default
+	Enabled bool `json:"enabled"`
+	// Directory is where recordings are saved in subdirectory
+	// aliyun_assist_session_recordings, which defaults to session_recordings
+	// under the directory of all installed versions
+	Directory string `json:"directory"`
+	// RecordInput records keystrokes besides output and resizing of terminal,
+	// which is disabled by default since keystrokes include typed passwords
+	RecordInput bool `json:"recordInput"`
+	// MaxBytesPerSession bounds size of one recording, the rest of session is
+	// not recorded when exceeded
+	MaxBytesPerSession int64 `json:"maxBytesPerSession"`
+	// MaxTotalBytes bounds size of all recordings, the oldest ones are
+	// removed when exceeded
+	MaxTotalBytes int64 `json:"maxTotalBytes"`
+	// RetentionDays is how long recordings are kept, 0 keeps them until
+	// MaxTotalBytes exceeded
+	RetentionDays int `json:"retentionDays"`
+}
+
+// ChannelConfig controls channels receiving messages from server
+type ChannelConfig struct {
+	// LongPollEnabled allows falling back to long-poll HTTP channel, which is
+	// disabled by default since the long-poll endpoint is not served in all
+	// regions yet
+	LongPollEnabled bool `json:"longPollEnabled"`
+}
+
+// ContainerConfig controls running commands in containers
+type ContainerConfig struct {
+	// StreamingCAFile is CA certificate in PEM verifying streaming server of
+	// CRI runtime, which is needed if the runtime serves streaming over TLS
+	// with a self-signed certificate
+	StreamingCAFile string `json:"streamingCAFile"`
+}
+
+type AgentConfig struct {
+	TaskPool         TaskPoolConfig         `json:"taskPool"`
+	Shutdown         ShutdownConfig         `json:"shutdown"`
+	KickAuth         KickAuthConfig         `json:"kickAuth"`
+	MetricsExporter  MetricsExporterConfig  `json:"metricsExporter"`
+	MetricsEvents    MetricsEventsConfig    `json:"metricsEvents"`
+	Session          SessionConfig          `json:"session"`
+	SessionRecording SessionRecordingConfig `json:"sessionRecording"`
+	Container        ContainerConfig        `json:"container"`
+	Channel          ChannelConfig          `json:"channel"`
+}
+
+var (
+	_agentConfig     *AgentConfig
+	_agentConfigLock sync.Mutex
+)
+
+func defaultConfig() *AgentConfig {
+	return &AgentConfig{
+		TaskPool: TaskPoolConfig{
+			MaxRunningTasks:           DefaultMaxRunningTasks,
+			MaxPendingTasks:           DefaultMaxPendingTasks,
+			ReservedHighPriorityTasks: DefaultReservedHighPriorityTasks,
+			ShortTaskTimeoutSeconds:   DefaultShortTaskTimeoutSeconds,
+		},
+		Shutdown: ShutdownConfig{
+			GracePeriodSeconds: DefaultShutdownGracePeriodSeconds,
+		},
+		KickAuth: KickAuthConfig{
+			Mode:                KickAuthModeAuto,
+			MaxClockSkewSeconds: DefaultKickMaxClockSkewSeconds,
+		},
+		MetricsExporter: MetricsExporterConfig{
+			ListenAddress: DefaultMetricsExporterListenAddress,
+		},
+		MetricsEvents: MetricsEventsConfig{
+			Sinks:              []string{MetricsEventSinkHTTP},
+			SpoolMaxBytes:      DefaultMetricsEventSpoolMaxBytes,
+			AuditFileMaxBytes:  DefaultMetricsEventAuditFileMaxBytes,
+			DedupWindowSeconds: DefaultMetricsEventDedupWindowSeconds,
+		},
+		Session: SessionConfig{
+			Shell: SessionLimitsConfig{
+				IdleTimeoutSeconds: DefaultSessionIdleTimeoutSeconds,
+				MaxDurationSeconds: DefaultSessionMaxDurationSeconds,
+			},
+			PortForward: SessionLimitsConfig{
+				IdleTimeoutSeconds: DefaultSessionIdleTimeoutSeconds,
+				MaxDurationSeconds: DefaultSessionMaxDurationSeconds,
+			},
+			WarningPeriodSeconds: DefaultSessionWarningPeriodSeconds,
+		},
+		SessionRecording: SessionRecordingConfig{
+			MaxBytesPerSession: DefaultSessionRecordingMaxBytesPerSession,
+			MaxTotalBytes:      DefaultSessionRecordingMaxTotalBytes,
+			RetentionDays:      DefaultSessionRecordingRetentionDays,
+		},
+	}
+}
+
+// GetConfig returns agent configuration, which is loaded only once
+func GetConfig() *AgentConfig {
+	_agentConfigLock.Lock()
+	defer _agentConfigLock.Unlock()
+	if _agentConfig != nil {
+		return _agentConfig
+	}
+
+	var paths []string
+	if crossVersionConfigDir, err := util.GetCrossVersionConfigPath(); err == nil {
+		paths = append(paths, filepath.Join(crossVersionConfigDir, configFilename))
+	}
+	if currentVersionConfigDir, err := util.GetConfigPath(); err == nil {
+		paths = append(paths, filepath.Join(currentVersionConfigDir, configFilename))
+	}
+	_agentConfig = loadConfig(paths...)
+	return _agentConfig
+}
+
+// loadConfig overlays configuration files in order on defaults, thus items in
+// latter file take precedence
+func loadConfig(paths ...string) *AgentConfig {
+	config := defaultConfig()
+	for _, path := range paths {
+		if !util.CheckFileIsExist(path) {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.GetLogger().WithError(err).Errorf("Failed to read agent configuration %s", path)
+			continue
+		}
+		if err := json.Unmarshal(content, config); err != nil {
+			log.GetLogger().WithError(err).Errorf("Invalid agent configuration %s is ignored", path)
+			continue
+		}
+		log.GetLogger().Infof("Loaded agent configuration %s", path)
+	}
+	config.normalize()
+	return config
+}
+
+func (c *AgentConfig) normalize() {
+	if c.TaskPool.MaxRunningTasks <= 0 {
+		c.TaskPool.MaxRunningTasks = DefaultMaxRunningTasks
+	}
+	if c.TaskPool.MaxPendingTasks <= 0 {
+		c.TaskPool.MaxPendingTasks = DefaultMaxPendingTasks
+	}
+	if c.TaskPool.ReservedHighPriorityTasks < 0 {
+		c.TaskPool.ReservedHighPriorityTasks = 0
+	}
+	if c.TaskPool.ShortTaskTimeoutSeconds < 0 {
+		c.TaskPool.ShortTaskTimeoutSeconds = 0
+	}
+	if c.TaskPool.MaxConcurrencyPerCommand < 0 {
+		c.TaskPool.MaxConcurrencyPerCommand = 0
+	}
+	if c.Shutdown.GracePeriodSeconds < 0 {
+		c.Shutdown.GracePeriodSeconds = 0
+	}
+	switch c.KickAuth.Mode {
+	case KickAuthModeAuto, KickAuthModeEnforce, KickAuthModeDisabled:
+	default:
+		log.GetLogger().Errorf("Unknown kick_vm authentication mode %s, fall back to %s", c.KickAuth.Mode, KickAuthModeAuto)
+		c.KickAuth.Mode = KickAuthModeAuto
+	}
+	if c.KickAuth.MaxClockSkewSeconds <= 0 {
+		c.KickAuth.MaxClockSkewSeconds = DefaultKickMaxClockSkewSeconds
+	}
+	if c.MetricsExporter.ListenAddress == "" {
+		c.MetricsExporter.ListenAddress = DefaultMetricsExporterListenAddress
+	}
+	var sinks []string
+	for _, sink := range c.MetricsEvents.Sinks {
+		switch sink {
+		case MetricsEventSinkHTTP, MetricsEventSinkFile, MetricsEventSinkSyslog:
+			sinks = append(sinks, sink)
+		default:
+			log.GetLogger().Errorf("Unknown metrics event sink %s is ignored", sink)
+		}
+	}
+	if len(sinks) == 0 {
+		sinks = []string{MetricsEventSinkHTTP}
+	}
+	c.MetricsEvents.Sinks = sinks
+	if c.MetricsEvents.SpoolMaxBytes <= 0 {
+		c.MetricsEvents.SpoolMaxBytes = DefaultMetricsEventSpoolMaxBytes
+	}
+	if c.MetricsEvents.AuditFileMaxBytes <= 0 {
+		c.MetricsEvents.AuditFileMaxBytes = DefaultMetricsEventAuditFileMaxBytes
+	}
+	if c.MetricsEvents.DedupWindowSeconds < 0 {
+		c.MetricsEvents.DedupWindowSeconds = 0
+	}
+	c.Session.Shell.normalize()
+	c.Session.PortForward.normalize()
+	if c.Session.WarningPeriodSeconds < 0 {
+		c.Session.WarningPeriodSeconds = 0
+	}
+	if c.SessionRecording.MaxBytesPerSession <= 0 {
+		c.SessionRecording.MaxBytesPerSession = DefaultSessionRecordingMaxBytesPerSession
+	}
+	if c.SessionRecording.MaxTotalBytes <= 0 {
+		c.SessionRecording.MaxTotalBytes = DefaultSessionRecordingMaxTotalBytes
+	}
+	if c.SessionRecording.RetentionDays < 0 {
+		c.SessionRecording.RetentionDays = 0
+	}
+}
+
+func (c *SessionLimitsConfig) normalize() {
+	if c.IdleTimeoutSeconds <= 0 {
+		c.IdleTimeoutSeconds = DefaultSessionIdleTimeoutSeconds
+	}
+	if c.MaxDurationSeconds <= 0 {
+		c.MaxDurationSeconds = DefaultSessionMaxDurationSeconds
+	}
+}
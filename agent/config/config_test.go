@@ -0,0 +1,50 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent_config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	crossVersionPath := filepath.Join(dir, "cross.json")
+	currentVersionPath := filepath.Join(dir, "current.json")
+	invalidPath := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(crossVersionPath, []byte(`{"taskPool":{"maxRunningTasks":20,"maxPendingTasks":100},"channel":{"longPollEnabled":true}}`), 0600)
+	ioutil.WriteFile(currentVersionPath, []byte(`{"taskPool":{"maxPendingTasks":200,"maxConcurrencyPerCommand":-1},"shutdown":{"gracePeriodSeconds":-5},"metricsExporter":{"enabled":true,"listenAddress":""},"metricsEvents":{"sinks":["file","kafka","syslog"],"spoolMaxBytes":-1},"session":{"shell":{"maxDurationSeconds":600},"portForward":{"idleTimeoutSeconds":-1,"maxDurationSeconds":86400},"portForwardAllowlist":["10.0.0.0/8","*.rds.aliyuncs.com"]},"sessionRecording":{"enabled":true,"recordInput":true,"maxTotalBytes":0}}`), 0600)
+	ioutil.WriteFile(invalidPath, []byte(`{"taskPool":`), 0600)
+
+	config := loadConfig()
+	assert.Equal(t, defaultConfig(), config)
+	assert.False(t, config.Channel.LongPollEnabled)
+	assert.False(t, config.SessionRecording.RecordInput)
+
+	config = loadConfig(crossVersionPath, currentVersionPath, invalidPath, filepath.Join(dir, "notexist.json"))
+	assert.Equal(t, 20, config.TaskPool.MaxRunningTasks)
+	assert.True(t, config.Channel.LongPollEnabled)
+	assert.Equal(t, 200, config.TaskPool.MaxPendingTasks)
+	assert.Equal(t, DefaultReservedHighPriorityTasks, config.TaskPool.ReservedHighPriorityTasks)
+	assert.Equal(t, 0, config.TaskPool.MaxConcurrencyPerCommand)
+	assert.Equal(t, 0, config.Shutdown.GracePeriodSeconds)
+	assert.True(t, config.MetricsExporter.Enabled)
+	assert.Equal(t, DefaultMetricsExporterListenAddress, config.MetricsExporter.ListenAddress)
+	assert.Equal(t, []string{MetricsEventSinkFile, MetricsEventSinkSyslog}, config.MetricsEvents.Sinks)
+	assert.Equal(t, int64(DefaultMetricsEventSpoolMaxBytes), config.MetricsEvents.SpoolMaxBytes)
+	assert.Equal(t, DefaultSessionIdleTimeoutSeconds, config.Session.Shell.IdleTimeoutSeconds)
+	assert.Equal(t, 600, config.Session.Shell.MaxDurationSeconds)
+	assert.Equal(t, DefaultSessionIdleTimeoutSeconds, config.Session.PortForward.IdleTimeoutSeconds)
+	assert.Equal(t, 86400, config.Session.PortForward.MaxDurationSeconds)
+	assert.Equal(t, DefaultSessionWarningPeriodSeconds, config.Session.WarningPeriodSeconds)
+	assert.Equal(t, []string{"10.0.0.0/8", "*.rds.aliyuncs.com"}, config.Session.PortForwardAllowlist)
+	assert.True(t, config.SessionRecording.Enabled)
+	assert.True(t, config.SessionRecording.RecordInput)
+	assert.Equal(t, int64(DefaultSessionRecordingMaxTotalBytes), config.SessionRecording.MaxTotalBytes)
+	assert.Equal(t, DefaultSessionRecordingRetentionDays, config.SessionRecording.RetentionDays)
+}
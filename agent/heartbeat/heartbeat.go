@@ -15,6 +15,8 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/checkvirt"
 	"github.com/aliyun/aliyun_assist_client/agent/flagging"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/timermanager"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"github.com/aliyun/aliyun_assist_client/agent/util/osutil"
@@ -72,12 +74,14 @@ func init() {
 func buildPingRequest(virtType string, osType string, osVersion string,
 	appVersion string, uptime uint64, timestamp int64, pid int,
 	processUptime int64, acknowledgeCounter uint64, azoneId string,
-	isColdstart bool, sendCounter uint64) string {
+	isColdstart bool, sendCounter uint64, resultQueueDepth int) string {
 	encodedOsVersion := url.QueryEscape(osVersion)
 	paramChars := fmt.Sprintf("?virt_type=%s&lang=golang&os_type=%s&os_version=%s&app_version=%s&uptime=%d&timestamp=%d&pid=%d&process_uptime=%d&index=%d&az=%s&virtiover=%d&machineid=%s&seq_no=%d",
 		virtType, osType, encodedOsVersion, appVersion, uptime, timestamp, pid,
 		processUptime, acknowledgeCounter, azoneId, _winVirtioIsOld, _machineId,
 		sendCounter)
+	// Number of task results waiting for delivery in outbox
+	paramChars = paramChars + fmt.Sprintf("&result_queue=%d", resultQueueDepth)
 	// Only first heart-beat need to carry cold-start flag
 	if acknowledgeCounter == 0 {
 		paramChars = paramChars + fmt.Sprintf("&cold_start=%t", isColdstart)
@@ -148,13 +152,15 @@ func doPing() error {
 
 	url := buildPingRequest(virtType, osType, osVersion, appVersion, startTime,
 		timestamp, pid, processUptime, acknowledgeCounter, azoneId, isColdstart,
-		sendCounter)
+		sendCounter, outbox.Depth())
 
 	nextIntervalSeconds := DefaultPingIntervalSeconds
 	newTasks := false
 
+	pingStartTime := time.Now()
 	res, err := invokePingRequest(url)
 	if err != nil {
+		openmetrics.Heartbeats.Inc(openmetrics.HeartbeatFailure)
 		log.GetLogger().WithFields(logrus.Fields{
 			"requestURL": url,
 		}).WithError(err).Errorln("Failed to invoke ping request")
@@ -162,6 +168,12 @@ func doPing() error {
 		// task.RunSystemNetCheck();
 		return err
 	}
+	openmetrics.Heartbeats.Inc(openmetrics.HeartbeatSuccess)
+	openmetrics.HeartbeatLatency.Set(time.Since(pingStartTime).Seconds())
+	// Network is available now, deliver queued task results as soon as possible
+	if outbox.Depth() > 0 {
+		outbox.Notify()
+	}
 
 	if !gjson.Valid(res) {
 		log.GetLogger().WithFields(logrus.Fields{
@@ -230,22 +242,26 @@ func doPing() error {
 }
 
 func PingwithRetries(retryCount int) {
+	var err error
 	for i := 0; i < retryCount; i++ {
-		if err := doPing(); err == nil {
+		if err = doPing(); err == nil {
 			_acknowledgeCounter++
 			break
 		}
 	}
 	_sendCounter++
+	recordPing(err)
 }
 
 func pingWithoutRetry() {
 	// Error(s) encountered during heart-beating has been logged internally,
 	// simply ignore it here.
-	if err := doPing(); err == nil {
+	err := doPing()
+	if err == nil {
 		_acknowledgeCounter++
 	}
 	_sendCounter++
+	recordPing(err)
 }
 
 func InitHeartbeatTimer() error {
@@ -38,10 +38,11 @@ func TestBuildPingRequest(t *testing.T) {
 	const sendCounter = 2
 	const azoneId = "1q23213"
 	const isColdstart = false
+	const resultQueueDepth = 3
 
 	requestURL := buildPingRequest(virtType, osType, osVersion, appVersion, uptime,
 		timestamp, pid, processUptime, acknowledgeCounter, azoneId, isColdstart,
-		sendCounter)
+		sendCounter, resultQueueDepth)
 	fmt.Println(requestURL)
 
 	segments, err := url.Parse(requestURL)
@@ -57,6 +58,7 @@ func TestBuildPingRequest(t *testing.T) {
 	unescapedOsTypeParam, err := url.QueryUnescape(params["os_version"][0])
 	assert.NoErrorf(t, err, "buildPingRequest should not produce malformed os_version parameter in querystring: %s", requestURL)
 	assert.Exactly(t, osVersion, unescapedOsTypeParam)
+	assert.Exactly(t, []string{"3"}, params["result_queue"])
 
 	// Ordinary case
 	var paramCases = []struct {
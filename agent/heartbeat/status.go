@@ -0,0 +1,42 @@
+package heartbeat
+
+import (
+	"sync"
+	"time"
+)
+
+// Status describes the most recent heart-beat sent to server
+type Status struct {
+	LastPingTime       time.Time `json:"lastPingTime"`
+	Succeeded          bool      `json:"succeeded"`
+	Error              string    `json:"error,omitempty"`
+	SendCounter        uint64    `json:"sendCounter"`
+	AcknowledgeCounter uint64    `json:"acknowledgeCounter"`
+}
+
+var (
+	_lastStatus     Status
+	_lastStatusLock sync.Mutex
+)
+
+func recordPing(err error) {
+	_lastStatusLock.Lock()
+	defer _lastStatusLock.Unlock()
+	_lastStatus = Status{
+		LastPingTime:       time.Now(),
+		Succeeded:          err == nil,
+		SendCounter:        _sendCounter,
+		AcknowledgeCounter: _acknowledgeCounter,
+	}
+	if err != nil {
+		_lastStatus.Error = err.Error()
+	}
+}
+
+// LastStatus returns status of the most recent heart-beat, whose LastPingTime
+// is zero when no heart-beat has been sent yet
+func LastStatus() Status {
+	_lastStatusLock.Lock()
+	defer _lastStatusLock.Unlock()
+	return _lastStatus
+}
@@ -0,0 +1,330 @@
+package hybrid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	// EnrollmentFileEnv specifies path of enrollment file instead of the
+	// default ones in config directories
+	EnrollmentFileEnv = "ALIYUN_ASSIST_ENROLLMENT_FILE"
+	// EnrollmentStatusFile is written in directory of hybrid credentials
+	EnrollmentStatusFile = "enrollment-status.json"
+
+	EnrollmentStateEnrolling         = "enrolling"
+	EnrollmentStateEnrolled          = "enrolled"
+	EnrollmentStateFailed            = "failed"
+	EnrollmentStateAlreadyRegistered = "already-registered"
+
+	enrollMaxAttempts  = 10
+	enrollInitialDelay = 5 * time.Second
+	enrollMaxDelay     = 5 * time.Minute
+)
+
+var (
+	// enrollmentFileNames are searched in cross-version config directory
+	// first, then config directory of current version
+	enrollmentFileNames = []string{"enrollment.yaml", "enrollment.yml", "enrollment.json"}
+
+	// enrollAfter is replaced in tests to skip waiting between attempts
+	enrollAfter = time.After
+)
+
+// EnrollmentConfig holds parameters of registration, which is the same as
+// command line flags of --register. Both YAML and JSON are accepted since
+// JSON is a subset of YAML.
+type EnrollmentConfig struct {
+	RegionId       string `yaml:"regionId" json:"regionId"`
+	ActivationCode string `yaml:"activationCode" json:"activationCode"`
+	ActivationId   string `yaml:"activationId" json:"activationId"`
+	InstanceName   string `yaml:"instanceName" json:"instanceName"`
+	// NetworkMode is "vpc" for registering via VPC endpoint, or empty for
+	// public endpoint
+	NetworkMode string `yaml:"networkMode" json:"networkMode"`
+}
+
+// EnrollmentStatus is written to status file for inspecting result of
+// unattended enrollment by other programs
+type EnrollmentStatus struct {
+	State      string    `json:"state"`
+	InstanceId string    `json:"instanceId,omitempty"`
+	RegionId   string    `json:"regionId,omitempty"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// FindEnrollmentFile returns path of enrollment file, or empty string if not
+// found
+func FindEnrollmentFile() string {
+	if path := os.Getenv(EnrollmentFileEnv); path != "" {
+		if util.CheckFileIsExist(path) {
+			return path
+		}
+		log.GetLogger().WithField("path", path).Warningln("Enrollment file specified by environment variable does not exist")
+		return ""
+	}
+
+	var dirs []string
+	if crossVersionConfigDir, err := util.GetCrossVersionConfigPath(); err == nil {
+		dirs = append(dirs, crossVersionConfigDir)
+	}
+	if currentVersionConfigDir, err := util.GetConfigPath(); err == nil {
+		dirs = append(dirs, currentVersionConfigDir)
+	}
+	for _, dir := range dirs {
+		for _, name := range enrollmentFileNames {
+			path := filepath.Join(dir, name)
+			if util.CheckFileIsExist(path) {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// checkEnrollmentFileMode refuses enrollment file readable by group or other
+// users, whose activation code may have been leaked to or replaced by them.
+// Access control lists on Windows are not checked.
+func checkEnrollmentFileMode(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("enrollment file is accessible by group or other users, mode %04o, expect 0600", perm)
+	}
+	return nil
+}
+
+// ParseEnrollmentFile reads and validates enrollment file
+func ParseEnrollmentFile(path string) (*EnrollmentConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	enrollmentConfig := &EnrollmentConfig{}
+	if err := yaml.Unmarshal(content, enrollmentConfig); err != nil {
+		return nil, fmt.Errorf("invalid enrollment file: %w", err)
+	}
+	if enrollmentConfig.RegionId == "" || enrollmentConfig.ActivationCode == "" || enrollmentConfig.ActivationId == "" {
+		return nil, errors.New("invalid enrollment file: regionId, activationCode and activationId are required")
+	}
+	if enrollmentConfig.NetworkMode != "" && enrollmentConfig.NetworkMode != "vpc" {
+		return nil, fmt.Errorf("invalid enrollment file: unknown networkMode %s", enrollmentConfig.NetworkMode)
+	}
+	return enrollmentConfig, nil
+}
+
+// Enroll registers the instance with parameters in enrollment file if found,
+// which allows images to enroll unattendedly at first boot. Only failures
+// wrapping ErrRegisterUnavailable are retried with backoff, thus it may take
+// long and should be called in background. It returns false only if stop is
+// closed while waiting to retry, and the enrollment file is kept for
+// enrolling at next start then. The file is deleted once the instance is
+// registered.
+func Enroll(stop <-chan struct{}) bool {
+	path := FindEnrollmentFile()
+	if path == "" {
+		return true
+	}
+	logger := log.GetLogger().WithField("path", path)
+	logger.Infoln("Enrollment file found")
+
+	if util.IsHybrid() {
+		logger.Infoln("Agent has already registered, enrollment file is removed")
+		writeEnrollmentStatus(&EnrollmentStatus{
+			State:      EnrollmentStateAlreadyRegistered,
+			InstanceId: util.GetInstanceId(),
+		})
+		if err := secureDelete(path); err != nil {
+			logger.WithError(err).Errorln("Failed to remove enrollment file")
+		}
+		return true
+	}
+
+	if err := checkEnrollmentFileMode(path); err != nil {
+		logger.WithError(err).Errorln("Refused to enroll with insecure enrollment file")
+		writeEnrollmentStatus(&EnrollmentStatus{
+			State: EnrollmentStateFailed,
+			Error: err.Error(),
+		})
+		reportEnrollEvent(false, err.Error())
+		return true
+	}
+
+	enrollmentConfig, err := ParseEnrollmentFile(path)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to parse enrollment file")
+		writeEnrollmentStatus(&EnrollmentStatus{
+			State: EnrollmentStateFailed,
+			Error: err.Error(),
+		})
+		reportEnrollEvent(false, err.Error())
+		return true
+	}
+
+	status := &EnrollmentStatus{
+		State:    EnrollmentStateEnrolling,
+		RegionId: enrollmentConfig.RegionId,
+	}
+	delay := enrollInitialDelay
+	for {
+		status.Attempts++
+		writeEnrollmentStatus(status)
+		instanceId, err := register(enrollmentConfig.RegionId, enrollmentConfig.ActivationCode,
+			enrollmentConfig.ActivationId, enrollmentConfig.InstanceName, enrollmentConfig.NetworkMode)
+		if err == nil {
+			status.State = EnrollmentStateEnrolled
+			status.InstanceId = instanceId
+			status.Error = ""
+			writeEnrollmentStatus(status)
+			reportEnrollEvent(true, fmt.Sprintf("register ok, instanceid=%s", instanceId))
+			logger.WithField("instanceId", instanceId).Infoln("Registered by enrollment file")
+			if err := secureDelete(path); err != nil {
+				logger.WithError(err).Errorln("Failed to remove enrollment file")
+			}
+			return true
+		}
+
+		status.Error = err.Error()
+		if !errors.Is(err, ErrRegisterUnavailable) || status.Attempts >= enrollMaxAttempts {
+			status.State = EnrollmentStateFailed
+			writeEnrollmentStatus(status)
+			reportEnrollEvent(false, err.Error())
+			logger.WithFields(logrus.Fields{
+				"attempts": status.Attempts,
+			}).WithError(err).Errorln("Failed to register by enrollment file")
+			return true
+		}
+		wait := enrollBackoff(delay)
+		logger.WithFields(logrus.Fields{
+			"attempts": status.Attempts,
+			"wait":     wait.String(),
+		}).WithError(err).Warningln("Failed to register by enrollment file, retry later")
+		select {
+		case <-enrollAfter(wait):
+		case <-stop:
+			logger.WithField("attempts", status.Attempts).Infoln("Enrollment is interrupted by stopping agent, continue at next start")
+			return false
+		}
+		delay *= 2
+		if delay > enrollMaxDelay {
+			delay = enrollMaxDelay
+		}
+	}
+}
+
+// enrollBackoff adds at most 20% jitter to delay, so that instances booted
+// from the same image would not retry at the same time
+func enrollBackoff(delay time.Duration) time.Duration {
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+func reportEnrollEvent(success bool, message string) {
+	msgkey := "info"
+	status := "success"
+	if !success {
+		msgkey = "errmsg"
+		status = "failed"
+	}
+	metrics.GetHybridRegisterEvent(
+		success,
+		"status", status,
+		"source", "enrollment",
+		msgkey, message,
+	).ReportEvent()
+}
+
+// writeEnrollmentStatus replaces status file atomically
+func writeEnrollmentStatus(status *EnrollmentStatus) {
+	dir, err := util.GetHybridCredentialPath()
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to get path of enrollment status")
+		return
+	}
+	status.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(dir, EnrollmentStatusFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to write enrollment status")
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		log.GetLogger().WithError(err).Errorln("Failed to write enrollment status")
+	}
+}
+
+// ReadEnrollmentStatus returns status of the most recent enrollment
+func ReadEnrollmentStatus() (*EnrollmentStatus, error) {
+	dir, err := util.GetHybridCredentialPath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, EnrollmentStatusFile))
+	if err != nil {
+		return nil, err
+	}
+	status := &EnrollmentStatus{}
+	if err := json.Unmarshal(content, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// secureDelete overwrites content of file with zeros before removing it. It
+// is best effort only: copy-on-write and journaling filesystems, or SSDs
+// remapping blocks, may still keep the activation code elsewhere on disk.
+func secureDelete(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	zeros := make([]byte, 4096)
+	for remaining := info.Size(); remaining > 0; {
+		n := int64(len(zeros))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := f.Write(zeros[:n]); err != nil {
+			f.Close()
+			return err
+		}
+		remaining -= n
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,221 @@
+package hybrid
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func TestParseEnrollmentFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrollment")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlPath := filepath.Join(dir, "enrollment.yaml")
+	ioutil.WriteFile(yamlPath, []byte("regionId: cn-test100\nactivationCode: code\nactivationId: id\nnetworkMode: vpc\n"), 0600)
+	enrollmentConfig, err := ParseEnrollmentFile(yamlPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "cn-test100", enrollmentConfig.RegionId)
+	assert.Equal(t, "code", enrollmentConfig.ActivationCode)
+	assert.Equal(t, "id", enrollmentConfig.ActivationId)
+	assert.Equal(t, "vpc", enrollmentConfig.NetworkMode)
+
+	jsonPath := filepath.Join(dir, "enrollment.json")
+	ioutil.WriteFile(jsonPath, []byte(`{"regionId":"cn-test100","activationCode":"code","activationId":"id","instanceName":"test"}`), 0600)
+	enrollmentConfig, err = ParseEnrollmentFile(jsonPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", enrollmentConfig.InstanceName)
+
+	ioutil.WriteFile(jsonPath, []byte(`{"regionId":"cn-test100","activationId":"id"}`), 0600)
+	_, err = ParseEnrollmentFile(jsonPath)
+	assert.Error(t, err)
+
+	ioutil.WriteFile(yamlPath, []byte("regionId: cn-test100\nactivationCode: code\nactivationId: id\nnetworkMode: classic\n"), 0600)
+	_, err = ParseEnrollmentFile(yamlPath)
+	assert.Error(t, err)
+}
+
+func TestSecureDelete(t *testing.T) {
+	f, err := ioutil.TempFile("", "enrollment")
+	assert.NoError(t, err)
+	f.WriteString("activationCode: secret")
+	f.Close()
+
+	assert.NoError(t, secureDelete(f.Name()))
+	assert.False(t, util.CheckFileIsExist(f.Name()))
+}
+
+func skipWait(time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	c <- time.Now()
+	return c
+}
+
+func TestEnroll(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	enrollAfter = skipWait
+	defer func() { enrollAfter = time.After }()
+
+	region := "cn-test100"
+	util.MockMetaServer(region)
+
+	f, err := ioutil.TempFile("", "enrollment")
+	assert.NoError(t, err)
+	f.WriteString("regionId: cn-test100\nactivationCode: code\nactivationId: id\n")
+	f.Close()
+	defer os.Remove(f.Name())
+	os.Setenv(EnrollmentFileEnv, f.Name())
+	defer os.Unsetenv(EnrollmentFileEnv)
+
+	// Error page of gateway and server error are retried until registered
+	url := "https://" + region + util.HYBRID_DOMAIN + "/luban/api/instance/register"
+	attempts := 0
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return httpmock.NewStringResponse(200, "<html>bad gateway</html>"), nil
+		}
+		if attempts < 3 {
+			return httpmock.NewStringResponse(503, "unavailable"), nil
+		}
+		return httpmock.NewStringResponse(200, `{"code":200,"instanceId":"xx-123"}`), nil
+	})
+
+	assert.True(t, Enroll(make(chan struct{})))
+	defer clean_unregister_data(false)
+
+	assert.Equal(t, 3, attempts)
+	assert.True(t, util.IsHybrid())
+	assert.False(t, util.CheckFileIsExist(f.Name()))
+	status, err := ReadEnrollmentStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, EnrollmentStateEnrolled, status.State)
+	assert.Equal(t, "xx-123", status.InstanceId)
+	assert.Equal(t, 3, status.Attempts)
+}
+
+func TestEnrollRejected(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	enrollAfter = skipWait
+	defer func() { enrollAfter = time.After }()
+
+	region := "cn-test100"
+	util.MockMetaServer(region)
+
+	f, err := ioutil.TempFile("", "enrollment")
+	assert.NoError(t, err)
+	f.WriteString(`{"regionId":"cn-test100","activationCode":"code","activationId":"id"}`)
+	f.Close()
+	defer os.Remove(f.Name())
+	os.Setenv(EnrollmentFileEnv, f.Name())
+	defer os.Unsetenv(EnrollmentFileEnv)
+
+	url := "https://" + region + util.HYBRID_DOMAIN + "/luban/api/instance/register"
+	attempts := 0
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return httpmock.NewStringResponse(200, `{"code":403}`), nil
+	})
+
+	assert.True(t, Enroll(make(chan struct{})))
+
+	assert.Equal(t, 1, attempts)
+	assert.False(t, util.IsHybrid())
+	// Enrollment file is kept for fixing and enrolling again
+	assert.True(t, util.CheckFileIsExist(f.Name()))
+	status, err := ReadEnrollmentStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, EnrollmentStateFailed, status.State)
+	assert.Contains(t, status.Error, ErrRegisterRejected.Error())
+}
+
+func TestEnrollStopped(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+
+	region := "cn-test100"
+	util.MockMetaServer(region)
+
+	f, err := ioutil.TempFile("", "enrollment")
+	assert.NoError(t, err)
+	f.WriteString("regionId: cn-test100\nactivationCode: code\nactivationId: id\n")
+	f.Close()
+	defer os.Remove(f.Name())
+	os.Setenv(EnrollmentFileEnv, f.Name())
+	defer os.Unsetenv(EnrollmentFileEnv)
+
+	url := "https://" + region + util.HYBRID_DOMAIN + "/luban/api/instance/register"
+	attempts := 0
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return httpmock.NewStringResponse(503, "unavailable"), nil
+	})
+
+	// Stopping agent does not wait for backoff
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, Enroll(stop))
+
+	assert.Equal(t, 1, attempts)
+	assert.False(t, util.IsHybrid())
+	assert.True(t, util.CheckFileIsExist(f.Name()))
+	status, err := ReadEnrollmentStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, EnrollmentStateEnrolling, status.State)
+}
+
+func TestEnrollInsecureFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file mode is not checked on windows")
+	}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+
+	region := "cn-test100"
+	util.MockMetaServer(region)
+
+	f, err := ioutil.TempFile("", "enrollment")
+	assert.NoError(t, err)
+	f.WriteString("regionId: cn-test100\nactivationCode: code\nactivationId: id\n")
+	f.Close()
+	defer os.Remove(f.Name())
+	assert.NoError(t, os.Chmod(f.Name(), 0644))
+	os.Setenv(EnrollmentFileEnv, f.Name())
+	defer os.Unsetenv(EnrollmentFileEnv)
+
+	url := "https://" + region + util.HYBRID_DOMAIN + "/luban/api/instance/register"
+	attempts := 0
+	httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return httpmock.NewStringResponse(200, `{"code":200,"instanceId":"xx-123"}`), nil
+	})
+
+	assert.True(t, Enroll(make(chan struct{})))
+
+	assert.Equal(t, 0, attempts)
+	assert.False(t, util.IsHybrid())
+	assert.True(t, util.CheckFileIsExist(f.Name()))
+	status, err := ReadEnrollmentStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, EnrollmentStateFailed, status.State)
+	assert.Contains(t, status.Error, "0644")
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -40,14 +41,35 @@ type RegisterInfo struct {
 type registerResponse struct {
 	Code       int    `json:"code"`
 	InstanceId string `json:"instanceId"`
+	// ServerPublicKey is PEM encoded public key to verify signed kick_vm
+	// commands, which is pinned once registered
+	ServerPublicKey string `json:"serverPublicKey"`
 }
 
 type unregisterResponse struct {
 	Code int `json:"code"`
 }
 
+type rotateKeyRequest struct {
+	PublicKeyBase64 string `json:"publicKey"`
+}
+
+type rotateKeyResponse struct {
+	Code int `json:"code"`
+}
+
+var (
+	ErrAlreadyRegistered = errors.New("agent already register, deregister first")
+	// ErrRegisterRejected marks failure of registration which would not be
+	// fixed by retrying, e.g., invalid activation code
+	ErrRegisterRejected = errors.New("register rejected by server")
+	// ErrRegisterUnavailable marks failure of registration which may succeed
+	// later, e.g., network error or server error
+	ErrRegisterUnavailable = errors.New("register service unavailable")
+)
+
 func Register(region string, code string, id string, name string, networkmode string, need_restart bool) (ret bool) {
-	log.GetLogger().Infoln(region, code, id, name)
+	log.GetLogger().Infoln(region, id, name)
 	errmsg := ""
 	defer func() {
 		msgkey := "info"
@@ -63,13 +85,33 @@ func Register(region string, code string, id string, name string, networkmode st
 		).ReportEvent()
 	}()
 
-	ret = true
 	if util.IsHybrid() {
 		fmt.Println("error, agent already register, deregister first")
 		errmsg = "error, agent already register, deregister first"
 		log.GetLogger().Infoln("error, agent already register, deregister first")
 		return false
 	}
+	instanceId, err := register(region, code, id, name, networkmode)
+	if err != nil {
+		errmsg = err.Error()
+		fmt.Println("register failed:", err)
+		return false
+	}
+	errmsg = fmt.Sprintf("register ok, instanceid=%s", instanceId)
+	fmt.Println("register ok")
+	fmt.Println("instance id:", instanceId)
+	if need_restart {
+		restartService()
+	}
+	fmt.Println("restart service")
+	return true
+}
+
+// register requests server to register the instance and saves credentials
+// when accepted. Returned error wraps ErrRegisterRejected when server refused
+// the request explicitly, or ErrRegisterUnavailable when the request may
+// succeed by retrying.
+func register(region string, code string, id string, name string, networkmode string) (string, error) {
 	hostname, _ := os.Hostname()
 	osType := "unknown"
 	if runtime.GOOS == "windows" {
@@ -78,13 +120,13 @@ func Register(region string, code string, id string, name string, networkmode st
 		osType = "linux"
 	}
 	ip, _ := osutil.ExternalIP()
+	// Refuse registering if private key could not be saved encrypted
+	if err := util.CheckHybridKeyProtector(); err != nil {
+		return "", err
+	}
 	var pub, pri bytes.Buffer
-	err := genRsaKey(&pub, &pri)
-	if err != nil {
-
-		errmsg = fmt.Sprintf("generate rsa key error: %s", err.Error())
-		fmt.Println("error, generate rsa key failed")
-		return false
+	if err := genRsaKey(&pub, &pri); err != nil {
+		return "", fmt.Errorf("generate rsa key error: %w", err)
 	}
 	encodeString := base64.StdEncoding.EncodeToString(pub.Bytes())
 	mid, _ := util.GetMachineID()
@@ -102,62 +144,56 @@ func Register(region string, code string, id string, name string, networkmode st
 		Id:              id,
 	}
 	jsonBytes, _ := json.Marshal(*info)
- 	var response string
 	domain := util.HYBRID_DOMAIN
 	if networkmode == "vpc" {
 		domain = util.HYBRID_DOMAIN_VPC
 	}
-	url := "https://" + region + domain + "/luban/api/instance/register"	
-	response, err = util.HttpPost(url, string(jsonBytes), "")
+	url := "https://" + region + domain + "/luban/api/instance/register"
+	response, err := util.HttpPost(url, string(jsonBytes), "")
 	if err != nil {
-		ret = false
-		errmsg = fmt.Sprintf("register request err: %s, url=%s", err.Error(), url)
-		fmt.Println(response, err)
-		return
+		// Server error and throttling are worth retrying, other errors of
+		// HTTP status mean the request itself is unacceptable
+		if httpErr, ok := err.(*util.HttpErrorCode); ok {
+			if code := httpErr.GetCode(); code < 500 && code != 429 {
+				return "", fmt.Errorf("%w: register request err: %s, url=%s, response=%s", ErrRegisterRejected, err.Error(), url, response)
+			}
+		}
+		return "", fmt.Errorf("%w: register request err: %s, url=%s", ErrRegisterUnavailable, err.Error(), url)
 	}
 
+	// Response not in JSON is usually an error page of gateway or proxy
 	if !gjson.Valid(response) {
-		ret = false
-		errmsg = fmt.Sprintf("invalid task info json, url=%s, response=%s", url, response)
-		fmt.Println("invalid task info json:", response)
-		return
+		return "", fmt.Errorf("%w: invalid task info json, url=%s, response=%s", ErrRegisterUnavailable, url, response)
 	}
 
 	var register_response registerResponse
-	if err := json.Unmarshal([]byte(response), &register_response); err == nil {
-		if register_response.Code == 200 {
-			var path string
-			if util.IsSelfHosted() {
-				path, _ = util.GetSelfhostedPath()
-			} else {
-				path, _ = util.GetHybridPath()
-			}
-			util.WriteStringToFile(path+"/network-mode", networkmode)
-			util.WriteStringToFile(path+"/pub-key", pub.String())
-			util.WriteStringToFile(path+"/pri-key", pri.String())
-			util.WriteStringToFile(path+"/region-id", region)
-			util.WriteStringToFile(path+"/instance-id", register_response.InstanceId)
-			util.WriteStringToFile(path+"/machine-id", mid)
-		} else {
-			ret = false
-		}
+	if err := json.Unmarshal([]byte(response), &register_response); err != nil {
+		return "", fmt.Errorf("invalid register response: %s, url=%s, response=%s", err.Error(), url, response)
+	}
+	if register_response.Code != 200 {
+		return "", fmt.Errorf("%w: register failed, responsecode=%d, url=%s, response=%s", ErrRegisterRejected, register_response.Code, url, response)
 	}
 
-	if !ret {
-		errmsg = fmt.Sprintf("register failed, responsecode=%d, url=%s", register_response.Code, url)
-		fmt.Println("register failed")
-		fmt.Println(response)
-		return
+	var path string
+	if util.IsSelfHosted() {
+		path, _ = util.GetSelfhostedPath()
 	} else {
-		errmsg = fmt.Sprintf("register ok, instanceid=%s", register_response.InstanceId)
-		fmt.Println("register ok")
-		fmt.Println("instance id:", register_response.InstanceId)
-		if need_restart {
-			restartService()
-		}
-		fmt.Println("restart service")
+		path, _ = util.GetHybridPath()
+	}
+	// Private key must be saved before instance id, which marks the agent as
+	// registered
+	if err := util.SaveHybridPrivateKey(path, pri.Bytes()); err != nil {
+		return "", fmt.Errorf("save private key error: %w", err)
 	}
-	return
+	util.WriteStringToFile(path+"/network-mode", networkmode)
+	util.WriteStringToFile(path+"/pub-key", pub.String())
+	util.WriteStringToFile(path+"/region-id", region)
+	util.WriteStringToFile(path+"/instance-id", register_response.InstanceId)
+	util.WriteStringToFile(path+"/machine-id", mid)
+	if register_response.ServerPublicKey != "" {
+		util.WriteStringToFile(path+"/server-pub-key", register_response.ServerPublicKey)
+	}
+	return register_response.InstanceId, nil
 }
 
 func UnRegister(need_restart bool) bool {
@@ -206,6 +242,85 @@ func UnRegister(need_restart bool) bool {
 	return ret
 }
 
+// RotateKey registers a new public key of the instance with server in request
+// signed by current private key, and replaces the private key once server
+// accepted the new one
+func RotateKey(need_restart bool) (ret bool) {
+	errmsg := ""
+	defer func() {
+		msgkey := "info"
+		status := "success"
+		if !ret {
+			msgkey = "errmsg"
+			status = "failed"
+		}
+		metrics.GetHybridRotateKeyEvent(
+			ret,
+			"status", status,
+			msgkey, errmsg,
+		).ReportEvent()
+	}()
+
+	if !util.IsHybrid() {
+		errmsg = "error, agent is not registered"
+		fmt.Println(errmsg)
+		return false
+	}
+	path, err := util.GetHybridCredentialPath()
+	if err != nil {
+		errmsg = fmt.Sprintf("get credential path error: %s", err.Error())
+		fmt.Println(errmsg)
+		return false
+	}
+	var pub, pri bytes.Buffer
+	if err := genRsaKey(&pub, &pri); err != nil {
+		errmsg = fmt.Sprintf("generate rsa key error: %s", err.Error())
+		fmt.Println("error, generate rsa key failed")
+		return false
+	}
+	// Keep the new key on disk before server accepts it, which would be lost
+	// otherwise
+	if err := util.StageHybridPrivateKey(path, pri.Bytes()); err != nil {
+		errmsg = fmt.Sprintf("save private key error: %s", err.Error())
+		fmt.Println("error, save private key failed:", err)
+		return false
+	}
+
+	jsonBytes, _ := json.Marshal(rotateKeyRequest{
+		PublicKeyBase64: base64.StdEncoding.EncodeToString(pub.Bytes()),
+	})
+	url := "https://" + util.GetServerHost() + "/luban/api/instance/rotate_key"
+	response, err := util.HttpPost(url, string(jsonBytes), "")
+	if err != nil {
+		util.DiscardStagedHybridPrivateKey(path)
+		errmsg = fmt.Sprintf("rotate key request err: %s, url=%s", err.Error(), url)
+		fmt.Println(response, err)
+		return false
+	}
+	var rotate_response rotateKeyResponse
+	if err := json.Unmarshal([]byte(response), &rotate_response); err != nil || rotate_response.Code != 200 {
+		util.DiscardStagedHybridPrivateKey(path)
+		errmsg = fmt.Sprintf("rotate key failed, responsecode=%d, url=%s", rotate_response.Code, url)
+		fmt.Println("rotate key failed")
+		fmt.Println(response)
+		return false
+	}
+
+	if err := util.CommitStagedHybridPrivateKey(path); err != nil {
+		errmsg = fmt.Sprintf("replace private key error: %s", err.Error())
+		fmt.Println("error, new key is accepted by server but failed to replace private key:", err)
+		return false
+	}
+	util.WriteStringToFile(path+"/pub-key", pub.String())
+	errmsg = "rotate key ok"
+	fmt.Println("rotate key ok")
+	if need_restart {
+		restartService()
+		fmt.Println("restart service")
+	}
+	return true
+}
+
 //RSA公钥私钥产生
 func genRsaKey(pub io.Writer, pri io.Writer) error {
 	// 生成私钥文件
@@ -256,9 +371,13 @@ func clean_unregister_data(need_restart bool) {
 	path, _ := util.GetHybridPath()
 	os.Remove(path + "/pub-key")
 	os.Remove(path + "/pri-key")
+	os.Remove(path + "/" + util.HybridEncryptedPrivateKeyFile)
+	util.ResetHybridPrivateKey()
 	os.Remove(path + "/region-id")
 	os.Remove(path + "/instance-id")
 	os.Remove(path + "/machine-id")
+	os.Remove(path + "/server-pub-key")
+	os.Remove(path + "/" + EnrollmentStatusFile)
 
 	if need_restart {
 		restartService()
@@ -6,9 +6,17 @@ import (
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"os"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	// Registering must not depend on passphrase, private key is sealed with
+	// machine id when no machine-bound key storage exists, e.g., on build hosts
+	os.Unsetenv(util.HybridKeyPassphraseEnv)
+	os.Exit(m.Run())
+}
+
 func TestKeyPair(t *testing.T) {
     var pub,pri bytes.Buffer
 	err := genRsaKey(&pub, &pri)
@@ -31,7 +39,7 @@ func TestRegister(t *testing.T) {
 	httpmock.RegisterResponder("POST", url,
 		httpmock.NewStringResponder(200, `{"code":200,"instanceId":"xx-123"}`))
 
-	ret := Register(region, "test_code", "test_id", "test_machine", false)
+	ret := Register(region, "test_code", "test_id", "test_machine", "", false)
 
 	assert.True(t, ret)
 	path,_ := util.GetHybridPath()
@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"github.com/aliyun/aliyun_assist_client/agent/inventory/gatherers"
+	"github.com/aliyun/aliyun_assist_client/agent/inventory/gatherers/file"
+	"github.com/aliyun/aliyun_assist_client/agent/inventory/gatherers/registry"
 	"github.com/aliyun/aliyun_assist_client/agent/inventory/model"
 	"github.com/aliyun/aliyun_assist_client/agent/inventory/uploader"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
@@ -27,6 +29,21 @@ var (
 	windowsOnlyTypes = []string{"ACS:Service", "ACS:WindowsRole", "ACS:WindowsRegistry", "ACS:WindowsUpdate"}
 )
 
+// DefaultPolicy enables all installed gatherers except those collecting
+// nothing without filters, i.e., file and registry gatherers. Gatherers not
+// supported by current OS are ignored by RunGatherers.
+func DefaultPolicy() model.Policy {
+	_, installedGatherers := gatherers.InitializeGatherers()
+	policy := model.Policy{InventoryPolicy: map[string]model.Config{}}
+	for name := range installedGatherers {
+		if name == file.GathererName || name == registry.GathererName {
+			continue
+		}
+		policy.InventoryPolicy[name] = model.Config{Collection: model.Enabled}
+	}
+	return policy
+}
+
 func RunGatherers(policy model.Policy) (items []model.Item, err error) {
 	_, installedGatherers := gatherers.InitializeGatherers()
 	applyGathererNames := collectGathererNames(policy)
@@ -1,7 +1,6 @@
 package kickvmhandle
 
 import (
-	"errors"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"github.com/aliyun/aliyun_assist_client/agent/util/process"
@@ -23,13 +22,13 @@ func stopAgant(params []string) error {
 	log.GetLogger().Println("stopAgant")
 	processer :=  process.ProcessCmd{}
 	if runtime.GOOS == "linux" {
-		processer.SyncRunSimple("aliyun-service", strings.Split("--stop", " "), 10)
+		return processer.SyncRunSimple("aliyun-service", strings.Split("--stop", " "), 10)
 	} else if runtime.GOOS == "windows" {
 		path, err := os.Executable()
 		if err != nil {
 			return err
 		}
-		processer.SyncRunSimple(path, strings.Split("--stop", " "), 10)
+		return processer.SyncRunSimple(path, strings.Split("--stop", " "), 10)
 	}
 	return nil
 }
@@ -37,18 +36,20 @@ func stopAgant(params []string) error {
 func removeAgant(params []string) error {
 	log.GetLogger().Println("removeAgant")
 	processer :=  process.ProcessCmd{}
-	if runtime.GOOS == "linux" {
-		processer.SyncRunSimple("aliyun-service", strings.Split("--remove", " "), 10)
-		processer.SyncRunSimple("aliyun-service", strings.Split("--stop", " "), 10)
-	} else if runtime.GOOS == "windows" {
-		path, err := os.Executable()
+	path := "aliyun-service"
+	if runtime.GOOS == "windows" {
+		var err error
+		path, err = os.Executable()
 		if err != nil {
 			return err
 		}
-		processer.SyncRunSimple(path, strings.Split("--remove", " "), 10)
-		processer.SyncRunSimple(path, strings.Split("--stop", " "), 10)
+	} else if runtime.GOOS != "linux" {
+		return nil
 	}
-	return nil
+	if err := processer.SyncRunSimple(path, strings.Split("--remove", " "), 10); err != nil {
+		return err
+	}
+	return processer.SyncRunSimple(path, strings.Split("--stop", " "), 10)
 }
 
 func updateAgant(params []string) error {
@@ -64,8 +65,7 @@ func updateAgant(params []string) error {
 		path += "aliyun_assist_update.exe"
 	}
 
-	processer.SyncRunSimple(path, strings.Split("--check_update", " "), 10)
-	return nil
+	return processer.SyncRunSimple(path, strings.Split("--check_update", " "), 10)
 }
 
 type AgentHandle struct {
@@ -83,11 +83,15 @@ func NewAgentHandle(action string, params []string) *AgentHandle{
 
 func (h *AgentHandle) DoAction() error{
 	if v, ok := agentRoute[h.action]; ok {
-		v(h.params)
-	} else {
-		return errors.New("no action found")
+		return v(h.params)
 	}
-	return nil
+	return ErrActionNotFound
+}
+
+// IsDeregister returns true for "kick_vm agent deregister", which is handled
+// by channel since it is not in agentRoute
+func (h *AgentHandle) IsDeregister() bool {
+	return h.action == "deregister" && len(h.params) == 0
 }
 
 func (h *AgentHandle) CheckAction() bool{
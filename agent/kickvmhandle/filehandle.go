@@ -1,8 +1,6 @@
 package kickvmhandle
 
 import (
-	"errors"
-
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
 )
@@ -20,7 +18,7 @@ func init ()  {
 func runFileTask(params []string) error {
 	log.GetLogger().Println("runFileTask")
 	if len(params) < 1 {
-		return errors.New("params error")
+		return ErrInvalidParameters
 	}
 	go func() {
 		taskengine.Fetch(true, params[0], taskengine.NormalTaskType, false)
@@ -31,7 +29,7 @@ func runFileTask(params []string) error {
 func stopFileTask(params []string) error {
 	log.GetLogger().Println("stopFileTask")
 	if len(params) < 1 {
-		return errors.New("params error")
+		return ErrInvalidParameters
 	}
 
 	go func() {
@@ -55,11 +53,9 @@ func NewFileHandle(action string, params []string) *FileHandle{
 
 func (h *FileHandle) DoAction() error{
 	if v, ok := fileRoute[h.action]; ok {
-		v(h.params)
-	} else {
-		return errors.New("no action found")
+		return v(h.params)
 	}
-	return nil
+	return ErrActionNotFound
 }
 
 func (h *FileHandle) CheckAction() bool{
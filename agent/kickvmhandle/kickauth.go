@@ -0,0 +1,242 @@
+package kickvmhandle
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+// Signed kick_vm command in text is in format
+//
+//	<command>|<timestamp>|<nonce>|<signature>
+//
+// where timestamp is Unix time in seconds, and signature is base64 encoded
+// RSA-SHA256 signature of "<command>\n<timestamp>\n<nonce>" signed by the
+// server private key, whose public key is pinned at registration.
+const (
+	signedKickSeparator = "|"
+
+	serverPublicKeyFilename = "server-pub-key"
+)
+
+var (
+	ErrKickUnsigned         = errors.New("command is not signed")
+	ErrKickExpired          = errors.New("command timestamp is out of allowed clock skew")
+	ErrKickReplayed         = errors.New("command nonce has been used")
+	ErrKickInvalidSignature = errors.New("command signature is invalid")
+	ErrKickNoServerKey      = errors.New("no server public key is pinned")
+)
+
+// SignedKick is a kick_vm command with its authentication fields, which are
+// empty for unsigned command
+type SignedKick struct {
+	Command   string
+	Timestamp int64
+	Nonce     string
+	Signature string
+}
+
+// ParseSignedKick splits authentication fields from message. Message not in
+// signed format is returned as unsigned command.
+func ParseSignedKick(msg string) SignedKick {
+	segments := strings.Split(msg, signedKickSeparator)
+	if len(segments) < 4 {
+		return SignedKick{Command: msg}
+	}
+	n := len(segments)
+	timestamp, err := strconv.ParseInt(segments[n-3], 10, 64)
+	if err != nil {
+		return SignedKick{Command: msg}
+	}
+	return SignedKick{
+		Command:   strings.Join(segments[:n-3], signedKickSeparator),
+		Timestamp: timestamp,
+		Nonce:     segments[n-2],
+		Signature: segments[n-1],
+	}
+}
+
+func (k *SignedKick) isSigned() bool {
+	return k.Nonce != "" && k.Signature != ""
+}
+
+func (k *SignedKick) signingInput() []byte {
+	return []byte(fmt.Sprintf("%s\n%d\n%s", k.Command, k.Timestamp, k.Nonce))
+}
+
+// RequiresSignature returns true for handle parsed by ParseOption able to stop,
+// remove, update or deregister agent. Shutting down the instance by
+// guest-shutdown always requires signature.
+func RequiresSignature(handle KickHandle) bool {
+	_, ok := handle.(*AgentHandle)
+	return ok
+}
+
+// kickVerifier verifies signature, timestamp and nonce of signed commands.
+// Nonces are remembered until their timestamp falls out of allowed clock
+// skew, after which replayed commands are rejected as expired.
+type kickVerifier struct {
+	lock    sync.Mutex
+	nonces  map[string]time.Time
+	maxSkew time.Duration
+	now     func() time.Time
+	// publicKey returns PEM encoded server public key
+	publicKey func() ([]byte, error)
+}
+
+var (
+	_kickVerifier     *kickVerifier
+	_kickVerifierOnce sync.Once
+)
+
+func getKickVerifier() *kickVerifier {
+	_kickVerifierOnce.Do(func() {
+		authConfig := config.GetConfig().KickAuth
+		_kickVerifier = newKickVerifier(time.Duration(authConfig.MaxClockSkewSeconds)*time.Second,
+			loadServerPublicKey)
+	})
+	return _kickVerifier
+}
+
+func newKickVerifier(maxSkew time.Duration, publicKey func() ([]byte, error)) *kickVerifier {
+	return &kickVerifier{
+		nonces:    make(map[string]time.Time),
+		maxSkew:   maxSkew,
+		now:       time.Now,
+		publicKey: publicKey,
+	}
+}
+
+func (v *kickVerifier) verify(kick SignedKick) error {
+	if !kick.isSigned() {
+		return ErrKickUnsigned
+	}
+	now := v.now()
+	issuedAt := time.Unix(kick.Timestamp, 0)
+	if issuedAt.Before(now.Add(-v.maxSkew)) || issuedAt.After(now.Add(v.maxSkew)) {
+		return ErrKickExpired
+	}
+	publicKey, err := v.publicKey()
+	if err != nil {
+		return ErrKickNoServerKey
+	}
+	if err := util.RsaVerifyWithSHA256(kick.signingInput(), kick.Signature, publicKey); err != nil {
+		return ErrKickInvalidSignature
+	}
+
+	// Only nonces of authentic commands are remembered, thus forged commands
+	// could not flood the cache
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for nonce, expireAt := range v.nonces {
+		if now.After(expireAt) {
+			delete(v.nonces, nonce)
+		}
+	}
+	if _, ok := v.nonces[kick.Nonce]; ok {
+		return ErrKickReplayed
+	}
+	v.nonces[kick.Nonce] = issuedAt.Add(v.maxSkew)
+	return nil
+}
+
+func isKickAuthRequired() bool {
+	mode := config.GetConfig().KickAuth.Mode
+	if mode != config.KickAuthModeAuto {
+		return kickAuthRequired(mode, false, false)
+	}
+	managed := util.IsHybrid() || util.IsSelfHosted()
+	return kickAuthRequired(mode, managed, managed && isServerPublicKeyPinned())
+}
+
+// kickAuthRequired tells whether sensitive commands must be signed. In auto
+// mode, hybrid or self-hosted instances registered before server public key
+// was pinned accept unsigned commands, otherwise they could never be updated
+// or stopped remotely. Only enforce mode rejects unsigned commands regardless.
+func kickAuthRequired(mode string, managed bool, pinned bool) bool {
+	switch mode {
+	case config.KickAuthModeEnforce:
+		return true
+	case config.KickAuthModeDisabled:
+		return false
+	default:
+		return managed && pinned
+	}
+}
+
+// isServerPublicKeyPinned returns true if server public key is pinned at
+// registration, or configured explicitly even if the file is missing
+func isServerPublicKeyPinned() bool {
+	if config.GetConfig().KickAuth.ServerPublicKeyFile != "" {
+		return true
+	}
+	path, err := serverPublicKeyPath()
+	return err == nil && util.CheckFileIsExist(path)
+}
+
+func serverPublicKeyPath() (string, error) {
+	if path := config.GetConfig().KickAuth.ServerPublicKeyFile; path != "" {
+		return path, nil
+	}
+	if util.IsSelfHosted() {
+		dir, err := util.GetSelfhostedPath()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, serverPublicKeyFilename), nil
+	}
+	dir, err := util.GetHybridPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, serverPublicKeyFilename), nil
+}
+
+func loadServerPublicKey() ([]byte, error) {
+	path, err := serverPublicKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// AuthorizeKick checks whether command received from channel is allowed to
+// be executed. Sensitive commands, as told by RequiresSignature on the parsed
+// command, must be signed when authentication is required, and every
+// rejection is reported via metrics.
+func AuthorizeKick(kick SignedKick, sensitive bool, channelType string) error {
+	if !sensitive || !isKickAuthRequired() {
+		return nil
+	}
+	err := getKickVerifier().verify(kick)
+	if err == nil {
+		return nil
+	}
+
+	log.GetLogger().WithFields(logrus.Fields{
+		"command":   kick.Command,
+		"channel":   channelType,
+		"timestamp": kick.Timestamp,
+		"nonce":     kick.Nonce,
+	}).WithError(err).Errorln("Rejected unauthorized kick_vm command")
+	metrics.GetKickRejectedEvent(
+		"command", kick.Command,
+		"channel", channelType,
+		"reason", err.Error(),
+		"timestamp", strconv.FormatInt(kick.Timestamp, 10),
+		"nonce", kick.Nonce,
+	).ReportEvent()
+	return err
+}
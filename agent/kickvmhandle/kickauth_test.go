@@ -0,0 +1,146 @@
+package kickvmhandle
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+)
+
+func signKick(t *testing.T, privateKey *rsa.PrivateKey, kick SignedKick) SignedKick {
+	hashed := sha256.Sum256(kick.signingInput())
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	assert.NoError(t, err)
+	kick.Signature = base64.StdEncoding.EncodeToString(signature)
+	return kick
+}
+
+func TestParseSignedKick(t *testing.T) {
+	kick := ParseSignedKick("kick_vm agent stop")
+	assert.Equal(t, SignedKick{Command: "kick_vm agent stop"}, kick)
+	assert.False(t, kick.isSigned())
+
+	kick = ParseSignedKick("kick_vm agent stop|1600000000|abc|c2ln")
+	assert.Equal(t, SignedKick{
+		Command:   "kick_vm agent stop",
+		Timestamp: 1600000000,
+		Nonce:     "abc",
+		Signature: "c2ln",
+	}, kick)
+	assert.True(t, kick.isSigned())
+
+	kick = ParseSignedKick("kick_vm file a|b|1600000000|abc|c2ln")
+	assert.Equal(t, "kick_vm file a|b", kick.Command)
+
+	kick = ParseSignedKick("kick_vm file a|b|c|d")
+	assert.Equal(t, SignedKick{Command: "kick_vm file a|b|c|d"}, kick)
+}
+
+func TestRequiresSignature(t *testing.T) {
+	assert.True(t, RequiresSignature(ParseOption("kick_vm agent stop")))
+	assert.True(t, RequiresSignature(ParseOption("kick_vm  agent\tderegister")))
+	assert.False(t, RequiresSignature(ParseOption("kick_vm")))
+	assert.False(t, RequiresSignature(ParseOption("kick_vm task run t-xxx")))
+	assert.False(t, RequiresSignature(ParseOption("")))
+
+	// Commands not starting with kick_vm are never executed
+	assert.Nil(t, ParseOption("x agent stop"))
+	assert.Nil(t, ParseOption("foo kick_vm agent deregister"))
+	assert.Nil(t, ParseOption("kick_vm agent"))
+
+	handle, ok := ParseOption("kick_vm agent deregister").(*AgentHandle)
+	assert.True(t, ok && handle.IsDeregister())
+	handle, ok = ParseOption("kick_vm agent deregister x").(*AgentHandle)
+	assert.True(t, ok && !handle.IsDeregister())
+}
+
+func TestKickVerifier(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	derPkix, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.NoError(t, err)
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: derPkix})
+
+	now := time.Unix(1600000000, 0)
+	verifier := newKickVerifier(5*time.Minute, func() ([]byte, error) {
+		return publicKey, nil
+	})
+	verifier.now = func() time.Time { return now }
+
+	kick := signKick(t, privateKey, SignedKick{
+		Command:   "kick_vm agent stop",
+		Timestamp: now.Unix(),
+		Nonce:     "nonce-1",
+	})
+	assert.NoError(t, verifier.verify(kick))
+	assert.Equal(t, ErrKickReplayed, verifier.verify(kick))
+
+	assert.Equal(t, ErrKickUnsigned, verifier.verify(SignedKick{Command: "kick_vm agent stop"}))
+
+	tampered := kick
+	tampered.Command = "kick_vm agent remove"
+	tampered.Nonce = "nonce-2"
+	assert.Equal(t, ErrKickInvalidSignature, verifier.verify(tampered))
+
+	expired := signKick(t, privateKey, SignedKick{
+		Command:   "kick_vm agent stop",
+		Timestamp: now.Add(-10 * time.Minute).Unix(),
+		Nonce:     "nonce-3",
+	})
+	assert.Equal(t, ErrKickExpired, verifier.verify(expired))
+
+	future := signKick(t, privateKey, SignedKick{
+		Command:   "kick_vm agent stop",
+		Timestamp: now.Add(10 * time.Minute).Unix(),
+		Nonce:     "nonce-4",
+	})
+	assert.Equal(t, ErrKickExpired, verifier.verify(future))
+
+	// Nonces are forgotten once out of clock skew
+	now = now.Add(6 * time.Minute)
+	assert.Equal(t, ErrKickExpired, verifier.verify(kick))
+	fresh := signKick(t, privateKey, SignedKick{
+		Command:   "kick_vm agent stop",
+		Timestamp: now.Unix(),
+		Nonce:     fmt.Sprintf("nonce-%d", now.Unix()),
+	})
+	assert.NoError(t, verifier.verify(fresh))
+	assert.Len(t, verifier.nonces, 1)
+
+	verifier.publicKey = func() ([]byte, error) {
+		return nil, errors.New("not found")
+	}
+	fresh.Nonce = "nonce-5"
+	assert.Equal(t, ErrKickNoServerKey, verifier.verify(fresh))
+}
+
+func TestKickAuthRequired(t *testing.T) {
+	tests := []struct {
+		mode     string
+		managed  bool
+		pinned   bool
+		required bool
+	}{
+		{config.KickAuthModeAuto, false, false, false},
+		// Instances registered before server key was pinned stay manageable
+		{config.KickAuthModeAuto, true, false, false},
+		{config.KickAuthModeAuto, true, true, true},
+		{config.KickAuthModeEnforce, false, false, true},
+		{config.KickAuthModeEnforce, true, false, true},
+		{config.KickAuthModeDisabled, true, true, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.required, kickAuthRequired(tt.mode, tt.managed, tt.pinned), "%+v", tt)
+	}
+}
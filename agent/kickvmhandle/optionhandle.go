@@ -30,10 +30,20 @@ func NewHealthCheckHandle() *HealthCheckHandle{
 	}
 }
 
+// ParseOption parses command in format of "kick_vm kick_type action params...",
+// and returns nil for anything else. Commands are split by whitespace as
+// RequiresSignature checks the parsed handle, thus what is checked is exactly
+// what is executed.
 func ParseOption(input string) KickHandle {
-    arrays := strings.Split(input, " ")
-    if len(arrays) < 2 {
-    	return nil
+	arrays := strings.Fields(input)
+	if len(arrays) < 2 || arrays[0] != "kick_vm" {
+		return nil
+	}
+	if arrays[1] == "noop" {
+		return NewHealthCheckHandle()
+	}
+	if len(arrays) < 3 {
+		return nil
 	}
 	var handle KickHandle = nil
 	if arrays[1] == "agent" {
@@ -42,8 +52,6 @@ func ParseOption(input string) KickHandle {
 		handle = NewTaskHandle(arrays[2],arrays[3:])
 	} else if arrays[1] == "session" {
 		handle = NewSessionHandle(arrays[2],arrays[3:])
-	} else if arrays[1] == "noop" {
-		handle = NewHealthCheckHandle()
 	} else if arrays[1] == "file" {
 		handle = NewFileHandle(arrays[2],arrays[3:])
 	} else if arrays[1] == "status" {
@@ -0,0 +1,272 @@
+package kickvmhandle
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+	"github.com/aliyun/aliyun_assist_client/agent/version"
+)
+
+const (
+	ActionStatusRunning   = "Running"
+	ActionStatusSucceeded = "Succeeded"
+	ActionStatusFailed    = "Failed"
+	// ActionStatusInterrupted means agent restarted before the action
+	// finished, and whether it took effect is unknown
+	ActionStatusInterrupted = "Interrupted"
+
+	ActionErrUnknownAction     = "UnknownAction"
+	ActionErrInvalidParameters = "InvalidParameters"
+	ActionErrExecutionFailed   = "ExecutionFailed"
+	ActionErrAgentRestarted    = "AgentRestarted"
+
+	pendingActionDirName = "kick_actions"
+	// outboxKeyPrefix keeps results of actions apart from results of tasks,
+	// which are keyed by task id in the same outbox
+	outboxKeyPrefix = "kick_action."
+)
+
+var (
+	ErrActionNotFound    = errors.New("no action found")
+	ErrInvalidParameters = errors.New("params error")
+
+	// Action id is used as file name of result as well
+	validActionIdPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+)
+
+// ActionResult is reported to server after kick_vm action finished
+type ActionResult struct {
+	ActionId     string      `json:"actionId"`
+	Command      string      `json:"command"`
+	Status       string      `json:"status"`
+	ErrorCode    string      `json:"errorCode,omitempty"`
+	ErrorMessage string      `json:"errorMessage,omitempty"`
+	Payload      interface{} `json:"payload,omitempty"`
+	StartTime    int64       `json:"startTime"`
+	EndTime      int64       `json:"endTime,omitempty"`
+}
+
+// pendingAction is saved before running action which stops agent, with
+// version of agent when the action started
+type pendingAction struct {
+	ActionResult
+	AgentVersion string `json:"agentVersion,omitempty"`
+}
+
+// ActionId returns nonce of signed command as action id, which is assigned by
+// server, or a generated one for unsigned command
+func (k *SignedKick) ActionId() string {
+	if !validActionIdPattern.MatchString(k.Nonce) {
+		k.Nonce = "kick-" + uuid.New().String()
+	}
+	return k.Nonce
+}
+
+// agentAction returns action of "kick_vm agent <action>" command, or empty
+// string for other commands
+func agentAction(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) < 3 || fields[0] != "kick_vm" || fields[1] != "agent" {
+		return ""
+	}
+	return fields[2]
+}
+
+// restartsAgent returns true for actions which would stop agent itself, whose
+// results could only be known after agent started again
+func restartsAgent(command string) bool {
+	switch agentAction(command) {
+	case "stop", "remove", "update":
+		return true
+	}
+	return false
+}
+
+func errorCodeOf(err error) string {
+	switch {
+	case errors.Is(err, ErrActionNotFound):
+		return ActionErrUnknownAction
+	case errors.Is(err, ErrInvalidParameters), errors.Is(err, ErrStatusNetworkInvalidParameters):
+		return ActionErrInvalidParameters
+	default:
+		return ActionErrExecutionFailed
+	}
+}
+
+// RunAction runs action of kick_vm command and reports its result
+func RunAction(handle KickHandle, kick SignedKick) *ActionResult {
+	result := &ActionResult{
+		ActionId:  kick.ActionId(),
+		Command:   kick.Command,
+		Status:    ActionStatusRunning,
+		StartTime: time.Now().UnixNano() / 1e6,
+	}
+	pending := restartsAgent(kick.Command)
+	if pending {
+		savePendingAction(&pendingAction{
+			ActionResult: *result,
+			AgentVersion: version.AssistVersion,
+		})
+	}
+
+	err := handle.DoAction()
+	if pending && err != nil && taskengine.IsShuttingDown() {
+		// Command stopping agent may time out while agent is shutting down
+		// gracefully, thus result would be reported after agent started again
+		log.GetLogger().WithField("actionId", result.ActionId).WithError(err).Warningln("kick_vm action is interrupted by agent shutdown")
+		return result
+	}
+	result.EndTime = time.Now().UnixNano() / 1e6
+	if err != nil {
+		result.Status = ActionStatusFailed
+		result.ErrorCode = errorCodeOf(err)
+		result.ErrorMessage = err.Error()
+	} else {
+		result.Status = ActionStatusSucceeded
+	}
+
+	if pending {
+		removePendingAction(result.ActionId)
+	}
+	ReportActionResult(result)
+	return result
+}
+
+// ReportActionResult queues result into outbox, which delivers it to server
+// with retrying
+func ReportActionResult(result *ActionResult) {
+	logger := log.GetLogger().WithFields(logrus.Fields{
+		"actionId": result.ActionId,
+		"command":  result.Command,
+		"status":   result.Status,
+	})
+	logger.WithField("errorCode", result.ErrorCode).Infoln("kick_vm action finished")
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to marshal result of kick_vm action")
+		return
+	}
+	key := outboxKeyPrefix + result.ActionId
+	if err := outbox.Enqueue(key, util.GetKickResultService(), string(content)); err != nil {
+		logger.WithError(err).Errorln("Failed to put result of kick_vm action into outbox")
+		util.HttpPost(util.GetKickResultService(), string(content), "")
+		return
+	}
+	outbox.Deliver(key)
+}
+
+func pendingActionDir() (string, error) {
+	cacheDir, err := util.GetCachePath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, pendingActionDirName)
+	if err := util.MakeSurePath(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func savePendingAction(action *pendingAction) {
+	dir, err := pendingActionDir()
+	if err == nil {
+		var content []byte
+		content, err = json.Marshal(action)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, action.ActionId+".json"), content, 0600)
+		}
+	}
+	if err != nil {
+		log.GetLogger().WithField("actionId", action.ActionId).WithError(err).Errorln("Failed to save pending kick_vm action")
+	}
+}
+
+func removePendingAction(actionId string) {
+	dir, err := pendingActionDir()
+	if err != nil {
+		return
+	}
+	if err := os.Remove(filepath.Join(dir, actionId+".json")); err != nil && !os.IsNotExist(err) {
+		log.GetLogger().WithField("actionId", actionId).WithError(err).Errorln("Failed to remove pending kick_vm action")
+	}
+}
+
+// interruptedResult returns result of action which stopped agent before its
+// result was reported. Only update is known to finish by restarting agent,
+// which succeeded if agent of another version is running now. Agent running
+// again means remove failed, while stop may have taken effect or not.
+func interruptedResult(action *pendingAction, currentVersion string) *ActionResult {
+	result := action.ActionResult
+	result.EndTime = time.Now().UnixNano() / 1e6
+	result.Payload = map[string]interface{}{
+		"agentRestarted":  true,
+		"agentVersion":    currentVersion,
+		"previousVersion": action.AgentVersion,
+	}
+	switch agentAction(result.Command) {
+	case "update":
+		if action.AgentVersion != "" && action.AgentVersion != currentVersion {
+			result.Status = ActionStatusSucceeded
+			return &result
+		}
+		result.Status = ActionStatusFailed
+		result.ErrorCode = ActionErrExecutionFailed
+		result.ErrorMessage = "agent restarted without being updated"
+	case "remove":
+		result.Status = ActionStatusFailed
+		result.ErrorCode = ActionErrExecutionFailed
+		result.ErrorMessage = "agent is still installed after restarted"
+	default:
+		result.Status = ActionStatusInterrupted
+		result.ErrorCode = ActionErrAgentRestarted
+		result.ErrorMessage = "agent restarted before result of action was reported"
+	}
+	return &result
+}
+
+// ReportInterruptedActions reports actions which stopped agent before their
+// results were reported
+func ReportInterruptedActions() {
+	dir, err := pendingActionDir()
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to get path of pending kick_vm actions")
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to read pending kick_vm actions")
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.GetLogger().WithError(err).Errorf("Failed to read pending kick_vm action %s", path)
+			continue
+		}
+		os.Remove(path)
+		var action pendingAction
+		if err := json.Unmarshal(content, &action); err != nil || action.ActionId == "" {
+			log.GetLogger().WithError(err).Errorf("Invalid pending kick_vm action %s is removed", path)
+			continue
+		}
+		ReportActionResult(interruptedResult(&action, version.AssistVersion))
+	}
+}
@@ -0,0 +1,60 @@
+package kickvmhandle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionId(t *testing.T) {
+	kick := SignedKick{Command: "kick_vm agent stop", Nonce: "nonce-1"}
+	assert.Equal(t, "nonce-1", kick.ActionId())
+
+	kick = SignedKick{Command: "kick_vm agent stop"}
+	actionId := kick.ActionId()
+	assert.True(t, strings.HasPrefix(actionId, "kick-"))
+	assert.Equal(t, actionId, kick.ActionId())
+
+	kick = SignedKick{Command: "kick_vm agent stop", Nonce: "../../etc/passwd"}
+	assert.True(t, strings.HasPrefix(kick.ActionId(), "kick-"))
+}
+
+func TestRestartsAgent(t *testing.T) {
+	assert.True(t, restartsAgent("kick_vm agent stop"))
+	assert.True(t, restartsAgent("kick_vm agent remove"))
+	assert.True(t, restartsAgent("kick_vm agent update"))
+	assert.False(t, restartsAgent("kick_vm agent deregister"))
+	assert.False(t, restartsAgent("kick_vm task run t-xxx"))
+	assert.False(t, restartsAgent("kick_vm"))
+}
+
+func TestErrorCodeOf(t *testing.T) {
+	assert.Equal(t, ActionErrUnknownAction, errorCodeOf(NewAgentHandle("unknown", nil).DoAction()))
+	assert.Equal(t, ActionErrInvalidParameters, errorCodeOf(NewTaskHandle("run", nil).DoAction()))
+	assert.Equal(t, ActionErrInvalidParameters, errorCodeOf(NewStatusHandle("network", []string{"--vpc"}).DoAction()))
+	assert.Equal(t, ActionErrInvalidParameters, errorCodeOf(fmt.Errorf("wrapped: %w", ErrInvalidParameters)))
+	assert.Equal(t, ActionErrExecutionFailed, errorCodeOf(errors.New("cmd run timeout")))
+}
+
+func TestInterruptedResult(t *testing.T) {
+	action := &pendingAction{
+		ActionResult: ActionResult{ActionId: "nonce-1", Command: "kick_vm agent update", Status: ActionStatusRunning},
+		AgentVersion: "2.1.0",
+	}
+	assert.Equal(t, ActionStatusSucceeded, interruptedResult(action, "2.2.0").Status)
+	result := interruptedResult(action, "2.1.0")
+	assert.Equal(t, ActionStatusFailed, result.Status)
+	assert.Equal(t, ActionErrExecutionFailed, result.ErrorCode)
+
+	action.Command = "kick_vm agent remove"
+	assert.Equal(t, ActionStatusFailed, interruptedResult(action, "2.1.0").Status)
+
+	action.Command = "kick_vm agent stop"
+	result = interruptedResult(action, "2.1.0")
+	assert.Equal(t, ActionStatusInterrupted, result.Status)
+	assert.Equal(t, ActionErrAgentRestarted, result.ErrorCode)
+	assert.Equal(t, "nonce-1", result.ActionId)
+}
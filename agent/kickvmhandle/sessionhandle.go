@@ -1,7 +1,6 @@
 package kickvmhandle
 
 import (
-	"errors"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
 )
@@ -59,11 +58,9 @@ func NewSessionHandle(action string, params []string) *SessionHandle{
 
 func (h *SessionHandle) DoAction() error{
 	if v, ok := sessionRoute[h.action]; ok {
-		v(h.params)
-	} else {
-		return errors.New("no action found")
+		return v(h.params)
 	}
-	return nil
+	return ErrActionNotFound
 }
 
 func (h *SessionHandle) CheckAction() bool{
@@ -38,10 +38,8 @@ func NewStatusHandle(action string, params []string) *StatusHandle {
 func (h *StatusHandle) DoAction() error {
 	if v, ok := statusRoute[h.action]; ok {
 		return v(h.params)
-	} else {
-		return errors.New("no action found")
 	}
-	return nil
+	return ErrActionNotFound
 }
 
 func (h *StatusHandle) CheckAction() bool {
@@ -57,7 +55,8 @@ func requestNetworkStatus(params []string) error {
 		"module": "requestNetworkStatus",
 	})
 	// REMEMBER: All actions in all kick_vm option handlers are not able to
-	// return results simultaneously.
+	// return results simultaneously, and their results are reported to server
+	// by RunAction afterwards.
 
 	flags := pflag.NewFlagSet("network", pflag.ContinueOnError)
 	needToRefresh := flags.Bool("refresh", false, "Request to refresh the network diagnostic result")
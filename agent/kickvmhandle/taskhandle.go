@@ -1,8 +1,6 @@
 package kickvmhandle
 
 import (
-	"errors"
-
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
 )
@@ -20,7 +18,7 @@ func init ()  {
 func runTask(params []string) error {
 	log.GetLogger().Println("runTask")
 	if len(params) < 1 {
-		return errors.New("params error")
+		return ErrInvalidParameters
 	}
 	go func() {
 		taskengine.Fetch(true, params[0], taskengine.NormalTaskType, false)	}()
@@ -30,7 +28,7 @@ func runTask(params []string) error {
 func stopTask(params []string) error {
 	log.GetLogger().Println("stopTask")
 	if len(params) < 1 {
-		return errors.New("params error")
+		return ErrInvalidParameters
 	}
 
 	go func() {
@@ -53,11 +51,9 @@ func NewTaskHandle(action string, params []string) *TaskHandle{
 
 func (h *TaskHandle) DoAction() error{
 	if v, ok := taskRoute[h.action]; ok {
-		v(h.params)
-	} else {
-		return errors.New("no action found")
+		return v(h.params)
 	}
-	return nil
+	return ErrActionNotFound
 }
 
 func (h *TaskHandle) CheckAction() bool{
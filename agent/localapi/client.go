@@ -0,0 +1,77 @@
+package localapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// Host in URL is ignored since requests are always sent to local endpoint
+	baseURL = "http://localapi"
+
+	statusTimeout = 10 * time.Second
+	// Actions like running inventory gatherers may take minutes
+	actionTimeout = 10 * time.Minute
+)
+
+// httpClient returns client sending requests to local endpoint
+func httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dial(ctx)
+			},
+		},
+	}
+}
+
+// GetStatus queries status of the running agent via local API
+func GetStatus() (*Status, error) {
+	resp, err := httpClient(statusTimeout).Get(baseURL + StatusPath)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	if err := parseResponse(resp, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// TriggerAction requests the running agent to run specified action via local
+// API, where body is optional parameters of the action in JSON format
+func TriggerAction(name string, body []byte) (*ActionResult, error) {
+	resp, err := httpClient(actionTimeout).Post(baseURL+actionPathPrefix+name,
+		"application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	result := &ActionResult{}
+	if err := parseResponse(resp, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errResp := errorResponse{}
+		if err := json.Unmarshal(content, &errResp); err == nil && errResp.ErrorMessage != "" {
+			return fmt.Errorf("%s (HTTP %d)", errResp.ErrorMessage, resp.StatusCode)
+		}
+		return fmt.Errorf("unexpected response (HTTP %d): %s", resp.StatusCode, string(content))
+	}
+	return json.Unmarshal(content, v)
+}
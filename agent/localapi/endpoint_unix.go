@@ -0,0 +1,66 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package localapi
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	socketDirName  = "localapi"
+	socketFileName = "agent.sock"
+)
+
+// Address returns path of Unix domain socket serving local API
+func Address() (string, error) {
+	cacheDir, err := util.GetCachePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, socketDirName, socketFileName), nil
+}
+
+// listen creates Unix domain socket in directory only accessible by agent
+// running as root, thus other users could neither connect nor replace it
+func listen() (net.Listener, error) {
+	socketPath, err := Address()
+	if err != nil {
+		return nil, err
+	}
+	socketDir := filepath.Dir(socketPath)
+	if err := os.MkdirAll(socketDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketDir, 0700); err != nil {
+		return nil, err
+	}
+	// Socket left by agent process exited unexpectedly
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func dial(ctx context.Context) (net.Conn, error) {
+	socketPath, err := Address()
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", socketPath)
+}
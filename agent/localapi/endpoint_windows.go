@@ -0,0 +1,29 @@
+package localapi
+
+import (
+	"context"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+const (
+	pipeName = `\\.\pipe\aliyun-assist-localapi`
+	// Only SYSTEM and built-in Administrators are allowed to access the pipe
+	pipeSecurityDescriptor = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+)
+
+// Address returns name of the named pipe serving local API
+func Address() (string, error) {
+	return pipeName, nil
+}
+
+func listen() (net.Listener, error) {
+	return winio.ListenPipe(pipeName, &winio.PipeConfig{
+		SecurityDescriptor: pipeSecurityDescriptor,
+	})
+}
+
+func dial(ctx context.Context) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, pipeName)
+}
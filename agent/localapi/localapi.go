@@ -0,0 +1,307 @@
+// Package localapi serves a local-only HTTP API for diagnosing the running
+// agent and triggering actions on it. The API listens on a Unix domain socket
+// only accessible by root on Linux and FreeBSD, or a named pipe only accessible
+// by SYSTEM and Administrators on Windows.
+package localapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/channel"
+	"github.com/aliyun/aliyun_assist_client/agent/checknet"
+	"github.com/aliyun/aliyun_assist_client/agent/heartbeat"
+	"github.com/aliyun/aliyun_assist_client/agent/inventory"
+	"github.com/aliyun/aliyun_assist_client/agent/inventory/model"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/pluginmanager"
+	"github.com/aliyun/aliyun_assist_client/agent/statemanager"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/timermanager"
+	"github.com/aliyun/aliyun_assist_client/agent/util/wrapgo"
+	"github.com/aliyun/aliyun_assist_client/agent/version"
+)
+
+const (
+	StatusPath         = "/status"
+	actionPathPrefix   = "/actions/"
+	ActionFetchTasks   = "fetch-tasks"
+	ActionInventory    = "inventory"
+	ActionRefreshState = "refresh-state"
+
+	// Body of request for inventory action is optional inventory policy
+	maxRequestBodyBytes = 1 << 20
+)
+
+var (
+	ErrServerStarted = errors.New("local API server has been started")
+)
+
+// Status describes the running agent
+type Status struct {
+	Version      string                      `json:"version"`
+	Pid          int                         `json:"pid"`
+	ChannelType  string                      `json:"channelType"`
+	ShuttingDown bool                        `json:"shuttingDown"`
+	Tasks        []taskengine.RunningTask    `json:"tasks"`
+	Sessions     []taskengine.RunningSession `json:"sessions"`
+	Timers       TimerStatus                 `json:"timers"`
+	Plugins      PluginStatus                `json:"plugins"`
+	Heartbeat    heartbeat.Status            `json:"heartbeat"`
+	Netcheck     *NetcheckStatus             `json:"netcheck"`
+	OutboxDepth  int                         `json:"outboxDepth"`
+}
+
+// TimerStatus counts timers registered in TimerManager
+type TimerStatus struct {
+	Registered          int  `json:"registered"`
+	Running             int  `json:"running"`
+	StateManagerRunning bool `json:"stateManagerRunning"`
+}
+
+// PluginStatus is status of plugins observed by the most recent health check
+type PluginStatus struct {
+	CheckedTime time.Time                    `json:"checkedTime"`
+	Plugins     []pluginmanager.PluginStatus `json:"plugins"`
+}
+
+// NetcheckStatus is the most recent network diagnostic report
+type NetcheckStatus struct {
+	Result       int       `json:"result"`
+	FinishedTime time.Time `json:"finishedTime"`
+}
+
+// ActionResult is responded after action triggered locally finished
+type ActionResult struct {
+	Action  string `json:"action"`
+	Message string `json:"message,omitempty"`
+}
+
+type errorResponse struct {
+	ErrorMessage string `json:"error"`
+}
+
+// action runs with body of request and returns message for response
+type action func(body []byte) (string, error)
+
+type server struct {
+	httpServer *http.Server
+	actions    map[string]action
+	statusFunc func() Status
+	isStopping func() bool
+
+	// Names of running actions, which are not allowed to run concurrently
+	running     map[string]bool
+	runningLock sync.Mutex
+}
+
+var (
+	_server     *server
+	_serverLock sync.Mutex
+)
+
+func newServer(statusFunc func() Status, actions map[string]action, isStopping func() bool) *server {
+	s := &server{
+		actions:    actions,
+		statusFunc: statusFunc,
+		isStopping: isStopping,
+		running:    make(map[string]bool, len(actions)),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatusPath, s.handleStatus)
+	mux.HandleFunc(actionPathPrefix, s.handleAction)
+	s.httpServer = &http.Server{
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start listens on local endpoint and serves API in background
+func Start() error {
+	_serverLock.Lock()
+	defer _serverLock.Unlock()
+	if _server != nil {
+		return ErrServerStarted
+	}
+
+	listener, err := listen()
+	if err != nil {
+		return err
+	}
+	_server = newServer(collectStatus, map[string]action{
+		ActionFetchTasks:   fetchTasks,
+		ActionInventory:    runInventory,
+		ActionRefreshState: refreshState,
+	}, taskengine.IsShuttingDown)
+	s := _server
+	wrapgo.GoWithDefaultPanicHandler(func() {
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.GetLogger().WithError(err).Errorln("Local API server stopped unexpectedly")
+		}
+	})
+	log.GetLogger().WithField("address", listener.Addr().String()).Infoln("Local API server started")
+	return nil
+}
+
+// Stop closes local endpoint and all connections of API
+func Stop() {
+	_serverLock.Lock()
+	defer _serverLock.Unlock()
+	if _server == nil {
+		return
+	}
+	if err := _server.httpServer.Close(); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to stop local API server")
+	}
+	_server = nil
+	log.GetLogger().Infoln("Local API server stopped")
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.statusFunc())
+}
+
+func (s *server) handleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Path[len(actionPathPrefix):]
+	run, ok := s.actions[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown action: "+name)
+		return
+	}
+	if s.isStopping() {
+		writeError(w, http.StatusServiceUnavailable, "agent is stopping")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.tryMarkRunning(name) {
+		writeError(w, http.StatusConflict, "action is already running: "+name)
+		return
+	}
+	defer s.clearRunning(name)
+
+	logger := log.GetLogger().WithField("action", name)
+	logger.Infoln("Run action requested by local API")
+	message, err := run(body)
+	if err != nil {
+		logger.WithError(err).Errorln("Action requested by local API failed")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ActionResult{
+		Action:  name,
+		Message: message,
+	})
+}
+
+func (s *server) tryMarkRunning(name string) bool {
+	s.runningLock.Lock()
+	defer s.runningLock.Unlock()
+	if s.running[name] {
+		return false
+	}
+	s.running[name] = true
+	return true
+}
+
+func (s *server) clearRunning(name string) {
+	s.runningLock.Lock()
+	defer s.runningLock.Unlock()
+	delete(s.running, name)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(content)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	content, _ := json.Marshal(errorResponse{ErrorMessage: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(content)
+}
+
+func collectStatus() Status {
+	status := Status{
+		Version:      version.AssistVersion,
+		Pid:          os.Getpid(),
+		ChannelType:  channel.ChannelTypeStr(channel.GetCurrentChannelType()),
+		ShuttingDown: taskengine.IsShuttingDown(),
+		Tasks:        taskengine.ListRunningTasks(),
+		Sessions:     taskengine.ListRunningSessions(),
+		Heartbeat:    heartbeat.LastStatus(),
+		OutboxDepth:  outbox.Depth(),
+	}
+	if timerManager := timermanager.GetTimerManager(); timerManager != nil {
+		for _, timer := range timerManager.GetAllTimers() {
+			status.Timers.Registered++
+			if timer.IsRunning() {
+				status.Timers.Running++
+			}
+		}
+	}
+	status.Timers.StateManagerRunning = statemanager.IsStateManagerTimerRunning()
+	status.Plugins.Plugins, status.Plugins.CheckedTime = pluginmanager.ObservedPluginStatus()
+	if report := checknet.LastReport(); report != nil {
+		status.Netcheck = &NetcheckStatus{
+			Result:       report.Result,
+			FinishedTime: report.FinishedTime,
+		}
+	}
+	return status
+}
+
+func fetchTasks(body []byte) (string, error) {
+	// Fetching tasks requested locally is treated like kicked by server
+	taskCount := taskengine.Fetch(true, "", taskengine.NormalTaskType, false)
+	if taskCount == taskengine.ErrUpdatingProcedureRunning {
+		return "", errors.New("fetching tasks is canceled due to another running fetching or updating process")
+	}
+	return fmt.Sprintf("fetched %d tasks", taskCount), nil
+}
+
+func runInventory(body []byte) (string, error) {
+	policy := inventory.DefaultPolicy()
+	if len(body) > 0 {
+		policy = model.Policy{}
+		if err := json.Unmarshal(body, &policy); err != nil {
+			return "", err
+		}
+	}
+	items, err := inventory.RunGatherers(policy)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("collected %d inventory items", len(items)), nil
+}
+
+func refreshState(body []byte) (string, error) {
+	statemanager.RefreshStateConfigs()
+	return "state configurations refreshed", nil
+}
@@ -0,0 +1,101 @@
+package localapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(actions map[string]action, stopping *bool) *httptest.Server {
+	s := newServer(func() Status {
+		return Status{Version: "1.0.0.1", ChannelType: "gshell"}
+	}, actions, func() bool {
+		return *stopping
+	})
+	return httptest.NewServer(s.httpServer.Handler)
+}
+
+func TestHandleStatus(t *testing.T) {
+	stopping := false
+	ts := newTestServer(nil, &stopping)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + StatusPath)
+	assert.NoError(t, err)
+	status := Status{}
+	assert.NoError(t, parseResponse(resp, &status))
+	assert.Equal(t, "1.0.0.1", status.Version)
+	assert.Equal(t, "gshell", status.ChannelType)
+
+	resp, err = http.Post(ts.URL+StatusPath, "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestHandleAction(t *testing.T) {
+	stopping := false
+	var received string
+	block := make(chan struct{})
+	started := make(chan struct{})
+	ts := newTestServer(map[string]action{
+		"echo": func(body []byte) (string, error) {
+			received = string(body)
+			return "done", nil
+		},
+		"fail": func(body []byte) (string, error) {
+			return "", errors.New("something wrong")
+		},
+		"block": func(body []byte) (string, error) {
+			close(started)
+			<-block
+			return "", nil
+		},
+	}, &stopping)
+	defer ts.Close()
+
+	post := func(name string, body string) (*ActionResult, error) {
+		resp, err := http.Post(ts.URL+actionPathPrefix+name, "application/json", strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		result := &ActionResult{}
+		return result, parseResponse(resp, result)
+	}
+
+	result, err := post("echo", `{"Policy":{}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionResult{Action: "echo", Message: "done"}, *result)
+	assert.Equal(t, `{"Policy":{}}`, received)
+
+	_, err = post("fail", "")
+	assert.EqualError(t, err, "something wrong (HTTP 500)")
+
+	_, err = post("unknown", "")
+	assert.EqualError(t, err, "unknown action: unknown (HTTP 404)")
+
+	resp, err := http.Get(ts.URL + actionPathPrefix + "echo")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+
+	// The same action is not allowed to run concurrently
+	done := make(chan error)
+	go func() {
+		_, err := post("block", "")
+		done <- err
+	}()
+	<-started
+	_, err = post("block", "")
+	assert.EqualError(t, err, "action is already running: block (HTTP 409)")
+	close(block)
+	assert.NoError(t, <-done)
+
+	stopping = true
+	_, err = post("echo", "")
+	assert.EqualError(t, err, "agent is stopping (HTTP 503)")
+}
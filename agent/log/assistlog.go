@@ -12,6 +12,7 @@ import (
 
 var Log *log.Logger
 var defaultLevel log.Level = log.InfoLevel
+var logDir string
 
 func InitLog(filename string, logpath string) {
 	logdir := ""
@@ -22,6 +23,8 @@ func InitLog(filename string, logpath string) {
 		logdir = logpath
 	}
 
+	logDir = filepath.Join(logdir, "log")
+
 	writer, err := rotatelogs.New(
 		logdir+"/log/"+filename+".%Y%m%d",
 		rotatelogs.WithMaxAge(time.Duration(24*30)*time.Hour),    //最长保留30天
@@ -44,3 +47,9 @@ func GetLogger() *log.Logger {
 	}
 	return Log
 }
+
+// GetLogDir returns directory of log files, which is empty before InitLog is
+// called
+func GetLogDir() string {
+	return logDir
+}
@@ -22,12 +22,14 @@ const (
 	EVENT_TASK_FAILED       MetricsEventID = "agent.task.failed"
 	EVENT_HYBRID_REGISTER   MetricsEventID = "agent.hybrid.register"
 	EVENT_HYBRID_UNREGISTER MetricsEventID = "agent.hybrid.unregister"
+	EVENT_HYBRID_ROTATE_KEY MetricsEventID = "agent.hybrid.rotatekey"
 	EVENT_SESSION_FAILED    MetricsEventID = "agent.session.failed"
 	EVENT_PERF_CPU_OVERLOAD MetricsEventID = "agent.pref.cup.overload"
 	EVENT_PERF_MEM_OVERLOAD MetricsEventID = "agent.pref.mem.overload"
 	EVENT_BASE_STARTUP      MetricsEventID = "agent.startup"
 	EVENT_BASE_VIRTIO       MetricsEventID = "agent.virtio"
 	EVENT_KDUMP             MetricsEventID = "agent.kdump"
+	EVENT_KICK_REJECTED     MetricsEventID = "agent.kick.rejected"
 
 	// event category
 	EVENT_CATEGORY_CHANNEL EventCategory = "CHANNEL"
@@ -39,13 +41,16 @@ const (
 	EVENT_CATEGORY_STARTUP EventCategory = "STARTUP"
 	EVENT_CATEGORY_VIRTIO  EventCategory = "VIRTIO"
 	EVENT_CATEGORY_KDUMP   EventCategory = "KDUMP"
+	EVENT_CATEGORY_KICK    EventCategory = "KICK"
 
 	// event subcategory
 	EVENT_SUBCATEGORY_CHANNEL_GSHELL    EventSubCategory = "gshell"
 	EVENT_SUBCATEGORY_CHANNEL_WS        EventSubCategory = "ws"
+	EVENT_SUBCATEGORY_CHANNEL_LONGPOLL  EventSubCategory = "longpoll"
 	EVENT_SUBCATEGORY_CHANNEL_MGR       EventSubCategory = "channelmgr"
 	EVENT_SUBCATEGORY_HYBRID_REGISTER   EventSubCategory = "register"
 	EVENT_SUBCATEGORY_HYBRID_UNREGISTER EventSubCategory = "unregister"
+	EVENT_SUBCATEGORY_HYBRID_ROTATE_KEY EventSubCategory = "rotatekey"
 	EVENT_SUBCATEGORY_PERF_CPU          EventSubCategory = "cpu"
 	EVENT_SUBCATEGORY_PERF_MEM          EventSubCategory = "mem"
 
@@ -78,20 +83,29 @@ type CommonInfo struct {
 	KernelVersion string `json:"kernekVersion"`
 }
 
+// ReportEvent delivers event to configured sinks. Events are spooled locally
+// and retried when the spool has been started, or sent once otherwise.
 func (m *MetricsEvent) ReportEvent() {
+	if s := getSpool(); s != nil {
+		s.enqueue(m)
+		return
+	}
 	// 序列化后上报
 	payload, err := json.Marshal(m)
 	if err != nil {
 		log.GetLogger().Errorf("metrics json.Marshal err: %s", err.Error())
 		return
 	}
-	url := util.GetMetricsService()
 	go func() {
-		doReport(url, string(payload))
+		for _, sink := range configuredSinks() {
+			if err := sink.Send(m, payload); err != nil {
+				log.GetLogger().WithError(err).Warningf("Failed to send metrics event %s to sink %s", m.EventId, sink.Name())
+			}
+		}
 	}()
 }
 
-func doReport(url, payload string) {
+func doReport(url, payload string) error {
 	_reportMutex.Lock()
 	defer _reportMutex.Unlock()
 	if _reportCounter >= _reportCounterLimit {
@@ -99,13 +113,15 @@ func doReport(url, payload string) {
 		if gap.Minutes() > 10 {
 			_reportCounter = 1
 			_startTime = time.Now()
-			util.HttpPost(url, payload, "")
+			_, err := util.HttpPost(url, payload, "")
+			return err
 		} else {
-			return
+			return ErrReportRateLimited
 		}
 	} else {
 		_reportCounter++
-		util.HttpPost(url, payload, "")
+		_, err := util.HttpPost(url, payload, "")
+		return err
 	}
 }
 
@@ -226,6 +242,23 @@ func GetHybridUnregisterEvent(success bool, keywords ...string) *MetricsEvent {
 	return event
 }
 
+func GetHybridRotateKeyEvent(success bool, keywords ...string) *MetricsEvent {
+	event := &MetricsEvent{
+		EventId:     EVENT_HYBRID_ROTATE_KEY,
+		Category:    EVENT_CATEGORY_HYBRID,
+		SubCategory: EVENT_SUBCATEGORY_HYBRID_ROTATE_KEY,
+		EventTime:   time.Now().UnixNano() / 1e6,
+		Common:      getCommonInfoStr(),
+		KeyWords:    genKeyWordsStr(keywords...),
+	}
+	if success {
+		event.EventLevel = EVENT_LEVEL_INFO
+	} else {
+		event.EventLevel = EVENT_LEVEL_ERROR
+	}
+	return event
+}
+
 // session manager系统
 func GetSessionFailedEvent(keywords ...string) *MetricsEvent {
 	event := &MetricsEvent{
@@ -303,3 +336,16 @@ func GetKdumpServiceStatusEvent(keywords ...string) *MetricsEvent {
 	}
 	return event
 }
+
+// kick_vm命令
+func GetKickRejectedEvent(keywords ...string) *MetricsEvent {
+	event := &MetricsEvent{
+		EventId:    EVENT_KICK_REJECTED,
+		Category:   EVENT_CATEGORY_KICK,
+		EventLevel: EVENT_LEVEL_ERROR,
+		EventTime:  time.Now().UnixNano() / 1e6,
+		Common:     getCommonInfoStr(),
+		KeyWords:   genKeyWordsStr(keywords...),
+	}
+	return event
+}
@@ -0,0 +1,158 @@
+// Package exporter serves metrics of agent internals over HTTP for scraping by
+// Prometheus or other monitoring systems supporting OpenMetrics.
+package exporter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/channel"
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
+	"github.com/aliyun/aliyun_assist_client/agent/pluginmanager"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
+	"github.com/aliyun/aliyun_assist_client/agent/util/wrapgo"
+)
+
+const (
+	MetricsPath = "/metrics"
+)
+
+var (
+	ErrExporterStarted = errors.New("metrics exporter has been started")
+
+	_httpServer     *http.Server
+	_httpServerLock sync.Mutex
+
+	_registerGaugesOnce sync.Once
+)
+
+// registerGauges registers gauges whose values are maintained by other
+// modules and collected when scraped
+func registerGauges(registry *openmetrics.Registry) {
+	registry.NewGaugeFunc("aliyun_assist_task_pool_pending_tasks",
+		"Tasks waiting in queue of task pool", nil, func() []openmetrics.Sample {
+			return []openmetrics.Sample{{Value: float64(taskengine.GetPool().PendingTasks())}}
+		})
+	registry.NewGaugeFunc("aliyun_assist_tasks_running",
+		"Tasks registered in task factory", nil, func() []openmetrics.Sample {
+			return []openmetrics.Sample{{Value: float64(len(taskengine.GetTaskFactory().GetAllTasks()))}}
+		})
+	registry.NewGaugeFunc("aliyun_assist_sessions_running",
+		"Sessions currently open", nil, func() []openmetrics.Sample {
+			return []openmetrics.Sample{{Value: float64(len(taskengine.GetSessionFactory().GetAllTasks()))}}
+		})
+	registry.NewGaugeFunc("aliyun_assist_outbox_depth",
+		"Task results waiting for delivery in outbox", nil, func() []openmetrics.Sample {
+			return []openmetrics.Sample{{Value: float64(outbox.Depth())}}
+		})
+	registry.NewGaugeFunc("aliyun_assist_channel_active",
+		"Channel currently used to receive commands from server", []string{"type"}, func() []openmetrics.Sample {
+			channelType := channel.ChannelTypeStr(channel.GetCurrentChannelType())
+			return []openmetrics.Sample{{LabelValues: []string{channelType}, Value: 1}}
+		})
+	registry.NewGaugeFunc("aliyun_assist_channel_health_score",
+		"Health score of channels from 0 to 100", []string{"type"}, func() []openmetrics.Sample {
+			scores := channel.GetHealthScores()
+			samples := make([]openmetrics.Sample, 0, len(scores))
+			for _, score := range scores {
+				samples = append(samples, openmetrics.Sample{
+					LabelValues: []string{score.Channel},
+					Value:       float64(score.Score),
+				})
+			}
+			return samples
+		})
+	registry.NewGaugeFunc("aliyun_assist_plugin_status",
+		"Status of plugins observed by the most recent health check", []string{"name", "version", "status"}, func() []openmetrics.Sample {
+			statusList, _ := pluginmanager.ObservedPluginStatus()
+			samples := make([]openmetrics.Sample, 0, len(statusList))
+			for _, status := range statusList {
+				samples = append(samples, openmetrics.Sample{
+					LabelValues: []string{status.Name, status.Version, status.Status},
+					Value:       1,
+				})
+			}
+			return samples
+		})
+}
+
+// acceptsOpenMetrics returns true when scraper prefers OpenMetrics format over
+// Prometheus text format
+func acceptsOpenMetrics(accept string) bool {
+	return strings.Contains(accept, "application/openmetrics-text")
+}
+
+func newHandler(registry *openmetrics.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		openMetrics := acceptsOpenMetrics(r.Header.Get("Accept"))
+		if openMetrics {
+			w.Header().Set("Content-Type", openmetrics.ContentTypeOpenMetrics)
+		} else {
+			w.Header().Set("Content-Type", openmetrics.ContentTypeText)
+		}
+		if err := registry.Write(w, openMetrics); err != nil {
+			log.GetLogger().WithError(err).Warningln("Failed to write metrics to scraper")
+		}
+	})
+	return mux
+}
+
+// Start serves metrics on address configured when the exporter is enabled
+func Start() error {
+	exporterConfig := config.GetConfig().MetricsExporter
+	if !exporterConfig.Enabled {
+		return nil
+	}
+
+	_httpServerLock.Lock()
+	defer _httpServerLock.Unlock()
+	if _httpServer != nil {
+		return ErrExporterStarted
+	}
+
+	registry := openmetrics.DefaultRegistry()
+	_registerGaugesOnce.Do(func() {
+		registerGauges(registry)
+	})
+	listener, err := net.Listen("tcp", exporterConfig.ListenAddress)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:     newHandler(registry),
+		ReadTimeout: 10 * time.Second,
+	}
+	_httpServer = server
+	wrapgo.GoWithDefaultPanicHandler(func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.GetLogger().WithError(err).Errorln("Metrics exporter stopped unexpectedly")
+		}
+	})
+	log.GetLogger().WithField("address", listener.Addr().String()).Infoln("Metrics exporter started")
+	return nil
+}
+
+// Stop closes the endpoint if started
+func Stop() {
+	_httpServerLock.Lock()
+	defer _httpServerLock.Unlock()
+	if _httpServer == nil {
+		return
+	}
+	if err := _httpServer.Close(); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to stop metrics exporter")
+	}
+	_httpServer = nil
+}
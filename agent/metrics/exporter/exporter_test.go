@@ -0,0 +1,44 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
+)
+
+func TestHandler(t *testing.T) {
+	registry := openmetrics.NewRegistry()
+	registry.NewCounter("test_heartbeats", "Heart-beats", "result").Inc("success")
+	ts := httptest.NewServer(newHandler(registry))
+	defer ts.Close()
+
+	scrape := func(accept string) (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+MetricsPath, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	contentType, body := scrape("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	assert.Equal(t, openmetrics.ContentTypeOpenMetrics, contentType)
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+	assert.Contains(t, body, `test_heartbeats_total{result="success"} 1`)
+
+	contentType, body = scrape("text/plain")
+	assert.Equal(t, openmetrics.ContentTypeText, contentType)
+	assert.NotContains(t, body, "# EOF")
+
+	resp, err := http.Post(ts.URL+MetricsPath, "text/plain", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+}
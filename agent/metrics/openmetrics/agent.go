@@ -0,0 +1,39 @@
+package openmetrics
+
+// Metrics of agent internals updated by the modules owning them. Gauges whose
+// values are maintained elsewhere are registered via NewGaugeFunc when the
+// exporter starts.
+var (
+	TasksStarted = defaultRegistry.NewCounter("aliyun_assist_tasks_started",
+		"Tasks whose command process started, by command type", "type")
+	// State is one of finished, failed, timeout and canceled. Tasks failing
+	// before command process started are counted as failed but not started.
+	TasksFinished = defaultRegistry.NewCounter("aliyun_assist_tasks_finished",
+		"Tasks ended, by command type and final state", "type", "state")
+
+	ChannelSwitches = defaultRegistry.NewCounter("aliyun_assist_channel_switches",
+		"Switches of active channel, by channel switched to", "to")
+
+	// Result is either success or failure
+	Heartbeats = defaultRegistry.NewCounter("aliyun_assist_heartbeats",
+		"Heart-beats sent to server, by result", "result")
+	HeartbeatLatency = defaultRegistry.NewGauge("aliyun_assist_heartbeat_latency_seconds",
+		"Latency of the most recent successful heart-beat")
+
+	ProcessCPUUsage = defaultRegistry.NewGauge("aliyun_assist_process_cpu_usage_percent",
+		"CPU usage of agent process measured by perfmon")
+	ProcessResidentMemory = defaultRegistry.NewGauge("aliyun_assist_process_resident_memory_bytes",
+		"Resident memory size of agent process measured by perfmon")
+	ProcessThreads = defaultRegistry.NewGauge("aliyun_assist_process_threads",
+		"Number of threads of agent process measured by perfmon")
+)
+
+const (
+	TaskStateFinished = "finished"
+	TaskStateFailed   = "failed"
+	TaskStateTimeout  = "timeout"
+	TaskStateCanceled = "canceled"
+
+	HeartbeatSuccess = "success"
+	HeartbeatFailure = "failure"
+)
@@ -0,0 +1,278 @@
+// Package openmetrics keeps counters and gauges of agent internals in memory
+// and exposes them in OpenMetrics or Prometheus text format for scraping.
+// Unlike events in package metrics, which are posted to server and limited in
+// rate, values here are never dropped.
+package openmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	ContentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+
+	// labelSeparator joins label values as key of sample, which never
+	// appears in valid UTF-8 text
+	labelSeparator = "\xff"
+)
+
+// Sample is value of metric with label values in order of label names
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type collector interface {
+	desc() *metricDesc
+	collect() []Sample
+}
+
+type metricDesc struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (d *metricDesc) desc() *metricDesc {
+	return d
+}
+
+// Registry holds metrics in order of registration
+type Registry struct {
+	lock       sync.Mutex
+	collectors []collector
+	names      map[string]struct{}
+}
+
+var defaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]struct{}),
+	}
+}
+
+// DefaultRegistry returns registry holding metrics of agent
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+func (r *Registry) register(c collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	name := c.desc().name
+	if _, ok := r.names[name]; ok {
+		panic("duplicate metric registered: " + name)
+	}
+	r.names[name] = struct{}{}
+	r.collectors = append(r.collectors, c)
+}
+
+// values stores samples keyed by joined label values
+type values struct {
+	lock    sync.Mutex
+	samples map[string]*Sample
+}
+
+func (v *values) add(delta float64, labelValues []string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.sampleOf(labelValues).Value += delta
+}
+
+func (v *values) set(value float64, labelValues []string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.sampleOf(labelValues).Value = value
+}
+
+func (v *values) sampleOf(labelValues []string) *Sample {
+	key := strings.Join(labelValues, labelSeparator)
+	sample, ok := v.samples[key]
+	if !ok {
+		sample = &Sample{LabelValues: append([]string(nil), labelValues...)}
+		v.samples[key] = sample
+	}
+	return sample
+}
+
+func (v *values) collect() []Sample {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	samples := make([]Sample, 0, len(v.samples))
+	for _, sample := range v.samples {
+		samples = append(samples, *sample)
+	}
+	return samples
+}
+
+// Counter is a monotonically increasing value partitioned by labels
+type Counter struct {
+	metricDesc
+	values
+}
+
+// NewCounter registers counter in registry. Name should not contain _total
+// suffix, which is appended in exposition.
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		metricDesc: metricDesc{name: name, help: help, metricType: typeCounter, labelNames: labelNames},
+		values:     values{samples: make(map[string]*Sample)},
+	}
+	r.register(c)
+	return c
+}
+
+// Inc increases counter of specified label values by 1
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases counter of specified label values by delta, which must not be
+// negative
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 || len(labelValues) != len(c.labelNames) {
+		return
+	}
+	c.add(delta, labelValues)
+}
+
+// Gauge is a value which could go up and down partitioned by labels
+type Gauge struct {
+	metricDesc
+	values
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		metricDesc: metricDesc{name: name, help: help, metricType: typeGauge, labelNames: labelNames},
+		values:     values{samples: make(map[string]*Sample)},
+	}
+	r.register(g)
+	return g
+}
+
+// Set sets gauge of specified label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	if len(labelValues) != len(g.labelNames) {
+		return
+	}
+	g.set(value, labelValues)
+}
+
+// gaugeFunc collects samples when scraped, for values maintained elsewhere
+type gaugeFunc struct {
+	metricDesc
+	fn func() []Sample
+}
+
+func (g *gaugeFunc) collect() []Sample {
+	return g.fn()
+}
+
+// NewGaugeFunc registers gauge whose samples are returned by fn when scraped
+func (r *Registry) NewGaugeFunc(name string, help string, labelNames []string, fn func() []Sample) {
+	r.register(&gaugeFunc{
+		metricDesc: metricDesc{name: name, help: help, metricType: typeGauge, labelNames: labelNames},
+		fn:         fn,
+	})
+}
+
+// Write writes all metrics in registry to w, in OpenMetrics text format when
+// openMetrics is true, or Prometheus text format otherwise
+func (r *Registry) Write(w io.Writer, openMetrics bool) error {
+	r.lock.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		d := c.desc()
+		samples := c.collect()
+		sort.Slice(samples, func(i, j int) bool {
+			return strings.Join(samples[i].LabelValues, labelSeparator) < strings.Join(samples[j].LabelValues, labelSeparator)
+		})
+
+		familyName := d.name
+		sampleName := d.name
+		if d.metricType == typeCounter {
+			sampleName = d.name + "_total"
+			// Prometheus text format requires the same name in metadata and
+			// samples
+			if !openMetrics {
+				familyName = sampleName
+			}
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", familyName, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", familyName, d.metricType)
+		for _, sample := range samples {
+			if len(sample.LabelValues) != len(d.labelNames) {
+				continue
+			}
+			bw.WriteString(sampleName)
+			writeLabels(bw, d.labelNames, sample.LabelValues)
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(sample.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+func writeLabels(bw *bufio.Writer, labelNames []string, labelValues []string) {
+	if len(labelNames) == 0 {
+		return
+	}
+	bw.WriteByte('{')
+	for i, name := range labelNames {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(name)
+		bw.WriteString(`="`)
+		bw.WriteString(escapeLabelValue(labelValues[i]))
+		bw.WriteByte('"')
+	}
+	bw.WriteByte('}')
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,55 @@
+package openmetrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	tasks := r.NewCounter("test_tasks", "Tasks started", "type")
+	latency := r.NewGauge("test_latency_seconds", "Latency\nof ping")
+	r.NewGaugeFunc("test_plugin_status", "Plugin status", []string{"name", "status"}, func() []Sample {
+		return []Sample{
+			{LabelValues: []string{`my"plugin`, "PERSIST_RUNNING"}, Value: 1},
+			{LabelValues: []string{"invalid"}, Value: 1},
+		}
+	})
+
+	tasks.Inc("RunShellScript")
+	tasks.Add(2, "RunShellScript")
+	tasks.Inc("RunBatScript")
+	tasks.Add(-1, "RunBatScript")
+	tasks.Inc("RunBatScript", "extra")
+	latency.Set(0.25)
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.Write(&buf, true))
+	assert.Equal(t, `# HELP test_tasks Tasks started
+# TYPE test_tasks counter
+test_tasks_total{type="RunBatScript"} 1
+test_tasks_total{type="RunShellScript"} 3
+# HELP test_latency_seconds Latency\nof ping
+# TYPE test_latency_seconds gauge
+test_latency_seconds 0.25
+# HELP test_plugin_status Plugin status
+# TYPE test_plugin_status gauge
+test_plugin_status{name="my\"plugin",status="PERSIST_RUNNING"} 1
+# EOF
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, r.Write(&buf, false))
+	assert.Contains(t, buf.String(), "# TYPE test_tasks_total counter\ntest_tasks_total{")
+	assert.NotContains(t, buf.String(), "# EOF")
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("test_gauge", "Gauge")
+	assert.Panics(t, func() {
+		r.NewCounter("test_gauge", "Counter")
+	})
+}
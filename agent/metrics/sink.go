@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	auditFileName = "metrics_events.jsonl"
+)
+
+var (
+	ErrReportRateLimited = errors.New("metrics events are reported too frequently")
+	ErrSinkUnsupported   = errors.New("metrics event sink is not supported on this platform")
+
+	_sinks     []EventSink
+	_sinksOnce sync.Once
+)
+
+// EventSink is a destination metrics events are delivered to
+type EventSink interface {
+	Name() string
+	// Send delivers event and its JSON encoding. Error returned means the
+	// event should be retried later.
+	Send(event *MetricsEvent, payload []byte) error
+}
+
+// configuredSinks returns sinks enabled in agent configuration
+func configuredSinks() []EventSink {
+	_sinksOnce.Do(func() {
+		eventsConfig := config.GetConfig().MetricsEvents
+		for _, name := range eventsConfig.Sinks {
+			sink, err := newSink(name, eventsConfig)
+			if err != nil {
+				log.GetLogger().WithError(err).Errorf("Failed to create metrics event sink %s", name)
+				continue
+			}
+			_sinks = append(_sinks, sink)
+		}
+	})
+	return _sinks
+}
+
+func newSink(name string, eventsConfig config.MetricsEventsConfig) (EventSink, error) {
+	switch name {
+	case config.MetricsEventSinkHTTP:
+		return &httpSink{}, nil
+	case config.MetricsEventSinkFile:
+		dir, err := eventsDir()
+		if err != nil {
+			return nil, err
+		}
+		return newFileSink(filepath.Join(dir, auditFileName), eventsConfig.AuditFileMaxBytes), nil
+	case config.MetricsEventSinkSyslog:
+		return newSyslogSink()
+	default:
+		return nil, fmt.Errorf("unknown metrics event sink %s", name)
+	}
+}
+
+// eventsDir returns directory keeping spool and audit file of events, i.e.,
+// the log directory
+func eventsDir() (string, error) {
+	// GetLogger initializes log in default directory if not yet
+	log.GetLogger()
+	dir := log.GetLogDir()
+	if err := util.MakeSurePath(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// httpSink posts events to metrics service of server
+type httpSink struct{}
+
+func (s *httpSink) Name() string {
+	return config.MetricsEventSinkHTTP
+}
+
+func (s *httpSink) Send(event *MetricsEvent, payload []byte) error {
+	err := doReport(util.GetMetricsService(), string(payload))
+	if httpErr, ok := err.(*util.HttpErrorCode); ok && !httpErr.IsRetryable() {
+		// Server rejected the event, retrying would not help
+		log.GetLogger().WithError(err).Errorf("Metrics event %s is rejected by server and dropped", event.EventId)
+		return nil
+	}
+	return err
+}
+
+// fileSink appends events as JSON lines to a local audit file, which is
+// rotated to a single backup when exceeding the size limit
+type fileSink struct {
+	lock     sync.Mutex
+	path     string
+	maxBytes int64
+}
+
+func newFileSink(path string, maxBytes int64) *fileSink {
+	return &fileSink{
+		path:     path,
+		maxBytes: maxBytes,
+	}
+}
+
+func (s *fileSink) Name() string {
+	return config.MetricsEventSinkFile
+}
+
+func (s *fileSink) Send(event *MetricsEvent, payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if info, err := os.Stat(s.path); err == nil && info.Size()+int64(len(payload))+1 > s.maxBytes {
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line := make([]byte, 0, len(payload)+1)
+	line = append(line, payload...)
+	line = append(line, '\n')
+	_, err = f.Write(line)
+	return err
+}
@@ -0,0 +1,54 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package metrics
+
+import (
+	"log/syslog"
+	"sync"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+)
+
+const (
+	syslogTag = "aliyun-assist"
+)
+
+// syslogSink writes events to local syslog daemon, connected lazily since
+// the daemon may start later than agent
+type syslogSink struct {
+	lock   sync.Mutex
+	writer *syslog.Writer
+}
+
+func newSyslogSink() (EventSink, error) {
+	return &syslogSink{}, nil
+}
+
+func (s *syslogSink) Name() string {
+	return config.MetricsEventSinkSyslog
+}
+
+func (s *syslogSink) Send(event *MetricsEvent, payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.writer == nil {
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, syslogTag)
+		if err != nil {
+			return err
+		}
+		s.writer = writer
+	}
+	var err error
+	if event.EventLevel == EVENT_LEVEL_ERROR {
+		err = s.writer.Err(string(payload))
+	} else {
+		err = s.writer.Info(string(payload))
+	}
+	if err != nil {
+		// Reconnect at next time
+		s.writer.Close()
+		s.writer = nil
+	}
+	return err
+}
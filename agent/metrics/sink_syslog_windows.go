@@ -0,0 +1,5 @@
+package metrics
+
+func newSyslogSink() (EventSink, error) {
+	return nil, ErrSinkUnsupported
+}
@@ -0,0 +1,421 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util/wrapgo"
+)
+
+const (
+	spoolFileName = "metrics_spool.jsonl"
+
+	spoolInitialBackoff = 2 * time.Second
+	spoolMaxBackoff     = 5 * time.Minute
+)
+
+// spoolRecord is an event waiting for delivery to some of sinks. Spool file
+// is a journal of records, where a later line with the same id replaces the
+// record, or removes it if marked as removed.
+type spoolRecord struct {
+	Id            uint64        `json:"id"`
+	Removed       bool          `json:"removed,omitempty"`
+	Hash          string        `json:"hash"`
+	Sinks         []string      `json:"sinks"`
+	Attempts      int           `json:"attempts"`
+	NextAttemptAt time.Time     `json:"nextAttemptAt"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	Event         *MetricsEvent `json:"event"`
+}
+
+// spool keeps undelivered events in a size-capped JSON lines file and
+// delivers them to sinks with exponential backoff. Changes are appended to
+// the file, which is compacted only when exceeding the size limit.
+type spool struct {
+	lock sync.Mutex
+	path string
+	// size of spool file including lines of replaced and removed records
+	size int64
+	// records in order of creation
+	records []*spoolRecord
+	nextId  uint64
+	// time when events were accepted by all sinks, indexed by hash
+	accepted map[string]time.Time
+
+	sinks       map[string]EventSink
+	maxBytes    int64
+	dedupWindow time.Duration
+
+	wakeup chan struct{}
+}
+
+var (
+	_spool     *spool
+	_spoolLock sync.Mutex
+)
+
+func newSpool(path string, sinks []EventSink, maxBytes int64, dedupWindow time.Duration) *spool {
+	s := &spool{
+		path:        path,
+		accepted:    make(map[string]time.Time),
+		sinks:       make(map[string]EventSink, len(sinks)),
+		maxBytes:    maxBytes,
+		dedupWindow: dedupWindow,
+		wakeup:      make(chan struct{}, 1),
+	}
+	for _, sink := range sinks {
+		s.sinks[sink.Name()] = sink
+	}
+	return s
+}
+
+// StartEventSpool loads events left undelivered by previous agent process and
+// starts delivering events in background. Events reported before the spool
+// started, or by processes never starting it, are sent to sinks directly and
+// lost on failure.
+func StartEventSpool() error {
+	_spoolLock.Lock()
+	defer _spoolLock.Unlock()
+	if _spool != nil {
+		return nil
+	}
+	dir, err := eventsDir()
+	if err != nil {
+		return err
+	}
+	eventsConfig := config.GetConfig().MetricsEvents
+	s := newSpool(filepath.Join(dir, spoolFileName), configuredSinks(),
+		eventsConfig.SpoolMaxBytes, time.Duration(eventsConfig.DedupWindowSeconds)*time.Second)
+	s.load()
+	_spool = s
+	wrapgo.GoWithDefaultPanicHandler(s.run)
+	return nil
+}
+
+func getSpool() *spool {
+	_spoolLock.Lock()
+	defer _spoolLock.Unlock()
+	return _spool
+}
+
+// eventHash identifies events with the same id and keywords for deduplication
+func eventHash(event *MetricsEvent) string {
+	sum := sha256.Sum256([]byte(string(event.EventId) + "\n" + event.KeyWords))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *spool) load() {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.GetLogger().WithError(err).Errorln("Failed to read metrics event spool")
+		}
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	invalid := 0
+	var ids []uint64
+	journal := make(map[uint64]*spoolRecord)
+	for scanner.Scan() {
+		record := &spoolRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil || (record.Event == nil && !record.Removed) {
+			invalid++
+			continue
+		}
+		if record.Id > s.nextId {
+			s.nextId = record.Id
+		}
+		if record.Removed {
+			delete(journal, record.Id)
+			continue
+		}
+		if _, ok := journal[record.Id]; !ok {
+			ids = append(ids, record.Id)
+		}
+		journal[record.Id] = record
+	}
+	for _, id := range ids {
+		record, ok := journal[id]
+		if !ok {
+			continue
+		}
+		delete(journal, id)
+		// Sinks may be disabled since the event was spooled
+		var sinks []string
+		for _, name := range record.Sinks {
+			if _, ok := s.sinks[name]; ok {
+				sinks = append(sinks, name)
+			}
+		}
+		if len(sinks) == 0 {
+			continue
+		}
+		record.Sinks = sinks
+		// Deliver events left by previous agent process immediately
+		record.NextAttemptAt = time.Time{}
+		s.records = append(s.records, record)
+	}
+	if invalid > 0 {
+		log.GetLogger().Errorf("Ignored %d invalid records in metrics event spool", invalid)
+	}
+	if len(s.records) > 0 {
+		log.GetLogger().Infof("Loaded %d undelivered metrics events from spool", len(s.records))
+	}
+	s.compact()
+}
+
+// enqueue spools event unless the same event is waiting for delivery or has
+// been delivered within deduplication window
+func (s *spool) enqueue(event *MetricsEvent) bool {
+	hash := eventHash(event)
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.dedupWindow > 0 {
+		for h, acceptedAt := range s.accepted {
+			if now.Sub(acceptedAt) >= s.dedupWindow {
+				delete(s.accepted, h)
+			}
+		}
+		if _, ok := s.accepted[hash]; ok {
+			return false
+		}
+		for _, record := range s.records {
+			if record.Hash == hash {
+				return false
+			}
+		}
+	}
+	if len(s.sinks) == 0 {
+		return false
+	}
+
+	s.nextId++
+	record := &spoolRecord{
+		Id:        s.nextId,
+		Hash:      hash,
+		Sinks:     make([]string, 0, len(s.sinks)),
+		CreatedAt: now,
+		Event:     event,
+	}
+	for name := range s.sinks {
+		record.Sinks = append(record.Sinks, name)
+	}
+	s.records = append(s.records, record)
+	s.appendRecord(record)
+
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// appendRecord appends record to spool file, or record of removal if it has
+// been removed. Spool file is compacted instead if the size limit would be
+// exceeded. It MUST be called with lock held after changing records.
+func (s *spool) appendRecord(record *spoolRecord) {
+	entry := record
+	if entry.Removed {
+		entry = &spoolRecord{Id: record.Id, Removed: true}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if s.size+int64(len(line)) > s.maxBytes {
+		s.compact()
+		return
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to write metrics event spool")
+		return
+	}
+	defer f.Close()
+	n, err := f.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to write metrics event spool")
+	}
+}
+
+// compact rewrites spool file with records kept, and drops the oldest records
+// when exceeding the size limit. It MUST be called with lock held.
+func (s *spool) compact() {
+	lines := make([][]byte, 0, len(s.records))
+	var size int64
+	for _, record := range s.records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			line = nil
+		}
+		lines = append(lines, line)
+		size += int64(len(line)) + 1
+	}
+	dropped := 0
+	for len(lines) > 0 && size > s.maxBytes {
+		size -= int64(len(lines[0])) + 1
+		lines = lines[1:]
+		s.records = s.records[1:]
+		dropped++
+	}
+	if dropped > 0 {
+		log.GetLogger().WithFields(logrus.Fields{
+			"dropped":  dropped,
+			"maxBytes": s.maxBytes,
+		}).Errorln("Metrics event spool is full, the oldest events are dropped")
+	}
+
+	var buffer bytes.Buffer
+	for _, line := range lines {
+		if line == nil {
+			continue
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+	}
+	tempPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, buffer.Bytes(), 0600); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to write metrics event spool")
+		return
+	}
+	if err := os.Rename(tempPath, s.path); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to write metrics event spool")
+		return
+	}
+	s.size = int64(buffer.Len())
+}
+
+func (s *spool) run() {
+	for {
+		for _, record := range s.dueRecords() {
+			s.deliver(record)
+		}
+
+		timer := time.NewTimer(s.nextWait())
+		select {
+		case <-timer.C:
+		case <-s.wakeup:
+			timer.Stop()
+		}
+	}
+}
+
+func (s *spool) dueRecords() []*spoolRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	var due []*spoolRecord
+	for _, record := range s.records {
+		if !record.NextAttemptAt.After(now) {
+			due = append(due, record)
+		}
+	}
+	return due
+}
+
+func (s *spool) nextWait() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	wait := spoolMaxBackoff
+	now := time.Now()
+	for _, record := range s.records {
+		if d := record.NextAttemptAt.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// deliver sends event to sinks not yet accepting it. Only the delivery
+// goroutine modifies sinks and attempts of record.
+func (s *spool) deliver(record *spoolRecord) {
+	s.lock.Lock()
+	sinkNames := append([]string(nil), record.Sinks...)
+	s.lock.Unlock()
+
+	payload, err := json.Marshal(record.Event)
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Invalid metrics event is dropped")
+		s.remove(record, false)
+		return
+	}
+	var failed []string
+	var lastErr error
+	for _, name := range sinkNames {
+		if err := s.sinks[name].Send(record.Event, payload); err != nil {
+			failed = append(failed, name)
+			lastErr = err
+		}
+	}
+	if len(failed) == 0 {
+		s.remove(record, true)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record.Attempts++
+	record.NextAttemptAt = time.Now().Add(spoolBackoff(record.Attempts))
+	// Attempts are not persisted since events are retried immediately after
+	// restart, thus spool file is only changed when some sinks accepted it
+	if len(failed) < len(record.Sinks) && !record.Removed {
+		record.Sinks = failed
+		s.appendRecord(record)
+	}
+	log.GetLogger().WithFields(logrus.Fields{
+		"event":    record.Event.EventId,
+		"sinks":    failed,
+		"attempts": record.Attempts,
+	}).WithError(lastErr).Warningf("Failed to deliver metrics event, would retry at %s", record.NextAttemptAt.Format(time.RFC3339))
+}
+
+func (s *spool) remove(record *spoolRecord, accepted bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, r := range s.records {
+		if r == record {
+			s.records = append(s.records[:i], s.records[i+1:]...)
+			record.Removed = true
+			s.appendRecord(record)
+			break
+		}
+	}
+	if accepted && s.dedupWindow > 0 {
+		s.accepted[record.Hash] = time.Now()
+	}
+}
+
+// spoolBackoff returns exponential delay with jitter before next attempt
+func spoolBackoff(attempts int) time.Duration {
+	delay := spoolInitialBackoff
+	for i := 1; i < attempts && delay < spoolMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > spoolMaxBackoff {
+		delay = spoolMaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSink struct {
+	lock sync.Mutex
+	name string
+	err  error
+	sent []MetricsEventID
+}
+
+func (s *mockSink) Name() string {
+	return s.name
+}
+
+func (s *mockSink) Send(event *MetricsEvent, payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, event.EventId)
+	return nil
+}
+
+func testEvent(id MetricsEventID, keywords ...string) *MetricsEvent {
+	return &MetricsEvent{
+		EventId:    id,
+		EventLevel: EVENT_LEVEL_ERROR,
+		EventTime:  time.Now().UnixNano() / 1e6,
+		KeyWords:   genKeyWordsStr(keywords...),
+	}
+}
+
+func TestSpoolDeduplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics_spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sink := &mockSink{name: "mock"}
+	s := newSpool(filepath.Join(dir, spoolFileName), []EventSink{sink}, 1<<20, time.Minute)
+
+	assert.True(t, s.enqueue(testEvent(EVENT_CHANNEL_FAILED, "errormsg", "a")))
+	assert.False(t, s.enqueue(testEvent(EVENT_CHANNEL_FAILED, "errormsg", "a")))
+	assert.True(t, s.enqueue(testEvent(EVENT_CHANNEL_FAILED, "errormsg", "b")))
+	assert.True(t, s.enqueue(testEvent(EVENT_UPDATE_FAILED, "errormsg", "a")))
+
+	for _, record := range s.dueRecords() {
+		s.deliver(record)
+	}
+	assert.Equal(t, 3, len(sink.sent))
+	assert.Equal(t, 0, len(s.records))
+	// Delivered event is deduplicated within window
+	assert.False(t, s.enqueue(testEvent(EVENT_CHANNEL_FAILED, "errormsg", "a")))
+	s.accepted[eventHash(testEvent(EVENT_CHANNEL_FAILED, "errormsg", "a"))] = time.Now().Add(-time.Minute)
+	assert.True(t, s.enqueue(testEvent(EVENT_CHANNEL_FAILED, "errormsg", "a")))
+}
+
+func TestSpoolRetryAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics_spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, spoolFileName)
+	good := &mockSink{name: "good"}
+	bad := &mockSink{name: "bad", err: errors.New("unavailable")}
+	s := newSpool(path, []EventSink{good, bad}, 1<<20, time.Minute)
+
+	assert.True(t, s.enqueue(testEvent(EVENT_PERF_CPU_OVERLOAD, "cpu", "90")))
+	for _, record := range s.dueRecords() {
+		s.deliver(record)
+	}
+	assert.Equal(t, 1, len(good.sent))
+	assert.Equal(t, 1, len(s.records))
+	assert.Equal(t, []string{"bad"}, s.records[0].Sinks)
+	assert.Equal(t, 1, s.records[0].Attempts)
+	assert.True(t, s.records[0].NextAttemptAt.After(time.Now()))
+	assert.Equal(t, 0, len(s.dueRecords()))
+
+	// Undelivered event is replayed to the failed sink only after restart
+	bad.err = nil
+	s = newSpool(path, []EventSink{good, bad}, 1<<20, time.Minute)
+	s.load()
+	due := s.dueRecords()
+	assert.Equal(t, 1, len(due))
+	s.deliver(due[0])
+	assert.Equal(t, 1, len(good.sent))
+	assert.Equal(t, 1, len(bad.sent))
+	s = newSpool(path, []EventSink{good, bad}, 1<<20, time.Minute)
+	s.load()
+	assert.Equal(t, 0, len(s.records))
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(content))
+}
+
+func TestSpoolAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics_spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, spoolFileName)
+	good := &mockSink{name: "good"}
+	bad := &mockSink{name: "bad", err: errors.New("unavailable")}
+	s := newSpool(path, []EventSink{good, bad}, 1<<20, time.Minute)
+
+	lines := func() []string {
+		content, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+	assert.True(t, s.enqueue(testEvent(EVENT_PERF_CPU_OVERLOAD, "cpu", "90")))
+	assert.True(t, s.enqueue(testEvent(EVENT_PERF_MEM_OVERLOAD, "mem", "90")))
+	first := lines()
+	assert.Equal(t, 2, len(first))
+
+	// Changes are appended without rewriting existing lines, and failure
+	// of the same sinks again changes nothing
+	for _, record := range s.dueRecords() {
+		s.deliver(record)
+	}
+	s.records[0].NextAttemptAt = time.Time{}
+	s.deliver(s.records[0])
+	bad.err = nil
+	s.records[1].NextAttemptAt = time.Time{}
+	s.deliver(s.records[1])
+	current := lines()
+	assert.Equal(t, 5, len(current))
+	assert.Equal(t, first, current[:2])
+	assert.Contains(t, current[4], `"removed":true`)
+
+	s = newSpool(path, []EventSink{good, bad}, 1<<20, time.Minute)
+	s.load()
+	assert.Equal(t, 1, len(s.records))
+	assert.Equal(t, []string{"bad"}, s.records[0].Sinks)
+	assert.Equal(t, EVENT_PERF_CPU_OVERLOAD, s.records[0].Event.EventId)
+	// Spool file is compacted after loading, and new ids do not collide
+	assert.Equal(t, 1, len(lines()))
+	assert.True(t, s.enqueue(testEvent(EVENT_PERF_CPU_OVERLOAD, "cpu", "95")))
+	assert.NotEqual(t, s.records[0].Id, s.records[1].Id)
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics_spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, spoolFileName)
+	s := newSpool(path, []EventSink{&mockSink{name: "mock"}}, 1024, time.Minute)
+
+	for i := 0; i < 20; i++ {
+		s.enqueue(testEvent(EVENT_TASK_FAILED, "taskid", strings.Repeat("t", 10)+string(rune('a'+i))))
+	}
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.True(t, info.Size() <= 1024)
+	assert.True(t, len(s.records) < 20)
+	// The latest event is kept
+	assert.Contains(t, s.records[len(s.records)-1].Event.KeyWords, strings.Repeat("t", 10)+"t")
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	assert.True(t, spoolBackoff(1) >= spoolInitialBackoff)
+	assert.True(t, spoolBackoff(2) >= 2*spoolInitialBackoff)
+	assert.True(t, spoolBackoff(100) >= spoolMaxBackoff)
+	assert.True(t, spoolBackoff(100) <= spoolMaxBackoff+spoolMaxBackoff/5)
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics_sink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, auditFileName)
+	sink := newFileSink(path, 100)
+
+	payload := []byte(`{"eventId":"` + strings.Repeat("x", 20) + `"}`)
+	assert.NoError(t, sink.Send(testEvent(EVENT_UPDATE_FAILED), payload))
+	assert.NoError(t, sink.Send(testEvent(EVENT_UPDATE_FAILED), payload))
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+
+	assert.NoError(t, sink.Send(testEvent(EVENT_UPDATE_FAILED), payload))
+	content, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(payload)+"\n", string(content))
+	_, err = os.Stat(path + ".1")
+	assert.NoError(t, err)
+}
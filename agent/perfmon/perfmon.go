@@ -9,6 +9,7 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/clientreport"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
 	"github.com/aliyun/aliyun_assist_client/agent/statemanager"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
 	"github.com/aliyun/aliyun_assist_client/agent/update"
@@ -106,6 +107,14 @@ var bCollectCpuLoadByAgent bool = true
 func StartSelfKillMon() {
 	var _taskFactory *taskengine.TaskFactory = taskengine.GetTaskFactory()
 	_perf = StartPerfmon(os.Getpid(), 5, func(cpuUsage float64, memory uint64, threads uint64) {
+		openmetrics.ProcessCPUUsage.Set(cpuUsage)
+		// Memory and threads are not measured on Windows yet
+		if memory > 0 {
+			openmetrics.ProcessResidentMemory.Set(float64(memory * 1024))
+		}
+		if threads > 0 {
+			openmetrics.ProcessThreads.Set(float64(threads))
+		}
 		if _taskFactory.IsAnyTaskRunning() || update.IsCPUIntensiveActionRunning() || taskengine.GetSessionFactory().IsAnyTaskRunning() { //没有任务执行时才监控性能
 			return
 		}
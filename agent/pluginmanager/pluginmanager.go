@@ -163,6 +163,7 @@ func pluginHealthCheckScan() {
 		Plugin: []PluginStatus{},
 	}
 	persistPluginCount := 0
+	abnormalPluginStatus := []PluginStatus{}
 	pluginInfoMap := make(map[string]*PluginInfo)
 	for _, pluginInfo := range pluginInfoList {
 		pluginInfoMap[pluginInfo.Name] = &pluginInfo
@@ -220,6 +221,7 @@ func pluginHealthCheckScan() {
 				pluginStatus.Version = pluginStatus.Version[:PLUGIN_VERSION_MAXLEN]
 			}
 			if pluginInfo.Status != PERSIST_RUNNING && pluginInfo.Status != REMOVED {
+				abnormalPluginStatus = append(abnormalPluginStatus, pluginStatus)
 				// // 状态异常的常驻插件本次不上报，acs-plugin-manager调用--start拉起后会单独上报该插件的状态
 				log.GetLogger().Warnf("plugin[%s] is not running, try to start it", pluginInfo.Name)
 				go func() {
@@ -241,6 +243,7 @@ func pluginHealthCheckScan() {
 			}
 		}
 	}
+	recordPluginStatus(append(abnormalPluginStatus, pluginStatusRequest.Plugin...), true)
 	requestPayloadBytes, err := json.Marshal(pluginStatusRequest)
 	if err != nil {
 		log.GetLogger().WithError(err).Error("pluginHealthCheckScan: pluginStatusList marshal err: " + err.Error())
@@ -374,6 +377,7 @@ func pluginHealthCheckPull() {
 		log.GetLogger().Infof("pluginHealthCheckPull: there is no persist plugin with heartbeat")
 		return
 	}
+	recordPluginStatus(pluginStatusRequest.Plugin, false)
 	willReport := true
 	if lazyReport {
 		// 如果lazyReport为true，对比本次上报的插件状态和上次的插件状态是否一致，如果不一致才上报
@@ -0,0 +1,44 @@
+package pluginmanager
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// Plugin status observed by the most recent health check, keyed by name
+	_observedPluginStatus     = map[string]PluginStatus{}
+	_observedPluginStatusTime time.Time
+	_observedPluginStatusLock sync.Mutex
+)
+
+// recordPluginStatus saves plugin status observed by health check. Full scan
+// replaces all records, while pulling heart-beat of persist plugins only
+// updates records of those plugins.
+func recordPluginStatus(statusList []PluginStatus, fullScan bool) {
+	_observedPluginStatusLock.Lock()
+	defer _observedPluginStatusLock.Unlock()
+	if fullScan {
+		_observedPluginStatus = map[string]PluginStatus{}
+	}
+	for _, status := range statusList {
+		_observedPluginStatus[status.Name] = status
+	}
+	_observedPluginStatusTime = time.Now()
+}
+
+// ObservedPluginStatus returns plugin status observed by the most recent
+// health check sorted by name, and when it was observed
+func ObservedPluginStatus() ([]PluginStatus, time.Time) {
+	_observedPluginStatusLock.Lock()
+	defer _observedPluginStatusLock.Unlock()
+	statusList := make([]PluginStatus, 0, len(_observedPluginStatus))
+	for _, status := range _observedPluginStatus {
+		statusList = append(statusList, status)
+	}
+	sort.Slice(statusList, func(i, j int) bool {
+		return statusList[i].Name < statusList[j].Name
+	})
+	return statusList, _observedPluginStatusTime
+}
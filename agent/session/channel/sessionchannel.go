@@ -7,10 +7,55 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/session/retry"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"github.com/gorilla/websocket"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	// StatusCodeWarning is code of status message warning user that session
+	// would be closed soon, followed by text of warning
+	StatusCodeWarning = 8
+	// StatusCodeStreamReady is code of status message telling client that
+	// agent accepts stream messages of multiplexed port forwarding, which
+	// agents of previous versions never send
+	StatusCodeStreamReady = 9
+
+	// StreamCloseUnsupported is error code closing streams opened in session
+	// other than multiplexed port forwarding
+	StreamCloseUnsupported = "Stream_unsupported"
+
+	limitReasonIdle        = "inactivity"
+	limitReasonMaxDuration = "maximum duration reached"
+)
+
+// SessionLimits bounds lifetime of session, which is canceled when no input
+// received within IdleTimeout or lasting longer than MaxDuration. Limits not
+// greater than 0 are disabled.
+type SessionLimits struct {
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+	// WarningPeriod is how long before closing the user is warned
+	WarningPeriod time.Duration
+	// WarnByStatus sends warning in status message instead of output stream,
+	// which would be mixed into forwarded data of port forwarding sessions
+	WarnByStatus bool
+}
+
+// nearest returns the limit closing session first and remaining time before
+// it, or empty reason if no limit enabled
+func (limits SessionLimits) nearest(elapsed time.Duration, idle time.Duration) (reason string, remaining time.Duration) {
+	if limits.IdleTimeout > 0 {
+		reason, remaining = limitReasonIdle, limits.IdleTimeout-idle
+	}
+	if limits.MaxDuration > 0 {
+		if durationRemaining := limits.MaxDuration - elapsed; reason == "" || durationRemaining <= remaining {
+			reason, remaining = limitReasonMaxDuration, durationRemaining
+		}
+	}
+	return
+}
+
 type InputStreamMessageHandler func(streamDataMessage message.Message) error
 
 type ISessionChannel interface {
@@ -18,6 +63,8 @@ type ISessionChannel interface {
 	Close() error
 	Reconnect() error
 	SendStreamDataMessage(inputData []byte) (err error)
+	SendStreamMessage(messageType uint32, streamId uint32, payload []byte) (err error)
+	SendStatusDataMessage(payload []byte) (err error)
 	GetChannelId() string
 	IsActive() bool
 }
@@ -26,15 +73,22 @@ type SessionChannel struct {
 	wsChannel  IWebSocketChannel
 	ChannelId string
 	StreamDataSequenceNumber int64
-	input_stream_cnt uint32
+	// lastInputTime is UnixNano of the time receiving the latest input
+	lastInputTime int64
 	inputStreamMessageHandler func(streamDataMessage message.Message) error
+	// acceptStreams is set for multiplexed port forwarding, stream messages
+	// are rejected otherwise
+	acceptStreams bool
+	// sendLock keeps order of sequence number when sending from goroutines
+	// of plugin and limits
+	sendLock sync.Mutex
 }
 
-func NewSessionChannel(url string, sessionId string, inputStreamMessageHandler InputStreamMessageHandler, cancelFlag util.CancelFlag) (*SessionChannel, error) {
+func NewSessionChannel(url string, sessionId string, inputStreamMessageHandler InputStreamMessageHandler, cancelFlag util.CancelFlag, limits SessionLimits) (*SessionChannel, error) {
 	sessionChannel := &SessionChannel{}
 	sessionChannel.StreamDataSequenceNumber = 0
 	sessionChannel.ChannelId = sessionId
-	sessionChannel.input_stream_cnt = 0
+	sessionChannel.lastInputTime = time.Now().UnixNano()
 	sessionChannel.wsChannel = &WebSocketChannel{
 	}
 	sessionChannel.inputStreamMessageHandler = inputStreamMessageHandler
@@ -70,20 +124,53 @@ func NewSessionChannel(url string, sessionId string, inputStreamMessageHandler I
 		log.GetLogger().Errorf("failed to initialize websocket channel for datachannel, error: %s", err)
 		return nil, err
 	}
-	go func () {
-		for {
-			if atomic.LoadUint32(&sessionChannel.input_stream_cnt) > 60*3 {
-				cancelFlag.Set(util.Canceled)
-				log.GetLogger().Infoln("timeout in sessionChannel")
-				break
+	go sessionChannel.watchLimits(limits, cancelFlag)
+
+	return sessionChannel,nil
+}
+
+// watchLimits warns user before session is closed by limits, and cancels the
+// session when any limit reached
+func (sessionChannel *SessionChannel) watchLimits(limits SessionLimits, cancelFlag util.CancelFlag) {
+	startTime := time.Now()
+	warnedReason := ""
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if cancelFlag.Canceled() || cancelFlag.State() == util.Completed {
+			return
+		}
+		now := time.Now()
+		idle := now.Sub(time.Unix(0, atomic.LoadInt64(&sessionChannel.lastInputTime)))
+		reason, remaining := limits.nearest(now.Sub(startTime), idle)
+		if reason == "" {
+			return
+		}
+		if remaining <= 0 {
+			log.GetLogger().Infof("timeout in sessionChannel %s due to %s", sessionChannel.ChannelId, reason)
+			cancelFlag.Set(util.Canceled)
+			return
+		}
+		if remaining > limits.WarningPeriod {
+			// Input received after warned about inactivity
+			warnedReason = ""
+			continue
+		}
+		if warnedReason != reason {
+			warnedReason = reason
+			seconds := int((remaining + time.Second - 1) / time.Second)
+			if err := sessionChannel.sendWarning(fmt.Sprintf("Session will be closed in %d seconds due to %s.", seconds, reason), limits.WarnByStatus); err != nil {
+				log.GetLogger().Errorf("Failed to send warning of session %s: %v", sessionChannel.ChannelId, err)
 			}
-			time.Sleep(time.Second)
-			atomic.AddUint32(&sessionChannel.input_stream_cnt, 1)
 		}
+	}
+}
 
-	} ()
-
-	return sessionChannel,nil
+func (sessionChannel *SessionChannel) sendWarning(warning string, byStatus bool) error {
+	if byStatus {
+		return sessionChannel.SendStatusDataMessage(append([]byte{StatusCodeWarning}, warning...))
+	}
+	return sessionChannel.SendStreamDataMessage([]byte("\r\n" + warning + "\r\n"))
 }
 
 func (sessionChannel *SessionChannel) IsActive() bool {
@@ -125,6 +212,12 @@ func (sessionChannel *SessionChannel) SendMessage( input []byte, inputType int)
 	return sessionChannel.wsChannel.SendMessage(input, inputType)
 }
 
+// AcceptStreamMessages makes stream messages passed to plugin, which is only
+// called for multiplexed port forwarding
+func (sessionChannel *SessionChannel) AcceptStreamMessages() {
+	sessionChannel.acceptStreams = true
+}
+
 func (sessionChannel *SessionChannel) GetChannelId() string {
 	return sessionChannel.ChannelId
 }
@@ -148,7 +241,7 @@ func (sessionChannel *SessionChannel) inputMessageHandler(rawMessage []byte) err
 		log.GetLogger().Infoln("user input payload: ", string(streamDataMessage.Payload))
 	}
 
-	atomic.StoreUint32(&sessionChannel.input_stream_cnt, 0)
+	atomic.StoreInt64(&sessionChannel.lastInputTime, time.Now().UnixNano())
 
 
 	switch streamDataMessage.MessageType {
@@ -158,6 +251,17 @@ func (sessionChannel *SessionChannel) inputMessageHandler(rawMessage []byte) err
 		 return sessionChannel.handleStreamDataMessage( *streamDataMessage, rawMessage)
 	case message.StatusDataMessage:
 		return sessionChannel.handleStreamDataMessage( *streamDataMessage, rawMessage)
+	case message.StreamOpenMessage, message.StreamDataMessage, message.StreamCloseMessage, message.StreamAckMessage:
+		if !sessionChannel.acceptStreams {
+			// e.g., server started shell session ignoring parameters of
+			// multiplexing, thus client is told instead of input taken
+			log.GetLogger().Warnf("Stream message received by session %s not multiplexed", sessionChannel.ChannelId)
+			if streamDataMessage.MessageType == message.StreamCloseMessage || streamDataMessage.MessageType == message.StreamAckMessage {
+				return nil
+			}
+			return sessionChannel.SendStreamMessage(message.StreamCloseMessage, streamDataMessage.StreamId, []byte(StreamCloseUnsupported))
+		}
+		return sessionChannel.handleStreamDataMessage( *streamDataMessage, rawMessage)
 	default:
 		log.GetLogger().Warnf("Invalid message type received: %d", streamDataMessage.MessageType)
 	}
@@ -177,20 +281,49 @@ func (sessionChannel *SessionChannel) handleStreamDataMessage(
 
 // SendStreamDataMessage sends a data message in a form of AgentMessage for streaming.
 func (sessionChannel *SessionChannel) SendStreamDataMessage(inputData []byte) (err error) {
-	if len(inputData) == 0 {
+	return sessionChannel.sendDataMessage(message.OutputStreamDataMessage, inputData)
+}
+
+// SendStatusDataMessage sends a status message, whose first byte of payload
+// is status code
+func (sessionChannel *SessionChannel) SendStatusDataMessage(payload []byte) (err error) {
+	return sessionChannel.sendDataMessage(message.StatusDataMessage, payload)
+}
+
+// SendStreamMessage sends a message of multiplexed port forwarding stream.
+// Payload of open and close messages could be empty.
+func (sessionChannel *SessionChannel) SendStreamMessage(messageType uint32, streamId uint32, payload []byte) (err error) {
+	if messageType == message.StreamDataMessage && len(payload) == 0 {
 		log.GetLogger().Debugf("Ignoring empty stream data payload.")
 		return nil
 	}
+	return sessionChannel.sendAgentMessage(&message.Message{
+		MessageType: messageType,
+		StreamId:    streamId,
+		Payload:     payload,
+	})
+}
 
-	agentMessage := &message.Message{
-		MessageType:   message.OutputStreamDataMessage,
-		SchemaVersion:  "1.01",
-		SessionId:  sessionChannel.ChannelId,
-		CreatedDate:    uint64(time.Now().UnixNano() / 1000000),
-		SequenceNumber: sessionChannel.StreamDataSequenceNumber,
-		PayloadLength:   uint32(len(inputData)),
-		Payload:        inputData,
+func (sessionChannel *SessionChannel) sendDataMessage(messageType uint32, inputData []byte) (err error) {
+	if len(inputData) == 0 {
+		log.GetLogger().Debugf("Ignoring empty stream data payload.")
+		return nil
 	}
+	return sessionChannel.sendAgentMessage(&message.Message{
+		MessageType: messageType,
+		Payload:     inputData,
+	})
+}
+
+// sendAgentMessage fills header fields of agentMessage and sends it
+func (sessionChannel *SessionChannel) sendAgentMessage(agentMessage *message.Message) (err error) {
+	sessionChannel.sendLock.Lock()
+	defer sessionChannel.sendLock.Unlock()
+	agentMessage.SchemaVersion = "1.01"
+	agentMessage.SessionId = sessionChannel.ChannelId
+	agentMessage.CreatedDate = uint64(time.Now().UnixNano() / 1000000)
+	agentMessage.SequenceNumber = sessionChannel.StreamDataSequenceNumber
+	agentMessage.PayloadLength = uint32(len(agentMessage.Payload))
 	msg, err := agentMessage.Serialize()
 
 	if util.IsVerboseMode() {
@@ -3,9 +3,12 @@ package channel
 import (
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
+	"github.com/stretchr/testify/assert"
 	"github.com/aliyun/aliyun_assist_client/agent/session/message"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 )
@@ -56,7 +59,7 @@ func TestNewSessionChannel(t *testing.T) {
 					"Initialize", func(c *WebSocketChannel, channelUrl string, onMessageHandler func([]byte), onErrorHandler func(error)) error { return nil })
 				defer guard.Unpatch()
 			}
-			_, err := NewSessionChannel(tt.args.url, tt.args.sessionId, tt.args.inputStreamMessageHandler, tt.args.cancelFlag)
+			_, err := NewSessionChannel(tt.args.url, tt.args.sessionId, tt.args.inputStreamMessageHandler, tt.args.cancelFlag, SessionLimits{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewSessionChannel() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -64,3 +67,105 @@ func TestNewSessionChannel(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionLimitsNearest(t *testing.T) {
+	limits := SessionLimits{
+		IdleTimeout: 3 * time.Minute,
+		MaxDuration: time.Hour,
+	}
+	reason, remaining := limits.nearest(10*time.Minute, time.Minute)
+	assert.Equal(t, limitReasonIdle, reason)
+	assert.Equal(t, 2*time.Minute, remaining)
+
+	reason, remaining = limits.nearest(59*time.Minute, time.Minute)
+	assert.Equal(t, limitReasonMaxDuration, reason)
+	assert.Equal(t, time.Minute, remaining)
+
+	reason, _ = SessionLimits{}.nearest(time.Hour, time.Hour)
+	assert.Equal(t, "", reason)
+}
+
+type recordingWebSocketChannel struct {
+	WebSocketChannel
+	lock     sync.Mutex
+	messages []message.Message
+}
+
+func (c *recordingWebSocketChannel) SendMessage(input []byte, inputType int) error {
+	msg := message.Message{}
+	if err := msg.Deserialize(input); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.messages = append(c.messages, msg)
+	c.lock.Unlock()
+	return nil
+}
+
+func TestWatchLimits(t *testing.T) {
+	for _, byStatus := range []bool{false, true} {
+		wsChannel := &recordingWebSocketChannel{}
+		sessionChannel := &SessionChannel{
+			ChannelId:     "sessionId",
+			wsChannel:     wsChannel,
+			lastInputTime: time.Now().UnixNano(),
+		}
+		cancelFlag := util.NewChanneledCancelFlag()
+		sessionChannel.watchLimits(SessionLimits{
+			IdleTimeout:   2500 * time.Millisecond,
+			WarningPeriod: 2 * time.Second,
+			WarnByStatus:  byStatus,
+		}, cancelFlag)
+
+		assert.True(t, cancelFlag.Canceled())
+		wsChannel.lock.Lock()
+		assert.Equal(t, 1, len(wsChannel.messages))
+		warning := wsChannel.messages[0]
+		wsChannel.lock.Unlock()
+		if byStatus {
+			assert.Equal(t, uint32(message.StatusDataMessage), warning.MessageType)
+			assert.Equal(t, byte(StatusCodeWarning), warning.Payload[0])
+			assert.Contains(t, string(warning.Payload[1:]), limitReasonIdle)
+		} else {
+			assert.Equal(t, uint32(message.OutputStreamDataMessage), warning.MessageType)
+			assert.Contains(t, string(warning.Payload), limitReasonIdle)
+		}
+	}
+}
+
+func TestRejectStreamMessages(t *testing.T) {
+	wsChannel := &recordingWebSocketChannel{}
+	handled := 0
+	sessionChannel := &SessionChannel{
+		ChannelId: "sessionId",
+		wsChannel: wsChannel,
+		inputStreamMessageHandler: func(streamDataMessage message.Message) error {
+			handled++
+			return nil
+		},
+	}
+	openMessage := &message.Message{
+		MessageType:   message.StreamOpenMessage,
+		SchemaVersion: "1.01",
+		SessionId:     "sessionId",
+		CreatedDate:   uint64(time.Now().UnixNano() / 1000000),
+		StreamId:      1,
+	}
+	raw, err := openMessage.Serialize()
+	assert.NoError(t, err)
+
+	// Shell session tells client instead of taking stream as input
+	assert.NoError(t, sessionChannel.inputMessageHandler(raw))
+	assert.Equal(t, 0, handled)
+	wsChannel.lock.Lock()
+	assert.Equal(t, 1, len(wsChannel.messages))
+	closeMessage := wsChannel.messages[0]
+	wsChannel.lock.Unlock()
+	assert.Equal(t, uint32(message.StreamCloseMessage), closeMessage.MessageType)
+	assert.Equal(t, uint32(1), closeMessage.StreamId)
+	assert.Equal(t, StreamCloseUnsupported, string(closeMessage.Payload))
+
+	sessionChannel.AcceptStreamMessages()
+	assert.NoError(t, sessionChannel.inputMessageHandler(raw))
+	assert.Equal(t, 1, handled)
+}
@@ -15,6 +15,18 @@ const (
 	SetSizeDataMessage = 2 //string = "set_size"
 	CloseDataChannel = 3
 	StatusDataMessage = 5
+	// Messages of multiplexed port forwarding, whose payload starts with
+	// stream id in 4 bytes
+	StreamOpenMessage = 6
+	StreamDataMessage = 7
+	StreamCloseMessage = 8
+	// StreamAckMessage grants sender of data messages more credits, i.e.,
+	// number of data messages consumed, in 4 bytes following stream id
+	StreamAckMessage = 9
+)
+
+const (
+	StreamIdLength = 4
 )
 
 const (
@@ -45,6 +57,15 @@ type Message struct {
 	// MessageId      string
 	PayloadLength  uint32
 	Payload        []byte
+	// StreamId identifies TCP stream of multiplexed port forwarding, only
+	// carried by stream messages
+	StreamId       uint32
+}
+
+// IsStreamMessage returns true if message of messageType carries stream id
+func IsStreamMessage(messageType uint32) bool {
+	return messageType == StreamOpenMessage || messageType == StreamDataMessage ||
+		messageType == StreamCloseMessage || messageType == StreamAckMessage
 }
 
 func (message *Message) Deserialize(input []byte) (err error) {
@@ -80,6 +101,19 @@ func (message *Message) Deserialize(input []byte) (err error) {
 	message.PayloadLength, err = getUInteger(input, AgentMessage_PayloadLengthOffset)
 	message.Payload = input[AgentMessage_PayloadOffset:]
 
+	if IsStreamMessage(message.MessageType) {
+		if len(message.Payload) < StreamIdLength {
+			log.GetLogger().Error("Could not deserialize field StreamId: payload is too short.")
+			return errors.New("Payload is too short for stream id.")
+		}
+		var streamId int32
+		if streamId, err = bytesToInteger(message.Payload[:StreamIdLength]); err != nil {
+			return err
+		}
+		message.StreamId = uint32(streamId)
+		message.Payload = message.Payload[StreamIdLength:]
+	}
+
 	return nil
 }
 
@@ -136,7 +170,12 @@ func (message *Message) Validate() error {
 }
 
 func (message *Message) Serialize() (result []byte, err error) {
-	payloadLength := uint32(len(message.Payload))
+	payload := message.Payload
+	if IsStreamMessage(message.MessageType) {
+		streamId, _ := integerToBytes(int32(message.StreamId))
+		payload = append(streamId, message.Payload...)
+	}
+	payloadLength := uint32(len(payload))
 	headerLength := uint32(AgentMessage_PayloadLengthOffset)
 	// If the payloadinfo length is incorrect, fix it.
 	if payloadLength != message.PayloadLength {
@@ -185,7 +224,7 @@ func (message *Message) Serialize() (result []byte, err error) {
 
 	startPosition = AgentMessage_PayloadOffset
 	endPosition = AgentMessage_PayloadOffset + int(payloadLength) - 1
-	if err = putBytes(result, startPosition, endPosition, message.Payload); err != nil {
+	if err = putBytes(result, startPosition, endPosition, payload); err != nil {
 		log.GetLogger().Errorf("Could not serialize Payload with error: %v", err)
 		return make([]byte, 1), err
 	}
@@ -31,4 +31,39 @@ func TestMessage_Serialize(t *testing.T) {
 	assert.Equal(t, agentMessage2.SchemaVersion, "1.1")
 	assert.Equal(t, agentMessage2.MessageType, uint32(OutputStreamDataMessage))
 	assert.Equal(t, string(agentMessage2.Payload), "hello world")
-}
\ No newline at end of file
+}
+func TestMessage_SerializeStream(t *testing.T) {
+	for _, payload := range [][]byte{[]byte("hello world"), {}} {
+		agentMessage := &Message{
+			MessageType:    StreamDataMessage,
+			SchemaVersion:  "1.1",
+			CreatedDate:    uint64(time.Now().UnixNano() / 1000000),
+			SequenceNumber: 102,
+			Payload:        payload,
+			StreamId:       0x01020304,
+		}
+		if len(payload) == 0 {
+			agentMessage.MessageType = StreamCloseMessage
+		}
+		msg, err := agentMessage.Serialize()
+		assert.Equal(t, err, nil)
+
+		agentMessage2 := &Message{}
+		err = agentMessage2.Deserialize(msg)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, agentMessage.MessageType, agentMessage2.MessageType)
+		assert.Equal(t, uint32(0x01020304), agentMessage2.StreamId)
+		assert.Equal(t, string(payload), string(agentMessage2.Payload))
+	}
+
+	// Stream message without stream id is invalid
+	agentMessage := &Message{
+		MessageType:    InputStreamDataMessage,
+		SchemaVersion:  "1.1",
+		CreatedDate:    uint64(time.Now().UnixNano() / 1000000),
+		Payload:        []byte("ab"),
+	}
+	msg, _ := agentMessage.Serialize()
+	msg[AgentMessage_MessageTypeOffset] = StreamDataMessage
+	assert.Error(t, (&Message{}).Deserialize(msg))
+}
@@ -251,6 +251,10 @@ func (c *Client) ProcessStatusDataChannel(payload []byte) error {
 			return errors.New("Connection closed. code 5")
 		} else if code == 3 {
 
+		} else if code == 8 { //即将断开连接的警告
+			// Output is the local connection in port forwarding, thus warning
+			// goes to stderr
+			fmt.Fprintln(os.Stderr, string(payload[1:]))
 		}
 	}
 	return nil
@@ -27,6 +27,8 @@ const (
 	ModeFlagName            = "mode"
 	LocalPortFlagName		= "local-port"
 	RemotePortFlagName		= "remote-port"
+	RemoteHostFlagName		= "remote-host"
+	MultiplexFlagName		= "multiplex"
 	AccessKeyIdFlagName     = "access-key-id"
 	AccessKeySecretFlagName = "access-key-secret"
 	StsTokenFlagName        = "sts-token"
@@ -58,6 +60,8 @@ func AddFlags(fs *cli.FlagSet) {
 	/////////////////////////////////////////////////
 	fs.Add(NewLocalPortFlag())
 	fs.Add(NewRemotePortFlag())
+	fs.Add(NewRemoteHostFlag())
+	fs.Add(NewMultiplexFlag())
 	/////////////////////////////////////////////////
     fs.Add(NewLanguageFlag())
 	fs.Add(NewRegionFlag())
@@ -115,6 +119,14 @@ func RemotePortFlag(fs *cli.FlagSet) *cli.Flag {
 	return fs.Get(RemotePortFlagName)
 }
 
+func RemoteHostFlag(fs *cli.FlagSet) *cli.Flag {
+	return fs.Get(RemoteHostFlagName)
+}
+
+func MultiplexFlag(fs *cli.FlagSet) *cli.Flag {
+	return fs.Get(MultiplexFlagName)
+}
+
 func AccessKeyIdFlag(fs *cli.FlagSet) *cli.Flag {
 	return fs.Get(AccessKeyIdFlagName)
 }
@@ -278,6 +290,30 @@ func NewRemotePortFlag() *cli.Flag {
 			"use `--remote-port <port>` to select remote port",
 			"使用 `--remote-port <port>` 指定实例的端口")}
 }
+
+func NewRemoteHostFlag() *cli.Flag {
+	return &cli.Flag{
+		Category: "caller",
+		Name: RemoteHostFlagName,
+		AssignedMode: cli.AssignedOnce,
+		DefaultValue: "",
+		Persistent: true,
+		Short: i18n.T(
+			"use `--remote-host <host>` to forward to a host reachable from the instance instead of the instance itself, which must be allowed by the agent",
+			"使用 `--remote-host <host>` 转发到实例可访问的其他主机而非实例本身，该主机需在 agent 的允许列表中")}
+}
+
+func NewMultiplexFlag() *cli.Flag {
+	return &cli.Flag{
+		Category: "caller",
+		Name: MultiplexFlagName,
+		AssignedMode: cli.AssignedNone,
+		DefaultValue: "",
+		Persistent: true,
+		Short: i18n.T(
+			"use `--multiplex` to carry all local connections over one session instead of one session per connection",
+			"使用 `--multiplex` 在一个会话中承载所有本地连接，而非每个连接一个会话")}
+}
 ///////////////////////////////////////////////////////////////////////////////////////////
 //--mode {AK|StsToken|RamRoleArn|EcsRamRole|RsaKeyPair|RamRoleArnWithRoleName}
 func NewModeFlag() *cli.Flag {
@@ -13,6 +13,7 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/session"
 	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/ssh"
 	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/portforward"
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/socks"
 	"github.com/spf13/pflag"
 )
 
@@ -98,6 +99,7 @@ func main() {
 	rootCmd.AddSubCommand(session.NewSessionCommand())
 	rootCmd.AddSubCommand(ssh.NewSshCommand())
 	rootCmd.AddSubCommand(portforward.NewPortForwardCommand())
+	rootCmd.AddSubCommand(socks.NewSocksCommand())
 	//rootCmd.AddSubCommand(cli.NewAutoCompleteCommand())
 	rootCmd.Execute(ctx, os.Args[1:])
 }
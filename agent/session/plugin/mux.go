@@ -0,0 +1,482 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/message"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	muxReadBufferSize = 2048
+	// muxOpenTimeout is how long to wait for agent connecting target of a
+	// stream, which dials with timeout of 10 seconds
+	muxOpenTimeout = 30 * time.Second
+	// muxReadyTimeout is how long to wait for agent announcing it supports
+	// stream messages after session started
+	muxReadyTimeout = 10 * time.Second
+	// muxWindowSize is number of data messages of a stream either side may
+	// send before acknowledged by the other, the same as agent. It is also
+	// size of queue buffering data from agent for local connection. Agent
+	// sending beyond the window violates protocol and the stream is closed,
+	// since other streams must not wait for a slow local connection.
+	muxWindowSize = 64
+	// muxAckBatchSize is number of data messages written to local connection
+	// before acknowledging them to agent
+	muxAckBatchSize = 16
+
+	// Error code of agent closing stream since target not allowed
+	streamCloseTargetDenied = "Target_denied"
+	// Status code of agent supporting stream messages
+	statusStreamReady = 9
+)
+
+var (
+	ErrStreamTargetDenied = errors.New("target is not allowed by agent")
+	ErrMuxNotSupported    = errors.New("agent does not support multiplexed port forwarding, please upgrade it")
+)
+
+type muxStream struct {
+	local net.Conn
+	// opened receives result of opening from agent, empty if connected or
+	// error code otherwise. It is nil if nobody waits or already opened.
+	opened chan string
+	// output queues data from agent, which is written to local connection by
+	// writeLocal. It is only sent to and closed by readLoop, and closed when
+	// agent closed the stream.
+	output chan []byte
+	// done is closed when the stream is removed for other reasons
+	done chan struct{}
+
+	// sendCredit is number of data messages which may be sent to agent
+	// before acknowledged, and sendClosed is set once no more data should be
+	// sent. Both are guarded by creditLock.
+	creditLock sync.Mutex
+	creditCond *sync.Cond
+	sendCredit int
+	sendClosed bool
+}
+
+func newMuxStream(local net.Conn, opened chan string) *muxStream {
+	stream := &muxStream{
+		local:      local,
+		opened:     opened,
+		output:     make(chan []byte, muxWindowSize),
+		done:       make(chan struct{}),
+		sendCredit: muxWindowSize,
+	}
+	stream.creditCond = sync.NewCond(&stream.creditLock)
+	return stream
+}
+
+// acquireCredit waits until agent has acknowledged enough data to send one
+// more data message, and returns false if the stream is closed meanwhile
+func (s *muxStream) acquireCredit() bool {
+	s.creditLock.Lock()
+	defer s.creditLock.Unlock()
+	for s.sendCredit <= 0 && !s.sendClosed {
+		s.creditCond.Wait()
+	}
+	if s.sendClosed {
+		return false
+	}
+	s.sendCredit--
+	return true
+}
+
+func (s *muxStream) addCredit(credit int) {
+	s.creditLock.Lock()
+	s.sendCredit += credit
+	s.creditLock.Unlock()
+	s.creditCond.Broadcast()
+}
+
+// stopSending wakes up and fails sender waiting for credits
+func (s *muxStream) stopSending() {
+	s.creditLock.Lock()
+	s.sendClosed = true
+	s.creditLock.Unlock()
+	s.creditCond.Broadcast()
+}
+
+// MuxClient carries many local connections over one session of multiplexed
+// port forwarding. Each connection is a stream identified by stream id, which
+// agent opens its own connection to target for.
+type MuxClient struct {
+	Dialer         *websocket.Dialer
+	Conn           *websocket.Conn
+	URL            string
+	token          string
+	writeMutex     sync.Mutex
+	sequenceNumber int64
+	verbosemode    bool
+
+	streamsLock  sync.Mutex
+	streams      map[uint32]*muxStream
+	nextStreamId uint32
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	done chan struct{}
+	err  error
+}
+
+func NewMuxClient(inputURL string, token string, verbosemode bool) *MuxClient {
+	return &MuxClient{
+		Dialer:      &websocket.Dialer{},
+		URL:         inputURL,
+		token:       token,
+		verbosemode: verbosemode,
+		streams:     make(map[uint32]*muxStream),
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Connect dials the websocket server and starts dispatching messages from
+// agent to streams
+func (c *MuxClient) Connect() error {
+	log.GetLogger().Debugln("Connecting to websocket: ", c.URL)
+	header := http.Header{}
+	header.Add("x-acs-session-token", c.token)
+	conn, _, err := c.Dialer.Dial(c.URL, header)
+	if err != nil {
+		return err
+	}
+	c.Conn = conn
+	go c.readLoop()
+	return nil
+}
+
+// WaitReady waits for agent announcing it supports stream messages, which
+// agents of previous versions never do
+func (c *MuxClient) WaitReady() error {
+	timer := time.NewTimer(muxReadyTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.ready:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("session closed: %v", c.err)
+	case <-timer.C:
+		return ErrMuxNotSupported
+	}
+}
+
+// Done is closed when the session is closed, then Err tells why
+func (c *MuxClient) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *MuxClient) Err() error {
+	return c.err
+}
+
+// Serve forwards local connection as a new stream until either side closes
+// it. Data is sent without waiting for agent connecting target, which agent
+// queues meanwhile.
+func (c *MuxClient) Serve(local net.Conn) error {
+	defer local.Close()
+	streamId, err := c.addStream(newMuxStream(local, nil))
+	if err != nil {
+		return err
+	}
+	if err := c.sendStreamMessage(message.StreamOpenMessage, streamId, nil); err != nil {
+		c.removeStream(streamId)
+		return err
+	}
+	return c.Forward(streamId, local)
+}
+
+// Open opens a stream for local connection and waits until agent connected
+// target of it. Target "host:port" is only given in dynamic port forwarding,
+// and must be empty otherwise. Once connected, ready is called before any
+// data from target is written to local connection, e.g., to reply the SOCKS
+// request first.
+func (c *MuxClient) Open(local net.Conn, target string, ready func() error) (uint32, error) {
+	opened := make(chan string, 1)
+	stream := newMuxStream(local, opened)
+	streamId, err := c.addStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.sendStreamMessage(message.StreamOpenMessage, streamId, []byte(target)); err != nil {
+		c.removeStream(streamId)
+		return 0, err
+	}
+
+	timer := time.NewTimer(muxOpenTimeout)
+	defer timer.Stop()
+	select {
+	case errorCode := <-opened:
+		if errorCode == streamCloseTargetDenied {
+			return 0, ErrStreamTargetDenied
+		} else if errorCode != "" {
+			return 0, fmt.Errorf("agent failed to connect %s: %s", target, errorCode)
+		}
+		if err := ready(); err != nil {
+			if c.removeStream(streamId) != nil {
+				c.sendStreamMessage(message.StreamCloseMessage, streamId, nil)
+			}
+			return 0, err
+		}
+		// Data from target has been queued meanwhile
+		go c.writeLocal(streamId, stream)
+		return streamId, nil
+	case <-c.done:
+		return 0, fmt.Errorf("session closed: %v", c.err)
+	case <-timer.C:
+		if c.removeStream(streamId) != nil {
+			c.sendStreamMessage(message.StreamCloseMessage, streamId, nil)
+		}
+		return 0, fmt.Errorf("timeout to connect %s", target)
+	}
+}
+
+// Forward sends data read from local connection to the opened stream until
+// either side closes it. Reading pauses while agent has not acknowledged a
+// whole window of data.
+func (c *MuxClient) Forward(streamId uint32, local net.Conn) error {
+	defer local.Close()
+	c.streamsLock.Lock()
+	stream, ok := c.streams[streamId]
+	c.streamsLock.Unlock()
+	if !ok {
+		// Closed by agent already
+		return nil
+	}
+	buff := make([]byte, muxReadBufferSize)
+	for {
+		size, err := local.Read(buff)
+		if size > 0 {
+			if !stream.acquireCredit() {
+				c.removeStream(streamId)
+				return nil
+			}
+			if sendErr := c.sendStreamMessage(message.StreamDataMessage, streamId, buff[:size]); sendErr != nil {
+				c.removeStream(streamId)
+				return sendErr
+			}
+		}
+		if err != nil {
+			// Stream closed by agent has been removed already
+			if c.removeStream(streamId) != nil {
+				log.GetLogger().Infof("local conn of stream %d closed, send StreamClose", streamId)
+				if err := c.sendStreamMessage(message.StreamCloseMessage, streamId, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+func (c *MuxClient) addStream(stream *muxStream) (uint32, error) {
+	c.streamsLock.Lock()
+	defer c.streamsLock.Unlock()
+	select {
+	case <-c.done:
+		return 0, fmt.Errorf("session closed: %v", c.err)
+	default:
+	}
+	c.nextStreamId++
+	c.streams[c.nextStreamId] = stream
+	// Local connection waiting for opening is written once Open returns
+	if stream.opened == nil {
+		go c.writeLocal(c.nextStreamId, stream)
+	}
+	return c.nextStreamId, nil
+}
+
+func (c *MuxClient) removeStream(streamId uint32) *muxStream {
+	c.streamsLock.Lock()
+	defer c.streamsLock.Unlock()
+	stream, ok := c.streams[streamId]
+	if !ok {
+		return nil
+	}
+	delete(c.streams, streamId)
+	close(stream.done)
+	stream.stopSending()
+	return stream
+}
+
+// writeLocal writes data from agent to local connection of the stream, and
+// closes the connection after all data written once agent closed the stream.
+// Data written is acknowledged to agent in batch.
+func (c *MuxClient) writeLocal(streamId uint32, stream *muxStream) {
+	consumed := 0
+	for {
+		select {
+		case data, ok := <-stream.output:
+			if !ok {
+				stream.local.Close()
+				return
+			}
+			if _, err := stream.local.Write(data); err != nil {
+				log.GetLogger().Errorf("write to local conn of stream %d err: %v", streamId, err)
+				if c.removeStream(streamId) != nil {
+					stream.local.Close()
+					c.sendStreamMessage(message.StreamCloseMessage, streamId, nil)
+				}
+				return
+			}
+			if consumed++; consumed >= muxAckBatchSize {
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, uint32(consumed))
+				if err := c.sendStreamMessage(message.StreamAckMessage, streamId, payload); err != nil {
+					return
+				}
+				consumed = 0
+			}
+		case <-stream.done:
+			return
+		}
+	}
+}
+
+func (c *MuxClient) readLoop() {
+	var err error
+	defer func() {
+		c.streamsLock.Lock()
+		c.err = err
+		close(c.done)
+		for streamId, stream := range c.streams {
+			close(stream.done)
+			stream.stopSending()
+			stream.local.Close()
+			delete(c.streams, streamId)
+		}
+		c.streamsLock.Unlock()
+		c.Conn.Close()
+	}()
+
+	for {
+		var data []byte
+		if _, data, err = c.Conn.ReadMessage(); err != nil {
+			log.GetLogger().Errorln("read msg err", err)
+			return
+		}
+		msg := message.Message{}
+		if err = msg.Deserialize(data); err != nil {
+			log.GetLogger().Errorf("Cannot deserialize raw message, err: %v.", err)
+			return
+		}
+		if err = msg.Validate(); err != nil {
+			log.GetLogger().Errorln("An error has occured, msg is invalid")
+			return
+		}
+		if c.verbosemode {
+			log.GetLogger().Infoln("read msg: ", msg.MessageType, msg.StreamId, msg.SequenceNumber)
+		}
+
+		switch msg.MessageType {
+		case message.StreamOpenMessage:
+			c.streamsLock.Lock()
+			if stream, ok := c.streams[msg.StreamId]; ok && stream.opened != nil {
+				stream.opened <- ""
+				stream.opened = nil
+			}
+			c.streamsLock.Unlock()
+		case message.StreamDataMessage:
+			c.streamsLock.Lock()
+			stream, ok := c.streams[msg.StreamId]
+			c.streamsLock.Unlock()
+			if !ok {
+				continue
+			}
+			// Never block here, which would stall all streams of the session.
+			// Queue is only full if agent ignored the window.
+			select {
+			case stream.output <- msg.Payload:
+			case <-stream.done:
+			default:
+				log.GetLogger().Errorf("agent sent beyond window of stream %d, close it", msg.StreamId)
+				if c.removeStream(msg.StreamId) != nil {
+					stream.local.Close()
+					c.sendStreamMessage(message.StreamCloseMessage, msg.StreamId, nil)
+				}
+			}
+		case message.StreamCloseMessage:
+			c.streamsLock.Lock()
+			stream, ok := c.streams[msg.StreamId]
+			delete(c.streams, msg.StreamId)
+			if ok && stream.opened != nil {
+				// Waiter of opening replies local connection with the error
+				stream.opened <- string(msg.Payload)
+				stream.opened = nil
+				close(stream.done)
+				ok = false
+			}
+			c.streamsLock.Unlock()
+			if ok {
+				// Local connection is closed by writeLocal after data queued
+				// has been written
+				log.GetLogger().Infof("stream %d closed by agent: %s", msg.StreamId, msg.Payload)
+				close(stream.output)
+				stream.stopSending()
+			}
+		case message.StreamAckMessage:
+			c.streamsLock.Lock()
+			stream, ok := c.streams[msg.StreamId]
+			c.streamsLock.Unlock()
+			if ok && len(msg.Payload) >= 4 {
+				stream.addCredit(int(binary.BigEndian.Uint32(msg.Payload)))
+			}
+		case message.StatusDataChannel:
+			if err = c.processStatus(msg.Payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *MuxClient) processStatus(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	switch payload[0] {
+	case 2: //建立连接失败
+		return fmt.Errorf("Failed to connect. code 2: %s", payload[1:])
+	case 5: //关闭连接
+		return errors.New("Connection closed. code 5")
+	case 8: //即将断开连接的警告
+		fmt.Fprintln(os.Stderr, string(payload[1:]))
+	case statusStreamReady:
+		c.readyOnce.Do(func() { close(c.ready) })
+	}
+	return nil
+}
+
+func (c *MuxClient) sendStreamMessage(messageType uint32, streamId uint32, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	agentMessage := &message.Message{
+		MessageType:    messageType,
+		SchemaVersion:  "1.01",
+		CreatedDate:    uint64(time.Now().UnixNano() / 1000000),
+		SequenceNumber: c.sequenceNumber,
+		PayloadLength:  uint32(len(payload)),
+		Payload:        payload,
+		StreamId:       streamId,
+	}
+	msg, err := agentMessage.Serialize()
+	if err != nil {
+		return fmt.Errorf("cannot serialize stream message %v", agentMessage)
+	}
+	if err = c.Conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		log.GetLogger().Errorf("Error sending stream message %v", err)
+		return err
+	}
+	c.sequenceNumber++
+	return nil
+}
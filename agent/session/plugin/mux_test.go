@@ -0,0 +1,275 @@
+package client
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/message"
+)
+
+// fakeAgent is the other side of websocket connection of MuxClient
+type fakeAgent struct {
+	conn     *websocket.Conn
+	received chan message.Message
+}
+
+func newFakeAgent(t *testing.T) (*MuxClient, *fakeAgent, func()) {
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+
+	client := NewMuxClient("ws"+strings.TrimPrefix(server.URL, "http"), "token", false)
+	assert.NoError(t, client.Connect())
+	agent := &fakeAgent{
+		conn:     <-conns,
+		received: make(chan message.Message, 256),
+	}
+	go func() {
+		for {
+			_, data, err := agent.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := message.Message{}
+			if err := msg.Deserialize(data); err == nil {
+				agent.received <- msg
+			}
+		}
+	}()
+	return client, agent, func() {
+		agent.conn.Close()
+		client.Conn.Close()
+		server.Close()
+	}
+}
+
+func (a *fakeAgent) send(t *testing.T, messageType uint32, streamId uint32, payload []byte) {
+	msg := &message.Message{
+		MessageType:   messageType,
+		SchemaVersion: "1.01",
+		CreatedDate:   uint64(time.Now().UnixNano() / 1000000),
+		PayloadLength: uint32(len(payload)),
+		Payload:       payload,
+		StreamId:      streamId,
+	}
+	data, err := msg.Serialize()
+	assert.NoError(t, err)
+	assert.NoError(t, a.conn.WriteMessage(websocket.BinaryMessage, data))
+}
+
+func (a *fakeAgent) next(t *testing.T) message.Message {
+	select {
+	case msg := <-a.received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stream message received")
+		return message.Message{}
+	}
+}
+
+func (a *fakeAgent) expectNothing(t *testing.T) {
+	select {
+	case msg := <-a.received:
+		t.Fatalf("unexpected message type %d of stream %d", msg.MessageType, msg.StreamId)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func ackPayload(credit int) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(credit))
+	return payload
+}
+
+func readString(t *testing.T, conn net.Conn, size int) string {
+	buf := make([]byte, size)
+	_, err := io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	return string(buf)
+}
+
+func TestMuxClientServe(t *testing.T) {
+	client, agent, cleanup := newFakeAgent(t)
+	defer cleanup()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	served := make(chan error, 1)
+	go func() { served <- client.Serve(local) }()
+
+	msg := agent.next(t)
+	assert.Equal(t, uint32(message.StreamOpenMessage), msg.MessageType)
+	assert.Equal(t, uint32(1), msg.StreamId)
+	assert.Empty(t, msg.Payload)
+
+	// Data is sent without waiting for agent connected target
+	remote.Write([]byte("hello"))
+	msg = agent.next(t)
+	assert.Equal(t, uint32(message.StreamDataMessage), msg.MessageType)
+	assert.Equal(t, "hello", string(msg.Payload))
+
+	agent.send(t, message.StreamOpenMessage, 1, nil)
+	agent.send(t, message.StreamDataMessage, 1, []byte("world"))
+	assert.Equal(t, "world", readString(t, remote, 5))
+
+	// Local connection is closed once agent closed the stream, which is not
+	// answered
+	agent.send(t, message.StreamCloseMessage, 1, []byte("Ok"))
+	_, err := remote.Read(make([]byte, 1))
+	assert.Error(t, err)
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve does not return")
+	}
+	agent.expectNothing(t)
+}
+
+func TestMuxClientOpen(t *testing.T) {
+	client, agent, cleanup := newFakeAgent(t)
+	defer cleanup()
+
+	type opened struct {
+		streamId uint32
+		err      error
+	}
+	open := func(local net.Conn, target string, ready func() error) chan opened {
+		result := make(chan opened, 1)
+		go func() {
+			streamId, err := client.Open(local, target, ready)
+			result <- opened{streamId, err}
+		}()
+		return result
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	result := open(local, "192.0.2.1:80", func() error { return nil })
+	msg := agent.next(t)
+	assert.Equal(t, uint32(message.StreamOpenMessage), msg.MessageType)
+	assert.Equal(t, "192.0.2.1:80", string(msg.Payload))
+	agent.send(t, message.StreamCloseMessage, msg.StreamId, []byte(streamCloseTargetDenied))
+	assert.Equal(t, ErrStreamTargetDenied, (<-result).err)
+
+	// Data from target arriving before ready is written after it
+	local, remote = net.Pipe()
+	defer remote.Close()
+	result = open(local, "127.0.0.1:22", func() error {
+		_, err := local.Write([]byte("ready"))
+		return err
+	})
+	msg = agent.next(t)
+	assert.Equal(t, uint32(message.StreamOpenMessage), msg.MessageType)
+	agent.send(t, message.StreamOpenMessage, msg.StreamId, nil)
+	agent.send(t, message.StreamDataMessage, msg.StreamId, []byte("early"))
+	assert.Equal(t, "ready", readString(t, remote, 5))
+	assert.Equal(t, "early", readString(t, remote, 5))
+	r := <-result
+	assert.NoError(t, r.err)
+	assert.Equal(t, msg.StreamId, r.streamId)
+}
+
+func TestMuxClientSessionClosed(t *testing.T) {
+	client, agent, cleanup := newFakeAgent(t)
+	defer cleanup()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	served := make(chan error, 1)
+	go func() { served <- client.Serve(local) }()
+	assert.Equal(t, uint32(message.StreamOpenMessage), agent.next(t).MessageType)
+
+	// Streams are closed together with the session
+	agent.conn.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not closed")
+	}
+	assert.Error(t, client.Err())
+	_, err := remote.Read(make([]byte, 1))
+	assert.Error(t, err)
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve does not return")
+	}
+
+	local, remote = net.Pipe()
+	defer remote.Close()
+	assert.Error(t, client.Serve(local))
+}
+
+func TestMuxClientOverflow(t *testing.T) {
+	client, agent, cleanup := newFakeAgent(t)
+	defer cleanup()
+
+	// Local connection never read
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go client.Serve(local)
+	assert.Equal(t, uint32(message.StreamOpenMessage), agent.next(t).MessageType)
+	agent.send(t, message.StreamOpenMessage, 1, nil)
+
+	// Agent ignoring the window is not waited for, and the stream is closed
+	for i := 0; i < muxWindowSize+2; i++ {
+		agent.send(t, message.StreamDataMessage, 1, []byte("data"))
+	}
+	msg := agent.next(t)
+	assert.Equal(t, uint32(message.StreamCloseMessage), msg.MessageType)
+	assert.Equal(t, uint32(1), msg.StreamId)
+	_, err := remote.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestMuxClientFlowControl(t *testing.T) {
+	client, agent, cleanup := newFakeAgent(t)
+	defer cleanup()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go client.Serve(local)
+	assert.Equal(t, uint32(message.StreamOpenMessage), agent.next(t).MessageType)
+	agent.send(t, message.StreamOpenMessage, 1, nil)
+
+	// Data written to local connection is acknowledged in batch
+	for i := 0; i < muxAckBatchSize; i++ {
+		agent.send(t, message.StreamDataMessage, 1, []byte("x"))
+	}
+	assert.Equal(t, strings.Repeat("x", muxAckBatchSize), readString(t, remote, muxAckBatchSize))
+	msg := agent.next(t)
+	assert.Equal(t, uint32(message.StreamAckMessage), msg.MessageType)
+	assert.Equal(t, ackPayload(muxAckBatchSize), msg.Payload)
+
+	// Sending stops after a whole window until acknowledged by agent
+	go func() {
+		for {
+			if _, err := remote.Write([]byte("y")); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < muxWindowSize; i++ {
+		assert.Equal(t, uint32(message.StreamDataMessage), agent.next(t).MessageType)
+	}
+	agent.expectNothing(t)
+	agent.send(t, message.StreamAckMessage, 1, ackPayload(1))
+	assert.Equal(t, uint32(message.StreamDataMessage), agent.next(t).MessageType)
+	agent.expectNothing(t)
+}
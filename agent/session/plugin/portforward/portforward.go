@@ -22,7 +22,7 @@ func NewPortForwardCommand() *cli.Command {
 		Short: i18n.T(
 			"use portforward forward local port to aliyun ecs instance",
 			"使用 portforward 将本地端口转发到阿里云实例"),
-		Usage: "portforward --instance {instance_id} --localport {local_port} --remoteport {remote_port}",
+		Usage: "portforward --instance {instance_id} --local-port {local_port} --remote-port {remote_port} [--remote-host {remote_host}] [--multiplex]",
 		Run: func(ctx *cli.Context, args []string) error {
 			if len(args) > 0 {
 				return cli.NewInvalidCommandError(args[0], ctx)
@@ -30,13 +30,15 @@ func NewPortForwardCommand() *cli.Command {
 			instance_id, _ := config.InstanceFlag(ctx.Flags()).GetValue()
 			local_port, _ := config.LocalPortFlag(ctx.Flags()).GetValue()
 			remote_port, _ := config.RemotePortFlag(ctx.Flags()).GetValue()
-			return doPortForward(ctx, instance_id, local_port, remote_port)
+			remote_host, _ := config.RemoteHostFlag(ctx.Flags()).GetValue()
+			multiplex := config.MultiplexFlag(ctx.Flags()).IsAssigned()
+			return doPortForward(ctx, instance_id, local_port, remote_port, remote_host, multiplex)
 		},
 	}
 	return c
 }
 
-func doPortForward(ctx *cli.Context, instance_id string, local_port string, remote_port string) error {
+func doPortForward(ctx *cli.Context, instance_id string, local_port string, remote_port string, remote_host string, multiplex bool) error {
 	session.CheckSessionEnabled(ctx)
 	var websocket_url string
 	ecs_client, err := session.GetEcsClient(ctx)
@@ -54,6 +56,14 @@ func doPortForward(ctx *cli.Context, instance_id string, local_port string, remo
 	}
 	remote_port_i, _ := strconv.Atoi(remote_port)
 	request.PortNumber = requests.NewInteger(remote_port_i)
+	if remote_host != "" {
+		// TargetServer is not modeled by the SDK yet, which server passes to
+		// agent as targetServer of the session
+		request.QueryParams["TargetServer"] = remote_host
+	}
+	if multiplex {
+		request.QueryParams["Multiplex"] = "true"
+	}
 	response, err := ecs_client.StartTerminalSession(request)
 	if err != nil {
 		log.GetLogger().Errorln(err, response)
@@ -77,7 +87,14 @@ func doPortForward(ctx *cli.Context, instance_id string, local_port string, remo
 		return fmt.Errorf("start tcp-listener err:%v", err)
 	}
 	log.GetLogger().Infoln("start tcp-listener, listening ", ip_port)
-	fmt.Printf("Port forwarding for SessionId: %s, local port %s, remote port %s\n", response.SessionId, local_port, remote_port)
+	if remote_host != "" {
+		fmt.Printf("Port forwarding for SessionId: %s, local port %s, remote host %s, remote port %s\n", response.SessionId, local_port, remote_host, remote_port)
+	} else {
+		fmt.Printf("Port forwarding for SessionId: %s, local port %s, remote port %s\n", response.SessionId, local_port, remote_port)
+	}
+	if multiplex {
+		return serveMultiplex(ctx, tcp_listener, url)
+	}
 	fmt.Println("Waiting for connections...")
 	for {
 		local_connect, err := tcp_listener.Accept()
@@ -99,3 +116,48 @@ func handleConnect(local_connect net.Conn, url string, ctx *cli.Context) {
 		log.GetLogger().Infof("connection[%s %s] closed\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String())
 	}
 }
+
+// serveMultiplex forwards all local connections over one session until the
+// session is closed
+func serveMultiplex(ctx *cli.Context, tcp_listener net.Listener, url string) error {
+	mux_client := client.NewMuxClient(url, "", config.VerboseFlag(ctx.Flags()).IsAssigned())
+	if err := mux_client.Connect(); err != nil {
+		tcp_listener.Close()
+		return fmt.Errorf("connect session err:%v", err)
+	}
+	// wait agent start the session, connections are queued by listener
+	if err := mux_client.WaitReady(); err != nil {
+		mux_client.Conn.Close()
+		tcp_listener.Close()
+		return err
+	}
+	go func() {
+		<-mux_client.Done()
+		tcp_listener.Close()
+	}()
+
+	fmt.Println("Waiting for connections...")
+	for {
+		local_connect, err := tcp_listener.Accept()
+		if err != nil {
+			select {
+			case <-mux_client.Done():
+				log.GetLogger().Infof("session closed: %v", mux_client.Err())
+				return mux_client.Err()
+			default:
+				continue
+			}
+		}
+		log.GetLogger().Infof("new connection from %s %s\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String())
+		fmt.Printf("new connection from %s %s\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String())
+		go func(local_connect net.Conn) {
+			if err := mux_client.Serve(local_connect); err != nil {
+				fmt.Printf("connection[%s %s] err: %v\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String(), err)
+				log.GetLogger().Infof("connection[%s %s] err: %v\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String(), err)
+			} else {
+				fmt.Printf("connection[%s %s] closed\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String())
+				log.GetLogger().Infof("connection[%s %s] closed\n", local_connect.RemoteAddr().Network(), local_connect.RemoteAddr().String())
+			}
+		}(local_connect)
+	}
+}
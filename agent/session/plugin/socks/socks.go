@@ -0,0 +1,153 @@
+package socks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	client "github.com/aliyun/aliyun_assist_client/agent/session/plugin"
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/cli"
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/config"
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/i18n"
+	"github.com/aliyun/aliyun_assist_client/agent/session/plugin/session"
+)
+
+const (
+	defaultLocalPort = "1080"
+	// handshakeTimeout bounds time for local client sending its request
+	handshakeTimeout = 30 * time.Second
+)
+
+func NewSocksCommand() *cli.Command {
+	c := &cli.Command{
+		Name: "socks",
+		Short: i18n.T(
+			"use socks start a local SOCKS5 proxy, which connects to requested host and port through aliyun ecs instance",
+			"使用 socks 启动本地 SOCKS5 代理，经由阿里云实例连接所请求的主机和端口"),
+		Usage: "socks --instance {instance_id} [--local-port {local_port}]",
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.NewInvalidCommandError(args[0], ctx)
+			}
+			instance_id, _ := config.InstanceFlag(ctx.Flags()).GetValue()
+			local_port, _ := config.LocalPortFlag(ctx.Flags()).GetValue()
+			return doSocks(ctx, instance_id, local_port)
+		},
+	}
+	return c
+}
+
+func doSocks(ctx *cli.Context, instance_id string, local_port string) error {
+	session.CheckSessionEnabled(ctx)
+	ecs_client, err := session.GetEcsClient(ctx)
+	if err != nil {
+		fmt.Print(err.Error())
+		log.GetLogger().Errorln(err)
+		return fmt.Errorf("get ecs client err:%v", err)
+	}
+	request := ecs.CreateStartTerminalSessionRequest()
+	request.Scheme = "https"
+	request.InstanceId = &[]string{instance_id}
+	// Target of each connection is given by SOCKS request, which agent checks
+	// against its port forwarding allowlist. If server does not pass these
+	// parameters, agent never announces stream support and rejects streams,
+	// thus WaitReady fails instead of using a shell session.
+	request.QueryParams["Dynamic"] = "true"
+	request.QueryParams["Multiplex"] = "true"
+	response, err := ecs_client.StartTerminalSession(request)
+	if err != nil {
+		log.GetLogger().Errorln(err, response)
+		fmt.Print(err.Error())
+		return err
+	}
+	log.GetLogger().Infof("response is %#v\n", response)
+	url := strings.Replace(response.WebSocketUrl, "sessionid", "sessionId", 1)
+	log.GetLogger().Infoln("websocket url:", url)
+
+	if local_port == "" {
+		local_port = defaultLocalPort
+	}
+	// Proxy without authentication must not be reachable from other hosts
+	ip_port := net.JoinHostPort("127.0.0.1", local_port)
+	tcp_listener, err := net.Listen("tcp", ip_port)
+	if err != nil {
+		return fmt.Errorf("start tcp-listener err:%v", err)
+	}
+	defer tcp_listener.Close()
+	log.GetLogger().Infoln("start tcp-listener, listening ", ip_port)
+
+	mux_client := client.NewMuxClient(url, "", config.VerboseFlag(ctx.Flags()).IsAssigned())
+	if err := mux_client.Connect(); err != nil {
+		return fmt.Errorf("connect session err:%v", err)
+	}
+	// wait agent start the session, connections are queued by listener
+	if err := mux_client.WaitReady(); err != nil {
+		mux_client.Conn.Close()
+		return err
+	}
+	go func() {
+		<-mux_client.Done()
+		tcp_listener.Close()
+	}()
+
+	fmt.Printf("SOCKS5 proxy for SessionId: %s, listening %s\n", response.SessionId, ip_port)
+	fmt.Println("Waiting for connections...")
+	for {
+		local_connect, err := tcp_listener.Accept()
+		if err != nil {
+			select {
+			case <-mux_client.Done():
+				log.GetLogger().Infof("session closed: %v", mux_client.Err())
+				return mux_client.Err()
+			default:
+				continue
+			}
+		}
+		go handleConnect(mux_client, local_connect)
+	}
+}
+
+func handleConnect(mux_client *client.MuxClient, local_connect net.Conn) {
+	defer local_connect.Close()
+	remote_addr := local_connect.RemoteAddr().String()
+
+	local_connect.SetDeadline(time.Now().Add(handshakeTimeout))
+	target, err := handshake(local_connect)
+	if err != nil {
+		log.GetLogger().Infof("connection[%s] handshake err: %v\n", remote_addr, err)
+		return
+	}
+	// Success must be replied before data from target reaches client
+	replied := false
+	streamId, err := mux_client.Open(local_connect, target, func() error {
+		replied = true
+		return reply(local_connect, replySucceeded)
+	})
+	if err != nil {
+		if replied {
+			log.GetLogger().Infof("connection[%s] reply err: %v\n", remote_addr, err)
+			return
+		}
+		fmt.Printf("connection[%s] to %s err: %v\n", remote_addr, target, err)
+		log.GetLogger().Infof("connection[%s] to %s err: %v\n", remote_addr, target, err)
+		if errors.Is(err, client.ErrStreamTargetDenied) {
+			reply(local_connect, replyNotAllowed)
+		} else {
+			reply(local_connect, replyHostUnreachable)
+		}
+		return
+	}
+	local_connect.SetDeadline(time.Time{})
+
+	fmt.Printf("connection[%s] to %s opened\n", remote_addr, target)
+	log.GetLogger().Infof("connection[%s] to %s opened, stream %d\n", remote_addr, target, streamId)
+	if err := mux_client.Forward(streamId, local_connect); err != nil {
+		log.GetLogger().Infof("connection[%s] to %s err: %v\n", remote_addr, target, err)
+	} else {
+		log.GetLogger().Infof("connection[%s] to %s closed\n", remote_addr, target)
+	}
+}
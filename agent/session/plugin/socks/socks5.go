@@ -0,0 +1,121 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol defined in RFC 1928, of which only CONNECT without
+// authentication is supported since the proxy only listens on loopback
+const (
+	socksVersion5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	replySucceeded           = 0x00
+	replyGeneralFailure      = 0x01
+	replyNotAllowed          = 0x02
+	replyHostUnreachable     = 0x04
+	replyCommandNotSupported = 0x07
+	replyAddressNotSupported = 0x08
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported SOCKS version")
+	ErrNoAcceptableMethod = errors.New("no acceptable authentication method")
+	ErrUnsupportedCommand = errors.New("unsupported SOCKS command")
+	ErrUnsupportedAddress = errors.New("unsupported SOCKS address type")
+)
+
+// handshake negotiates method with SOCKS5 client and reads its CONNECT
+// request, then returns the requested target as "host:port". Failures are
+// replied to client, while success must be replied by caller once target is
+// connected.
+func handshake(conn io.ReadWriter) (string, error) {
+	// Greeting: VER NMETHODS METHODS
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion5 {
+		return "", ErrUnsupportedVersion
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodNoAuth {
+			method = methodNoAuth
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion5, method}); err != nil {
+		return "", err
+	}
+	if method == methodNoAcceptable {
+		return "", ErrNoAcceptableMethod
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion5 {
+		return "", ErrUnsupportedVersion
+	}
+	var host string
+	switch request[3] {
+	case atypIPv4, atypIPv6:
+		addr := make([]byte, net.IPv4len)
+		if request[3] == atypIPv6 {
+			addr = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		reply(conn, replyAddressNotSupported)
+		return "", ErrUnsupportedAddress
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	if request[1] != cmdConnect {
+		reply(conn, replyCommandNotSupported)
+		return "", fmt.Errorf("%w: %d", ErrUnsupportedCommand, request[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// reply sends reply to CONNECT request. Bound address is not known since
+// target is connected by agent, thus zero address is replied.
+func reply(conn io.Writer, code byte) error {
+	_, err := conn.Write([]byte{socksVersion5, code, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
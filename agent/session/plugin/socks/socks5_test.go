@@ -0,0 +1,63 @@
+package socks
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// handshakeWith runs handshake against client writing request, and returns
+// requested target, error and replies of handshake
+func handshakeWith(request []byte) (string, error, []byte) {
+	server, client := net.Pipe()
+	replies := make(chan []byte, 1)
+	// Writing returns once handshake read all, or server closed
+	go client.Write(request)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, client)
+		replies <- buf.Bytes()
+	}()
+	target, err := handshake(server)
+	server.Close()
+	return target, err, <-replies
+}
+
+func TestHandshake(t *testing.T) {
+	greeting := []byte{socksVersion5, 2, 0x02, methodNoAuth}
+
+	target, err, replies := handshakeWith(append(greeting, socksVersion5, cmdConnect, 0, atypIPv4, 10, 0, 0, 1, 0x1f, 0x90))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:8080", target)
+	assert.Equal(t, []byte{socksVersion5, methodNoAuth}, replies)
+
+	target, err, _ = handshakeWith(append(greeting, socksVersion5, cmdConnect, 0, atypDomain, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', 0, 80))
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com:80", target)
+
+	ipv6 := append([]byte{socksVersion5, cmdConnect, 0, atypIPv6}, net.ParseIP("::1")...)
+	target, err, _ = handshakeWith(append(greeting, append(ipv6, 1, 187)...))
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:443", target)
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	_, err, replies := handshakeWith([]byte{socksVersion5, 1, 0x02})
+	assert.ErrorIs(t, err, ErrNoAcceptableMethod)
+	assert.Equal(t, []byte{socksVersion5, methodNoAcceptable}, replies)
+
+	_, err, _ = handshakeWith([]byte{0x04, 1, cmdConnect})
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// BIND is not supported
+	_, err, replies = handshakeWith([]byte{socksVersion5, 1, methodNoAuth, socksVersion5, 0x02, 0, atypIPv4, 10, 0, 0, 1, 0, 80})
+	assert.ErrorIs(t, err, ErrUnsupportedCommand)
+	assert.Equal(t, byte(replyCommandNotSupported), replies[3])
+
+	_, err, replies = handshakeWith([]byte{socksVersion5, 1, methodNoAuth, socksVersion5, cmdConnect, 0, 0x05})
+	assert.ErrorIs(t, err, ErrUnsupportedAddress)
+	assert.Equal(t, byte(replyAddressNotSupported), replies[3])
+}
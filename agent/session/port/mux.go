@@ -0,0 +1,328 @@
+package port
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/session/message"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	// maxStreams limits concurrent TCP streams of one multiplexed session
+	maxStreams        = 256
+	streamDialTimeout = 10 * time.Second
+	// streamWindowSize is number of data messages of a stream either side
+	// may send before acknowledged by the other, which is also size of queue
+	// buffering input from client while dialing target or writing to it.
+	// Client sending beyond the window violates protocol and the stream is
+	// closed, since the session must not wait for a slow target.
+	streamWindowSize = 64
+	// streamAckBatchSize is number of data messages written to target before
+	// acknowledging them to client
+	streamAckBatchSize = 16
+)
+
+// portStream is one TCP connection to target in multiplexed port forwarding.
+// Input from client is queued and written by its own goroutine, thus dialing
+// or a slow connection does not block other streams.
+type portStream struct {
+	id uint32
+	// target is "host:port" given by client in dynamic mode
+	target string
+	input  chan []byte
+	done   chan struct{}
+	lock   sync.Mutex
+	conn   net.Conn
+	closed bool
+	// sendCredit is number of data messages which may be sent to client
+	// before acknowledged, guarded by lock
+	sendCredit int
+	creditCond *sync.Cond
+}
+
+func newPortStream(id uint32, target string) *portStream {
+	s := &portStream{
+		id:         id,
+		target:     target,
+		input:      make(chan []byte, streamWindowSize),
+		done:       make(chan struct{}),
+		sendCredit: streamWindowSize,
+	}
+	s.creditCond = sync.NewCond(&s.lock)
+	return s
+}
+
+// setConn returns false if stream is closed while dialing
+func (s *portStream) setConn(conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conn = conn
+	return true
+}
+
+func (s *portStream) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.creditCond.Broadcast()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// acquireCredit waits until client has acknowledged enough data to send one
+// more data message, and returns false if the stream is closed meanwhile
+func (s *portStream) acquireCredit() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.sendCredit <= 0 && !s.closed {
+		s.creditCond.Wait()
+	}
+	if s.closed {
+		return false
+	}
+	s.sendCredit--
+	return true
+}
+
+func (s *portStream) addCredit(credit int) {
+	s.lock.Lock()
+	s.sendCredit += credit
+	s.lock.Unlock()
+	s.creditCond.Broadcast()
+}
+
+// streamAckPayload encodes number of data messages acknowledged
+func streamAckPayload(credit int) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(credit))
+	return payload
+}
+
+// handleMultiplexMessage handles messages from client in multiplexed mode,
+// where each stream message opens, writes to or closes one connection to
+// target. Agent replies open message once connected, or close message with
+// error code as payload if failed. Data messages of each direction are flow
+// controlled by window of streamWindowSize, and acknowledged by ack message
+// once consumed.
+func (p *PortPlugin) handleMultiplexMessage(streamDataMessage message.Message) error {
+	streamId := streamDataMessage.StreamId
+	switch streamDataMessage.MessageType {
+	case message.StreamOpenMessage:
+		p.openStream(streamId, string(streamDataMessage.Payload))
+	case message.StreamDataMessage:
+		p.streamsLock.Lock()
+		s, ok := p.streams[streamId]
+		p.streamsLock.Unlock()
+		if !ok {
+			// Stream is closed by agent, tell client again in case it missed
+			log.GetLogger().Warnf("Data for unknown stream %d of %s", streamId, p.id)
+			return p.dataChannel.SendStreamMessage(message.StreamCloseMessage, streamId, []byte(IO_socket_error))
+		}
+		if util.IsVerboseMode() {
+			log.GetLogger().Infoln("write data:", streamId, string(streamDataMessage.Payload))
+		}
+		// Never block here, which would stall all streams of the session.
+		// Queue is only full if client ignored the window.
+		select {
+		case s.input <- streamDataMessage.Payload:
+		case <-s.done:
+		default:
+			log.GetLogger().Warnf("Client sent beyond window of stream %d of %s, close it", streamId, p.id)
+			p.closeStream(streamId, true, Stream_overflow)
+		}
+	case message.StreamAckMessage:
+		if len(streamDataMessage.Payload) < 4 {
+			log.GetLogger().Warnf("Invalid ack of stream %d of %s", streamId, p.id)
+			return nil
+		}
+		p.streamsLock.Lock()
+		s, ok := p.streams[streamId]
+		p.streamsLock.Unlock()
+		if ok {
+			s.addCredit(int(binary.BigEndian.Uint32(streamDataMessage.Payload)))
+		}
+	case message.StreamCloseMessage:
+		p.closeStream(streamId, false, "")
+	case message.StatusDataMessage:
+		return p.handleStatusMessage(streamDataMessage.Payload)
+	}
+	return nil
+}
+
+func (p *PortPlugin) openStream(streamId uint32, target string) {
+	p.streamsLock.Lock()
+	if _, ok := p.streams[streamId]; ok {
+		p.streamsLock.Unlock()
+		log.GetLogger().Warnf("Stream %d of %s is already open", streamId, p.id)
+		return
+	}
+	if len(p.streams) >= maxStreams {
+		p.streamsLock.Unlock()
+		log.GetLogger().Warnf("Too many streams of %s, stream %d refused", p.id, streamId)
+		p.dataChannel.SendStreamMessage(message.StreamCloseMessage, streamId, []byte(Open_port_failed))
+		return
+	}
+	s := newPortStream(streamId, target)
+	p.streams[streamId] = s
+	p.streamsLock.Unlock()
+
+	log.GetLogger().Infof("Open stream %d of %s, target[%s]", streamId, p.id, target)
+	go p.writeStream(s)
+}
+
+// closeStream removes and closes the stream, and tells client with error code
+// as reason when closed by agent side
+func (p *PortPlugin) closeStream(streamId uint32, notify bool, reason string) {
+	p.streamsLock.Lock()
+	s, ok := p.streams[streamId]
+	delete(p.streams, streamId)
+	p.streamsLock.Unlock()
+	if !ok {
+		return
+	}
+	s.close()
+	log.GetLogger().Infof("Close stream %d of %s", streamId, p.id)
+	if notify {
+		if err := p.dataChannel.SendStreamMessage(message.StreamCloseMessage, streamId, []byte(reason)); err != nil {
+			log.GetLogger().Errorf("Unable to send stream close message: %v", err)
+		}
+	}
+}
+
+func (p *PortPlugin) closeAllStreams() {
+	p.streamsLock.Lock()
+	streams := p.streams
+	p.streams = make(map[uint32]*portStream)
+	p.streamsLock.Unlock()
+	for _, s := range streams {
+		s.close()
+	}
+}
+
+// writeStream dials target for the stream, then writes input from client to
+// it until the stream is closed
+func (p *PortPlugin) writeStream(s *portStream) {
+	address := p.address()
+	if p.dynamic {
+		var errorCode string
+		if address, errorCode = p.streamAddress(s.target); errorCode != "" {
+			p.closeStream(s.id, true, errorCode)
+			return
+		}
+	}
+	conn, err := net.DialTimeout("tcp", address, streamDialTimeout)
+	if err != nil {
+		log.GetLogger().Errorf("Unable to open stream %d of %s: %v", s.id, p.id, err)
+		p.closeStream(s.id, true, Open_port_failed)
+		return
+	}
+	if !s.setConn(conn) {
+		conn.Close()
+		return
+	}
+	if err := p.dataChannel.SendStreamMessage(message.StreamOpenMessage, s.id, nil); err != nil {
+		log.GetLogger().Errorf("Unable to send stream open message: %v", err)
+		p.closeStream(s.id, false, "")
+		return
+	}
+	go p.readStream(s)
+
+	consumed := 0
+	for {
+		select {
+		case data := <-s.input:
+			if _, err := conn.Write(data); err != nil {
+				log.GetLogger().Errorf("Unable to write to stream %d, err: %v.", s.id, err)
+				p.closeStream(s.id, true, IO_socket_error)
+				return
+			}
+			if consumed++; consumed >= streamAckBatchSize {
+				if err := p.dataChannel.SendStreamMessage(message.StreamAckMessage, s.id, streamAckPayload(consumed)); err != nil {
+					log.GetLogger().Errorf("Unable to send stream ack message: %v", err)
+					p.closeStream(s.id, false, "")
+					return
+				}
+				consumed = 0
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// readStream sends data read from connection of the stream to client, and
+// stops reading while client has not acknowledged a whole window of data
+func (p *PortPlugin) readStream(s *portStream) {
+	packet := make([]byte, sendPackageSize)
+	for {
+		numBytes, err := s.conn.Read(packet)
+		if numBytes > 0 {
+			if util.IsVerboseMode() {
+				log.GetLogger().Infoln("read data:", s.id, string(packet[:numBytes]))
+			}
+			if !s.acquireCredit() {
+				return
+			}
+			p.waitSendSlot()
+			if sendErr := p.dataChannel.SendStreamMessage(message.StreamDataMessage, s.id, packet[:numBytes]); sendErr != nil {
+				log.GetLogger().Errorf("Unable to send stream data message: %v", sendErr)
+				p.closeStream(s.id, false, "")
+				return
+			}
+		}
+		if err != nil {
+			log.GetLogger().Infof("Stream %d of %s finished reading: %v", s.id, p.id, err)
+			p.closeStream(s.id, true, Ok)
+			return
+		}
+	}
+}
+
+// streamAddress checks target "host:port" of dynamic stream against allowlist
+// and returns the address to dial, or error code if not allowed
+func (p *PortPlugin) streamAddress(target string) (string, string) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		log.GetLogger().Warningf("Invalid target %q of %s: %v", target, p.id, err)
+		return "", Open_port_failed
+	}
+	if portNumber, err := strconv.Atoi(port); err != nil || portNumber <= 0 || portNumber > 65535 {
+		log.GetLogger().Warningf("Invalid target %q of %s", target, p.id)
+		return "", Open_port_failed
+	}
+	dialHost, err := resolveTarget(host, config.GetConfig().Session.PortForwardAllowlist, net.LookupIP)
+	if err != nil {
+		log.GetLogger().Warningf("Port forwarding to %s denied: %v", target, err)
+		if errors.Is(err, ErrTargetDenied) {
+			return "", Target_denied
+		}
+		return "", Open_port_failed
+	}
+	return net.JoinHostPort(dialHost, port), ""
+}
+
+// waitSendSlot keeps sending rate of all streams together within flow limit
+// of the session, as single connection does in writePump
+func (p *PortPlugin) waitSendSlot() {
+	p.sendSlotLock.Lock()
+	defer p.sendSlotLock.Unlock()
+	if wait := time.Until(p.nextSendTime); wait > 0 {
+		time.Sleep(wait)
+	}
+	p.nextSendTime = time.Now().Add(time.Duration(p.sendInterval) * time.Millisecond)
+}
@@ -0,0 +1,234 @@
+package port
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/session/message"
+)
+
+type sentStreamMessage struct {
+	messageType uint32
+	streamId    uint32
+	payload     string
+}
+
+// fakeSessionChannel records stream messages sent by plugin
+type fakeSessionChannel struct {
+	sent chan sentStreamMessage
+}
+
+func (c *fakeSessionChannel) Open() error                                  { return nil }
+func (c *fakeSessionChannel) Close() error                                 { return nil }
+func (c *fakeSessionChannel) Reconnect() error                             { return nil }
+func (c *fakeSessionChannel) SendStreamDataMessage(inputData []byte) error { return nil }
+func (c *fakeSessionChannel) SendStatusDataMessage(payload []byte) error   { return nil }
+func (c *fakeSessionChannel) GetChannelId() string                         { return "c-test" }
+func (c *fakeSessionChannel) IsActive() bool                               { return true }
+
+func (c *fakeSessionChannel) SendStreamMessage(messageType uint32, streamId uint32, payload []byte) error {
+	c.sent <- sentStreamMessage{messageType, streamId, string(payload)}
+	return nil
+}
+
+func (c *fakeSessionChannel) next(t *testing.T) sentStreamMessage {
+	select {
+	case m := <-c.sent:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stream message sent")
+		return sentStreamMessage{}
+	}
+}
+
+func TestMultiplexStreams(t *testing.T) {
+	// Target echoes one message on each connection, then closes it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				conn.Write(buf[:n])
+			}(conn)
+		}
+	}()
+
+	dataChannel := &fakeSessionChannel{sent: make(chan sentStreamMessage, 16)}
+	p := NewPortPlugin("c-test", "", listener.Addr().(*net.TCPAddr).Port, 0, true, false)
+	p.dialHost = "127.0.0.1"
+	p.dataChannel = dataChannel
+	defer p.Stop()
+
+	for _, streamId := range []uint32{1, 2} {
+		assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamOpenMessage, StreamId: streamId}))
+	}
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamDataMessage, StreamId: 2, Payload: []byte("two")}))
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamDataMessage, StreamId: 1, Payload: []byte("one")}))
+
+	received := map[uint32][]sentStreamMessage{}
+	for i := 0; i < 6; i++ {
+		m := dataChannel.next(t)
+		received[m.streamId] = append(received[m.streamId], m)
+	}
+	for streamId, payload := range map[uint32]string{1: "one", 2: "two"} {
+		assert.Equal(t, []sentStreamMessage{
+			{message.StreamOpenMessage, streamId, ""},
+			{message.StreamDataMessage, streamId, payload},
+			{message.StreamCloseMessage, streamId, Ok},
+		}, received[streamId])
+	}
+
+	// Data of closed stream is answered by close
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamDataMessage, StreamId: 1, Payload: []byte("again")}))
+	assert.Equal(t, sentStreamMessage{message.StreamCloseMessage, 1, IO_socket_error}, dataChannel.next(t))
+
+	// Stream closed by client is not answered
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamOpenMessage, StreamId: 3}))
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamCloseMessage, StreamId: 3}))
+	p.streamsLock.Lock()
+	assert.Equal(t, 0, len(p.streams))
+	p.streamsLock.Unlock()
+}
+
+func TestDynamicStreams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello"))
+			conn.Close()
+		}
+	}()
+
+	dataChannel := &fakeSessionChannel{sent: make(chan sentStreamMessage, 16)}
+	p := NewPortPlugin("c-test", "", 0, 0, false, true)
+	p.dataChannel = dataChannel
+	defer p.Stop()
+	assert.True(t, p.multiplex)
+
+	// Local target is always allowed, while remote ones need allowlist
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamOpenMessage, StreamId: 1, Payload: []byte(listener.Addr().String())}))
+	assert.Equal(t, sentStreamMessage{message.StreamOpenMessage, 1, ""}, dataChannel.next(t))
+	assert.Equal(t, sentStreamMessage{message.StreamDataMessage, 1, "hello"}, dataChannel.next(t))
+	assert.Equal(t, sentStreamMessage{message.StreamCloseMessage, 1, Ok}, dataChannel.next(t))
+
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamOpenMessage, StreamId: 2, Payload: []byte("192.0.2.1:80")}))
+	assert.Equal(t, sentStreamMessage{message.StreamCloseMessage, 2, Target_denied}, dataChannel.next(t))
+
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamOpenMessage, StreamId: 3, Payload: []byte("localhost")}))
+	assert.Equal(t, sentStreamMessage{message.StreamCloseMessage, 3, Open_port_failed}, dataChannel.next(t))
+}
+
+func TestStreamOverflow(t *testing.T) {
+	dataChannel := &fakeSessionChannel{sent: make(chan sentStreamMessage, 16)}
+	p := NewPortPlugin("c-test", "", 0, 0, true, false)
+	p.dataChannel = dataChannel
+	defer p.Stop()
+
+	// Stream whose target is not written, e.g., still dialing
+	p.streams[1] = newPortStream(1, "")
+	for i := 0; i < streamWindowSize; i++ {
+		assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamDataMessage, StreamId: 1, Payload: []byte("data")}))
+	}
+	select {
+	case m := <-dataChannel.sent:
+		t.Fatalf("unexpected stream message %v", m)
+	default:
+	}
+
+	// Client sending beyond window is not waited for, and the stream is closed
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamDataMessage, StreamId: 1, Payload: []byte("data")}))
+	assert.Equal(t, sentStreamMessage{message.StreamCloseMessage, 1, Stream_overflow}, dataChannel.next(t))
+	p.streamsLock.Lock()
+	assert.Equal(t, 0, len(p.streams))
+	p.streamsLock.Unlock()
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	// Target keeps sending until agent stops reading, and consumes input
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			buf := make([]byte, 64)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+		data := bytes.Repeat([]byte("x"), 1024)
+		for {
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	dataChannel := &fakeSessionChannel{sent: make(chan sentStreamMessage, 16)}
+	p := NewPortPlugin("c-test", "", listener.Addr().(*net.TCPAddr).Port, 0, true, false)
+	p.dialHost = "127.0.0.1"
+	p.dataChannel = dataChannel
+	defer p.Stop()
+
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamOpenMessage, StreamId: 1}))
+	assert.Equal(t, sentStreamMessage{message.StreamOpenMessage, 1, ""}, dataChannel.next(t))
+
+	// Input written to target is acknowledged in batch
+	for i := 0; i < streamAckBatchSize; i++ {
+		assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamDataMessage, StreamId: 1, Payload: []byte("data")}))
+	}
+	dataMessages := 0
+	for acked := false; !acked; {
+		switch m := dataChannel.next(t); m.messageType {
+		case message.StreamDataMessage:
+			dataMessages++
+		case message.StreamAckMessage:
+			assert.Equal(t, string(streamAckPayload(streamAckBatchSize)), m.payload)
+			acked = true
+		default:
+			t.Fatalf("unexpected stream message %v", m)
+		}
+	}
+
+	// Output stops after a whole window until acknowledged by client
+	for dataMessages < streamWindowSize {
+		assert.Equal(t, uint32(message.StreamDataMessage), dataChannel.next(t).messageType)
+		dataMessages++
+	}
+	select {
+	case m := <-dataChannel.sent:
+		t.Fatalf("unexpected stream message %v beyond window", m)
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.NoError(t, p.InputStreamMessageHandler(message.Message{MessageType: message.StreamAckMessage, StreamId: 1, Payload: streamAckPayload(1)}))
+	assert.Equal(t, uint32(message.StreamDataMessage), dataChannel.next(t).messageType)
+	select {
+	case m := <-dataChannel.sent:
+		t.Fatalf("unexpected stream message %v beyond window", m)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
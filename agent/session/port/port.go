@@ -6,8 +6,10 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/aliyun/aliyun_assist_client/agent/config"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/session/channel"
 	"github.com/aliyun/aliyun_assist_client/agent/session/message"
@@ -20,6 +22,8 @@ const(
 	Open_port_failed = "Open_port_failed"
 	Read_port_failed = "Read_port_failed"
 	IO_socket_error = "IO_port_failed"
+	Target_denied = "Target_denied"
+	Stream_overflow = "Stream_overflow"
 )
 
 const (
@@ -31,21 +35,40 @@ const (
 type PortPlugin struct {
 	id        string
 	portNumber   int
+	// targetHost is where to forward specified by server, empty for the
+	// instance itself
+	targetHost   string
+	// dialHost is targetHost checked against allowlist
+	dialHost     string
 	dataChannel channel.ISessionChannel
 	conn               net.Conn
 	reconnectToPort bool
 	reconnectToPortErr chan error
 	flowLimit	int
 	sendInterval int
+	// multiplex means many TCP streams carried by stream messages, each of
+	// which dials its own connection, instead of the single conn
+	multiplex    bool
+	// dynamic means target of each stream is given by client when opening,
+	// e.g., by SOCKS proxy, instead of targetHost and portNumber
+	dynamic      bool
+	streams      map[uint32]*portStream
+	streamsLock  sync.Mutex
+	sendSlotLock sync.Mutex
+	nextSendTime time.Time
 }
 
-func NewPortPlugin(id string, portNumber int, flowLimit int) *PortPlugin {
+func NewPortPlugin(id string, targetHost string, portNumber int, flowLimit int, multiplex bool, dynamic bool) *PortPlugin {
 	plugin := &PortPlugin{
 		id:id,
 		reconnectToPort:false,
+		targetHost:targetHost,
 		portNumber:portNumber,
 		reconnectToPortErr: make(chan error),
 		sendInterval: defaultSendInterval,
+		multiplex: multiplex || dynamic,
+		dynamic: dynamic,
+		streams: make(map[uint32]*portStream),
 	}
 	if flowLimit > 0 {
 		plugin.sendInterval = 1000 / (flowLimit / 8 / sendPackageSize)
@@ -57,6 +80,13 @@ func NewPortPlugin(id string, portNumber int, flowLimit int) *PortPlugin {
 }
 
 func (p *PortPlugin) Stop() {
+	if p.multiplex {
+		p.closeAllStreams()
+		return
+	}
+	if p.conn == nil {
+		return
+	}
 	if p.conn.Close() != nil {
 		p.conn.Close()
 	}
@@ -76,7 +106,25 @@ func (p *PortPlugin) Execute(dataChannel channel.ISessionChannel, cancelFlag uti
 	}()
 	log.GetLogger().Infoln("start port")
 	var err error
-	if p.conn, err = net.Dial("tcp", "localhost:"+strconv.Itoa(p.portNumber)); err != nil {
+	// Targets of dynamic port forwarding are checked when opening streams
+	if !p.dynamic {
+		if p.dialHost, err = resolveTarget(p.targetHost, config.GetConfig().Session.PortForwardAllowlist, net.LookupIP); err != nil {
+			log.GetLogger().Warningf("Port forwarding to %s:%d denied: %v", p.targetHost, p.portNumber, err)
+			if errors.Is(err, ErrTargetDenied) {
+				return Target_denied
+			}
+			return Open_port_failed
+		}
+	}
+	if p.multiplex {
+		// Connections are dialed when client opens streams, which waits for
+		// agent announcing it supports them
+		log.GetLogger().Infof("start multiplexed port forwarding, dynamic[%v]", p.dynamic)
+		if err := p.dataChannel.SendStatusDataMessage([]byte{channel.StatusCodeStreamReady}); err != nil {
+			log.GetLogger().Errorf("Unable to send stream ready status: %v", err)
+			return IO_socket_error
+		}
+	} else if p.conn, err = net.Dial("tcp", p.address()); err != nil {
 		errorString := fmt.Errorf("Unable to start port: %s", err)
 		log.GetLogger().Errorln(errorString)
 		return Open_port_failed
@@ -100,15 +148,19 @@ func (p *PortPlugin) Execute(dataChannel channel.ISessionChannel, cancelFlag uti
 
 
 	done := make(chan string, 1)
-	go func() {
-		done <- p.writePump()
-	}()
+	if !p.multiplex {
+		go func() {
+			done <- p.writePump()
+		}()
+	}
 	log.GetLogger().Infof("Plugin %s started", p.id)
 
 
 	select {
 	case <-cancelled:
-		p.reconnectToPortErr <- errors.New("Session has been cancelled")
+		if !p.multiplex {
+			p.reconnectToPortErr <- errors.New("Session has been cancelled")
+		}
 		log.GetLogger().Info("The session was cancelled")
 
 
@@ -120,6 +172,10 @@ func (p *PortPlugin) Execute(dataChannel channel.ISessionChannel, cancelFlag uti
 	return errorCode
 }
 
+func (p *PortPlugin) address() string {
+	return net.JoinHostPort(p.dialHost, strconv.Itoa(p.portNumber))
+}
+
 func (p *PortPlugin) writePump() (errorCode string) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -180,15 +236,18 @@ func (p *PortPlugin) onError() int {
 }
 
 func (p *PortPlugin) InputStreamMessageHandler(streamDataMessage message.Message) error {
+	if p.multiplex {
+		return p.handleMultiplexMessage(streamDataMessage)
+	}
 
 	if p.conn == nil  {
 		log.GetLogger().Infoln("InputStreamMessageHandler: connect not ready")
 		return nil
 	}
 	if p.reconnectToPort {
-		log.GetLogger().Infof("InputStreamMessageHandler:Reconnect to port: %s", p.portNumber)
+		log.GetLogger().Infof("InputStreamMessageHandler:Reconnect to port: %d", p.portNumber)
 		var err error
-		p.conn, err = net.Dial("tcp", "localhost:"+strconv.Itoa(p.portNumber));
+		p.conn, err = net.Dial("tcp", p.address());
 		p.reconnectToPortErr <- err
 		if err != nil {
 			return err
@@ -206,26 +265,32 @@ func (p *PortPlugin) InputStreamMessageHandler(streamDataMessage message.Message
 			log.GetLogger().Infoln("write data:", string(streamDataMessage.Payload))
 		}
 	case message.StatusDataMessage:
-		if len(streamDataMessage.Payload) > 0 {
-			code, err := message.BytesToIntU(streamDataMessage.Payload[0:1])
-			if err == nil {
-				if code == 7 { // 设置agent的发送速率
-					speed, err := message.BytesToIntU(streamDataMessage.Payload[1:]) // speed 单位是 bps
-					if speed == 0 {
-						break
-					}
-					if err != nil {
-						log.GetLogger().Errorf("Invalid flowLimit: %s", err)
-						return err
-					}
-					p.sendInterval = 1000 / (speed / 8 / sendPackageSize)
-					log.GetLogger().Infof("Set send speed, channelId[%s] speed[%d]bps sendInterval[%d]ms\n", p.id, speed, p.sendInterval)
+		return p.handleStatusMessage(streamDataMessage.Payload)
+	}
+	return nil
+}
+
+// handleStatusMessage handles status message from client, which sets sending
+// rate by status code 7 followed by speed in bps
+func (p *PortPlugin) handleStatusMessage(payload []byte) error {
+	if len(payload) > 0 {
+		code, err := message.BytesToIntU(payload[0:1])
+		if err == nil {
+			if code == 7 { // 设置agent的发送速率
+				speed, err := message.BytesToIntU(payload[1:]) // speed 单位是 bps
+				if speed == 0 {
+					return nil
+				}
+				if err != nil {
+					log.GetLogger().Errorf("Invalid flowLimit: %s", err)
+					return err
 				}
-			} else {
-				log.GetLogger().Errorf("Parse status code err: %s", err)
+				p.sendInterval = 1000 / (speed / 8 / sendPackageSize)
+				log.GetLogger().Infof("Set send speed, channelId[%s] speed[%d]bps sendInterval[%d]ms\n", p.id, speed, p.sendInterval)
 			}
+		} else {
+			log.GetLogger().Errorf("Parse status code err: %s", err)
 		}
-		break
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,82 @@
+package port
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	ErrTargetDenied = errors.New("target host is not in port forwarding allowlist")
+)
+
+// isLocalHost returns true for target meaning the instance itself, which is
+// always allowed
+func isLocalHost(host string) bool {
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// matchHostname returns true when hostname equals pattern, or is a subdomain
+// of pattern starting with "*."
+func matchHostname(pattern string, hostname string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(hostname, pattern[1:])
+	}
+	return pattern == hostname
+}
+
+// resolveTarget checks target host against allowlist and returns the host to
+// dial. Hostname allowed by name is dialed as is, otherwise it is resolved and
+// the first address within allowed CIDRs is dialed, so that the address
+// checked is exactly the one connected.
+func resolveTarget(host string, allowlist []string, lookupIP func(host string) ([]net.IP, error)) (string, error) {
+	if isLocalHost(host) {
+		return "localhost", nil
+	}
+	if len(allowlist) == 0 {
+		return "", ErrTargetDenied
+	}
+
+	var allowedNets []*net.IPNet
+	isIP := net.ParseIP(host) != nil
+	for _, entry := range allowlist {
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			allowedNets = append(allowedNets, ipNet)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			allowedNets = append(allowedNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		} else if !isIP && matchHostname(entry, host) {
+			return host, nil
+		}
+	}
+	if len(allowedNets) == 0 {
+		return "", ErrTargetDenied
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		var err error
+		if ips, err = lookupIP(host); err != nil {
+			return "", fmt.Errorf("resolve target host %s: %w", host, err)
+		}
+	}
+	for _, ip := range ips {
+		for _, ipNet := range allowedNets {
+			if ipNet.Contains(ip) {
+				return ip.String(), nil
+			}
+		}
+	}
+	return "", ErrTargetDenied
+}
@@ -0,0 +1,55 @@
+package port
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTarget(t *testing.T) {
+	lookupIP := func(host string) ([]net.IP, error) {
+		switch host {
+		case "redis.internal":
+			return []net.IP{net.ParseIP("192.168.0.10"), net.ParseIP("10.0.0.5")}, nil
+		case "evil.example.com":
+			return []net.IP{net.ParseIP("169.254.169.254")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	allowlist := []string{"10.0.0.0/8", "172.16.1.1", "*.rds.aliyuncs.com", "db.internal"}
+
+	tests := []struct {
+		host      string
+		allowlist []string
+		want      string
+		wantErr   error
+	}{
+		{host: "", want: "localhost"},
+		{host: "localhost", want: "localhost"},
+		{host: "127.0.0.1", want: "localhost"},
+		{host: "10.0.0.1", wantErr: ErrTargetDenied},
+		{host: "10.1.2.3", allowlist: allowlist, want: "10.1.2.3"},
+		{host: "172.16.1.1", allowlist: allowlist, want: "172.16.1.1"},
+		{host: "172.16.1.2", allowlist: allowlist, wantErr: ErrTargetDenied},
+		{host: "rm-xxx.mysql.rds.aliyuncs.com", allowlist: allowlist, want: "rm-xxx.mysql.rds.aliyuncs.com"},
+		{host: "rds.aliyuncs.com", allowlist: []string{"*.rds.aliyuncs.com"}, wantErr: ErrTargetDenied},
+		{host: "DB.internal.", allowlist: allowlist, want: "DB.internal."},
+		{host: "redis.internal", allowlist: allowlist, want: "10.0.0.5"},
+		{host: "evil.example.com", allowlist: allowlist, wantErr: ErrTargetDenied},
+	}
+	for _, tt := range tests {
+		got, err := resolveTarget(tt.host, tt.allowlist, lookupIP)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.host)
+			continue
+		}
+		assert.NoError(t, err, tt.host)
+		assert.Equal(t, tt.want, got, tt.host)
+	}
+
+	_, err := resolveTarget("unknown.internal", allowlist, lookupIP)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrTargetDenied))
+}
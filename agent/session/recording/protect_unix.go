@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package recording
+
+import (
+	"os"
+)
+
+// protectDir restricts directory of recordings to its owner, i.e., root
+func protectDir(dir string) error {
+	return os.Chmod(dir, 0700)
+}
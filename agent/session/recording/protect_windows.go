@@ -0,0 +1,23 @@
+package recording
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// Only SYSTEM and built-in Administrators are allowed to access recordings,
+// and the protected DACL stops inheriting permissions of parent directory
+const dirSecurityDescriptor = "D:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)"
+
+// protectDir replaces DACL of directory of recordings
+func protectDir(dir string) error {
+	sd, err := windows.SecurityDescriptorFromString(dirSecurityDescriptor)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	return windows.SetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+}
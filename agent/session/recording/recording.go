@@ -0,0 +1,329 @@
+// Package recording records interactive shell sessions in asciicast v2 format,
+// i.e., a JSON header line followed by one JSON array per event, for replaying
+// sessions in audit. Recordings are named by channel id of sessions, with a
+// numeric suffix if a recording of the same channel id exists.
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	FileExtension = ".cast"
+
+	eventOutput = "o"
+	eventInput  = "i"
+	eventResize = "r"
+	eventMarker = "m"
+
+	// Size of terminal in header when not known at start, which is followed
+	// by a resize event once client sets it
+	defaultWidth  = 80
+	defaultHeight = 24
+
+	// maxRecordingsPerChannel bounds suffixes tried for a new recording
+	maxRecordingsPerChannel = 1000
+
+	// subdirName is created under configured directory for recordings, thus
+	// permissions of the configured one, which may be shared, are untouched
+	subdirName = "aliyun_assist_session_recordings"
+)
+
+var (
+	// Recordings being written are never removed when pruning
+	_activePaths     = make(map[string]bool)
+	_activePathsLock sync.Mutex
+)
+
+type header struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recording describes a saved recording
+type Recording struct {
+	ChannelId  string    `json:"channelId"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// Recorder writes events of one session to its recording. Methods of nil
+// Recorder do nothing, thus callers need not check whether recording is
+// enabled.
+type Recorder struct {
+	lock        sync.Mutex
+	file        *os.File
+	path        string
+	startTime   time.Time
+	written     int64
+	maxBytes    int64
+	recordInput bool
+	stopped     bool
+}
+
+// Dir returns directory of recordings, which is only accessible by
+// administrators once recording started. Recordings are kept in a dedicated
+// subdirectory of the configured directory.
+func Dir() (string, error) {
+	dir := config.GetConfig().SessionRecording.Directory
+	if dir == "" {
+		return util.GetSessionRecordingPath()
+	}
+	dir = filepath.Join(dir, subdirName)
+	if err := util.MakeSurePath(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Start creates recording of session when session recording is enabled, or
+// returns nil otherwise
+func Start(channelId string, width uint32, height uint32) (*Recorder, error) {
+	recordingConfig := config.GetConfig().SessionRecording
+	if !recordingConfig.Enabled {
+		return nil, nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := protectDir(dir); err != nil {
+		return nil, err
+	}
+	prune(dir, recordingConfig.MaxTotalBytes, time.Duration(recordingConfig.RetentionDays)*24*time.Hour, time.Now())
+
+	r, err := newRecorder(dir, channelId, width, height, recordingConfig.MaxBytesPerSession, recordingConfig.RecordInput)
+	if err != nil {
+		return nil, err
+	}
+	_activePathsLock.Lock()
+	_activePaths[r.path] = true
+	_activePathsLock.Unlock()
+	log.GetLogger().WithFields(logrus.Fields{
+		"channelId": channelId,
+		"path":      r.path,
+	}).Infoln("Session recording started")
+	return r, nil
+}
+
+// createFile creates a new recording of channel in dir. Existing recordings
+// are never overwritten, e.g., when server reuses channel id.
+func createFile(dir string, channelId string) (*os.File, error) {
+	// Channel id comes from server, which must not escape the directory
+	name := filepath.Base(channelId)
+	for i := 0; i < maxRecordingsPerChannel; i++ {
+		path := filepath.Join(dir, name+FileExtension)
+		if i > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s.%d%s", name, i, FileExtension))
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			return file, err
+		}
+	}
+	return nil, fmt.Errorf("too many recordings of channel %s", channelId)
+}
+
+func newRecorder(dir string, channelId string, width uint32, height uint32, maxBytes int64, recordInput bool) (*Recorder, error) {
+	file, err := createFile(dir, channelId)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		file:        file,
+		path:        file.Name(),
+		startTime:   time.Now(),
+		maxBytes:    maxBytes,
+		recordInput: recordInput,
+	}
+	if width == 0 || height == 0 {
+		width, height = defaultWidth, defaultHeight
+	}
+	headerLine, _ := json.Marshal(header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.startTime.Unix(),
+		Title:     channelId,
+		Env: map[string]string{
+			"TERM": "xterm-256color",
+		},
+	})
+	if err := r.writeLine(headerLine); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Output records data sent to client
+func (r *Recorder) Output(data []byte) {
+	if r == nil || len(data) == 0 {
+		return
+	}
+	r.record(eventOutput, string(data))
+}
+
+// Input records data received from client
+func (r *Recorder) Input(data []byte) {
+	if r == nil || !r.recordInput || len(data) == 0 {
+		return
+	}
+	r.record(eventInput, string(data))
+}
+
+// Resize records new size of terminal
+func (r *Recorder) Resize(cols uint32, rows uint32) {
+	if r == nil {
+		return
+	}
+	r.record(eventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close finishes the recording
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	_activePathsLock.Lock()
+	delete(_activePaths, r.path)
+	_activePathsLock.Unlock()
+	return err
+}
+
+func (r *Recorder) record(eventType string, data string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil || r.stopped {
+		return
+	}
+	elapsed := time.Since(r.startTime).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err != nil {
+		return
+	}
+	if r.written+int64(len(line))+1 > r.maxBytes {
+		// Leave a marker so that replaying tells the recording is incomplete
+		r.stopped = true
+		marker, _ := json.Marshal([]interface{}{elapsed, eventMarker, "recording stopped: size limit reached"})
+		r.writeLine(marker)
+		log.GetLogger().WithField("path", r.path).Warningln("Session recording stopped due to size limit")
+		return
+	}
+	if err := r.writeLine(line); err != nil {
+		r.stopped = true
+		log.GetLogger().WithField("path", r.path).WithError(err).Errorln("Failed to write session recording")
+	}
+}
+
+func (r *Recorder) writeLine(line []byte) error {
+	n, err := r.file.Write(append(line, '\n'))
+	r.written += int64(n)
+	return err
+}
+
+// List returns saved recordings from the newest to the oldest
+func List() ([]Recording, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	recordings, err := listDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].ModifiedAt.After(recordings[j].ModifiedAt)
+	})
+	return recordings, nil
+}
+
+func listDir(dir string) ([]Recording, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	recordings := make([]Recording, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), FileExtension) {
+			continue
+		}
+		recordings = append(recordings, Recording{
+			ChannelId:  channelIdOf(info.Name()),
+			Path:       filepath.Join(dir, info.Name()),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+		})
+	}
+	return recordings, nil
+}
+
+// channelIdOf returns channel id of recording from its file name
+func channelIdOf(name string) string {
+	name = strings.TrimSuffix(name, FileExtension)
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		if _, err := strconv.Atoi(name[idx+1:]); err == nil {
+			return name[:idx]
+		}
+	}
+	return name
+}
+
+// prune removes recordings older than retention, then the oldest ones until
+// total size is within maxTotalBytes. Recordings being written are kept.
+func prune(dir string, maxTotalBytes int64, retention time.Duration, now time.Time) {
+	recordings, err := listDir(dir)
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to list session recordings")
+		return
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].ModifiedAt.Before(recordings[j].ModifiedAt)
+	})
+	var totalBytes int64
+	for _, recording := range recordings {
+		totalBytes += recording.Size
+	}
+
+	_activePathsLock.Lock()
+	defer _activePathsLock.Unlock()
+	for _, recording := range recordings {
+		expired := retention > 0 && now.Sub(recording.ModifiedAt) > retention
+		if (!expired && totalBytes <= maxTotalBytes) || _activePaths[recording.Path] {
+			continue
+		}
+		if err := os.Remove(recording.Path); err != nil {
+			log.GetLogger().WithError(err).Errorln("Failed to remove session recording")
+			continue
+		}
+		totalBytes -= recording.Size
+		log.GetLogger().WithField("path", recording.Path).Infoln("Session recording removed")
+	}
+}
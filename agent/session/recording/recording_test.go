@@ -0,0 +1,181 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+)
+
+func readEvents(t *testing.T, path string) (header, [][]interface{}) {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var h header
+	var events [][]interface{}
+	scanner := bufio.NewScanner(f)
+	assert.True(t, scanner.Scan())
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &h))
+	for scanner.Scan() {
+		var event []interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return h, events
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "c-123"+FileExtension)
+	r, err := newRecorder(dir, "c-123", 0, 0, 1024, true)
+	assert.NoError(t, err)
+	assert.Equal(t, path, r.path)
+	r.Output([]byte("$ "))
+	r.Input([]byte("ls\r"))
+	r.Resize(120, 40)
+	assert.NoError(t, r.Close())
+	// Events after closed are ignored
+	r.Output([]byte("ignored"))
+
+	h, events := readEvents(t, path)
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, uint32(defaultWidth), h.Width)
+	assert.Equal(t, uint32(defaultHeight), h.Height)
+	assert.Equal(t, "c-123", h.Title)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, []interface{}{"o", "$ "}, events[0][1:])
+	assert.Equal(t, []interface{}{"i", "ls\r"}, events[1][1:])
+	assert.Equal(t, []interface{}{"r", "120x40"}, events[2][1:])
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	if os.PathSeparator == '/' {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// Nil recorder does nothing
+	var nilRecorder *Recorder
+	nilRecorder.Output([]byte("x"))
+	assert.NoError(t, nilRecorder.Close())
+}
+
+func TestRecorderLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "c-456"+FileExtension)
+	r, err := newRecorder(dir, "c-456", 100, 30, 200, false)
+	assert.NoError(t, err)
+	r.Input([]byte("secret\r"))
+	for i := 0; i < 10; i++ {
+		r.Output([]byte("0123456789"))
+	}
+	assert.NoError(t, r.Close())
+
+	h, events := readEvents(t, path)
+	assert.Equal(t, uint32(100), h.Width)
+	assert.True(t, len(events) > 0 && len(events) < 10)
+	for _, event := range events[:len(events)-1] {
+		assert.Equal(t, "o", event[1])
+	}
+	assert.Equal(t, "m", events[len(events)-1][1])
+}
+
+func TestRecorderChannelReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first, err := newRecorder(dir, "c-789", 0, 0, 1024, false)
+	assert.NoError(t, err)
+	first.Output([]byte("first"))
+	assert.NoError(t, first.Close())
+	second, err := newRecorder(dir, "c-789", 0, 0, 1024, false)
+	assert.NoError(t, err)
+	assert.NoError(t, second.Close())
+
+	// Existing recording is kept
+	assert.Equal(t, filepath.Join(dir, "c-789.1"+FileExtension), second.path)
+	_, events := readEvents(t, first.path)
+	assert.Equal(t, 1, len(events))
+	recordings, err := listDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(recordings))
+	for _, recording := range recordings {
+		assert.Equal(t, "c-789", recording.ChannelId)
+	}
+}
+
+func TestStartInConfiguredDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Chmod(dir, 0755))
+
+	recordingConfig := &config.GetConfig().SessionRecording
+	defer func(saved config.SessionRecordingConfig) { *recordingConfig = saved }(*recordingConfig)
+	recordingConfig.Enabled = true
+	recordingConfig.Directory = dir
+
+	r, err := Start("c-configured", 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	// Configured directory may be shared, only the subdirectory is protected
+	assert.Equal(t, filepath.Join(dir, subdirName), filepath.Dir(r.path))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+		info, err = os.Stat(filepath.Dir(r.path))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	content := make([]byte, 100)
+	for i, name := range []string{"expired", "old", "new", "active"} {
+		path := filepath.Join(dir, name+FileExtension)
+		ioutil.WriteFile(path, content, 0600)
+		modTime := now.Add(-time.Duration(4-i) * time.Hour)
+		if name == "expired" {
+			modTime = now.Add(-48 * time.Hour)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "other.txt"), content, 0600)
+
+	activePath := filepath.Join(dir, "active"+FileExtension)
+	_activePaths[activePath] = true
+	defer delete(_activePaths, activePath)
+
+	prune(dir, 200, 24*time.Hour, now)
+
+	recordings, err := listDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, recording := range recordings {
+		names = append(names, recording.ChannelId)
+	}
+	assert.ElementsMatch(t, []string{"new", "active"}, names)
+	assert.FileExists(t, filepath.Join(dir, "other.txt"))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/session/channel"
+	"github.com/aliyun/aliyun_assist_client/agent/session/recording"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"os"
 	"runtime"
@@ -71,6 +72,16 @@ func (p *ShellPlugin) Execute(dataChannel channel.ISessionChannel, cancelFlag ut
 	}()
 	log.GetLogger().Infoln("start pty")
 	var err error
+	// Recorder is ready before pty started, from when input is accepted.
+	// Recording is optional, session goes on even if it failed.
+	if p.recorder, err = recording.Start(p.id, 0, 0); err != nil {
+		log.GetLogger().Errorf("Failed to start session recording: %v", err)
+	}
+	defer func() {
+		if err := p.recorder.Close(); err != nil {
+			log.GetLogger().Errorf("Failed to close session recording: %v", err)
+		}
+	}()
 	err = StartPty(p)
 	if err != nil {
 		errorString := fmt.Errorf("Unable to start shell: %s", err)
@@ -196,6 +207,7 @@ func (p *ShellPlugin) processStdoutData(
 			return processedBuf, fmt.Errorf("unable to send stream data message: %s", err)
 		}
 	}
+	p.recorder.Output(processedBuf.Bytes())
 
 	// log.GetLogger().Println("data output: ", string(processedBuf.Bytes()))
 	// return incomplete utf8 encoded unicode bytes to be processed with next batch of stdoutBytes
@@ -3,6 +3,7 @@ package shell
 import (
 	"github.com/aliyun/aliyun_assist_client/agent/session/channel"
 	"github.com/aliyun/aliyun_assist_client/agent/session/message"
+	"github.com/aliyun/aliyun_assist_client/agent/session/recording"
 	"os"
 )
 
@@ -16,6 +17,7 @@ type ShellPlugin struct {
 	dataChannel channel.ISessionChannel
 	flowLimit	int
 	sendInterval	int
+	recorder	*recording.Recorder
 }
 
 const (
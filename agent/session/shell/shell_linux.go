@@ -14,6 +14,7 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/session/channel"
 	"github.com/aliyun/aliyun_assist_client/agent/session/message"
+	"github.com/aliyun/aliyun_assist_client/agent/session/recording"
 	"github.com/aliyun/aliyun_assist_client/agent/util/process"
 )
 
@@ -30,6 +31,7 @@ type ShellPlugin struct {
 	first_ws_row uint32
 	flowLimit	int
 	sendInterval	int
+	recorder	*recording.Recorder
 }
 
 const (
@@ -164,6 +166,7 @@ func (p *ShellPlugin) InputStreamMessageHandler(streamDataMessage message.Messag
 			log.GetLogger().Errorf("Unable to write to stdin, err: %v.", err)
 			return err
 		}
+		p.recorder.Input(streamDataMessage.Payload)
 		break
 	case message.SetSizeDataMessage:
 		var size SizeData
@@ -176,6 +179,7 @@ func (p *ShellPlugin) InputStreamMessageHandler(streamDataMessage message.Messag
 			log.GetLogger().Errorf("Unable to set pty size: %s", err)
 			return err
 		}
+		p.recorder.Resize(size.Cols, size.Rows)
 		break
 	case message.StatusDataMessage:
 		if len(streamDataMessage.Payload) > 0 {
@@ -6,6 +6,7 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/session/channel"
 	"github.com/aliyun/aliyun_assist_client/agent/session/message"
+	"github.com/aliyun/aliyun_assist_client/agent/session/recording"
 	"github.com/aliyun/aliyun_assist_client/agent/session/winpty"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"os"
@@ -30,6 +31,7 @@ type ShellPlugin struct {
 	first_ws_row uint32
 	flowLimit	int
 	sendInterval	int
+	recorder	*recording.Recorder
 }
 
 func StartPty(plugin *ShellPlugin)( err error) {
@@ -123,6 +125,7 @@ func (p *ShellPlugin) InputStreamMessageHandler(streamDataMessage message.Messag
 			log.GetLogger().Errorf("Unable to write to stdin, err: %v.", err)
 			return err
 		}
+		p.recorder.Input(streamDataMessage.Payload)
 	case message.SetSizeDataMessage:
 		var size SizeData
 		if err := json.Unmarshal(streamDataMessage.Payload, &size); err != nil {
@@ -134,6 +137,7 @@ func (p *ShellPlugin) InputStreamMessageHandler(streamDataMessage message.Messag
 			log.GetLogger().Errorf("Unable to set pty size: %s", err)
 			return err
 		}
+		p.recorder.Resize(size.Cols, size.Rows)
 	case message.StatusDataMessage:
 		if len(streamDataMessage.Payload) > 0 {
 			code, err := message.BytesToIntU(streamDataMessage.Payload[0:1])
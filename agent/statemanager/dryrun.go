@@ -0,0 +1,113 @@
+package statemanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/statemanager/resources"
+)
+
+// ValidateTemplate checks template content and parameters offline: the
+// template must be well-formed without dependency cycles, each state must be
+// of a known resource type with known and valid properties, and every
+// parameter reference must be resolved.
+func ValidateTemplate(data []byte, userParameters string) (nodes []StateNode, err error) {
+	template, err := ParseStateTemplate(data)
+	if err != nil {
+		return
+	}
+	for i, state := range template.States {
+		name := state.Name
+		if name == "" {
+			name = fmt.Sprintf("%dth state", i)
+		}
+		rs, err2 := NewResourceState(StateDef{ResourceType: state.ResourceType})
+		if err2 != nil {
+			return nil, fmt.Errorf("state %s: %w", name, err2)
+		}
+		if err2 = resources.CheckProperties(rs, withoutReferences(state.Properties)); err2 != nil {
+			return nil, fmt.Errorf("state %s has invalid properties: %w", name, err2)
+		}
+	}
+	parameterValueMap, err := parseUserParameters(userParameters)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ResolveParameterValue(template.Parameters, parameterValueMap)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range template.States {
+		for _, v := range state.Properties {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			for _, ph := range re.FindAllString(s, -1) {
+				name := strings.TrimSpace(ph[2 : len(ph)-2])
+				// Optional parameter without value renders as empty string,
+				// which is most likely a mistake when referenced
+				prop, declared := template.Parameters[name]
+				_, given := parameterValueMap[name]
+				if !declared || (!given && prop.Default == nil) {
+					return nil, fmt.Errorf("parameter %s referenced by %s has no value", name, ph)
+				}
+			}
+		}
+	}
+	nodes, err = ParseStateNodes(data, userParameters)
+	if err != nil {
+		return
+	}
+	for _, node := range nodes {
+		if validator, ok := node.State.(resources.Validator); ok {
+			if err = validator.Validate(); err != nil {
+				return nil, fmt.Errorf("state %s: %w", node.Name, err)
+			}
+		}
+	}
+	return
+}
+
+// withoutReferences drops properties which are parameter references, since
+// their types are known only after resolving
+func withoutReferences(properties map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(properties))
+	for k, v := range properties {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// DryRun reports for each state what Apply would change, without changing
+// anything on the instance. Dependencies and failure policies are ignored so
+// that every state is examined.
+func DryRun(nodes []StateNode) (results []StateResult) {
+	for _, node := range nodes {
+		result := StateResult{
+			Name:         node.Name,
+			ResourceType: node.ResourceType,
+		}
+		dryRunner, ok := node.State.(resources.DryRunner)
+		if !ok {
+			result.Status = Failed
+			result.Message = fmt.Sprintf("dry run is not supported by %s", node.ResourceType)
+			results = append(results, result)
+			continue
+		}
+		var extraInfo string
+		var err error
+		result.Status, extraInfo, err = dryRunner.DryRun()
+		if err != nil {
+			result.Status = Failed
+			result.Message = err.Error()
+		} else {
+			result.Message = strings.TrimSpace(extraInfo)
+		}
+		results = append(results, result)
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package statemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	_, err := ValidateTemplate([]byte(template), `{"remotePath":"http://one-oss-bucket"}`)
+	assert.Nil(t, err)
+
+	_, err = ValidateTemplate([]byte(template), "")
+	assert.Contains(t, err.Error(), "remotePath")
+
+	_, err = ValidateTemplate([]byte(`{"States": [{"ResourceType": "ACS:Unknown"}]}`), "")
+	assert.Contains(t, err.Error(), "unsupported resource type")
+
+	_, err = ValidateTemplate([]byte(`{"States": [{"ResourceType": "ACS:File", "Properties": {"Path": "/tmp/a"}}]}`), "")
+	assert.Contains(t, err.Error(), "Path")
+
+	_, err = ValidateTemplate([]byte(`{"States": [{"ResourceType": "ACS:File", "Properties": {"DestinationPath": "/tmp/a", "Mode": "999"}}]}`), "")
+	assert.Contains(t, err.Error(), "invalid file mode")
+}
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dryrun")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.conf")
+	content := `{
+		"Parameters": {"path": {"Type": "String"}},
+		"States": [
+		  {
+			"ResourceType": "ACS:File",
+			"Properties": {"DestinationPath": "{{ path }}", "Contents": "hello"}
+		  }
+		]
+	}`
+	nodes, err := ValidateTemplate([]byte(content), `{"path": "`+filepath.ToSlash(path)+`"}`)
+	assert.Nil(t, err)
+
+	results := DryRun(nodes)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, NotCompliant, results[0].Status)
+	assert.Contains(t, results[0].Message, "Ensure")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
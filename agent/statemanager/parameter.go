@@ -0,0 +1,116 @@
+package statemanager
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ParamTypeString  = "String"
+	ParamTypeNumber  = "Number"
+	ParamTypeBoolean = "Boolean"
+	ParamTypeList    = "List"
+	ParamTypeObject  = "Object"
+	ParamTypeJson    = "Json"
+)
+
+// validateParameter checks value of parameter against its type and constraints
+func validateParameter(name string, prop ParamProp, value interface{}) error {
+	if err := checkParameterType(prop.Type, value); err != nil {
+		return fmt.Errorf("invalid value of parameter %s: %w", name, err)
+	}
+	if len(prop.AllowedValues) > 0 {
+		allowed := false
+		for _, allowedValue := range prop.AllowedValues {
+			if parameterValueEqual(allowedValue, value) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("value %v of parameter %s is not in allowed values %v", value, name, prop.AllowedValues)
+		}
+	}
+	if prop.AllowedPattern != "" {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("AllowedPattern of parameter %s applies only to String value", name)
+		}
+		pattern, err := regexp.Compile(prop.AllowedPattern)
+		if err != nil {
+			return fmt.Errorf("invalid AllowedPattern of parameter %s: %w", name, err)
+		}
+		if !pattern.MatchString(s) {
+			return fmt.Errorf("value %s of parameter %s does not match pattern %s", s, name, prop.AllowedPattern)
+		}
+	}
+	if prop.MinLength > 0 || prop.MaxLength > 0 {
+		var length int
+		switch v := value.(type) {
+		case string:
+			length = utf8.RuneCountInString(v)
+		case []interface{}:
+			length = len(v)
+		default:
+			return fmt.Errorf("MinLength and MaxLength of parameter %s apply only to String or List value", name)
+		}
+		if prop.MinLength > 0 && length < prop.MinLength {
+			return fmt.Errorf("length of parameter %s is %d, less than MinLength %d", name, length, prop.MinLength)
+		}
+		if prop.MaxLength > 0 && length > prop.MaxLength {
+			return fmt.Errorf("length of parameter %s is %d, greater than MaxLength %d", name, length, prop.MaxLength)
+		}
+	}
+	return nil
+}
+
+func checkParameterType(paramType string, value interface{}) error {
+	var ok bool
+	switch strings.ToLower(paramType) {
+	case "", strings.ToLower(ParamTypeJson):
+		return nil
+	case strings.ToLower(ParamTypeString):
+		_, ok = value.(string)
+	case strings.ToLower(ParamTypeNumber):
+		_, ok = toFloat64(value)
+	case strings.ToLower(ParamTypeBoolean):
+		_, ok = value.(bool)
+	case strings.ToLower(ParamTypeList):
+		_, ok = value.([]interface{})
+	case strings.ToLower(ParamTypeObject):
+		_, ok = value.(map[string]interface{})
+	default:
+		return fmt.Errorf("unknown parameter type %s", paramType)
+	}
+	if !ok {
+		return fmt.Errorf("%v is not of type %s", value, paramType)
+	}
+	return nil
+}
+
+// toFloat64 converts numbers decoded from JSON or written in Go to float64
+func toFloat64(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func parameterValueEqual(a interface{}, b interface{}) bool {
+	fa, okA := toFloat64(a)
+	fb, okB := toFloat64(b)
+	if okA && okB {
+		return fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,53 @@
+package statemanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateParameter(t *testing.T) {
+	assert.Nil(t, validateParameter("p", ParamProp{Type: "String"}, "abc"))
+	assert.NotNil(t, validateParameter("p", ParamProp{Type: "String"}, 1))
+	assert.Nil(t, validateParameter("p", ParamProp{Type: "Number"}, 1))
+	assert.Nil(t, validateParameter("p", ParamProp{Type: "Number"}, 1.5))
+	assert.NotNil(t, validateParameter("p", ParamProp{Type: "Number"}, "1"))
+	assert.Nil(t, validateParameter("p", ParamProp{Type: "Boolean"}, true))
+	assert.NotNil(t, validateParameter("p", ParamProp{Type: "Boolean"}, "true"))
+	assert.Nil(t, validateParameter("p", ParamProp{Type: "List"}, []interface{}{"a"}))
+	assert.NotNil(t, validateParameter("p", ParamProp{Type: "List"}, "a"))
+	assert.Nil(t, validateParameter("p", ParamProp{Type: "Object"}, map[string]interface{}{}))
+	assert.NotNil(t, validateParameter("p", ParamProp{Type: "Object"}, []interface{}{}))
+	assert.NotNil(t, validateParameter("p", ParamProp{Type: "Unknown"}, "a"))
+
+	allowed := ParamProp{Type: "Number", AllowedValues: []interface{}{float64(1), float64(2)}}
+	assert.Nil(t, validateParameter("p", allowed, 2))
+	assert.NotNil(t, validateParameter("p", allowed, 3))
+
+	pattern := ParamProp{Type: "String", AllowedPattern: "^[0-7]{3,4}$"}
+	assert.Nil(t, validateParameter("p", pattern, "0644"))
+	assert.NotNil(t, validateParameter("p", pattern, "999"))
+
+	length := ParamProp{Type: "List", MinLength: 1, MaxLength: 2}
+	assert.Nil(t, validateParameter("p", length, []interface{}{"a"}))
+	assert.NotNil(t, validateParameter("p", length, []interface{}{}))
+	assert.NotNil(t, validateParameter("p", length, []interface{}{"a", "b", "c"}))
+}
+
+func TestResolveRequiredParameter(t *testing.T) {
+	paramDefs := map[string]ParamProp{
+		"path": {Type: "String", Required: true},
+	}
+	_, err := ResolveParameterValue(paramDefs, map[string]interface{}{})
+	assert.NotNil(t, err)
+
+	params, err := ResolveParameterValue(paramDefs, map[string]interface{}{"path": "/tmp"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp", params["path"])
+
+	_, err = ResolveParameterValue(paramDefs, map[string]interface{}{"path": 1})
+	assert.NotNil(t, err)
+
+	_, err = ParseStateNodes([]byte(template), `{"mode": 644}`)
+	assert.Contains(t, err.Error(), "mode")
+}
@@ -0,0 +1,120 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+)
+
+// CommandState declares an idempotent command: CheckCommand tells whether the
+// instance is in desired state by exiting with 0, and Command is run to bring
+// the instance to desired state only when CheckCommand fails.
+type CommandState struct {
+	// CommandType is RunShellScript, RunBatScript or RunPowerShellScript,
+	// empty means default shell of current platform
+	CommandType  string
+	Command      string
+	CheckCommand string
+	WorkingDir   string
+	// Timeout in seconds for each of Command and CheckCommand
+	Timeout int
+}
+
+// CommandResult is reported as extraInfo when command state is not compliant
+type CommandResult struct {
+	Command  string
+	ExitCode int
+	Output   string
+}
+
+// CommandPlan is reported as extraInfo by DryRun, telling commands Apply
+// would run
+type CommandPlan struct {
+	// WouldCheck is always run by Apply
+	WouldCheck string
+	// WouldRun is run by Apply only if WouldCheck fails
+	WouldRun string
+}
+
+func (cs *CommandState) Load(properties map[string]interface{}) (err error) {
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, cs)
+	return
+}
+
+// Apply runs Command if CheckCommand reports not compliant, then runs
+// CheckCommand again to verify the result
+func (cs *CommandState) Apply() (status string, extraInfo string, err error) {
+	status, extraInfo, err = cs.Monitor()
+	if status != NotCompliant {
+		return
+	}
+	log.GetLogger().Infof("check command not passed, run command: %s", cs.Command)
+	exitCode, output, err := runScript(cs.CommandType, cs.Command, cs.WorkingDir, cs.Timeout)
+	if err != nil {
+		return Failed, "", fmt.Errorf("run command failed: %w", err)
+	}
+	if exitCode != 0 {
+		return Failed, "", fmt.Errorf("command exits with code %d: %s", exitCode, truncateOutput(output))
+	}
+	return cs.Monitor()
+}
+
+// Monitor runs CheckCommand, exit code 0 means compliant
+func (cs *CommandState) Monitor() (status string, extraInfo string, err error) {
+	if err = cs.Validate(); err != nil {
+		return Failed, "", err
+	}
+	exitCode, output, err := runScript(cs.CommandType, cs.CheckCommand, cs.WorkingDir, cs.Timeout)
+	if err != nil {
+		return Failed, "", fmt.Errorf("run check command failed: %w", err)
+	}
+	if exitCode == 0 {
+		return Compliant, "", nil
+	}
+	data, err := json.Marshal(CommandResult{
+		Command:  cs.CheckCommand,
+		ExitCode: exitCode,
+		Output:   truncateOutput(output),
+	})
+	if err != nil {
+		return Failed, "", err
+	}
+	return NotCompliant, string(data), nil
+}
+
+// DryRun runs nothing, since CheckCommand may have side effects as well, and
+// reports NotCompliant with commands Apply would run
+func (cs *CommandState) DryRun() (status string, extraInfo string, err error) {
+	if err = cs.Validate(); err != nil {
+		return Failed, "", err
+	}
+	data, err := json.Marshal(CommandPlan{
+		WouldCheck: cs.CheckCommand,
+		WouldRun:   cs.Command,
+	})
+	if err != nil {
+		return Failed, "", err
+	}
+	return NotCompliant, string(data), nil
+}
+
+func (cs *CommandState) Validate() error {
+	if cs.Command == "" {
+		return errors.New("Command is required for command state")
+	}
+	if cs.CheckCommand == "" {
+		return errors.New("CheckCommand is required for command state")
+	}
+	switch cs.CommandType {
+	case "", "RunShellScript", "RunBatScript", "RunPowerShellScript":
+	default:
+		return fmt.Errorf("invalid CommandType %s", cs.CommandType)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+package resources
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commandstate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+
+	cs := &CommandState{}
+	err = cs.Load(map[string]interface{}{
+		"CommandType":  "RunShellScript",
+		"Command":      "touch " + marker,
+		"CheckCommand": "test -f " + marker,
+		"Timeout":      10,
+	})
+	assert.Nil(t, err)
+
+	status, extraInfo, err := cs.Monitor()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+	assert.Contains(t, extraInfo, "ExitCode")
+
+	status, _, err = cs.Apply()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+	assert.True(t, fileExists(marker))
+
+	cs.Command = "exit 3"
+	os.Remove(marker)
+	status, _, err = cs.Apply()
+	assert.NotNil(t, err)
+	assert.Equal(t, Failed, status)
+}
+
+func TestCommandStateDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commandstate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+
+	cs := &CommandState{}
+	err = cs.Load(map[string]interface{}{
+		"CommandType":  "RunShellScript",
+		"Command":      "touch " + marker,
+		"CheckCommand": "touch " + marker + " && false",
+		"Timeout":      10,
+	})
+	assert.Nil(t, err)
+
+	// Neither CheckCommand nor Command is run
+	status, extraInfo, err := cs.DryRun()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+	assert.Contains(t, extraInfo, "WouldCheck")
+	assert.False(t, fileExists(marker))
+}
+
+func TestVersionMatches(t *testing.T) {
+	assert.True(t, versionMatches("1.20.1-10.el7", ""))
+	assert.True(t, versionMatches("1.20.1-10.el7", "1.20.1"))
+	assert.True(t, versionMatches("1.20.1-10.el7", "1.20.1-10.el7"))
+	assert.False(t, versionMatches("1.20.10-1.el7", "1.20.1"))
+}
@@ -0,0 +1,67 @@
+package resources
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util/process"
+)
+
+const (
+	// defaultCommandTimeout is timeout in seconds of commands run by resources
+	defaultCommandTimeout = 600
+	// maxOutputLength limits output kept in extraInfo of state report
+	maxOutputLength = 2048
+)
+
+// runCommand runs command and returns its exit code and combined output
+func runCommand(workingDir string, timeout int, name string, args ...string) (exitCode int, output string, err error) {
+	var outputBuffer process.SafeBuffer
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	processCmd := process.NewProcessCmd()
+	exitCode, _, err = processCmd.SyncRun(workingDir, name, args, &outputBuffer, &outputBuffer, nil, nil, timeout)
+	output = outputBuffer.String()
+	return
+}
+
+// runScript runs script with interpreter of commandType, which is same as
+// CommandType of command tasks. Empty commandType means default interpreter
+// of current platform.
+func runScript(commandType string, script string, workingDir string, timeout int) (exitCode int, output string, err error) {
+	switch commandType {
+	case "RunPowerShellScript":
+		return runCommand(workingDir, timeout, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	case "RunBatScript":
+		return runCommand(workingDir, timeout, "cmd", "/c", script)
+	case "RunShellScript":
+		return runCommand(workingDir, timeout, "sh", "-c", script)
+	default:
+		if runtime.GOOS == "windows" {
+			return runCommand(workingDir, timeout, "cmd", "/c", script)
+		}
+		return runCommand(workingDir, timeout, "sh", "-c", script)
+	}
+}
+
+// truncateOutput keeps the tail of output which is usually most informative
+func truncateOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxOutputLength {
+		return output[len(output)-maxOutputLength:]
+	}
+	return output
+}
+
+// commandError converts non-zero exit code to error with output
+func commandError(exitCode int, output string, err error) error {
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exit code %d: %s", exitCode, truncateOutput(output))
+	}
+	return nil
+}
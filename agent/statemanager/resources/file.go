@@ -1,9 +1,48 @@
 package resources
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+const (
+	EnsurePresent = "Present"
+	EnsureAbsent  = "Absent"
+
+	FileTypeFile      = "File"
+	FileTypeDirectory = "Directory"
+
+	defaultFileMode      = 0644
+	defaultDirectoryMode = 0755
+	downloadTimeout      = 5 * time.Minute
+
+	// sourceCacheFile records checksums of content fetched from remote
+	// SourcePath, which dry run compares with instead of downloading
+	sourceCacheFile = "state_file_sources.json"
+	// maxCachedSources bounds the number of sources recorded, the ones
+	// fetched earliest are forgotten when exceeded
+	maxCachedSources = 256
 )
 
+var sourceCacheLock sync.Mutex
+
 type FileState struct {
 	Ensure          string
 	State           string
@@ -15,6 +54,24 @@ type FileState struct {
 	Contents        string
 	Checksum        string
 	Attributes      string
+
+	// content caches desired file content so that SourcePath is fetched only
+	// once during Apply and the following Monitor
+	content []byte
+	// offline is set during DryRun, which never downloads remote SourcePath
+	offline bool
+}
+
+// sourceMetadata is recorded for each remote SourcePath once fetched
+type sourceMetadata struct {
+	Md5       string
+	FetchedAt time.Time
+}
+
+// FileDiff is reported as extraInfo when file is not compliant
+type FileDiff struct {
+	DestinationPath string
+	Diff            map[string]DiffItem
 }
 
 func (fs *FileState) Load(properties map[string]interface{}) (err error) {
@@ -26,10 +83,475 @@ func (fs *FileState) Load(properties map[string]interface{}) (err error) {
 	return
 }
 
+// Apply creates, updates or deletes file according to the declared state,
+// then checks the result with Monitor
 func (fs *FileState) Apply() (status string, extraInfo string, err error) {
-	return Compliant, "", nil
+	if err = fs.Validate(); err != nil {
+		return Failed, "", err
+	}
+	if fs.ensure() == EnsureAbsent {
+		if err = fs.remove(); err != nil {
+			return Failed, "", err
+		}
+		return fs.Monitor()
+	}
+	if fs.fileType() == FileTypeDirectory {
+		err = fs.applyDirectory()
+	} else {
+		err = fs.applyFile()
+	}
+	if err != nil {
+		log.GetLogger().WithError(err).Errorf("apply file state %s failed", fs.DestinationPath)
+		return Failed, "", err
+	}
+	return fs.Monitor()
 }
 
+// Monitor compares the file on disk with the declared state
 func (fs *FileState) Monitor() (status string, extraInfo string, err error) {
-	return Compliant, "", nil
+	if err = fs.Validate(); err != nil {
+		return Failed, "", err
+	}
+	diff, err := fs.diff()
+	if err != nil {
+		return Failed, "", err
+	}
+	if len(diff) == 0 {
+		return Compliant, "", nil
+	}
+	data, err := json.Marshal(FileDiff{
+		DestinationPath: fs.DestinationPath,
+		Diff:            diff,
+	})
+	if err != nil {
+		return Failed, "", err
+	}
+	return NotCompliant, string(data), nil
+}
+
+func (fs *FileState) ensure() string {
+	if fs.Ensure == "" {
+		return EnsurePresent
+	}
+	return fs.Ensure
+}
+
+func (fs *FileState) fileType() string {
+	if fs.State == "" {
+		return FileTypeFile
+	}
+	return fs.State
+}
+
+// DryRun reports changes Apply would make to the file. Remote SourcePath is
+// never downloaded: content is compared with Checksum if specified, or with
+// checksum of content fetched from SourcePath last time otherwise.
+func (fs *FileState) DryRun() (status string, extraInfo string, err error) {
+	fs.offline = true
+	defer func() { fs.offline = false }()
+	return fs.Monitor()
+}
+
+func (fs *FileState) Validate() error {
+	if fs.DestinationPath == "" {
+		return errors.New("DestinationPath is required for file state")
+	}
+	if fs.ensure() != EnsurePresent && fs.ensure() != EnsureAbsent {
+		return fmt.Errorf("invalid Ensure %s, should be %s or %s", fs.Ensure, EnsurePresent, EnsureAbsent)
+	}
+	if fs.fileType() != FileTypeFile && fs.fileType() != FileTypeDirectory {
+		return fmt.Errorf("invalid State %s, should be %s or %s", fs.State, FileTypeFile, FileTypeDirectory)
+	}
+	if fs.Contents != "" && fs.SourcePath != "" {
+		return errors.New("Contents and SourcePath can not be specified at the same time")
+	}
+	if _, err := fs.fileMode(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fileMode returns the declared permission bits, or 0 if Mode is not specified
+func (fs *FileState) fileMode() (os.FileMode, error) {
+	if fs.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(fs.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid file mode %s", fs.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// hasContent reports whether the state declares content of the file
+func (fs *FileState) hasContent() bool {
+	return fs.Contents != "" || fs.SourcePath != ""
+}
+
+// desiredContent returns content from Contents or SourcePath, and verifies it
+// with Checksum if specified
+func (fs *FileState) desiredContent() ([]byte, error) {
+	if fs.content != nil {
+		return fs.content, nil
+	}
+	var content []byte
+	var err error
+	if fs.SourcePath != "" {
+		content, err = fetchSource(fs.SourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("fetch file from %s failed: %w", fs.SourcePath, err)
+		}
+	} else {
+		content = []byte(fs.Contents)
+	}
+	if fs.Checksum != "" {
+		match, err := verifyChecksum(bytes.NewReader(content), fs.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			return nil, fmt.Errorf("checksum of content does not match %s", fs.Checksum)
+		}
+	}
+	fs.content = content
+	return content, nil
+}
+
+func (fs *FileState) remove() error {
+	fi, err := os.Lstat(fs.DestinationPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if fs.fileType() != FileTypeDirectory {
+			return fmt.Errorf("%s is a directory, State should be %s to delete it", fs.DestinationPath, FileTypeDirectory)
+		}
+		log.GetLogger().Infof("delete directory %s", fs.DestinationPath)
+		return os.RemoveAll(fs.DestinationPath)
+	}
+	log.GetLogger().Infof("delete file %s", fs.DestinationPath)
+	return os.Remove(fs.DestinationPath)
+}
+
+func (fs *FileState) applyDirectory() error {
+	mode, _ := fs.fileMode()
+	if mode == 0 {
+		mode = defaultDirectoryMode
+	}
+	fi, err := os.Stat(fs.DestinationPath)
+	if err == nil && !fi.IsDir() {
+		return fmt.Errorf("%s exists but is not a directory", fs.DestinationPath)
+	}
+	if os.IsNotExist(err) {
+		log.GetLogger().Infof("create directory %s", fs.DestinationPath)
+		if err = os.MkdirAll(fs.DestinationPath, mode); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return fs.applyAttributes()
+}
+
+func (fs *FileState) applyFile() error {
+	fi, err := os.Stat(fs.DestinationPath)
+	exist := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if exist && fi.IsDir() {
+		return fmt.Errorf("%s exists but is a directory", fs.DestinationPath)
+	}
+	needWrite := !exist
+	if exist && fs.hasContent() {
+		same, _, err := fs.contentMatches()
+		if err != nil {
+			return err
+		}
+		needWrite = !same
+	}
+	if needWrite {
+		var content []byte
+		if fs.hasContent() {
+			if content, err = fs.desiredContent(); err != nil {
+				return err
+			}
+		}
+		log.GetLogger().Infof("write file %s", fs.DestinationPath)
+		if err = writeFileAtomic(fs.DestinationPath, content); err != nil {
+			return err
+		}
+	}
+	return fs.applyAttributes()
+}
+
+// applyAttributes sets mode, owner and group of DestinationPath
+func (fs *FileState) applyAttributes() error {
+	mode, _ := fs.fileMode()
+	if mode != 0 && modeSupported {
+		if err := os.Chmod(fs.DestinationPath, mode); err != nil {
+			return fmt.Errorf("chmod %s failed: %w", fs.DestinationPath, err)
+		}
+	}
+	if fs.Owner != "" || fs.Group != "" {
+		if err := chownFile(fs.DestinationPath, fs.Owner, fs.Group); err != nil {
+			return fmt.Errorf("chown %s failed: %w", fs.DestinationPath, err)
+		}
+	}
+	return nil
+}
+
+// contentMatches checks whether content of existing DestinationPath is same
+// as the declared one, and returns checksum of the declared content. Checksum
+// is used directly when specified, so that SourcePath need not be downloaded.
+func (fs *FileState) contentMatches() (bool, string, error) {
+	f, err := os.Open(fs.DestinationPath)
+	if err != nil {
+		return false, "", err
+	}
+	defer f.Close()
+	checksum := fs.Checksum
+	if checksum == "" && fs.offline && isRemoteSource(fs.SourcePath) {
+		metadata, ok := cachedSourceMetadata(fs.SourcePath)
+		if !ok {
+			return false, fmt.Sprintf("unknown since %s is not downloaded in dry run", fs.SourcePath), nil
+		}
+		checksum = "md5:" + metadata.Md5
+	}
+	if checksum != "" {
+		match, err := verifyChecksum(f, checksum)
+		return match, checksum, err
+	}
+	content, err := fs.desiredContent()
+	if err != nil {
+		return false, "", err
+	}
+	actual, err := ioutil.ReadAll(f)
+	if err != nil {
+		return false, "", err
+	}
+	return bytes.Equal(actual, content), util.ComputeBinMd5(content), nil
+}
+
+func (fs *FileState) diff() (map[string]DiffItem, error) {
+	diff := make(map[string]DiffItem)
+	fi, err := os.Lstat(fs.DestinationPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exist := err == nil
+	if fs.ensure() == EnsureAbsent {
+		if exist {
+			diff["Ensure"] = DiffItem{Expected: EnsureAbsent, Actual: EnsurePresent}
+		}
+		return diff, nil
+	}
+	if !exist {
+		diff["Ensure"] = DiffItem{Expected: EnsurePresent, Actual: EnsureAbsent}
+		return diff, nil
+	}
+	if fi.IsDir() != (fs.fileType() == FileTypeDirectory) {
+		actual := FileTypeFile
+		if fi.IsDir() {
+			actual = FileTypeDirectory
+		}
+		diff["State"] = DiffItem{Expected: fs.fileType(), Actual: actual}
+		return diff, nil
+	}
+	if fs.fileType() == FileTypeFile && fs.hasContent() {
+		same, expected, err := fs.contentMatches()
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			actual, _ := util.ComputeMd5(fs.DestinationPath)
+			diff["Checksum"] = DiffItem{Expected: expected, Actual: actual}
+		}
+	}
+	mode, _ := fs.fileMode()
+	if mode != 0 && modeSupported && fi.Mode().Perm() != mode.Perm() {
+		diff["Mode"] = DiffItem{
+			Expected: fmt.Sprintf("%04o", mode.Perm()),
+			Actual:   fmt.Sprintf("%04o", fi.Mode().Perm()),
+		}
+	}
+	if fs.Owner != "" || fs.Group != "" {
+		owner, group, err := fileOwnership(fs.DestinationPath)
+		if err != nil {
+			return nil, err
+		}
+		if fs.Owner != "" && !sameAccount(fs.Owner, owner) {
+			diff["Owner"] = DiffItem{Expected: fs.Owner, Actual: owner.name}
+		}
+		if fs.Group != "" && !sameAccount(fs.Group, group) {
+			diff["Group"] = DiffItem{Expected: fs.Group, Actual: group.name}
+		}
+	}
+	return diff, nil
+}
+
+// account is the owner or group of a file, name may be empty if it can not
+// be looked up
+type account struct {
+	id   string
+	name string
+}
+
+func sameAccount(expected string, actual account) bool {
+	if actual.id == "" && actual.name == "" {
+		// ownership is not supported on current platform
+		return true
+	}
+	return expected == actual.name || expected == actual.id
+}
+
+// verifyChecksum checks content from r against checksum in form of
+// "md5:<hex>", "sha256:<hex>" or a bare md5/sha256 hex string
+func verifyChecksum(r io.Reader, checksum string) (bool, error) {
+	algorithm := ""
+	expected := checksum
+	if idx := strings.Index(checksum, ":"); idx >= 0 {
+		algorithm = strings.ToLower(checksum[:idx])
+		expected = checksum[idx+1:]
+	}
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	var h hash.Hash
+	switch {
+	case algorithm == "md5" || (algorithm == "" && len(expected) == md5.Size*2):
+		h = md5.New()
+	case algorithm == "sha256" || (algorithm == "" && len(expected) == sha256.Size*2):
+		h = sha256.New()
+	default:
+		return false, fmt.Errorf("unsupported checksum %s", checksum)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == expected, nil
+}
+
+func isRemoteSource(source string) bool {
+	lower := strings.ToLower(source)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// fetchSource reads content from a http(s) url or a local path. Checksum of
+// content from url is recorded for dry run.
+func fetchSource(source string) ([]byte, error) {
+	if !isRemoteSource(source) {
+		return ioutil.ReadFile(source)
+	}
+	tempDir, err := util.GetTempPath()
+	if err != nil {
+		return nil, err
+	}
+	tempFile, err := ioutil.TempFile(tempDir, "state_file_")
+	if err != nil {
+		return nil, err
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
+	if err = util.HttpDownloadWithTimeout(source, tempPath, downloadTimeout); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(tempPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordSourceMetadata(source, content); err != nil {
+		log.GetLogger().WithError(err).Warningf("Failed to record checksum of %s", source)
+	}
+	return content, nil
+}
+
+func sourceCachePath() (string, error) {
+	dir, err := util.GetCachePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sourceCacheFile), nil
+}
+
+func loadSourceCache() map[string]sourceMetadata {
+	cache := make(map[string]sourceMetadata)
+	path, err := sourceCachePath()
+	if err != nil {
+		return cache
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+// cachedSourceMetadata returns metadata recorded when source fetched last time
+func cachedSourceMetadata(source string) (sourceMetadata, bool) {
+	sourceCacheLock.Lock()
+	defer sourceCacheLock.Unlock()
+	metadata, ok := loadSourceCache()[source]
+	return metadata, ok
+}
+
+func recordSourceMetadata(source string, content []byte) error {
+	sourceCacheLock.Lock()
+	defer sourceCacheLock.Unlock()
+	cache := loadSourceCache()
+	cache[source] = sourceMetadata{
+		Md5:       util.ComputeBinMd5(content),
+		FetchedAt: time.Now(),
+	}
+	for len(cache) > maxCachedSources {
+		var earliest string
+		for s, m := range cache {
+			if earliest == "" || m.FetchedAt.Before(cache[earliest].FetchedAt) {
+				earliest = s
+			}
+		}
+		delete(cache, earliest)
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	path, err := sourceCachePath()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes content to a temporary file in the same directory
+// and renames it to path, so a partially written file is never left behind
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, defaultDirectoryMode); err != nil {
+		return err
+	}
+	mode := os.FileMode(defaultFileMode)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tempFile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	_, err = tempFile.Write(content)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
 }
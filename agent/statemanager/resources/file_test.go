@@ -0,0 +1,158 @@
+package resources
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func TestFileStateApplyAndMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "test.conf")
+
+	fs := &FileState{
+		Ensure:          "Present",
+		State:           "File",
+		DestinationPath: path,
+		Contents:        "hello",
+		Mode:            "600",
+	}
+	status, _, err := fs.Monitor()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+
+	status, extraInfo, err := fs.Apply()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status, extraInfo)
+	content, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "hello", string(content))
+	if runtime.GOOS != "windows" {
+		fi, _ := os.Stat(path)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	ioutil.WriteFile(path, []byte("drifted"), 0600)
+	status, extraInfo, err = fs.Monitor()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+	var diff FileDiff
+	assert.Nil(t, json.Unmarshal([]byte(extraInfo), &diff))
+	assert.Equal(t, path, diff.DestinationPath)
+	assert.Contains(t, diff.Diff, "Checksum")
+
+	status, _, err = fs.Apply()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+
+	absent := &FileState{Ensure: "Absent", DestinationPath: path}
+	status, _, err = absent.Apply()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+	assert.False(t, fileExists(path))
+}
+
+func TestFileStateChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source")
+	ioutil.WriteFile(source, []byte("hello"), 0644)
+
+	fs := &FileState{
+		DestinationPath: filepath.Join(dir, "dest"),
+		SourcePath:      source,
+		Checksum:        "md5:5d41402abc4b2a76b9719d911017c592",
+	}
+	status, _, err := fs.Apply()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+
+	fs = &FileState{
+		DestinationPath: filepath.Join(dir, "dest2"),
+		SourcePath:      source,
+		Checksum:        "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	}
+	status, _, err = fs.Apply()
+	assert.NotNil(t, err)
+	assert.Equal(t, Failed, status)
+}
+
+func TestFileStateDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a", "b")
+
+	fs := &FileState{State: "Directory", DestinationPath: path}
+	status, _, err := fs.Apply()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+	assert.True(t, fileExists(path))
+
+	file := &FileState{State: "File", DestinationPath: path}
+	status, extraInfo, err := file.Monitor()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+	assert.Contains(t, extraInfo, "State")
+}
+
+func TestFileStateDryRunRemoteSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.conf")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("hello"), 0644))
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	source := server.URL + "/" + filepath.Base(dir)
+
+	fs := &FileState{
+		Ensure:          "Present",
+		State:           "File",
+		DestinationPath: path,
+		SourcePath:      source,
+	}
+	// Source never fetched is not downloaded
+	status, extraInfo, err := fs.DryRun()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+	assert.Contains(t, extraInfo, "not downloaded")
+	assert.Equal(t, 0, downloads)
+
+	// Checksum of source fetched last time is compared with
+	status, _, err = fs.Monitor()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+	assert.Equal(t, 1, downloads)
+	fs.content = nil
+	status, _, err = fs.DryRun()
+	assert.Nil(t, err)
+	assert.Equal(t, Compliant, status)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("changed"), 0644))
+	status, extraInfo, err = fs.DryRun()
+	assert.Nil(t, err)
+	assert.Equal(t, NotCompliant, status)
+	assert.Contains(t, extraInfo, util.ComputeBinMd5([]byte("hello")))
+	assert.Equal(t, 1, downloads)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
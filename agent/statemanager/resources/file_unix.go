@@ -0,0 +1,62 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+package resources
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+const modeSupported = true
+
+func fileOwnership(path string) (owner account, group account, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		err = fmt.Errorf("can not get ownership of %s", path)
+		return
+	}
+	owner.id = strconv.FormatUint(uint64(stat.Uid), 10)
+	if u, err2 := user.LookupId(owner.id); err2 == nil {
+		owner.name = u.Username
+	}
+	group.id = strconv.FormatUint(uint64(stat.Gid), 10)
+	if g, err2 := user.LookupGroupId(group.id); err2 == nil {
+		group.name = g.Name
+	}
+	return
+}
+
+func chownFile(path string, owner string, group string) error {
+	uid, gid := -1, -1
+	if owner != "" {
+		if id, err := strconv.Atoi(owner); err == nil {
+			uid = id
+		} else {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if group != "" {
+		if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return os.Chown(path, uid, gid)
+}
@@ -0,0 +1,13 @@
+package resources
+
+// Unix permission bits and ownership are not applicable to files on Windows,
+// so Mode, Owner and Group are ignored
+const modeSupported = false
+
+func fileOwnership(path string) (owner account, group account, err error) {
+	return
+}
+
+func chownFile(path string, owner string, group string) error {
+	return nil
+}
@@ -2,6 +2,7 @@ package resources
 
 import (
 	"encoding/json"
+	"errors"
 
 	"github.com/aliyun/aliyun_assist_client/agent/inventory"
 	"github.com/aliyun/aliyun_assist_client/agent/inventory/model"
@@ -29,3 +30,19 @@ func (is *InventoryState) Monitor() (status string, extraInfo string, err error)
 	}
 	return Compliant, "", nil
 }
+
+func (is *InventoryState) Validate() error {
+	if len(is.InventoryPolicy) == 0 {
+		return errors.New("Policy is required for inventory state")
+	}
+	return nil
+}
+
+// DryRun does not run gatherers since they upload inventory data to server,
+// and inventory state never changes the instance
+func (is *InventoryState) DryRun() (status string, extraInfo string, err error) {
+	if err = is.Validate(); err != nil {
+		return Failed, "", err
+	}
+	return Compliant, "", nil
+}
@@ -0,0 +1,109 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+)
+
+// PackageState declares state of an OS package managed by rpm or dpkg
+type PackageState struct {
+	Name string
+	// Ensure is Present or Absent
+	Ensure string
+	// Version is the expected version of package when Ensure is Present, empty
+	// means any version
+	Version string
+}
+
+func (ps *PackageState) Load(properties map[string]interface{}) (err error) {
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, ps)
+	return
+}
+
+// Apply installs or removes package, then checks the result with Monitor
+func (ps *PackageState) Apply() (status string, extraInfo string, err error) {
+	if err = ps.Validate(); err != nil {
+		return Failed, "", err
+	}
+	installed, version, err := packageVersion(ps.Name)
+	if err != nil {
+		return Failed, "", err
+	}
+	if ps.ensure() == EnsureAbsent {
+		if installed {
+			log.GetLogger().Infof("remove package %s", ps.Name)
+			if err = removePackage(ps.Name); err != nil {
+				return Failed, "", fmt.Errorf("remove package %s failed: %w", ps.Name, err)
+			}
+		}
+	} else if !installed || !versionMatches(version, ps.Version) {
+		log.GetLogger().Infof("install package %s version %s", ps.Name, ps.Version)
+		if err = installPackage(ps.Name, ps.Version); err != nil {
+			return Failed, "", fmt.Errorf("install package %s failed: %w", ps.Name, err)
+		}
+	}
+	return ps.Monitor()
+}
+
+// Monitor compares installed package with the declared state
+func (ps *PackageState) Monitor() (status string, extraInfo string, err error) {
+	if err = ps.Validate(); err != nil {
+		return Failed, "", err
+	}
+	installed, version, err := packageVersion(ps.Name)
+	if err != nil {
+		return Failed, "", err
+	}
+	diff := make(map[string]DiffItem)
+	if ps.ensure() == EnsureAbsent {
+		if installed {
+			diff["Ensure"] = DiffItem{Expected: EnsureAbsent, Actual: EnsurePresent}
+		}
+	} else if !installed {
+		diff["Ensure"] = DiffItem{Expected: EnsurePresent, Actual: EnsureAbsent}
+	} else if !versionMatches(version, ps.Version) {
+		diff["Version"] = DiffItem{Expected: ps.Version, Actual: version}
+	}
+	return resourceDiffResult(ps.Name, diff)
+}
+
+func (ps *PackageState) ensure() string {
+	if ps.Ensure == "" {
+		return EnsurePresent
+	}
+	return ps.Ensure
+}
+
+// DryRun reports changes Apply would make to the package
+func (ps *PackageState) DryRun() (status string, extraInfo string, err error) {
+	return ps.Monitor()
+}
+
+func (ps *PackageState) Validate() error {
+	if ps.Name == "" {
+		return errors.New("Name is required for package state")
+	}
+	if ps.ensure() != EnsurePresent && ps.ensure() != EnsureAbsent {
+		return fmt.Errorf("invalid Ensure %s, should be %s or %s", ps.Ensure, EnsurePresent, EnsureAbsent)
+	}
+	return nil
+}
+
+// versionMatches checks installed version against expected one. Expected
+// version may omit the release part, e.g. "1.20.1" matches "1.20.1-10.el7"
+func versionMatches(installed string, expected string) bool {
+	if expected == "" {
+		return true
+	}
+	return installed == expected ||
+		strings.HasPrefix(installed, expected+"-") ||
+		strings.HasPrefix(installed, expected+"+")
+}
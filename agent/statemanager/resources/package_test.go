@@ -0,0 +1,175 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"bou.ke/monkey"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePackages replaces package manager with installed versions in map
+type fakePackages struct {
+	installed  map[string]string
+	installErr error
+	installs   int
+	removes    int
+}
+
+func (f *fakePackages) patch() func() {
+	guards := []*monkey.PatchGuard{
+		monkey.Patch(packageVersion, func(name string) (bool, string, error) {
+			version, ok := f.installed[name]
+			return ok, version, nil
+		}),
+		monkey.Patch(installPackage, func(name string, version string) error {
+			f.installs++
+			if f.installErr != nil {
+				return f.installErr
+			}
+			if version == "" {
+				version = "1.0-1"
+			}
+			f.installed[name] = version
+			return nil
+		}),
+		monkey.Patch(removePackage, func(name string) error {
+			f.removes++
+			delete(f.installed, name)
+			return nil
+		}),
+	}
+	return func() {
+		for _, guard := range guards {
+			guard.Unpatch()
+		}
+	}
+}
+
+func TestPackageLoad(t *testing.T) {
+	ps := &PackageState{}
+	assert.NoError(t, ps.Load(map[string]interface{}{
+		"Name":    "nginx",
+		"Ensure":  "Present",
+		"Version": "1.20.1",
+	}))
+	assert.Equal(t, PackageState{Name: "nginx", Ensure: EnsurePresent, Version: "1.20.1"}, *ps)
+
+	// Ensure defaults to Present
+	ps = &PackageState{}
+	assert.NoError(t, ps.Load(map[string]interface{}{"Name": "nginx"}))
+	assert.NoError(t, ps.Validate())
+	assert.Equal(t, EnsurePresent, ps.ensure())
+
+	assert.Error(t, (&PackageState{}).Validate())
+	assert.Error(t, (&PackageState{Name: "nginx", Ensure: "Latest"}).Validate())
+}
+
+func TestPackageTransitions(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed map[string]string
+		state     PackageState
+		// Status of Monitor before and after Apply, and the diff before
+		before     string
+		beforeDiff string
+		after      string
+		installs   int
+		removes    int
+	}{
+		{
+			name:       "install absent package",
+			installed:  map[string]string{},
+			state:      PackageState{Name: "nginx"},
+			before:     NotCompliant,
+			beforeDiff: "Ensure",
+			after:      Compliant,
+			installs:   1,
+		},
+		{
+			name:       "change version",
+			installed:  map[string]string{"nginx": "1.18.0-1"},
+			state:      PackageState{Name: "nginx", Version: "1.20.1"},
+			before:     NotCompliant,
+			beforeDiff: "Version",
+			after:      Compliant,
+			installs:   1,
+		},
+		{
+			name:      "version without release matches",
+			installed: map[string]string{"nginx": "1.20.1-10.el7"},
+			state:     PackageState{Name: "nginx", Version: "1.20.1"},
+			before:    Compliant,
+			after:     Compliant,
+		},
+		{
+			name:       "remove present package",
+			installed:  map[string]string{"nginx": "1.20.1-10.el7"},
+			state:      PackageState{Name: "nginx", Ensure: EnsureAbsent, Version: "1.20.1"},
+			before:     NotCompliant,
+			beforeDiff: "Ensure",
+			after:      Compliant,
+			removes:    1,
+		},
+		{
+			name:      "already absent",
+			installed: map[string]string{},
+			state:     PackageState{Name: "nginx", Ensure: EnsureAbsent},
+			before:    Compliant,
+			after:     Compliant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packages := &fakePackages{installed: tt.installed}
+			defer packages.patch()()
+
+			status, extraInfo, err := tt.state.Monitor()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.before, status)
+			if tt.beforeDiff != "" {
+				var diff ResourceDiff
+				assert.NoError(t, json.Unmarshal([]byte(extraInfo), &diff))
+				assert.Equal(t, "nginx", diff.Name)
+				assert.Contains(t, diff.Diff, tt.beforeDiff)
+			}
+			// Dry run reports the same without changing anything
+			status, _, err = tt.state.DryRun()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.before, status)
+			assert.Equal(t, 0, packages.installs+packages.removes)
+
+			status, _, err = tt.state.Apply()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.after, status)
+			assert.Equal(t, tt.installs, packages.installs)
+			assert.Equal(t, tt.removes, packages.removes)
+
+			// Applying again changes nothing
+			status, _, err = tt.state.Apply()
+			assert.NoError(t, err)
+			assert.Equal(t, Compliant, status)
+			assert.Equal(t, tt.installs, packages.installs)
+			assert.Equal(t, tt.removes, packages.removes)
+		})
+	}
+}
+
+func TestPackageApplyFailed(t *testing.T) {
+	packages := &fakePackages{
+		installed:  map[string]string{},
+		installErr: errors.New("no package nginx available"),
+	}
+	defer packages.patch()()
+
+	status, _, err := (&PackageState{Name: "nginx"}).Apply()
+	assert.Equal(t, Failed, status)
+	assert.Error(t, err)
+	assert.Empty(t, packages.installed)
+
+	status, _, err = (&PackageState{Name: "nginx", Ensure: "Latest"}).Apply()
+	assert.Equal(t, Failed, status)
+	assert.Error(t, err)
+	assert.Equal(t, 1, packages.installs)
+}
@@ -0,0 +1,81 @@
+//go:build !windows
+// +build !windows
+
+package resources
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func packageVersion(name string) (installed bool, version string, err error) {
+	if util.HasCmdInLinux("dpkg-query") {
+		exitCode, output, err := runCommand("", 0, "dpkg-query", "-W", "-f=${Status}|${Version}", name)
+		if err != nil {
+			return false, "", err
+		}
+		if exitCode != 0 {
+			return false, "", nil
+		}
+		fields := strings.SplitN(strings.TrimSpace(output), "|", 2)
+		if len(fields) != 2 || !strings.HasSuffix(fields[0], " installed") {
+			return false, "", nil
+		}
+		return true, fields[1], nil
+	}
+	if util.HasCmdInLinux("rpm") {
+		exitCode, output, err := runCommand("", 0, "rpm", "-q", "--qf", "%{VERSION}-%{RELEASE}", name)
+		if err != nil {
+			return false, "", err
+		}
+		if exitCode != 0 {
+			return false, "", nil
+		}
+		return true, strings.TrimSpace(output), nil
+	}
+	return false, "", errors.New("neither rpm nor dpkg is found")
+}
+
+func installPackage(name string, version string) error {
+	var exitCode int
+	var output string
+	var err error
+	if util.HasCmdInLinux("apt-get") {
+		target := name
+		if version != "" {
+			target = name + "=" + version
+		}
+		exitCode, output, err = runCommand("", 0, "apt-get", "install", "-y", "--allow-downgrades", target)
+	} else if util.HasCmdInLinux("yum") {
+		target := name
+		if version != "" {
+			target = name + "-" + version
+		}
+		exitCode, output, err = runCommand("", 0, "yum", "install", "-y", target)
+		if err == nil && exitCode == 0 && version != "" {
+			// yum install does nothing when another version is installed
+			if installed, current, _ := packageVersion(name); installed && !versionMatches(current, version) {
+				exitCode, output, err = runCommand("", 0, "yum", "downgrade", "-y", target)
+			}
+		}
+	} else {
+		return errors.New("neither apt-get nor yum is found")
+	}
+	return commandError(exitCode, output, err)
+}
+
+func removePackage(name string) error {
+	var exitCode int
+	var output string
+	var err error
+	if util.HasCmdInLinux("apt-get") {
+		exitCode, output, err = runCommand("", 0, "apt-get", "remove", "-y", name)
+	} else if util.HasCmdInLinux("yum") {
+		exitCode, output, err = runCommand("", 0, "yum", "remove", "-y", name)
+	} else {
+		return errors.New("neither apt-get nor yum is found")
+	}
+	return commandError(exitCode, output, err)
+}
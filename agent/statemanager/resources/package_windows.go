@@ -0,0 +1,19 @@
+package resources
+
+import (
+	"errors"
+)
+
+var errPackageNotSupported = errors.New("package state is not supported on windows")
+
+func packageVersion(name string) (installed bool, version string, err error) {
+	return false, "", errPackageNotSupported
+}
+
+func installPackage(name string, version string) error {
+	return errPackageNotSupported
+}
+
+func removePackage(name string) error {
+	return errPackageNotSupported
+}
@@ -1,5 +1,11 @@
 package resources
 
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
 const (
 	Compliant    = "Compliant"
 	NotCompliant = "NotCompliant"
@@ -11,3 +17,41 @@ type ResourceState interface {
 	Apply() (status string, extraInfo string, err error)
 	Monitor() (status string, extraInfo string, err error)
 }
+
+// Validator is implemented by resource states which can check their
+// properties without touching the instance
+type Validator interface {
+	Validate() error
+}
+
+// DryRunner is implemented by resource states which can tell what Apply would
+// change without changing anything. The returned status is Compliant if Apply
+// would change nothing, or NotCompliant with the changes in extraInfo.
+type DryRunner interface {
+	DryRun() (status string, extraInfo string, err error)
+}
+
+// CheckProperties checks that properties contain only fields known by the
+// type of rs and have values of right types
+func CheckProperties(rs ResourceState, properties map[string]interface{}) error {
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+	v := reflect.New(reflect.TypeOf(rs).Elem()).Interface()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// DiffItem describes one property of a resource which drifts from the declared state
+type DiffItem struct {
+	Expected interface{}
+	Actual   interface{}
+}
+
+// ResourceDiff is reported as extraInfo when a named resource is not compliant
+type ResourceDiff struct {
+	Name string
+	Diff map[string]DiffItem
+}
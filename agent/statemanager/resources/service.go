@@ -0,0 +1,129 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+)
+
+const (
+	ServiceRunning = "Running"
+	ServiceStopped = "Stopped"
+)
+
+// ServiceState declares state of a systemd/SysV service or a Windows service
+type ServiceState struct {
+	Name string
+	// Ensure is Running or Stopped, empty means running state is not managed
+	Ensure string
+	// Enabled specifies whether service starts on boot, nil means not managed
+	Enabled *bool
+}
+
+func (ss *ServiceState) Load(properties map[string]interface{}) (err error) {
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, ss)
+	return
+}
+
+// Apply starts/stops and enables/disables service, then checks the result with Monitor
+func (ss *ServiceState) Apply() (status string, extraInfo string, err error) {
+	if err = ss.Validate(); err != nil {
+		return Failed, "", err
+	}
+	running, enabled, static, err := serviceStatus(ss.Name)
+	if err != nil {
+		return Failed, "", err
+	}
+	if err = ss.checkStatic(static); err != nil {
+		return Failed, "", err
+	}
+	if ss.Enabled != nil && *ss.Enabled != enabled {
+		log.GetLogger().Infof("set service %s enabled to %t", ss.Name, *ss.Enabled)
+		if err = setServiceEnabled(ss.Name, *ss.Enabled); err != nil {
+			return Failed, "", fmt.Errorf("set service %s enabled to %t failed: %w", ss.Name, *ss.Enabled, err)
+		}
+	}
+	if ss.Ensure != "" && (ss.Ensure == ServiceRunning) != running {
+		log.GetLogger().Infof("set service %s to %s", ss.Name, ss.Ensure)
+		if err = setServiceRunning(ss.Name, ss.Ensure == ServiceRunning); err != nil {
+			return Failed, "", fmt.Errorf("set service %s to %s failed: %w", ss.Name, ss.Ensure, err)
+		}
+	}
+	return ss.Monitor()
+}
+
+// Monitor compares the service with the declared state
+func (ss *ServiceState) Monitor() (status string, extraInfo string, err error) {
+	if err = ss.Validate(); err != nil {
+		return Failed, "", err
+	}
+	running, enabled, static, err := serviceStatus(ss.Name)
+	if err != nil {
+		return Failed, "", err
+	}
+	if err = ss.checkStatic(static); err != nil {
+		return Failed, "", err
+	}
+	diff := make(map[string]DiffItem)
+	if ss.Ensure != "" && (ss.Ensure == ServiceRunning) != running {
+		actual := ServiceStopped
+		if running {
+			actual = ServiceRunning
+		}
+		diff["Ensure"] = DiffItem{Expected: ss.Ensure, Actual: actual}
+	}
+	if ss.Enabled != nil && *ss.Enabled != enabled {
+		diff["Enabled"] = DiffItem{Expected: *ss.Enabled, Actual: enabled}
+	}
+	return resourceDiffResult(ss.Name, diff)
+}
+
+// DryRun reports changes Apply would make to the service
+func (ss *ServiceState) DryRun() (status string, extraInfo string, err error) {
+	return ss.Monitor()
+}
+
+// checkStatic fails state managing Enabled of static service, which would
+// never be compliant
+func (ss *ServiceState) checkStatic(static bool) error {
+	if ss.Enabled != nil && static {
+		return fmt.Errorf("service %s is static and cannot be enabled or disabled, remove Enabled from the state", ss.Name)
+	}
+	return nil
+}
+
+func (ss *ServiceState) Validate() error {
+	if ss.Name == "" {
+		return errors.New("Name is required for service state")
+	}
+	if strings.ContainsAny(ss.Name, `/\*?[`) {
+		return fmt.Errorf("invalid service name %s", ss.Name)
+	}
+	if ss.Ensure != "" && ss.Ensure != ServiceRunning && ss.Ensure != ServiceStopped {
+		return fmt.Errorf("invalid Ensure %s, should be %s or %s", ss.Ensure, ServiceRunning, ServiceStopped)
+	}
+	return nil
+}
+
+// resourceDiffResult returns Compliant if diff is empty, otherwise NotCompliant
+// with diff as extraInfo
+func resourceDiffResult(name string, diff map[string]DiffItem) (status string, extraInfo string, err error) {
+	if len(diff) == 0 {
+		return Compliant, "", nil
+	}
+	data, err := json.Marshal(ResourceDiff{
+		Name: name,
+		Diff: diff,
+	})
+	if err != nil {
+		return Failed, "", err
+	}
+	return NotCompliant, string(data), nil
+}
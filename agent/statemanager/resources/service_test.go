@@ -0,0 +1,23 @@
+package resources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceValidate(t *testing.T) {
+	assert.NoError(t, (&ServiceState{Name: "sshd", Ensure: ServiceRunning}).Validate())
+	assert.Error(t, (&ServiceState{}).Validate())
+	assert.Error(t, (&ServiceState{Name: "sshd", Ensure: "Started"}).Validate())
+	for _, name := range []string{"*", "ssh?", "a[b]", "../sshd", `a\b`} {
+		assert.Error(t, (&ServiceState{Name: name}).Validate(), name)
+	}
+}
+
+func TestServiceCheckStatic(t *testing.T) {
+	enabled := false
+	assert.NoError(t, (&ServiceState{Name: "a"}).checkStatic(true))
+	assert.NoError(t, (&ServiceState{Name: "a", Enabled: &enabled}).checkStatic(false))
+	assert.Error(t, (&ServiceState{Name: "a", Enabled: &enabled}).checkStatic(true))
+}
@@ -0,0 +1,107 @@
+//go:build !windows
+// +build !windows
+
+package resources
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+// serviceStatus returns whether service is running and enabled. Static is
+// true for systemd units which have no install section, thus can be neither
+// enabled nor disabled.
+func serviceStatus(name string) (running bool, enabled bool, static bool, err error) {
+	if runtime.GOOS == "freebsd" {
+		exitCode, _, err := runCommand("", 0, "service", name, "onestatus")
+		if err != nil {
+			return false, false, false, err
+		}
+		running = exitCode == 0
+		exitCode, _, err = runCommand("", 0, "service", name, "enabled")
+		if err != nil {
+			return false, false, false, err
+		}
+		return running, exitCode == 0, false, nil
+	}
+	if util.IsSystemdLinux() {
+		exitCode, _, err := runCommand("", 0, "systemctl", "is-active", "--quiet", name)
+		if err != nil {
+			return false, false, false, err
+		}
+		running = exitCode == 0
+		_, output, err := runCommand("", 0, "systemctl", "is-enabled", name)
+		if err != nil {
+			return false, false, false, err
+		}
+		state := strings.TrimSpace(output)
+		return running, state == "enabled" || state == "enabled-runtime", state == "static", nil
+	}
+	exitCode, _, err := runCommand("", 0, "service", name, "status")
+	if err != nil {
+		return false, false, false, err
+	}
+	running = exitCode == 0
+	if util.HasCmdInLinux("chkconfig") {
+		exitCode, _, err = runCommand("", 0, "chkconfig", name)
+		return running, err == nil && exitCode == 0, false, err
+	}
+	// Debian style SysV init: enabled if a start link exists in any runlevel.
+	// Name has been validated to contain no pattern characters.
+	links, err := filepath.Glob("/etc/rc[2-5].d/S*" + name)
+	return running, err == nil && len(links) > 0, false, err
+}
+
+func setServiceRunning(name string, running bool) error {
+	action := "stop"
+	if running {
+		action = "start"
+	}
+	var exitCode int
+	var output string
+	var err error
+	if runtime.GOOS == "freebsd" {
+		exitCode, output, err = runCommand("", 0, "service", name, "one"+action)
+	} else if util.IsSystemdLinux() {
+		exitCode, output, err = runCommand("", 0, "systemctl", action, name)
+	} else {
+		exitCode, output, err = runCommand("", 0, "service", name, action)
+	}
+	return commandError(exitCode, output, err)
+}
+
+func setServiceEnabled(name string, enabled bool) error {
+	var exitCode int
+	var output string
+	var err error
+	if runtime.GOOS == "freebsd" {
+		value := "NO"
+		if enabled {
+			value = "YES"
+		}
+		exitCode, output, err = runCommand("", 0, "sysrc", fmt.Sprintf("%s_enable=%s", name, value))
+	} else if util.IsSystemdLinux() {
+		action := "disable"
+		if enabled {
+			action = "enable"
+		}
+		exitCode, output, err = runCommand("", 0, "systemctl", action, name)
+	} else if util.HasCmdInLinux("chkconfig") {
+		action := "off"
+		if enabled {
+			action = "on"
+		}
+		exitCode, output, err = runCommand("", 0, "chkconfig", name, action)
+	} else {
+		action := "disable"
+		if enabled {
+			action = "enable"
+		}
+		exitCode, output, err = runCommand("", 0, "update-rc.d", name, action)
+	}
+	return commandError(exitCode, output, err)
+}
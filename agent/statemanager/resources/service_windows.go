@@ -0,0 +1,39 @@
+package resources
+
+import (
+	"strings"
+)
+
+// serviceStatus returns whether service is running and starts automatically.
+// Start type of Windows service can always be changed, thus never static.
+func serviceStatus(name string) (running bool, enabled bool, static bool, err error) {
+	exitCode, output, err := runCommand("", 0, "sc", "query", name)
+	if err = commandError(exitCode, output, err); err != nil {
+		return false, false, false, err
+	}
+	running = strings.Contains(output, "RUNNING")
+	exitCode, output, err = runCommand("", 0, "sc", "qc", name)
+	if err = commandError(exitCode, output, err); err != nil {
+		return false, false, false, err
+	}
+	enabled = strings.Contains(output, "AUTO_START")
+	return running, enabled, false, nil
+}
+
+func setServiceRunning(name string, running bool) error {
+	action := "stop"
+	if running {
+		action = "start"
+	}
+	exitCode, output, err := runCommand("", 0, "net", action, name)
+	return commandError(exitCode, output, err)
+}
+
+func setServiceEnabled(name string, enabled bool) error {
+	startType := "demand"
+	if enabled {
+		startType = "auto"
+	}
+	exitCode, output, err := runCommand("", 0, "sc", "config", name, "start=", startType)
+	return commandError(exitCode, output, err)
+}
@@ -0,0 +1,203 @@
+package statemanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/statemanager/resources"
+)
+
+const (
+	// Stop skips all remaining states once the state fails
+	OnFailureStop = "Stop"
+	// Continue ignores the failure and goes on with all remaining states,
+	// including the ones depending on the failed state
+	OnFailureContinue = "Continue"
+	// SkipDependents skips states which depend on the failed state directly
+	// or indirectly, and goes on with other states
+	OnFailureSkipDependents = "SkipDependents"
+)
+
+// Skipped is status of a state which is not run because of failure of other states
+const Skipped = "Skipped"
+
+// StateNode is a resource state with its dependencies and failure policy
+type StateNode struct {
+	Name         string
+	ResourceType string
+	DependsOn    []string
+	OnFailure    string
+	State        resources.ResourceState
+}
+
+// StateResult is status of a single state reported in extraInfo
+type StateResult struct {
+	Name         string
+	ResourceType string
+	Status       string
+	Message      string `json:",omitempty"`
+}
+
+type namedStateDef struct {
+	StateDef
+	name      string
+	onFailure string
+}
+
+// normalizeOnFailure accepts policies like "skip-dependents" or "skipDependents"
+func normalizeOnFailure(onFailure string) (string, error) {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(onFailure))
+	switch normalized {
+	case "", "stop":
+		return OnFailureStop, nil
+	case "continue":
+		return OnFailureContinue, nil
+	case "skipdependents":
+		return OnFailureSkipDependents, nil
+	default:
+		return "", fmt.Errorf("invalid OnFailure %s, should be %s, %s or %s",
+			onFailure, OnFailureStop, OnFailureContinue, OnFailureSkipDependents)
+	}
+}
+
+// sortStateDefs validates names and dependencies of states, and sorts them
+// topologically. States without dependency between them keep the order in
+// template. A dependency cycle is reported as error.
+func sortStateDefs(states []StateDef) (sorted []namedStateDef, err error) {
+	defs := make([]namedStateDef, len(states))
+	indexes := make(map[string]int, len(states))
+	for i, state := range states {
+		name := state.Name
+		if name == "" {
+			name = fmt.Sprintf("%dth state", i)
+		}
+		if _, ok := indexes[name]; ok {
+			return nil, fmt.Errorf("duplicated state name %s", name)
+		}
+		onFailure, err := normalizeOnFailure(state.OnFailure)
+		if err != nil {
+			return nil, fmt.Errorf("state %s: %w", name, err)
+		}
+		indexes[name] = i
+		defs[i] = namedStateDef{StateDef: state, name: name, onFailure: onFailure}
+	}
+	inDegrees := make([]int, len(states))
+	dependents := make([][]int, len(states))
+	for i, def := range defs {
+		for _, dependency := range def.DependsOn {
+			j, ok := indexes[dependency]
+			if !ok {
+				return nil, fmt.Errorf("state %s depends on unknown state %s", def.name, dependency)
+			}
+			if j == i {
+				return nil, fmt.Errorf("state %s depends on itself", def.name)
+			}
+			inDegrees[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	done := make([]bool, len(states))
+	for len(sorted) < len(defs) {
+		// pick the first ready state in template order to keep sorting stable
+		next := -1
+		for i := range defs {
+			if !done[i] && inDegrees[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i, def := range defs {
+				if !done[i] {
+					cycle = append(cycle, def.name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle detected among states: %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		sorted = append(sorted, defs[next])
+		for _, dependent := range dependents[next] {
+			inDegrees[dependent]--
+		}
+	}
+	return sorted, nil
+}
+
+// runStateNodes applies or monitors sorted states according to their
+// dependencies and failure policies, returns aggregate status and per-state results
+func runStateNodes(nodes []StateNode, mode string) (status string, results []StateResult) {
+	statuses := make(map[string]string, len(nodes))
+	stopped := ""
+	for _, node := range nodes {
+		result := StateResult{
+			Name:         node.Name,
+			ResourceType: node.ResourceType,
+		}
+		if stopped != "" {
+			result.Status = Skipped
+			result.Message = fmt.Sprintf("skipped because state %s failed", stopped)
+		} else if failed := failedDependency(node, nodes, statuses); failed != "" {
+			result.Status = Skipped
+			result.Message = fmt.Sprintf("skipped because dependency %s failed", failed)
+		} else {
+			var extraInfo string
+			var err error
+			if mode == Monitor {
+				result.Status, extraInfo, err = node.State.Monitor()
+			} else {
+				result.Status, extraInfo, err = node.State.Apply()
+				if result.Status == NotCompliant {
+					// apply should not return NotCompliant
+					result.Status = Failed
+				}
+			}
+			if result.Status == "" {
+				result.Status = Failed
+			}
+			if err != nil {
+				result.Message = err.Error()
+			} else {
+				result.Message = extraInfo
+			}
+			if result.Status == Failed {
+				log.GetLogger().WithError(err).Errorf("state %s failed: %s", node.Name, extraInfo)
+				if node.OnFailure == OnFailureStop {
+					stopped = node.Name
+				}
+			}
+		}
+		statuses[node.Name] = result.Status
+		results = append(results, result)
+	}
+	status = Compliant
+	for _, result := range results {
+		if result.Status == Failed || result.Status == Skipped {
+			status = Failed
+			break
+		}
+		if result.Status == NotCompliant {
+			status = NotCompliant
+		}
+	}
+	return
+}
+
+// failedDependency returns name of a dependency of node which was skipped, or
+// which failed with a failure policy other than Continue
+func failedDependency(node StateNode, nodes []StateNode, statuses map[string]string) string {
+	for _, dependency := range node.DependsOn {
+		switch statuses[dependency] {
+		case Skipped:
+			return dependency
+		case Failed:
+			for _, n := range nodes {
+				if n.Name == dependency && n.OnFailure != OnFailureContinue {
+					return dependency
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package statemanager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResourceState struct {
+	status string
+	runs   int
+}
+
+func (f *fakeResourceState) Load(properties map[string]interface{}) (err error) {
+	return nil
+}
+
+func (f *fakeResourceState) Apply() (status string, extraInfo string, err error) {
+	f.runs++
+	if f.status == Failed {
+		return Failed, "", errors.New("fake failure")
+	}
+	return f.status, "", nil
+}
+
+func (f *fakeResourceState) Monitor() (status string, extraInfo string, err error) {
+	return f.Apply()
+}
+
+func TestSortStateDefs(t *testing.T) {
+	sorted, err := sortStateDefs([]StateDef{
+		{Name: "config", DependsOn: []string{"package"}},
+		{Name: "service", DependsOn: []string{"config", "package"}},
+		{Name: "package"},
+		{},
+	})
+	assert.Nil(t, err)
+	var names []string
+	for _, def := range sorted {
+		names = append(names, def.name)
+	}
+	assert.Equal(t, []string{"package", "config", "service", "3th state"}, names)
+
+	_, err = sortStateDefs([]StateDef{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"a"}},
+	})
+	assert.Contains(t, err.Error(), "cycle")
+
+	_, err = sortStateDefs([]StateDef{{Name: "a", DependsOn: []string{"c"}}})
+	assert.Contains(t, err.Error(), "unknown state")
+
+	_, err = sortStateDefs([]StateDef{{Name: "a"}, {Name: "a"}})
+	assert.Contains(t, err.Error(), "duplicated")
+
+	sorted, err = sortStateDefs([]StateDef{{Name: "a", OnFailure: "skip-dependents"}})
+	assert.Nil(t, err)
+	assert.Equal(t, OnFailureSkipDependents, sorted[0].onFailure)
+
+	_, err = sortStateDefs([]StateDef{{Name: "a", OnFailure: "retry"}})
+	assert.NotNil(t, err)
+}
+
+func TestRunStateNodes(t *testing.T) {
+	newNodes := func(onFailure string) ([]StateNode, []*fakeResourceState) {
+		states := []*fakeResourceState{{status: Failed}, {status: Compliant}, {status: Compliant}, {status: Compliant}}
+		return []StateNode{
+			{Name: "a", OnFailure: onFailure, State: states[0]},
+			{Name: "b", DependsOn: []string{"a"}, OnFailure: OnFailureStop, State: states[1]},
+			{Name: "c", DependsOn: []string{"b"}, OnFailure: OnFailureStop, State: states[2]},
+			{Name: "d", OnFailure: OnFailureStop, State: states[3]},
+		}, states
+	}
+
+	nodes, states := newNodes(OnFailureStop)
+	status, results := runStateNodes(nodes, Apply)
+	assert.Equal(t, Failed, status)
+	assert.Equal(t, Failed, results[0].Status)
+	assert.Equal(t, "fake failure", results[0].Message)
+	assert.Equal(t, Skipped, results[3].Status)
+	assert.Equal(t, 0, states[3].runs)
+
+	nodes, states = newNodes(OnFailureSkipDependents)
+	status, results = runStateNodes(nodes, Apply)
+	assert.Equal(t, Failed, status)
+	assert.Equal(t, Skipped, results[1].Status)
+	assert.Equal(t, Skipped, results[2].Status)
+	assert.Equal(t, Compliant, results[3].Status)
+	assert.Equal(t, 1, states[3].runs)
+
+	nodes, states = newNodes(OnFailureContinue)
+	status, results = runStateNodes(nodes, Apply)
+	assert.Equal(t, Failed, status)
+	assert.Equal(t, Compliant, results[1].Status)
+	assert.Equal(t, Compliant, results[2].Status)
+	assert.Equal(t, 1, states[1].runs)
+
+	states[0].status = NotCompliant
+	status, results = runStateNodes(nodes, Monitor)
+	assert.Equal(t, NotCompliant, status)
+	assert.Equal(t, NotCompliant, results[0].Status)
+}
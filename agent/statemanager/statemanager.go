@@ -81,6 +81,12 @@ func getStateConfig(stateConfigId string) (config StateConfiguration, ok bool) {
 	return
 }
 
+// RefreshStateConfigs immediately pulls state configurations from server
+// instead of waiting for the state manager timer, e.g., when requested locally
+func RefreshStateConfigs() {
+	refreshStateConfigs()
+}
+
 // refreshStateConfigs pulls state configurations from server and refresh state configuration timers
 func refreshStateConfigs() {
 	defer func() {
@@ -181,55 +187,23 @@ func enforce(config StateConfiguration) (err error) {
 			WriteTemplateCache(config.TemplateName, config.TemplateVersion, content)
 		}
 	}
-	resourceStates, err := ParseResourceState(content, config.Parameters)
+	stateNodes, err := ParseStateNodes(content, config.Parameters)
 	if err != nil {
+		log.GetLogger().WithError(err).Errorf("parse state configuration %s failed", config.StateConfigurationId)
+		msg = fmt.Sprintf("parse template %s %s failed: %s", config.TemplateName, config.TemplateVersion, err.Error())
+		reportResult(config, Failed, mode, map[string]interface{}{"message": msg})
 		return
 	}
-	if len(resourceStates) == 0 {
+	if len(stateNodes) == 0 {
 		log.GetLogger().Errorf("no state definition is parsed from configuration %s", config.StateConfigurationId)
 		return
 	}
-	var resultStatus, singleStatus string
-	var extraInfo string
-	var notSuccessItems = make(map[string]interface{})
-	switch mode {
-	case Apply:
-		for index, rs := range resourceStates {
-			resultStatus, extraInfo, err = rs.Apply()
-			if resultStatus == Failed {
-				notSuccessItems[fmt.Sprintf("%dth state", index)] = err.Error()
-				break
-			}
-			if resultStatus == NotCompliant {
-				// apply should not return NotCompliant
-				resultStatus = Failed
-				notSuccessItems[fmt.Sprintf("%dth state", index)] = extraInfo
-				break
-			}
-		}
-	case Monitor:
-		resultStatus = Compliant
-		for index, rs := range resourceStates {
-			singleStatus, extraInfo, err = rs.Monitor()
-			if singleStatus == Failed {
-				notSuccessItems[fmt.Sprintf("%dth state", index)] = err.Error()
-				resultStatus = Failed
-				break
-			}
-			if singleStatus == NotCompliant {
-				notSuccessItems[fmt.Sprintf("%dth state", index)] = extraInfo
-				resultStatus = NotCompliant
-			}
-		}
-	}
-	if resultStatus == "" {
-		resultStatus = Failed
-	}
+	resultStatus, stateResults := runStateNodes(stateNodes, mode)
 	log.GetLogger().WithFields(logrus.Fields{
 		"stateConfigurationId": config.StateConfigurationId,
 		"configureMode":        mode,
 	}).Infof("result status is %s", resultStatus)
-	reportResult(config, resultStatus, mode, notSuccessItems)
+	reportResult(config, resultStatus, mode, map[string]interface{}{"States": stateResults})
 	return
 }
 
@@ -347,13 +321,22 @@ func NewResourceState(state StateDef) (rs resources.ResourceState, err error) {
 	switch state.ResourceType {
 	case "ACS:Inventory":
 		rs = &resources.InventoryState{}
-		rs.Load(state.Properties)
 	case "ACS:File":
 		rs = &resources.FileState{}
-		rs.Load(state.Properties)
+	case "ACS:Service":
+		rs = &resources.ServiceState{}
+	case "ACS:Package":
+		rs = &resources.PackageState{}
+	case "ACS:Command":
+		rs = &resources.CommandState{}
 	default:
 		log.GetLogger().Error("unsupported resource type ", state.ResourceType)
 		err = fmt.Errorf("unsupported resource type %s in template", state.ResourceType)
+		return
+	}
+	if err = rs.Load(state.Properties); err != nil {
+		log.GetLogger().WithError(err).Errorf("load properties of %s failed", state.ResourceType)
+		return nil, fmt.Errorf("invalid properties of %s: %w", state.ResourceType, err)
 	}
 	log.GetLogger().Infof("resource state definition: %v", rs)
 	return
@@ -10,7 +10,6 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/inventory/model"
 	"github.com/aliyun/aliyun_assist_client/agent/util/timetool"
 	"github.com/stretchr/testify/assert"
-	"github.com/aliyun/aliyun_assist_client/agent/statemanager/resources"
 )
 
 func TestGetMode1(t *testing.T) {
@@ -208,11 +207,16 @@ func Test_enforce(t *testing.T) {
 					ResourceType: "ACS:Inventory",
 					Properties: make(map[string]interface{}),
 				}
-				guard_1 := monkey.Patch(ParseResourceState, func([]byte, string) ([]resources.ResourceState, error) {
-					resourceStates := []resources.ResourceState{}
+				guard_1 := monkey.Patch(ParseStateNodes, func([]byte, string) ([]StateNode, error) {
+					stateNodes := []StateNode{}
 					resourceState, _ := NewResourceState(state)
-					resourceStates = append(resourceStates, resourceState)
-					return resourceStates, nil
+					stateNodes = append(stateNodes, StateNode{
+						Name:         "0th state",
+						ResourceType: state.ResourceType,
+						OnFailure:    OnFailureStop,
+						State:        resourceState,
+					})
+					return stateNodes, nil
 				})
 				defer guard_1.Unpatch()
 				if tt.name == "normalMonitor" {
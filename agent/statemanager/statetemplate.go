@@ -18,13 +18,31 @@ type ParamDef struct {
 }
 
 type ParamProp struct {
+	// Type is one of String, Number, Boolean, List, Object and Json, value of
+	// Json parameter is not checked
 	Type    string
 	Default interface{}
+	// Required parameter must have a value from user or Default
+	Required bool
+	// AllowedValues lists all valid values of the parameter
+	AllowedValues []interface{}
+	// AllowedPattern is a regular expression which String value must match
+	AllowedPattern string
+	// MinLength and MaxLength limit length of String or List value, 0 means no limit
+	MinLength int
+	MaxLength int
 }
 
 type StateDef struct {
+	// Name identifies the state in DependsOn of other states and in report,
+	// defaults to "<index>th state"
+	Name         string
 	ResourceType string
 	Properties   map[string]interface{}
+	// DependsOn lists names of states which must succeed before this one
+	DependsOn []string
+	// OnFailure is one of Stop, Continue and SkipDependents, defaults to Stop
+	OnFailure string
 }
 
 type StateTemplate struct {
@@ -77,7 +95,9 @@ func Resolve(orig string, paramMap map[string]interface{}) (v interface{}) {
 	return ret
 }
 
-func ResolveParameterValue(params map[string]ParamProp, userParams map[string]interface{}) (result map[string]interface{}) {
+// ResolveParameterValue decides value of each parameter from user parameters
+// and defaults, and validates it against the parameter definition
+func ResolveParameterValue(params map[string]ParamProp, userParams map[string]interface{}) (result map[string]interface{}, err error) {
 	if len(params) == 0 {
 		return
 	}
@@ -88,11 +108,18 @@ func ResolveParameterValue(params map[string]ParamProp, userParams map[string]in
 			result[name] = value
 		} else if prop.Default != nil {
 			result[name] = prop.Default
+		} else if prop.Required {
+			return nil, fmt.Errorf("value not specified for required parameter %s", name)
 		} else {
-			log.GetLogger().Error("value not specified for parameter" + name)
-			result[name] = nil
+			// Placeholder of optional parameter renders as empty string
+			// rather than "<nil>"
+			log.GetLogger().Warn("value not specified for parameter " + name + ", empty string is used")
+			result[name] = ""
 			continue
 		}
+		if err = validateParameter(name, prop, result[name]); err != nil {
+			return nil, err
+		}
 	}
 	return
 }
@@ -104,26 +131,56 @@ func ParseStateTemplate(data []byte) (t StateTemplate, err error) {
 		err = fmt.Errorf("parse template fail: %w", err)
 		return
 	}
+	if _, err = sortStateDefs(t.States); err != nil {
+		err = fmt.Errorf("invalid template: %w", err)
+		return
+	}
 	return
 }
 
-func ParseResourceState(data []byte, userParameters string) (rs []resources.ResourceState, err error) {
-	template, err := ParseStateTemplate(data)
-	if err != nil {
-		return
-	}
-	var parameterValueMap = make(map[string]interface{})
+func parseUserParameters(userParameters string) (parameterValueMap map[string]interface{}, err error) {
+	parameterValueMap = make(map[string]interface{})
 	if strings.TrimSpace(userParameters) != "" {
 		err = json.Unmarshal([]byte(userParameters), &parameterValueMap)
 		if err != nil {
 			log.GetLogger().WithFields(logrus.Fields{
 				"parameters": userParameters,
 			}).WithError(err).Errorf("parameters is not a valid json")
-		return
 		}
 	}
-	paramMap := ResolveParameterValue(template.Parameters, parameterValueMap)
-	for _, state := range template.States {
+	return
+}
+
+// ParseResourceState parses template data to resource states in dependency order
+func ParseResourceState(data []byte, userParameters string) (rs []resources.ResourceState, err error) {
+	nodes, err := ParseStateNodes(data, userParameters)
+	for _, node := range nodes {
+		rs = append(rs, node.State)
+	}
+	return
+}
+
+// ParseStateNodes parses template data to resource states with their
+// dependencies and failure policies, sorted in dependency order
+func ParseStateNodes(data []byte, userParameters string) (nodes []StateNode, err error) {
+	template, err := ParseStateTemplate(data)
+	if err != nil {
+		return
+	}
+	parameterValueMap, err := parseUserParameters(userParameters)
+	if err != nil {
+		return
+	}
+	paramMap, err := ResolveParameterValue(template.Parameters, parameterValueMap)
+	if err != nil {
+		return
+	}
+	sorted, err := sortStateDefs(template.States)
+	if err != nil {
+		return
+	}
+	for _, def := range sorted {
+		state := def.StateDef
 		for k, v := range state.Properties {
 			orig, ok := v.(string)
 			if ok {
@@ -132,10 +189,16 @@ func ParseResourceState(data []byte, userParameters string) (rs []resources.Reso
 			}
 		}
 		resourceState, err2 := NewResourceState(state)
-		if err != nil {
-			return rs, err2
+		if err2 != nil {
+			return nodes, err2
 		}
-		rs = append(rs, resourceState)
+		nodes = append(nodes, StateNode{
+			Name:         def.name,
+			ResourceType: state.ResourceType,
+			DependsOn:    state.DependsOn,
+			OnFailure:    def.onFailure,
+			State:        resourceState,
+		})
 	}
 	return
 }
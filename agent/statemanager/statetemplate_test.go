@@ -35,10 +35,12 @@ func TestResolveParameterValue(t *testing.T) {
 		"regionId": "cn-zhangjiakou",
 		"timeout":  3600,
 	}
-	params := ResolveParameterValue(paramDefs, userParams)
+	params, err := ResolveParameterValue(paramDefs, userParams)
+	assert.Nil(t, err)
 	assert.Equal(t, "cn-zhangjiakou", params["regionId"])
 	assert.Equal(t, 3600, params["timeout"])
-	assert.Nil(t, params["option"])
+	assert.Equal(t, "", params["option"])
+	assert.Equal(t, "option: ", Resolve("option: {{ option }}", params))
 }
 
 func TestResolveJsonParameterValue(t *testing.T) {
@@ -58,11 +60,13 @@ func TestResolveJsonParameterValue(t *testing.T) {
 		},
 	}
 
-	params := ResolveParameterValue(paramDefs, userParams)
+	params, err := ResolveParameterValue(paramDefs, userParams)
+	assert.Nil(t, err)
 	finalValue := params["settings"].(map[string]interface{})
 	assert.Equal(t, "uservalue", finalValue["userkey"])
 
-	params = ResolveParameterValue(paramDefs, map[string]interface{}{})
+	params, err = ResolveParameterValue(paramDefs, map[string]interface{}{})
+	assert.Nil(t, err)
 	finalValue = params["settings"].(map[string]interface{})
 	innerValue := finalValue["key"]
 	innerJson := innerValue.(map[string]interface{})
@@ -178,3 +182,86 @@ func TestInventoryTemplate(t *testing.T) {
 	assert.Equal(t, "Enabled", inventoryPolicy["ACS:File"].Collection)
 	assert.Equal(t, "[{\"Path\": \"/home/admin/test\",\"Pattern\":[\"*\"],\"Recursive\":false}]", inventoryPolicy["ACS:File"].Filters)
 }
+
+var serviceTemplate string = `{
+		"FormatVersion": "OOS-2019-06-01-State",
+		"Parameters": {
+		  "enabled": {
+			"Type": "Boolean",
+			"Default": true
+		  }
+		},
+		"States": [
+		  {
+			"ResourceType": "ACS:Service",
+			"Properties": {
+			  "Name": "nginx",
+			  "Ensure": "Running",
+			  "Enabled": "{{ enabled }}"
+			}
+		  },
+		  {
+			"ResourceType": "ACS:Package",
+			"Properties": {
+			  "Name": "nginx",
+			  "Version": "1.20.1"
+			}
+		  },
+		  {
+			"ResourceType": "ACS:Command",
+			"Properties": {
+			  "Command": "echo ok > /tmp/ok",
+			  "CheckCommand": "test -f /tmp/ok",
+			  "Timeout": 30
+			}
+		  }
+		]
+	}`
+
+func TestParseServicePackageCommandState(t *testing.T) {
+	rs, err := ParseResourceState([]byte(serviceTemplate), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rs))
+	service := rs[0].(*resources.ServiceState)
+	assert.Equal(t, "nginx", service.Name)
+	assert.Equal(t, "Running", service.Ensure)
+	assert.True(t, *service.Enabled)
+	pkg := rs[1].(*resources.PackageState)
+	assert.Equal(t, "1.20.1", pkg.Version)
+	command := rs[2].(*resources.CommandState)
+	assert.Equal(t, "test -f /tmp/ok", command.CheckCommand)
+	assert.Equal(t, 30, command.Timeout)
+
+	_, err = ParseResourceState([]byte(`{"States":[{"ResourceType":"ACS:Service","Properties":{"Enabled":"yes"}}]}`), "")
+	assert.NotNil(t, err)
+}
+
+func TestParseStateNodes(t *testing.T) {
+	content := `{
+		"States": [
+		  {
+			"Name": "command",
+			"ResourceType": "ACS:Command",
+			"DependsOn": ["package"],
+			"OnFailure": "continue",
+			"Properties": {"Command": "echo", "CheckCommand": "true"}
+		  },
+		  {
+			"Name": "package",
+			"ResourceType": "ACS:Package",
+			"Properties": {"Name": "nginx"}
+		  }
+		]
+	}`
+	nodes, err := ParseStateNodes([]byte(content), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, "package", nodes[0].Name)
+	assert.Equal(t, OnFailureStop, nodes[0].OnFailure)
+	assert.Equal(t, "command", nodes[1].Name)
+	assert.Equal(t, OnFailureContinue, nodes[1].OnFailure)
+	assert.Equal(t, []string{"package"}, nodes[1].DependsOn)
+
+	_, err = ParseStateTemplate([]byte(`{"States": [{"Name": "a", "DependsOn": ["a"]}]}`))
+	assert.NotNil(t, err)
+}
@@ -35,12 +35,7 @@ func reportInvalidTask(taskId string, param string, value string) (string, error
 
 func sendStoppedOutput(taskId string, start int64, end int64, exitcode int,
 	dropped int, output string, reason string) (string, error) {
-	path := util.GetStoppedOutputService()
-	// luban/api/v1/task/stopped API requires extra result=killed parameter in
-	// querystring
-	querystring := fmt.Sprintf("?taskId=%s&start=%d&end=%d&exitcode=%d&dropped=%d&result=%s",
-		taskId, start, end, exitcode, dropped, reason)
-	url := path + querystring
+	url := stoppedOutputURL(taskId, start, end, exitcode, dropped, reason)
 
 	var response string
 	var err error
@@ -52,3 +47,13 @@ func sendStoppedOutput(taskId string, start int64, end int64, exitcode int,
 
 	return response, err
 }
+
+func stoppedOutputURL(taskId string, start int64, end int64, exitcode int,
+	dropped int, reason string) string {
+	path := util.GetStoppedOutputService()
+	// luban/api/v1/task/stopped API requires extra result=killed parameter in
+	// querystring
+	querystring := fmt.Sprintf("?taskId=%s&start=%d&end=%d&exitcode=%d&dropped=%d&result=%s",
+		taskId, start, end, exitcode, dropped, reason)
+	return path + querystring
+}
@@ -17,9 +17,10 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/aliyun/aliyun_assist_client/agent/log"
-	"github.com/aliyun/aliyun_assist_client/agent/taskengine/cri"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/host"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/models"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/parameters"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
@@ -51,23 +52,22 @@ type Task struct {
 	cancelMut               sync.Mutex
 	output                  bytes.Buffer
 	data_sended             uint32
+	journal                 *taskJournal
+}
+
+// processIdGetter is implemented by processors running command as local
+// process, whose id is recorded in task journal
+type processIdGetter interface {
+	Pid() int
 }
 
 func NewTask(taskInfo models.RunTaskInfo, scheduleLocation *time.Location, onFinish FinishCallback) *Task {
-	timeout, err := strconv.Atoi(taskInfo.TimeOut)
-	if err != nil {
-		timeout = 3600
-	}
+	timeout := parseTimeout(taskInfo.TimeOut)
 
 	var processor TaskProcessor
 	if taskInfo.ContainerId != "" || taskInfo.ContainerName != "" {
-		processor = &cri.CRIProcessor{
-			TaskId: taskInfo.TaskId,
-			ContainerIdentifier: taskInfo.ContainerId,
-			ContainerName: taskInfo.ContainerName,
-			CommandType: taskInfo.CommandType,
-			Timeout: timeout,
-		}
+		processor = newContainerProcessor(taskInfo.TaskId, taskInfo.ContainerId,
+			taskInfo.ContainerName, taskInfo.CommandType, timeout)
 	} else {
 		processor = &host.HostProcessor{
 			TaskId: taskInfo.TaskId,
@@ -158,7 +158,17 @@ func (task *Task) PreCheck(reportVerified bool) error {
 	return nil
 }
 
+// Run runs the task. Errors are returned only when the task fails before its
+// command process started, and such task is counted as failed as well.
 func (task *Task) Run() (taskerrors.ErrorCode, error) {
+	errCode, err := task.run()
+	if err != nil {
+		openmetrics.TasksFinished.Inc(task.taskInfo.CommandType, openmetrics.TaskStateFailed)
+	}
+	return errCode, err
+}
+
+func (task *Task) run() (taskerrors.ErrorCode, error) {
 	if err := task.PreCheck(false); err != nil {
 		return 0, err
 	}
@@ -235,8 +245,15 @@ func (task *Task) Run() (taskerrors.ErrorCode, error) {
 
 	task.startTime = time.Now()
 	task.monotonicStartTimestamp = timetool.ToAccurateTime(task.startTime.Local())
+	if task.taskInfo.Cronat == "" {
+		task.cancelMut.Lock()
+		task.journal = startTaskJournal(task.taskInfo.TaskId, task.startTime,
+			task.monotonicStartTimestamp, parseTimeout(task.taskInfo.TimeOut))
+		task.cancelMut.Unlock()
+	}
 	task.sendTaskStart()
 	taskLogger.Infof("Sent starting event")
+	openmetrics.TasksStarted.Inc(task.taskInfo.CommandType)
 
 	// Replace variable representing states with context and channel operation,
 	// to replace dangerous state tranfering operation with straightforward
@@ -270,6 +287,7 @@ func (task *Task) Run() (taskerrors.ErrorCode, error) {
 				task.sendRunningOutput(running_output.String())
 				atomic.AddUint32(&task.data_sended, uint32(running_output.Len()))
 				taskLogger.Infof("Running output sent: %d bytes", atomic.LoadUint32(&task.data_sended))
+				task.journal.updateProgress(task.processPid(), atomic.LoadUint32(&task.data_sended))
 			case <-ctx.Done():
 				return
 			}
@@ -324,6 +342,7 @@ func (task *Task) Run() (taskerrors.ErrorCode, error) {
 			task.sendOutput("finished", task.getReportString(task.output))
 		}
 	}
+	openmetrics.TasksFinished.Inc(task.taskInfo.CommandType, finalStateOf(status, task.IsCancled()))
 	endTaskLogger := log.GetLogger().WithFields(logrus.Fields{
 		"TaskId": task.taskInfo.TaskId,
 		"Phase":  "Ending",
@@ -345,6 +364,20 @@ func (task *Task) Run() (taskerrors.ErrorCode, error) {
 	return 0, nil
 }
 
+// finalStateOf classifies ended command process for metrics
+func finalStateOf(status int, canceled bool) string {
+	switch {
+	case status == process.Fail:
+		return openmetrics.TaskStateFailed
+	case status == process.Timeout:
+		return openmetrics.TaskStateTimeout
+	case canceled:
+		return openmetrics.TaskStateCanceled
+	default:
+		return openmetrics.TaskStateFinished
+	}
+}
+
 func (task *Task) sendTaskVerified() {
 	queryParams := fmt.Sprintf("?taskId=%s", task.taskInfo.TaskId)
 	url := util.GetVerifiedTaskService() + queryParams
@@ -381,9 +414,9 @@ func (task *Task) sendOutput(status string, output string) {
 	} else if status == "timeout" {
 		url = util.GetTimeoutOutputService()
 	} else if status == "canceled" {
-		sendStoppedOutput(task.taskInfo.TaskId, task.monotonicStartTimestamp,
-			task.monotonicEndTimestamp, task.exit_code, task.droped, output,
-			stopReasonKilled)
+		task.deliverFinalResult(status, stoppedOutputURL(task.taskInfo.TaskId,
+			task.monotonicStartTimestamp, task.monotonicEndTimestamp,
+			task.exit_code, task.droped, stopReasonKilled), output)
 		return
 	} else if status == "failed" {
 		url = util.GetErrorOutputService()
@@ -396,13 +429,7 @@ func (task *Task) sendOutput(status string, output string) {
 	url += task.wallClockQueryParams()
 	url += task.processer.ExtraLubanParams()
 
-	var err error
-	_, err = util.HttpPost(url, output, "text")
-
-	for i := 0; i < 3 && err != nil; i++ {
-		time.Sleep(time.Duration(2) * time.Second)
-		_, err = util.HttpPost(url, output, "text")
-	}
+	task.deliverFinalResult(status, url, output)
 
 	if task.onFinish != nil {
 		task.onFinish()
@@ -427,10 +454,33 @@ func (task *Task) SendError(output string, errCode fmt.Stringer, errDesc string)
 		}
 	}
 
-	_, err := util.HttpPost(requestURL, output, "text")
+	task.deliverFinalResult("failed", requestURL, output)
+}
+
+// deliverFinalResult sends final result of task. Results of non-periodic
+// tasks are persisted in outbox and retried in background until delivered.
+// Periodic tasks reuse the same task id for each invocation, thus their
+// results are simply retried several times.
+func (task *Task) deliverFinalResult(state string, reportURL string, output string) {
+	task.journal.recordFinalReport(state, reportURL, output)
+	if task.taskInfo.Cronat == "" {
+		err := outbox.Enqueue(task.taskInfo.TaskId, reportURL, output)
+		if err == nil {
+			// Result in outbox would survive agent restart as well
+			task.journal.acknowledge()
+			outbox.Deliver(task.taskInfo.TaskId)
+			return
+		}
+		log.GetLogger().WithField("TaskId", task.taskInfo.TaskId).WithError(err).Errorln("Failed to put final result into outbox")
+	}
+
+	_, err := util.HttpPost(reportURL, output, "text")
 	for i := 0; i < 3 && err != nil; i++ {
 		time.Sleep(time.Duration(2) * time.Second)
-		_, err = util.HttpPost(requestURL, output, "text")
+		_, err = util.HttpPost(reportURL, output, "text")
+	}
+	if err == nil {
+		task.journal.acknowledge()
 	}
 }
 
@@ -485,6 +535,21 @@ func (task *Task) sendRunningOutput(data string) {
 	util.HttpPost(url, data, "text")
 }
 
+func (task *Task) processPid() int {
+	if getter, ok := task.processer.(processIdGetter); ok {
+		return getter.Pid()
+	}
+	return 0
+}
+
+func parseTimeout(timeOut string) int {
+	timeout, err := strconv.Atoi(timeOut)
+	if err != nil {
+		timeout = 3600
+	}
+	return timeout
+}
+
 func (task *Task) IsCancled() bool {
 	task.cancelMut.Lock()
 	defer task.cancelMut.Unlock()
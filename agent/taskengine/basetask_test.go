@@ -1,6 +1,7 @@
 package taskengine
 
 import (
+	"bytes"
 	"encoding/base64"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
@@ -9,6 +10,10 @@ import (
 	"strconv"
 	"testing"
 	"time"
+
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/openmetrics"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/models"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
 )
 
 func addMockServer() {
@@ -52,7 +57,7 @@ func TestRunTask(t *testing.T) {
 	rand_num := rand.Intn(10000000)
 	rand_str := strconv.Itoa(rand_num)
 
-	info := RunTaskInfo{
+	info := models.RunTaskInfo{
 		InstanceId:  "i-test",
 		CommandType: commandType,
 		TaskId:  "t-test" + rand_str,
@@ -61,7 +66,7 @@ func TestRunTask(t *testing.T) {
 		WorkingDir:workingDir,
 		Content:content,
 	}
-	task := NewTask(info)
+	task := NewTask(info, nil, nil)
 
 	errcode, err := task.Run()
 
@@ -75,4 +80,25 @@ func TestRunTask(t *testing.T) {
 
 	assert.Equal(t, nil , err)
 	assert.Equal(t, 0 , int(errcode))
-}
\ No newline at end of file
+}
+
+func TestRunFailedBeforeStarted(t *testing.T) {
+	mockMetrics()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterNoResponder(httpmock.NewStringResponder(200, ""))
+
+	task := NewTask(models.RunTaskInfo{
+		TaskId:      "t-test-invalid",
+		CommandType: "RunInvalidScript",
+		TimeOut:     "120",
+	}, nil, nil)
+	_, err := task.Run()
+	assert.Error(t, err)
+
+	// Task never started is counted as failed
+	var buffer bytes.Buffer
+	assert.NoError(t, openmetrics.DefaultRegistry().Write(&buffer, false))
+	assert.Contains(t, buffer.String(), `aliyun_assist_tasks_finished_total{type="RunInvalidScript",state="failed"} 1`)
+	assert.NotContains(t, buffer.String(), `aliyun_assist_tasks_started_total{type="RunInvalidScript"}`)
+}
@@ -0,0 +1,93 @@
+// Package containercmd compiles commands executed inside containers, which
+// record their process id thus can be killed from outside.
+package containercmd
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// Process id of command is recorded in the file under pidDir of Linux
+	// container, or temporary directory of the user in Windows container,
+	// thus it can be killed when task is canceled or timeout
+	pidFileNameFormat = "aliyun_assist_task_%s.pid"
+	// pidDir is only accessible by the user running commands, thus pid file
+	// at predictable path could not be replaced by other users of container
+	pidDir = "/tmp/.aliyun_assist"
+	// pidDirCheck creates pidDir and fails if it is not a directory owned by
+	// current user
+	pidDirCheck = `d=` + pidDir + `; (umask 077; mkdir -p "$d") && [ ! -L "$d" ] && [ -O "$d" ] && chmod 700 "$d" || { echo "insecure directory $d" >&2; exit 1; }`
+
+	// KillTimeout limits execution of KillCommand and RemovePidFileCommand
+	KillTimeout = 10 * time.Second
+)
+
+// PidFileName returns name of file recording process id of the task
+func PidFileName(taskId string) string {
+	return fmt.Sprintf(pidFileNameFormat, taskId)
+}
+
+// CompileCommand chooses interpreter inside container according to command
+// type. PowerShell and batch scripts are for Windows containers.
+func CompileCommand(commandType string, commandContent string, pidFileName string) []string {
+	switch commandType {
+	case "RunPowerShellScript":
+		script := fmt.Sprintf("Set-Content -Path (Join-Path $env:TEMP '%s') -Value $PID\n%s", pidFileName, commandContent)
+		return powershellCommand(script)
+	case "RunBatScript":
+		// cmd.exe can neither tell its own process id nor read script from
+		// argument safely, so the script is written to a temporary file and
+		// run by a PowerShell wrapper whose process tree would be killed
+		script := fmt.Sprintf(`Set-Content -Path (Join-Path $env:TEMP '%s') -Value $PID
+$batFile = Join-Path $env:TEMP ('aliyun_assist_' + $PID + '.bat')
+[IO.File]::WriteAllBytes($batFile, [Convert]::FromBase64String('%s'))
+& cmd.exe /c $batFile
+$exitCode = $LASTEXITCODE
+Remove-Item -Force $batFile
+exit $exitCode`, pidFileName, base64.StdEncoding.EncodeToString([]byte(commandContent)))
+		return powershellCommand(script)
+	default:
+		// The shell replaces itself with the one running command, thus
+		// recorded process id is exactly the one of command
+		script := fmt.Sprintf(`%s; echo $$ > "$d/%s"; exec /bin/sh -c "$1"`, pidDirCheck, pidFileName)
+		return []string{"/bin/sh", "-c", script, "sh", commandContent}
+	}
+}
+
+// KillCommand kills the process recorded in pid file and its children
+func KillCommand(commandType string, pidFileName string) []string {
+	switch commandType {
+	case "RunPowerShellScript", "RunBatScript":
+		script := fmt.Sprintf("$pidFile = Join-Path $env:TEMP '%s'\n& taskkill.exe /F /T /PID (Get-Content $pidFile)", pidFileName)
+		return powershellCommand(script)
+	default:
+		script := fmt.Sprintf(`%s; pid=$(cat "$d/%s") || exit 1; pkill -KILL -P "$pid"; kill -KILL "$pid"`, pidDirCheck, pidFileName)
+		return []string{"/bin/sh", "-c", script}
+	}
+}
+
+// RemovePidFileCommand removes pid file after the task finished
+func RemovePidFileCommand(commandType string, pidFileName string) []string {
+	switch commandType {
+	case "RunPowerShellScript", "RunBatScript":
+		script := fmt.Sprintf("Remove-Item -Force -ErrorAction SilentlyContinue (Join-Path $env:TEMP '%s')", pidFileName)
+		return powershellCommand(script)
+	default:
+		return []string{"rm", "-f", pidDir + "/" + pidFileName}
+	}
+}
+
+// powershellCommand passes script via -EncodedCommand to avoid quoting
+// problems, which requires base64-encoded UTF-16LE string
+func powershellCommand(script string) []string {
+	codes := utf16.Encode([]rune(script))
+	buf := make([]byte, len(codes)*2)
+	for i, code := range codes {
+		binary.LittleEndian.PutUint16(buf[i*2:], code)
+	}
+	return []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-EncodedCommand", base64.StdEncoding.EncodeToString(buf)}
+}
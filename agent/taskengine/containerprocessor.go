@@ -0,0 +1,85 @@
+package taskengine
+
+import (
+	"strings"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/cri"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/docker"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+)
+
+// containerProcessor runs command in container via CRI runtime service, and
+// falls back to docker when the container can not be found via CRI, e.g., on
+// hosts running standalone dockerd without CRI socket.
+type containerProcessor struct {
+	TaskProcessor
+
+	dockerProcessor TaskProcessor
+}
+
+func newContainerProcessor(taskId string, containerId string, containerName string, commandType string, timeout int) TaskProcessor {
+	dockerProcessor := &docker.DockerProcessor{
+		TaskId: taskId,
+		ContainerIdentifier: containerId,
+		ContainerName: containerName,
+		CommandType: commandType,
+		Timeout: timeout,
+	}
+	if strings.HasPrefix(containerId, docker.IdentifierPrefix) {
+		return dockerProcessor
+	}
+
+	criProcessor := &cri.CRIProcessor{
+		TaskId: taskId,
+		ContainerIdentifier: containerId,
+		ContainerName: containerName,
+		CommandType: commandType,
+		Timeout: timeout,
+	}
+	// Container identifier in format <runtime>://<container-id> specifies
+	// the CRI runtime explicitly, no need to fall back
+	if strings.Contains(containerId, "://") {
+		return criProcessor
+	}
+	return &containerProcessor{
+		TaskProcessor: criProcessor,
+		dockerProcessor: dockerProcessor,
+	}
+}
+
+func (p *containerProcessor) PreCheck() (string, error) {
+	if invalidParameter, err := p.TaskProcessor.PreCheck(); err != nil {
+		return invalidParameter, err
+	}
+	return p.dockerProcessor.PreCheck()
+}
+
+func (p *containerProcessor) Prepare(commandContent string) error {
+	criErr := p.TaskProcessor.Prepare(commandContent)
+	if !isContainerUnreachable(criErr) {
+		return criErr
+	}
+
+	dockerErr := p.dockerProcessor.Prepare(commandContent)
+	if isContainerUnreachable(dockerErr) {
+		// Container is found in neither CRI runtime nor docker. Report
+		// ContainerNotFound rather than ContainerConnectFailed if any of
+		// them is available.
+		if validationErr, ok := criErr.(taskerrors.NormalizedValidationError); ok && validationErr.Param() == "ContainerConnectFailed" {
+			return dockerErr
+		}
+		return criErr
+	}
+	log.GetLogger().WithError(criErr).Info("Container not found via CRI runtime service, run command via docker instead")
+	p.TaskProcessor = p.dockerProcessor
+	return dockerErr
+}
+
+func isContainerUnreachable(err error) bool {
+	validationErr, ok := err.(taskerrors.NormalizedValidationError)
+	if !ok {
+		return false
+	}
+	return validationErr.Param() == "ContainerConnectFailed" || validationErr.Param() == "ContainerNotFound"
+}
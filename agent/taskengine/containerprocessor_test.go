@@ -0,0 +1,80 @@
+package taskengine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/cri"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/docker"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+)
+
+// fakeContainerRuntime stands for processor of either CRI runtime or docker
+type fakeContainerRuntime struct {
+	TaskProcessor
+	prepareErr error
+	prepared   bool
+}
+
+func (f *fakeContainerRuntime) Prepare(commandContent string) error {
+	f.prepared = true
+	return f.prepareErr
+}
+
+func TestNewContainerProcessor(t *testing.T) {
+	_, ok := newContainerProcessor("t-1", "docker://abc", "", "RunShellScript", 60).(*docker.DockerProcessor)
+	assert.True(t, ok)
+	_, ok = newContainerProcessor("t-1", "containerd://abc", "", "RunShellScript", 60).(*cri.CRIProcessor)
+	assert.True(t, ok)
+	_, ok = newContainerProcessor("t-1", "abc", "", "RunShellScript", 60).(*containerProcessor)
+	assert.True(t, ok)
+}
+
+func TestContainerProcessorFallback(t *testing.T) {
+	notFound := taskerrors.NewContainerNotFoundError()
+	connectFailed := taskerrors.NewContainerConnectError(errors.New("no such socket"))
+	stateAbnormal := taskerrors.NewContainerStateAbnormalError("exited")
+
+	tests := []struct {
+		name      string
+		criErr    error
+		dockerErr error
+		// useDocker tells whether command runs via docker after preparing
+		useDocker bool
+		wantErr   error
+	}{
+		{"found via CRI", nil, nil, false, nil},
+		{"not found via CRI", notFound, nil, true, nil},
+		{"no CRI runtime", connectFailed, nil, true, nil},
+		{"abnormal in CRI", stateAbnormal, nil, false, stateAbnormal},
+		{"abnormal in docker", notFound, stateAbnormal, true, stateAbnormal},
+		// Neither runtime has the container, not found is preferred
+		{"not found anywhere", notFound, notFound, false, notFound},
+		{"not found via CRI without docker", notFound, connectFailed, false, notFound},
+		{"not found in docker without CRI", connectFailed, notFound, false, notFound},
+		{"no runtime available", connectFailed, connectFailed, false, connectFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criRuntime := &fakeContainerRuntime{prepareErr: tt.criErr}
+			dockerRuntime := &fakeContainerRuntime{prepareErr: tt.dockerErr}
+			p := &containerProcessor{
+				TaskProcessor:   criRuntime,
+				dockerProcessor: dockerRuntime,
+			}
+
+			err := p.Prepare("echo hello")
+			assert.Equal(t, tt.wantErr, err)
+			assert.True(t, criRuntime.prepared)
+			// Docker is only tried when container is unreachable via CRI
+			assert.Equal(t, isContainerUnreachable(tt.criErr), dockerRuntime.prepared)
+			if tt.useDocker {
+				assert.Equal(t, dockerRuntime, p.TaskProcessor)
+			} else {
+				assert.Equal(t, criRuntime, p.TaskProcessor)
+			}
+		})
+	}
+}
@@ -1,16 +1,20 @@
 package cri
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
-	"k8s.io/kubernetes/pkg/probe/exec"
-	utilexec "k8s.io/utils/exec"
+	"github.com/sirupsen/logrus"
+	runtimeapis "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	libcri "github.com/aliyun/aliyun_assist_client/agent/container/cri"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/containercmd"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
 	"github.com/aliyun/aliyun_assist_client/agent/util/process"
 )
@@ -30,6 +34,11 @@ type CRIProcessor struct {
 
 	// Connection to container runtime service
 	connection *containerConnection
+
+	// Cancellation of running command
+	lock sync.Mutex
+	canceled bool
+	cancelRun context.CancelFunc
 }
 
 func (p *CRIProcessor) PreCheck() (string, error) {
@@ -72,35 +81,87 @@ func (p *CRIProcessor) SyncRun(
 		stdoutWriter io.Writer,
 		stderrWriter io.Writer,
 		stdinReader  io.Reader)  (exitCode int, status int, err error) {
-	compiledCommand := []string{"/bin/sh", "-c", p.CommandContent}
-	timeout := time.Duration(p.Timeout) * time.Second
-	stdout, stderr, err := p.connection.runtimeService.ExecSync(p.connection.containerId, compiledCommand, timeout)
-
-	stdoutWriter.Write(stdout)
-	stderrWriter.Write(stderr)
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if p.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.Timeout) * time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	p.lock.Lock()
+	if p.canceled {
+		p.lock.Unlock()
+		return 1, process.Success, nil
+	}
+	p.cancelRun = cancel
+	p.lock.Unlock()
+
+	response, err := p.connection.runtimeService.Exec(&runtimeapis.ExecRequest{
+		ContainerId: p.connection.containerId,
+		Cmd: containercmd.CompileCommand(p.CommandType, p.CommandContent, containercmd.PidFileName(p.TaskId)),
+		Stdin: stdinReader != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return 1, process.Fail, taskerrors.NewContainerRuntimeInternalError(err)
+	}
 
+	exitCode, err = streamExec(ctx, response.Url, stdinReader, stdoutWriter, stderrWriter)
 	if err != nil {
-		// I know, I know error-handling code below deeps into the CRI client
-		// implementation of Kubernetes too much
-		var exitcodeErr *utilexec.CodeExitError
-		var timeoutErr *exec.TimeoutError
-		if errors.As(err, &exitcodeErr) {
-			return exitcodeErr.Code, process.Success, nil
-		} else if errors.As(err, &timeoutErr) {
+		p.lock.Lock()
+		canceled := p.canceled
+		p.lock.Unlock()
+		if canceled {
+			// Final state of canceled task has been reported by Task.Cancel
+			return 1, process.Success, nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			p.killRemoteProcess()
 			return 1, process.Timeout, err
-		} else {
-			return 1, process.Fail, taskerrors.NewContainerRuntimeInternalError(err)
 		}
+		return 1, process.Fail, taskerrors.NewContainerRuntimeInternalError(err)
 	}
-	return 0, process.Success, nil
+	return exitCode, process.Success, nil
 }
 
 func (p *CRIProcessor) Cancel() {
-	return
+	p.lock.Lock()
+	p.canceled = true
+	cancelRun := p.cancelRun
+	p.lock.Unlock()
+
+	if cancelRun == nil {
+		return
+	}
+	p.killRemoteProcess()
+	cancelRun()
 }
 
 func (p *CRIProcessor) Cleanup(removeScriptFile bool) error {
-	return nil
+	if p.connection == nil {
+		return nil
+	}
+	_, _, err := p.connection.runtimeService.ExecSync(p.connection.containerId,
+		containercmd.RemovePidFileCommand(p.CommandType, containercmd.PidFileName(p.TaskId)), containercmd.KillTimeout)
+	return err
+}
+
+// killRemoteProcess kills the process tree of the command inside container,
+// since closing the stream does not terminate it
+func (p *CRIProcessor) killRemoteProcess() {
+	taskLogger := log.GetLogger().WithFields(logrus.Fields{
+		"TaskId": p.TaskId,
+		"Phase":  "CRIProcessor-Kill",
+	})
+	stdout, stderr, err := p.connection.runtimeService.ExecSync(p.connection.containerId,
+		containercmd.KillCommand(p.CommandType, containercmd.PidFileName(p.TaskId)), containercmd.KillTimeout)
+	if err != nil {
+		taskLogger.WithError(err).Warningf("Failed to kill command process in container: stdout=%s stderr=%s", stdout, stderr)
+		return
+	}
+	taskLogger.Info("Killed command process in container")
 }
 
 func (p *CRIProcessor) SideEffect() error {
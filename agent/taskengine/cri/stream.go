@@ -0,0 +1,213 @@
+package cri
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+)
+
+// Channel numbers and subprotocol of the streaming server of CRI runtimes,
+// see k8s.io/kubernetes/pkg/kubelet/cri/streaming/remotecommand
+const (
+	stdinChannel  = 0
+	stdoutChannel = 1
+	stderrChannel = 2
+	errorChannel  = 3
+	// closeChannel is only supported by v5 protocol, whose message closes the
+	// channel given by its second byte, e.g., EOF of stdin
+	closeChannel = 255
+
+	v4BinaryWebsocketProtocol = "v4.channel.k8s.io"
+	v5BinaryWebsocketProtocol = "v5.channel.k8s.io"
+
+	streamHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	errExecStatusMissing = errors.New("stream closed without exit status")
+	errStdinNotClosable  = errors.New("streaming server does not support closing stdin, which requires " + v5BinaryWebsocketProtocol)
+)
+
+// execStatus is the subset of metav1.Status sent on error channel when the
+// command exits
+type execStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Details *struct {
+		Causes []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"causes"`
+	} `json:"details"`
+}
+
+// exitCode extracts exit code from status, see v4WriteStatusFunc in
+// k8s.io/kubernetes/pkg/kubelet/cri/streaming/remotecommand
+func (s *execStatus) exitCode() (int, error) {
+	if s.Status == "Success" {
+		return 0, nil
+	}
+	if s.Reason == "NonZeroExitCode" && s.Details != nil {
+		for _, cause := range s.Details.Causes {
+			if cause.Reason == "ExitCode" {
+				code, err := strconv.Atoi(cause.Message)
+				if err != nil {
+					return 1, fmt.Errorf("invalid exit code %s: %w", cause.Message, err)
+				}
+				return code, nil
+			}
+		}
+	}
+	return 1, fmt.Errorf("command execution failed: %s", s.Message)
+}
+
+// streamExec connects to the streaming URL returned by Exec of CRI runtime
+// service via websocket, copies stdin to the command and its output to
+// stdoutWriter and stderrWriter as soon as received, until the command exits
+// or ctx is done.
+func streamExec(ctx context.Context, execURL string, stdinReader io.Reader, stdoutWriter io.Writer, stderrWriter io.Writer) (int, error) {
+	u, err := url.Parse(execURL)
+	if err != nil {
+		return 1, fmt.Errorf("invalid streaming url %s: %w", execURL, err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: streamHandshakeTimeout,
+		// v5 is preferred since stdin could only be closed by it
+		Subprotocols: []string{v5BinaryWebsocketProtocol, v4BinaryWebsocketProtocol},
+	}
+	if u.Scheme == "wss" {
+		if dialer.TLSClientConfig, err = streamingTLSConfig(); err != nil {
+			return 1, err
+		}
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), http.Header{})
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return 1, fmt.Errorf("failed to connect streaming server: %w", err)
+	}
+	defer conn.Close()
+	// Command reading stdin would never exit if its EOF cannot be sent
+	if stdinReader != nil && conn.Subprotocol() != v5BinaryWebsocketProtocol {
+		return 1, errStdinNotClosable
+	}
+
+	// Closing connection is the only way to interrupt blocking ReadMessage
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stopWatching:
+		}
+	}()
+
+	if stdinReader != nil {
+		go copyStdin(conn, stdinReader, stopWatching)
+	}
+
+	var statusBuffer bytes.Buffer
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return 1, ctx.Err()
+			}
+			if statusBuffer.Len() == 0 {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					return 1, errExecStatusMissing
+				}
+				return 1, fmt.Errorf("failed to read from streaming server: %w", err)
+			}
+			break
+		}
+		if messageType != websocket.BinaryMessage || len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case stdoutChannel:
+			stdoutWriter.Write(data[1:])
+		case stderrChannel:
+			stderrWriter.Write(data[1:])
+		case errorChannel:
+			statusBuffer.Write(data[1:])
+		}
+	}
+
+	var status execStatus
+	if err := json.Unmarshal(statusBuffer.Bytes(), &status); err != nil {
+		return 1, fmt.Errorf("invalid exit status %s: %w", statusBuffer.String(), err)
+	}
+	return status.exitCode()
+}
+
+// streamingTLSConfig verifies streaming server of container runtime against
+// CA configured for it, or system roots if not configured. Runtimes serving
+// streaming over TLS usually use a self-signed certificate, whose CA must be
+// configured then.
+func streamingTLSConfig() (*tls.Config, error) {
+	caFile := config.GetConfig().Container.StreamingCAFile
+	if caFile == "" {
+		return &tls.Config{}, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA of streaming server: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in CA file %s of streaming server", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// copyStdin sends stdin to the command and closes its stdin on EOF, until
+// done is closed. Read of stdinReader could not be interrupted, thus it stops
+// when the pending Read returns after done.
+func copyStdin(conn *websocket.Conn, stdinReader io.Reader, done <-chan struct{}) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdinReader.Read(buf)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if n > 0 {
+			message := append([]byte{stdinChannel}, buf[:n]...)
+			if writeErr := conn.WriteMessage(websocket.BinaryMessage, message); writeErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				conn.WriteMessage(websocket.BinaryMessage, []byte{closeChannel, stdinChannel})
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package cri
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStreamServer(t *testing.T, status string, wait time.Duration) *httptest.Server {
+	upgrader := websocket.Upgrader{Subprotocols: []string{v4BinaryWebsocketProtocol}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{stdoutChannel}, "out"...))
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{stderrChannel}, "err"...))
+		time.Sleep(wait)
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{errorChannel}, status...))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+}
+
+func TestStreamExec(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		exitCode int
+		hasError bool
+	}{
+		{
+			name:     "success",
+			status:   `{"metadata":{},"status":"Success"}`,
+			exitCode: 0,
+		},
+		{
+			name:     "nonZeroExitCode",
+			status:   `{"metadata":{},"status":"Failure","message":"command terminated with non-zero exit code","reason":"NonZeroExitCode","details":{"causes":[{"reason":"ExitCode","message":"3"}]}}`,
+			exitCode: 3,
+		},
+		{
+			name:     "internalError",
+			status:   `{"metadata":{},"status":"Failure","message":"container not running","reason":"InternalError"}`,
+			exitCode: 1,
+			hasError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStreamServer(t, tt.status, 0)
+			defer server.Close()
+
+			var stdout, stderr bytes.Buffer
+			exitCode, err := streamExec(context.Background(), server.URL, nil, &stdout, &stderr)
+			assert.Equal(t, tt.exitCode, exitCode)
+			assert.Equal(t, tt.hasError, err != nil)
+			assert.Equal(t, "out", stdout.String())
+			assert.Equal(t, "err", stderr.String())
+		})
+	}
+}
+
+func TestStreamExecTimeout(t *testing.T) {
+	server := newStreamServer(t, `{"status":"Success"}`, 3*time.Second)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	_, err := streamExec(ctx, server.URL, nil, &stdout, &stderr)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "out", stdout.String())
+}
+
+func TestStreamExecStdin(t *testing.T) {
+	// Server echoes stdin to stdout until stdin is closed
+	upgrader := websocket.Upgrader{Subprotocols: []string{v5BinaryWebsocketProtocol}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if !assert.NoError(t, err) {
+				return
+			}
+			if data[0] == closeChannel {
+				assert.Equal(t, []byte{closeChannel, stdinChannel}, data)
+				break
+			}
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{stdoutChannel}, data[1:]...))
+		}
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{errorChannel}, `{"status":"Success"}`...))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := streamExec(context.Background(), server.URL, bytes.NewBufferString("input"), &stdout, &stderr)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+	assert.Equal(t, "input", stdout.String())
+
+	// Stdin is not sent if it could not be closed
+	v4Server := newStreamServer(t, `{"status":"Success"}`, 0)
+	defer v4Server.Close()
+	_, err = streamExec(context.Background(), v4Server.URL, bytes.NewBufferString("input"), &stdout, &stderr)
+	assert.Equal(t, errStdinNotClosable, err)
+}
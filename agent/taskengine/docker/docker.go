@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+)
+
+type containerConnection struct {
+	client *client.Client
+	containerId string
+	containerName string
+}
+
+func getContainer(connectTimeout time.Duration, containerId string, containerName string) (*containerConnection, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, taskerrors.NewContainerConnectError(err)
+	}
+
+	containerListOptions := types.ContainerListOptions{
+		All: true,
+	}
+	if containerId != "" {
+		containerListOptions.Filters = filters.NewArgs(filters.KeyValuePair{
+			Key: "id",
+			Value: containerId,
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	containers, err := cli.ContainerList(ctx, containerListOptions)
+	if err != nil {
+		cli.Close()
+		return nil, taskerrors.NewContainerConnectError(err)
+	}
+
+	if containerName != "" {
+		containersOfName := make([]types.Container, 0, len(containers))
+		for _, container := range containers {
+			if hasName(container.Names, containerName) {
+				containersOfName = append(containersOfName, container)
+			}
+		}
+		// Keep consistent with CRI: if container found by id is filtered out
+		// by name, ContainerNameAndIdNotMatch error should be reported.
+		if containerId != "" && len(containers) > 0 && len(containersOfName) == 0 {
+			cli.Close()
+			return nil, taskerrors.NewContainerNameAndIdNotMatchError(containerId, containerName)
+		}
+		containers = containersOfName
+	}
+	if len(containers) == 0 {
+		cli.Close()
+		return nil, taskerrors.NewContainerNotFoundError()
+	}
+	if len(containers) > 1 {
+		cli.Close()
+		return nil, taskerrors.NewContainerNameDuplicatedError()
+	}
+
+	connection := &containerConnection{
+		client: cli,
+		containerId: containers[0].ID,
+		containerName: containerName,
+	}
+	if connection.containerName == "" && len(containers[0].Names) > 0 {
+		connection.containerName = strings.TrimPrefix(containers[0].Names[0], "/")
+	}
+	// Codes below SHOULD always return connection object to indicate the
+	// container matching specified attributes has been found successfully
+	if containers[0].State != "running" {
+		return connection, taskerrors.NewContainerStateAbnormalError(strings.ToUpper(containers[0].State))
+	}
+
+	return connection, nil
+}
+
+// Container names got from docker API are prefixed by a slash '/', see
+// stripAndSelectName in agent/container/docker for detail
+func hasName(names []string, expectedName string) bool {
+	for _, name := range names {
+		if strings.TrimPrefix(name, "/") == expectedName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/containercmd"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+	"github.com/aliyun/aliyun_assist_client/agent/util/process"
+)
+
+// IdentifierPrefix is the prefix of container identifier specifying the
+// container should be accessed via docker directly
+const IdentifierPrefix = "docker://"
+
+// DockerProcessor runs command inside container via docker exec, for hosts
+// running standalone dockerd without CRI-compatible runtime service
+type DockerProcessor struct {
+	TaskId string
+	// Fundamental properties of command process
+	ContainerIdentifier string
+	ContainerName string
+	CommandType string
+	CommandContent string
+	Timeout int
+
+	// Extracted properties about target container
+	containerId string
+
+	// Connection to docker daemon
+	connection *containerConnection
+
+	// Cancellation of running command
+	lock sync.Mutex
+	canceled bool
+	cancelRun context.CancelFunc
+}
+
+func (p *DockerProcessor) PreCheck() (string, error) {
+	p.containerId = strings.TrimPrefix(p.ContainerIdentifier, IdentifierPrefix)
+	if p.containerId == "" && p.ContainerIdentifier != "" {
+		validationErr := taskerrors.NewInvalidContainerIdError()
+		return validationErr.Param(), validationErr
+	}
+	return "", nil
+}
+
+func (p *DockerProcessor) Prepare(commandContent string) error {
+	var err error
+	p.connection, err = getContainer(10 * time.Second, p.containerId, p.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	p.CommandContent = commandContent
+	return nil
+}
+
+func (p *DockerProcessor) SyncRun(
+		stdoutWriter io.Writer,
+		stderrWriter io.Writer,
+		stdinReader  io.Reader)  (exitCode int, status int, err error) {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if p.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.Timeout) * time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	p.lock.Lock()
+	if p.canceled {
+		p.lock.Unlock()
+		return 1, process.Success, nil
+	}
+	p.cancelRun = cancel
+	p.lock.Unlock()
+
+	execCreated, err := p.connection.client.ContainerExecCreate(ctx, p.connection.containerId, types.ExecConfig{
+		AttachStdin: stdinReader != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd: containercmd.CompileCommand(p.CommandType, p.CommandContent, containercmd.PidFileName(p.TaskId)),
+	})
+	if err != nil {
+		return p.handleRunError(ctx, err)
+	}
+	attached, err := p.connection.client.ContainerExecAttach(ctx, execCreated.ID, types.ExecStartCheck{})
+	if err != nil {
+		return p.handleRunError(ctx, err)
+	}
+	defer attached.Close()
+
+	if stdinReader != nil {
+		go func() {
+			io.Copy(attached.Conn, stdinReader)
+			attached.CloseWrite()
+		}()
+	}
+	copied := make(chan error, 1)
+	go func() {
+		// Output of exec without tty is multiplexed into one stream
+		_, err := stdcopy.StdCopy(stdoutWriter, stderrWriter, attached.Reader)
+		copied <- err
+	}()
+
+	select {
+	case err = <-copied:
+		if err != nil {
+			return p.handleRunError(ctx, err)
+		}
+	case <-ctx.Done():
+		// Closing attached stream does not terminate the command
+		attached.Close()
+		return p.handleRunError(ctx, ctx.Err())
+	}
+
+	inspectCtx, inspectCancel := context.WithTimeout(context.Background(), containercmd.KillTimeout)
+	defer inspectCancel()
+	inspected, err := p.connection.client.ContainerExecInspect(inspectCtx, execCreated.ID)
+	if err != nil {
+		return 1, process.Fail, taskerrors.NewContainerRuntimeInternalError(err)
+	}
+	return inspected.ExitCode, process.Success, nil
+}
+
+func (p *DockerProcessor) handleRunError(ctx context.Context, err error) (int, int, error) {
+	p.lock.Lock()
+	canceled := p.canceled
+	p.lock.Unlock()
+	if canceled {
+		// Final state of canceled task has been reported by Task.Cancel
+		return 1, process.Success, nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		p.killRemoteProcess()
+		return 1, process.Timeout, err
+	}
+	return 1, process.Fail, taskerrors.NewContainerRuntimeInternalError(err)
+}
+
+func (p *DockerProcessor) Cancel() {
+	p.lock.Lock()
+	p.canceled = true
+	cancelRun := p.cancelRun
+	p.lock.Unlock()
+
+	if cancelRun == nil {
+		return
+	}
+	p.killRemoteProcess()
+	cancelRun()
+}
+
+func (p *DockerProcessor) Cleanup(removeScriptFile bool) error {
+	if p.connection == nil {
+		return nil
+	}
+	defer p.connection.client.Close()
+	return p.execSync(containercmd.RemovePidFileCommand(p.CommandType, containercmd.PidFileName(p.TaskId)))
+}
+
+func (p *DockerProcessor) SideEffect() error {
+	return nil
+}
+
+func (p *DockerProcessor) ExtraLubanParams() string {
+	if p.connection == nil {
+		return fmt.Sprintf("&containerId=%s&containerName=%s", p.ContainerIdentifier, p.ContainerName)
+	}
+
+	return fmt.Sprintf("&containerId=%s&containerName=%s", p.connection.containerId, p.connection.containerName)
+}
+
+// killRemoteProcess kills the process tree of the command inside container,
+// since docker provides no way to kill process of exec
+func (p *DockerProcessor) killRemoteProcess() {
+	taskLogger := log.GetLogger().WithFields(logrus.Fields{
+		"TaskId": p.TaskId,
+		"Phase":  "DockerProcessor-Kill",
+	})
+	if err := p.execSync(containercmd.KillCommand(p.CommandType, containercmd.PidFileName(p.TaskId))); err != nil {
+		taskLogger.WithError(err).Warning("Failed to kill command process in container")
+		return
+	}
+	taskLogger.Info("Killed command process in container")
+}
+
+// execSync runs auxiliary command in container and waits for it to finish
+func (p *DockerProcessor) execSync(cmd []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), containercmd.KillTimeout)
+	defer cancel()
+	execCreated, err := p.connection.client.ContainerExecCreate(ctx, p.connection.containerId, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd: cmd,
+	})
+	if err != nil {
+		return err
+	}
+	attached, err := p.connection.client.ContainerExecAttach(ctx, execCreated.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer attached.Close()
+	if _, err = io.Copy(io.Discard, attached.Reader); err != nil {
+		return err
+	}
+	inspected, err := p.connection.client.ContainerExecInspect(ctx, execCreated.ID)
+	if err != nil {
+		return err
+	}
+	if inspected.ExitCode != 0 {
+		return fmt.Errorf("command exited with code %d", inspected.ExitCode)
+	}
+	return nil
+}
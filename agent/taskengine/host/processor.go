@@ -199,6 +199,10 @@ func (p *HostProcessor) SyncRun(
 	return p.exitCode, p.resultStatus, err
 }
 
+func (p *HostProcessor) Pid() int {
+	return p.processCmd.Pid()
+}
+
 func (p *HostProcessor) Cancel() {
 	p.processCmd.Cancel()
 }
@@ -0,0 +1,356 @@
+package taskengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+	"github.com/aliyun/aliyun_assist_client/agent/util/timetool"
+	"github.com/aliyun/aliyun_assist_client/agent/util/wrapgo"
+)
+
+const (
+	journalDirName = "task_journal"
+
+	// Task is running and no final state has been determined
+	journalStateRunning = "running"
+)
+
+var (
+	// orphanCheckInterval is replaced in tests to check orphaned processes
+	// more frequently
+	orphanCheckInterval = 5 * time.Second
+
+	// _orphanedTasks are tasks whose processes were started by previous agent
+	// process and still running, indexed by task id
+	_orphanedTasks     = make(map[string]*orphanedTask)
+	_orphanedTasksLock sync.Mutex
+)
+
+// taskJournalEntry is persisted state of a non-periodic task, which is
+// removed once the final output has been acknowledged by server
+type taskJournalEntry struct {
+	TaskId string
+	// StartTime is wall clock when the task started, and StartTimestamp is
+	// the monotonic one reported to server
+	StartTime      time.Time
+	StartTimestamp int64
+	Timeout        int
+	Pid            int
+	// OutputOffset is length of output already sent as running output
+	OutputOffset uint32
+	State        string
+	// Final report which has not been acknowledged yet
+	ReportURL string `json:",omitempty"`
+	Output    string `json:",omitempty"`
+}
+
+// taskJournal keeps journal of a task in memory and on disk. Methods of nil
+// journal are no-op, e.g., for periodic tasks.
+type taskJournal struct {
+	lock         sync.Mutex
+	entry        taskJournalEntry
+	acknowledged bool
+}
+
+// orphanedTask is a task interrupted by agent restart while its process is
+// still running. Only its process id is known, since pipes of output were
+// closed together with previous agent process.
+type orphanedTask struct {
+	entry    taskJournalEntry
+	canceled int32
+}
+
+// journalWriteLock serializes file operations on journal directory
+var journalWriteLock sync.Mutex
+
+func journalDir() (string, error) {
+	cacheDir, err := util.GetCachePath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, journalDirName)
+	if err := util.MakeSurePath(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func journalFilePath(taskId string) (string, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, taskId+".json"), nil
+}
+
+func writeJournalEntry(entry *taskJournalEntry) error {
+	path, err := journalFilePath(entry.TaskId)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	journalWriteLock.Lock()
+	defer journalWriteLock.Unlock()
+	// Write to temporary file and rename it, thus journal would never be
+	// truncated by crash during writing
+	tempPath := path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+func removeJournalEntry(taskId string) error {
+	path, err := journalFilePath(taskId)
+	if err != nil {
+		return err
+	}
+
+	journalWriteLock.Lock()
+	defer journalWriteLock.Unlock()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func loadJournalEntries() ([]taskJournalEntry, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []taskJournalEntry
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.GetLogger().WithError(err).Errorf("Failed to read task journal %s", path)
+			continue
+		}
+		var entry taskJournalEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.TaskId == "" {
+			log.GetLogger().WithError(err).Errorf("Invalid task journal %s is removed", path)
+			os.Remove(path)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func startTaskJournal(taskId string, startTime time.Time, startTimestamp int64, timeout int) *taskJournal {
+	j := &taskJournal{
+		entry: taskJournalEntry{
+			TaskId:         taskId,
+			StartTime:      startTime,
+			StartTimestamp: startTimestamp,
+			Timeout:        timeout,
+			State:          journalStateRunning,
+		},
+	}
+	if err := writeJournalEntry(&j.entry); err != nil {
+		log.GetLogger().WithField("TaskId", taskId).WithError(err).Errorln("Failed to write task journal")
+	}
+	return j
+}
+
+// updateProgress records process id and length of output sent so far
+func (j *taskJournal) updateProgress(pid int, outputOffset uint32) {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.acknowledged || j.entry.State != journalStateRunning {
+		return
+	}
+	if j.entry.Pid == pid && j.entry.OutputOffset == outputOffset {
+		return
+	}
+	j.entry.Pid = pid
+	j.entry.OutputOffset = outputOffset
+	if err := writeJournalEntry(&j.entry); err != nil {
+		log.GetLogger().WithField("TaskId", j.entry.TaskId).WithError(err).Errorln("Failed to update task journal")
+	}
+}
+
+// recordFinalReport persists final state and report before it is sent, thus
+// it can be resent after agent restarted
+func (j *taskJournal) recordFinalReport(state string, reportURL string, output string) {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.acknowledged {
+		return
+	}
+	j.entry.State = state
+	j.entry.ReportURL = reportURL
+	j.entry.Output = output
+	if err := writeJournalEntry(&j.entry); err != nil {
+		log.GetLogger().WithField("TaskId", j.entry.TaskId).WithError(err).Errorln("Failed to record final report in task journal")
+	}
+}
+
+// acknowledge removes journal after final report has been accepted by server
+func (j *taskJournal) acknowledge() {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.acknowledged = true
+	if err := removeJournalEntry(j.entry.TaskId); err != nil {
+		log.GetLogger().WithField("TaskId", j.entry.TaskId).WithError(err).Errorln("Failed to remove task journal")
+	}
+}
+
+// ReconcileTaskJournal handles journals left by previous agent process:
+// unacknowledged final reports are queued into outbox, and tasks interrupted by agent
+// restart are reported as failed once their orphaned processes exit.
+//
+// Orphaned processes are not fully re-attached: output written after restart
+// is lost, and exit code is unknown. They are still killed when the task
+// times out or is canceled by server.
+func ReconcileTaskJournal() {
+	entries, err := loadJournalEntries()
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to load task journals")
+		return
+	}
+	for i := range entries {
+		entry := entries[i]
+		journalLogger := log.GetLogger().WithFields(logrus.Fields{
+			"TaskId": entry.TaskId,
+			"Phase":  "Reconciling",
+		})
+		if entry.State != journalStateRunning {
+			journalLogger.Infof("Resend unacknowledged final report of %s task", entry.State)
+			resendFinalReport(&entry)
+			continue
+		}
+		if entry.Pid > 0 && isProcessAlive(entry.Pid) {
+			journalLogger.Infof("Wait for orphaned process %d of interrupted task to exit", entry.Pid)
+			orphan := &orphanedTask{
+				entry: entry,
+			}
+			_orphanedTasksLock.Lock()
+			_orphanedTasks[entry.TaskId] = orphan
+			_orphanedTasksLock.Unlock()
+			wrapgo.GoWithDefaultPanicHandler(func() {
+				waitOrphanedProcess(orphan)
+			})
+			continue
+		}
+		journalLogger.Info("Report task interrupted by agent restart")
+		reportInterruptedTask(&entry, "")
+	}
+}
+
+// resendFinalReport moves unacknowledged final report into outbox, which
+// delivers it in background
+func resendFinalReport(entry *taskJournalEntry) {
+	if err := outbox.Enqueue(entry.TaskId, entry.ReportURL, entry.Output); err != nil {
+		log.GetLogger().WithField("TaskId", entry.TaskId).WithError(err).Errorln("Failed to put final report into outbox, would retry next time")
+		return
+	}
+	removeJournalEntry(entry.TaskId)
+	outbox.Notify()
+}
+
+// waitOrphanedProcess waits for the process of task started by previous
+// agent process to exit, and kills it once the task timed out
+func waitOrphanedProcess(orphan *orphanedTask) {
+	defer func() {
+		_orphanedTasksLock.Lock()
+		delete(_orphanedTasks, orphan.entry.TaskId)
+		_orphanedTasksLock.Unlock()
+	}()
+	entry := &orphan.entry
+	deadline := entry.StartTime.Add(time.Duration(entry.Timeout) * time.Second)
+	for isProcessAlive(entry.Pid) {
+		if entry.Timeout > 0 && time.Now().After(deadline) {
+			detail := fmt.Sprintf("process %d was killed since the task timed out", entry.Pid)
+			if err := killOrphanedProcess(entry.Pid); err != nil {
+				log.GetLogger().WithField("TaskId", entry.TaskId).WithError(err).Errorf("Failed to kill orphaned process %d", entry.Pid)
+				detail = fmt.Sprintf("process %d was still running when the task timed out", entry.Pid)
+			}
+			reportInterruptedTask(entry, detail)
+			return
+		}
+		time.Sleep(orphanCheckInterval)
+	}
+	if atomic.LoadInt32(&orphan.canceled) != 0 {
+		// Reported as stopped when canceled
+		removeJournalEntry(entry.TaskId)
+		return
+	}
+	reportInterruptedTask(entry, fmt.Sprintf("process %d exited with unknown exit code", entry.Pid))
+}
+
+// cancelOrphanedTask kills process of the task started by previous agent
+// process, and returns false if no such task is running
+func cancelOrphanedTask(taskId string) bool {
+	_orphanedTasksLock.Lock()
+	orphan, ok := _orphanedTasks[taskId]
+	_orphanedTasksLock.Unlock()
+	if !ok {
+		return false
+	}
+	atomic.StoreInt32(&orphan.canceled, 1)
+	if err := killOrphanedProcess(orphan.entry.Pid); err != nil {
+		log.GetLogger().WithField("TaskId", taskId).WithError(err).Errorf("Failed to kill orphaned process %d", orphan.entry.Pid)
+	}
+	return true
+}
+
+func reportInterruptedTask(entry *taskJournalEntry, detail string) {
+	errDesc := "The agent restarted while the task was running"
+	if detail != "" {
+		errDesc = errDesc + ", " + detail
+	}
+	endTime := time.Now()
+	var end int64
+	if entry.StartTime.IsZero() {
+		end = timetool.ToAccurateTime(endTime.Local())
+	} else {
+		end = entry.StartTimestamp + endTime.Sub(entry.StartTime).Milliseconds()
+	}
+	queryString := fmt.Sprintf("?taskId=%s&start=%d&end=%d&exitCode=%d&dropped=%d&errCode=%s&errDesc=%s",
+		entry.TaskId, entry.StartTimestamp, end, 1, 0,
+		taskerrors.WrapErrTaskInterrupted.String(), url.QueryEscape(errDesc))
+
+	entry.State = "failed"
+	entry.ReportURL = util.GetErrorOutputService() + queryString
+	entry.Output = ""
+	if err := writeJournalEntry(entry); err != nil {
+		log.GetLogger().WithField("TaskId", entry.TaskId).WithError(err).Errorln("Failed to record final report in task journal")
+	}
+	resendFinalReport(entry)
+}
@@ -0,0 +1,123 @@
+package taskengine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func findJournalEntry(t *testing.T, taskId string) (taskJournalEntry, bool) {
+	entries, err := loadJournalEntries()
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		if entry.TaskId == taskId {
+			return entry, true
+		}
+	}
+	return taskJournalEntry{}, false
+}
+
+// outboxReport reads final report of the task queued in outbox, and removes
+// it from disk
+func outboxReport(t *testing.T, taskId string) (outbox.Report, bool) {
+	cacheDir, err := util.GetCachePath()
+	assert.NoError(t, err)
+	path := filepath.Join(cacheDir, "task_outbox", taskId+".json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return outbox.Report{}, false
+	}
+	defer os.Remove(path)
+	var report outbox.Report
+	assert.NoError(t, json.Unmarshal(data, &report))
+	return report, true
+}
+
+func TestTaskJournalRoundTrip(t *testing.T) {
+	taskId := "t-journal-roundtrip"
+	defer removeJournalEntry(taskId)
+	startTime := time.Now()
+
+	j := startTaskJournal(taskId, startTime, 1600000000000, 60)
+	entry, ok := findJournalEntry(t, taskId)
+	assert.True(t, ok)
+	assert.Equal(t, journalStateRunning, entry.State)
+	assert.True(t, startTime.Equal(entry.StartTime))
+	assert.Equal(t, int64(1600000000000), entry.StartTimestamp)
+	assert.Equal(t, 60, entry.Timeout)
+
+	j.updateProgress(1234, 100)
+	entry, _ = findJournalEntry(t, taskId)
+	assert.Equal(t, 1234, entry.Pid)
+	assert.Equal(t, uint32(100), entry.OutputOffset)
+
+	j.recordFinalReport("finished", "https://example.com/finish?taskId="+taskId, "output")
+	entry, _ = findJournalEntry(t, taskId)
+	assert.Equal(t, "finished", entry.State)
+	assert.Equal(t, "output", entry.Output)
+	// Progress is never recorded after final state
+	j.updateProgress(1234, 200)
+	entry, _ = findJournalEntry(t, taskId)
+	assert.Equal(t, uint32(100), entry.OutputOffset)
+
+	j.acknowledge()
+	_, ok = findJournalEntry(t, taskId)
+	assert.False(t, ok)
+
+	// Journal of periodic tasks is nil
+	var nilJournal *taskJournal
+	nilJournal.updateProgress(1, 1)
+	nilJournal.recordFinalReport("finished", "", "")
+	nilJournal.acknowledge()
+}
+
+func TestReconcileTaskJournal(t *testing.T) {
+	mockMetrics()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+
+	// Unacknowledged final report is moved into outbox
+	finished := &taskJournalEntry{
+		TaskId:    "t-journal-finished",
+		State:     "finished",
+		ReportURL: "https://example.com/finish?taskId=t-journal-finished",
+		Output:    "output",
+	}
+	assert.NoError(t, writeJournalEntry(finished))
+	// Task whose process is gone is reported as interrupted
+	interrupted := &taskJournalEntry{
+		TaskId:         "t-journal-interrupted",
+		StartTime:      time.Now().Add(-time.Minute),
+		StartTimestamp: 1600000000000,
+		Timeout:        3600,
+		State:          journalStateRunning,
+	}
+	assert.NoError(t, writeJournalEntry(interrupted))
+
+	ReconcileTaskJournal()
+
+	_, ok := findJournalEntry(t, finished.TaskId)
+	assert.False(t, ok)
+	report, ok := outboxReport(t, finished.TaskId)
+	assert.True(t, ok)
+	assert.Equal(t, finished.ReportURL, report.URL)
+	assert.Equal(t, "output", report.Output)
+
+	_, ok = findJournalEntry(t, interrupted.TaskId)
+	assert.False(t, ok)
+	report, ok = outboxReport(t, interrupted.TaskId)
+	assert.True(t, ok)
+	assert.Contains(t, report.URL, "taskId="+interrupted.TaskId)
+	assert.Contains(t, report.URL, "start=1600000000000")
+	assert.Contains(t, report.URL, "errCode="+taskerrors.WrapErrTaskInterrupted.String())
+}
@@ -0,0 +1,29 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package taskengine
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func isProcessAlive(pid int) bool {
+	// Signal 0 performs error checking only, and EPERM means the process
+	// exists but belongs to other user
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// killOrphanedProcess kills process group of the task. Process of task leads
+// its own group, while other process reusing the id rarely does.
+func killOrphanedProcess(pid int) error {
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return err
+	}
+	if pgid != pid {
+		return fmt.Errorf("process %d is not leader of process group, which may not be the task", pid)
+	}
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
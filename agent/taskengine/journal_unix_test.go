@@ -0,0 +1,101 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package taskengine
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+// startOrphanedProcess starts process leading its own process group like
+// task, and returns channel closed once it exits
+func startOrphanedProcess(t *testing.T) (*exec.Cmd, chan struct{}) {
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	assert.NoError(t, cmd.Start())
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	return cmd, exited
+}
+
+func waitExited(t *testing.T, exited chan struct{}) {
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("orphaned process is not killed")
+	}
+}
+
+func TestOrphanedTaskTimeout(t *testing.T) {
+	mockMetrics()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+	orphanCheckInterval = 10 * time.Millisecond
+	defer func() { orphanCheckInterval = 5 * time.Second }()
+
+	cmd, exited := startOrphanedProcess(t)
+	defer cmd.Process.Kill()
+	entry := &taskJournalEntry{
+		TaskId:    "t-journal-timeout",
+		StartTime: time.Now(),
+		Timeout:   1,
+		Pid:       cmd.Process.Pid,
+		State:     journalStateRunning,
+	}
+	assert.NoError(t, writeJournalEntry(entry))
+
+	ReconcileTaskJournal()
+	waitExited(t, exited)
+	assert.True(t, waitUntil(5*time.Second, func() bool {
+		_, ok := findJournalEntry(t, entry.TaskId)
+		return !ok
+	}))
+	report, ok := outboxReport(t, entry.TaskId)
+	assert.True(t, ok)
+	assert.Contains(t, report.URL, "timed+out")
+}
+
+func TestOrphanedTaskCanceled(t *testing.T) {
+	mockMetrics()
+	defer util.NilRequest.Clear()
+	defer httpmock.DeactivateAndReset()
+	orphanCheckInterval = 10 * time.Millisecond
+	defer func() { orphanCheckInterval = 5 * time.Second }()
+
+	cmd, exited := startOrphanedProcess(t)
+	defer cmd.Process.Kill()
+	entry := &taskJournalEntry{
+		TaskId:    "t-journal-canceled",
+		StartTime: time.Now(),
+		Timeout:   3600,
+		Pid:       cmd.Process.Pid,
+		State:     journalStateRunning,
+	}
+	assert.NoError(t, writeJournalEntry(entry))
+
+	ReconcileTaskJournal()
+	assert.False(t, cancelOrphanedTask("t-journal-unknown"))
+	assert.True(t, cancelOrphanedTask(entry.TaskId))
+	waitExited(t, exited)
+	// Canceled task is reported as stopped instead of interrupted
+	assert.True(t, waitUntil(5*time.Second, func() bool {
+		_, ok := findJournalEntry(t, entry.TaskId)
+		return !ok
+	}))
+	_, ok := outboxReport(t, entry.TaskId)
+	assert.False(t, ok)
+	assert.True(t, waitUntil(5*time.Second, func() bool {
+		return !cancelOrphanedTask(entry.TaskId)
+	}))
+}
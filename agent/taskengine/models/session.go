@@ -7,5 +7,10 @@ type SessionTaskInfo struct {
 	SessionId    string `json:"channelId"`
 	WebsocketUrl string `json:"websocketUrl"`
 	PortNumber  string `json:"portNumber"`
+	TargetServer string `json:"targetServer"` // 端口转发的目标主机，即 StartTerminalSession 的 TargetServer 参数，为空时转发到本机
+	Multiplex   bool   `json:"multiplex"` // 端口转发是否在一个会话中复用多个TCP连接
+	Dynamic     bool   `json:"dynamic"` // 动态端口转发(SOCKS)，每个连接的目标由客户端指定，隐含复用
 	FlowLimit	 int    `json:"flowLimit"` // 最大流量 单位 bps
+	IdleTimeout  int    `json:"idleTimeout"` // 无输入断开时间 单位 s，0 表示采用本地配置，不超过本地配置
+	MaxDuration  int    `json:"maxDuration"` // 会话最长持续时间 单位 s，0 表示采用本地配置，不超过本地配置
 }
@@ -0,0 +1,400 @@
+// Package outbox persists final results of tasks on disk and delivers them
+// to server with exponential backoff, thus results would not be lost when
+// network is unavailable for a while or the agent restarts.
+package outbox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+	"github.com/aliyun/aliyun_assist_client/agent/util/wrapgo"
+)
+
+const (
+	outboxDirName = "task_outbox"
+
+	// MaxQueueLength bounds the number of undelivered results kept on disk,
+	// the oldest one is dropped when exceeded
+	MaxQueueLength = 1000
+
+	initialBackoff = 2 * time.Second
+	maxBackoff     = 5 * time.Minute
+
+	// deliveredCacheSize bounds the number of recently delivered task ids
+	// remembered to skip duplicated results
+	deliveredCacheSize = 1000
+)
+
+// Report is a final result of task to be posted to server
+type Report struct {
+	TaskId        string
+	URL           string
+	Output        string
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+}
+
+type outbox struct {
+	lock sync.Mutex
+	// pending reports indexed by task id
+	pending map[string]*Report
+	// delivered task ids in order of delivery
+	delivered      []string
+	deliveredIndex map[string]struct{}
+	// task ids whose results are being posted
+	inflight map[string]struct{}
+
+	wakeup  chan struct{}
+	started bool
+}
+
+var _outbox = &outbox{
+	pending:        make(map[string]*Report),
+	deliveredIndex: make(map[string]struct{}),
+	inflight:       make(map[string]struct{}),
+	wakeup:         make(chan struct{}, 1),
+}
+
+func outboxDir() (string, error) {
+	cacheDir, err := util.GetCachePath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, outboxDirName)
+	if err := util.MakeSurePath(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func reportFilePath(taskId string) (string, error) {
+	dir, err := outboxDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, taskId+".json"), nil
+}
+
+func writeReport(report *Report) error {
+	path, err := reportFilePath(report.TaskId)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+func removeReport(taskId string) error {
+	path, err := reportFilePath(taskId)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Start loads undelivered results left by previous agent process and starts
+// delivering them in background
+func Start() {
+	_outbox.lock.Lock()
+	if _outbox.started {
+		_outbox.lock.Unlock()
+		return
+	}
+	_outbox.started = true
+	_outbox.load()
+	_outbox.lock.Unlock()
+
+	wrapgo.GoWithDefaultPanicHandler(_outbox.run)
+}
+
+// load MUST be called with lock held
+func (o *outbox) load() {
+	dir, err := outboxDir()
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to get path of task result outbox")
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to read task result outbox")
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.GetLogger().WithError(err).Errorf("Failed to read task result %s", path)
+			continue
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil || report.TaskId == "" {
+			log.GetLogger().WithError(err).Errorf("Invalid task result %s is removed", path)
+			os.Remove(path)
+			continue
+		}
+		if _, err := url.Parse(report.URL); err != nil {
+			log.GetLogger().WithError(err).Errorf("Invalid task result %s is removed", path)
+			os.Remove(path)
+			continue
+		}
+		// Deliver results left by previous agent process immediately
+		report.NextAttemptAt = time.Time{}
+		o.pending[report.TaskId] = &report
+	}
+	if len(o.pending) > 0 {
+		log.GetLogger().Infof("Loaded %d undelivered task results from outbox", len(o.pending))
+	}
+}
+
+// Enqueue persists final result of task. Result of the task already queued is
+// replaced, and result of the task already delivered is ignored.
+func Enqueue(taskId string, reportURL string, output string) error {
+	return _outbox.enqueue(taskId, reportURL, output)
+}
+
+func (o *outbox) enqueue(taskId string, reportURL string, output string) error {
+	if _, err := url.Parse(reportURL); err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if _, ok := o.deliveredIndex[taskId]; ok {
+		log.GetLogger().WithField("TaskId", taskId).Warning("Result of task has been delivered, duplicated one is ignored")
+		return nil
+	}
+
+	report := &Report{
+		TaskId:    taskId,
+		URL:       reportURL,
+		Output:    output,
+		CreatedAt: time.Now(),
+	}
+	if err := writeReport(report); err != nil {
+		return err
+	}
+	if _, ok := o.pending[taskId]; !ok && len(o.pending) >= MaxQueueLength {
+		o.dropOldest()
+	}
+	o.pending[taskId] = report
+	return nil
+}
+
+// dropOldest MUST be called with lock held
+func (o *outbox) dropOldest() {
+	var oldest *Report
+	for _, report := range o.pending {
+		if oldest == nil || report.CreatedAt.Before(oldest.CreatedAt) {
+			oldest = report
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	delete(o.pending, oldest.TaskId)
+	removeReport(oldest.TaskId)
+	log.GetLogger().WithFields(logrus.Fields{
+		"TaskId":   oldest.TaskId,
+		"attempts": oldest.Attempts,
+	}).Errorln("Task result outbox is full, the oldest result is dropped")
+	metrics.GetTaskFailedEvent(
+		"taskid", oldest.TaskId,
+		"errormsg", "result dropped because outbox is full",
+	).ReportEvent()
+}
+
+// Deliver tries to deliver result of the task immediately. Result failed to
+// be delivered would be retried in background.
+func Deliver(taskId string) bool {
+	_outbox.lock.Lock()
+	report, ok := _outbox.pending[taskId]
+	_outbox.lock.Unlock()
+	if !ok {
+		return false
+	}
+	return _outbox.deliver(report)
+}
+
+// Notify wakes up delivery in background immediately, e.g., when network is
+// known to be available again
+func Notify() {
+	_outbox.lock.Lock()
+	for _, report := range _outbox.pending {
+		report.NextAttemptAt = time.Time{}
+	}
+	_outbox.lock.Unlock()
+
+	select {
+	case _outbox.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Depth returns the number of results waiting for delivery
+func Depth() int {
+	_outbox.lock.Lock()
+	defer _outbox.lock.Unlock()
+	return len(_outbox.pending)
+}
+
+func (o *outbox) run() {
+	for {
+		for _, report := range o.dueReports() {
+			o.deliver(report)
+		}
+
+		timer := time.NewTimer(o.nextWait())
+		select {
+		case <-timer.C:
+		case <-o.wakeup:
+			timer.Stop()
+		}
+	}
+}
+
+func (o *outbox) dueReports() []*Report {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	now := time.Now()
+	var due []*Report
+	for _, report := range o.pending {
+		if !report.NextAttemptAt.After(now) {
+			due = append(due, report)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	return due
+}
+
+func (o *outbox) nextWait() time.Duration {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	wait := maxBackoff
+	now := time.Now()
+	for _, report := range o.pending {
+		if d := report.NextAttemptAt.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+func (o *outbox) deliver(report *Report) bool {
+	o.lock.Lock()
+	if _, ok := o.inflight[report.TaskId]; ok {
+		o.lock.Unlock()
+		return false
+	}
+	o.inflight[report.TaskId] = struct{}{}
+	reportURL, output, attempts := report.URL, report.Output, report.Attempts
+	o.lock.Unlock()
+	defer func() {
+		o.lock.Lock()
+		delete(o.inflight, report.TaskId)
+		o.lock.Unlock()
+	}()
+
+	logger := log.GetLogger().WithFields(logrus.Fields{
+		"TaskId":   report.TaskId,
+		"attempts": attempts,
+	})
+	err := post(reportURL, output)
+	if httpErr, ok := err.(*util.HttpErrorCode); ok && !httpErr.IsRetryable() {
+		// Server rejected the result, retrying would not help
+		logger.WithError(err).Errorln("Task result is rejected by server and dropped")
+		err = nil
+	}
+	if err == nil {
+		o.markDelivered(report)
+		return true
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	report.Attempts++
+	report.NextAttemptAt = time.Now().Add(backoff(report.Attempts))
+	if o.pending[report.TaskId] == report {
+		if err := writeReport(report); err != nil {
+			logger.WithError(err).Errorln("Failed to update task result in outbox")
+		}
+	}
+	logger.WithError(err).Warningf("Failed to deliver task result, would retry at %s", report.NextAttemptAt.Format(time.RFC3339))
+	return false
+}
+
+func (o *outbox) markDelivered(report *Report) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	taskId := report.TaskId
+	// Result may be replaced during delivery, which should be kept
+	if o.pending[taskId] == report {
+		delete(o.pending, taskId)
+		if err := removeReport(taskId); err != nil {
+			log.GetLogger().WithField("TaskId", taskId).WithError(err).Errorln("Failed to remove delivered task result from outbox")
+		}
+	}
+	if _, ok := o.deliveredIndex[taskId]; ok {
+		return
+	}
+	o.deliveredIndex[taskId] = struct{}{}
+	o.delivered = append(o.delivered, taskId)
+	if len(o.delivered) > deliveredCacheSize {
+		delete(o.deliveredIndex, o.delivered[0])
+		o.delivered = o.delivered[1:]
+	}
+}
+
+func post(reportURL string, output string) error {
+	// URL has been validated when queued
+	u, _ := url.Parse(reportURL)
+	// Server host may be changed since the result was queued, e.g., after
+	// agent restarted
+	if host := util.GetServerHost(); host != "" {
+		u.Host = host
+	}
+	_, err := util.HttpPost(u.String(), output, "text")
+	return err
+}
+
+// backoff returns exponential delay with jitter before next attempt
+func backoff(attempts int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/util"
+)
+
+func TestBackoff(t *testing.T) {
+	previous := time.Duration(0)
+	for attempts := 1; attempts <= 10; attempts++ {
+		delay := backoff(attempts)
+		// Jitter adds at most 20% to delay, which stops growing at maxBackoff
+		assert.GreaterOrEqual(t, int64(delay), int64(previous)*5/6)
+		assert.LessOrEqual(t, int64(delay), int64(maxBackoff)*6/5)
+		previous = delay
+	}
+	assert.GreaterOrEqual(t, int64(backoff(1)), int64(initialBackoff))
+	assert.GreaterOrEqual(t, int64(backoff(20)), int64(maxBackoff))
+}
+
+func TestEnqueueIdempotent(t *testing.T) {
+	o := &outbox{
+		pending:        make(map[string]*Report),
+		deliveredIndex: make(map[string]struct{}),
+		inflight:       make(map[string]struct{}),
+		wakeup:         make(chan struct{}, 1),
+	}
+	taskId := "t-outbox-test"
+	defer removeReport(taskId)
+
+	assert.NoError(t, o.enqueue(taskId, "https://example.com/luban/api/v1/task/finish?taskId="+taskId, "first"))
+	assert.NoError(t, o.enqueue(taskId, "https://example.com/luban/api/v1/task/finish?taskId="+taskId, "second"))
+	assert.Equal(t, 1, len(o.pending))
+	assert.Equal(t, "second", o.pending[taskId].Output)
+
+	o.markDelivered(o.pending[taskId])
+	assert.Equal(t, 0, len(o.pending))
+	path, _ := reportFilePath(taskId)
+	assert.NoFileExists(t, path)
+
+	// Result of delivered task is ignored
+	assert.NoError(t, o.enqueue(taskId, "https://example.com/luban/api/v1/task/finish?taskId="+taskId, "third"))
+	assert.Equal(t, 0, len(o.pending))
+}
+
+func TestDeliverRetryable(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	util.NilRequest.Set()
+	defer util.NilRequest.Clear()
+	region := "cn-test100"
+	util.MockMetaServer(region)
+
+	o := &outbox{
+		pending:        make(map[string]*Report),
+		deliveredIndex: make(map[string]struct{}),
+		inflight:       make(map[string]struct{}),
+		wakeup:         make(chan struct{}, 1),
+	}
+	taskId := "t-outbox-retry"
+	defer removeReport(taskId)
+	reportURL := "https://" + util.GetServerHost() + "/luban/api/v1/task/finish?taskId=" + taskId
+	assert.NoError(t, o.enqueue(taskId, reportURL, "output"))
+
+	// Rate limiting and request timeout are retried later
+	for _, code := range []int{429, 408, 503} {
+		httpmock.RegisterResponder("POST", reportURL, httpmock.NewStringResponder(code, ""))
+		assert.False(t, o.deliver(o.pending[taskId]))
+		assert.Equal(t, 1, len(o.pending))
+	}
+	assert.Equal(t, 3, o.pending[taskId].Attempts)
+
+	// Result rejected by server is dropped
+	httpmock.RegisterResponder("POST", reportURL, httpmock.NewStringResponder(400, ""))
+	assert.True(t, o.deliver(o.pending[taskId]))
+	assert.Equal(t, 0, len(o.pending))
+}
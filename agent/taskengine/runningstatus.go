@@ -0,0 +1,57 @@
+package taskengine
+
+import (
+	"sort"
+)
+
+// RunningTask describes task registered in TaskFactory
+type RunningTask struct {
+	TaskId      string `json:"taskId"`
+	CommandId   string `json:"commandId,omitempty"`
+	CommandName string `json:"commandName,omitempty"`
+	Repeat      string `json:"repeat"`
+	Canceled    bool   `json:"canceled"`
+}
+
+// RunningSession describes session registered in SessionFactory
+type RunningSession struct {
+	SessionId  string `json:"sessionId"`
+	TaskId     string `json:"taskId,omitempty"`
+	PortNumber string `json:"portNumber,omitempty"`
+}
+
+// ListRunningTasks returns tasks currently registered sorted by task id
+func ListRunningTasks() []RunningTask {
+	tasks := GetTaskFactory().GetAllTasks()
+	runningTasks := make([]RunningTask, 0, len(tasks))
+	for _, task := range tasks {
+		runningTasks = append(runningTasks, RunningTask{
+			TaskId:      task.taskInfo.TaskId,
+			CommandId:   task.taskInfo.CommandId,
+			CommandName: task.taskInfo.CommandName,
+			Repeat:      string(task.taskInfo.Repeat),
+			Canceled:    task.IsCancled(),
+		})
+	}
+	sort.Slice(runningTasks, func(i, j int) bool {
+		return runningTasks[i].TaskId < runningTasks[j].TaskId
+	})
+	return runningTasks
+}
+
+// ListRunningSessions returns sessions currently open sorted by session id
+func ListRunningSessions() []RunningSession {
+	sessions := GetSessionFactory().GetAllTasks()
+	runningSessions := make([]RunningSession, 0, len(sessions))
+	for _, session := range sessions {
+		runningSessions = append(runningSessions, RunningSession{
+			SessionId:  session.sessionId,
+			TaskId:     session.taskId,
+			PortNumber: session.portNumber,
+		})
+	}
+	sort.Slice(runningSessions, func(i, j int) bool {
+		return runningSessions[i].SessionId < runningSessions[j].SessionId
+	})
+	return runningSessions
+}
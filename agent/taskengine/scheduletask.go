@@ -10,9 +10,11 @@ import (
 	"github.com/sirupsen/logrus"
 	heavylock "github.com/viney-shih/go-lock"
 
+	"github.com/aliyun/aliyun_assist_client/agent/config"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/metrics"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/models"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/timermanager"
 	"github.com/aliyun/aliyun_assist_client/agent/util/atomicutil"
 )
@@ -57,6 +59,12 @@ func EnableFetchingTask() {
 	atomic.StoreInt32(&_neverDirectWrite_Atomic_FetchingTaskEnabled, 1)
 }
 
+// DisableFetchingTask clears prviate indicator to refuse fetching tasks, e.g.,
+// when agent is stopping
+func DisableFetchingTask() {
+	atomic.StoreInt32(&_neverDirectWrite_Atomic_FetchingTaskEnabled, 0)
+}
+
 func isEnabledFetchingTask() bool {
 	state := atomic.LoadInt32(&_neverDirectWrite_Atomic_FetchingTaskEnabled)
 	return state != 0
@@ -159,7 +167,7 @@ func dispatchRunTask(taskInfo models.RunTaskInfo) {
 		// Non-periodic tasks are managed by TaskFactory
 		taskFactory.AddTask(t)
 		pool := GetPool()
-		pool.RunTask(func ()  {
+		err := pool.RunTaskWithPriority(func ()  {
 			code, err := t.Run()
 			if code != 0 || err != nil {
 				metrics.GetTaskFailedEvent(
@@ -170,7 +178,13 @@ func dispatchRunTask(taskInfo models.RunTaskInfo) {
 			}
 			taskFactory := GetTaskFactory()
 			taskFactory.RemoveTaskByName(t.taskInfo.TaskId)
-		})
+		}, taskPriority(taskInfo), taskInfo.CommandId)
+		if err != nil {
+			taskFactory.RemoveTaskByName(t.taskInfo.TaskId)
+			scheduleLogger.WithError(err).Errorln("Rejected by task pool")
+			reportRejectedTask(t, err)
+			return
+		}
 		scheduleLogger.Info("Scheduled for pending or running")
 	case models.RunTaskCron, models.RunTaskRate, models.RunTaskAt:
 		// Periodic tasks are managed by _periodicTaskSchedules
@@ -189,6 +203,27 @@ func dispatchRunTask(taskInfo models.RunTaskInfo) {
 	}
 }
 
+// taskPriority classifies tasks with short timeout as high priority, thus they
+// would not wait behind long scripts
+func taskPriority(taskInfo models.RunTaskInfo) TaskPriority {
+	shortTaskTimeout := config.GetConfig().TaskPool.ShortTaskTimeoutSeconds
+	if shortTaskTimeout > 0 && parseTimeout(taskInfo.TimeOut) <= shortTaskTimeout {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// reportRejectedTask reports invocation rejected by task pool as failed
+func reportRejectedTask(t *Task, err error) {
+	if taskErr, ok := err.(taskerrors.ExecutionError); ok {
+		t.SendError("", taskErr.Code(), taskErr.Error())
+	}
+	metrics.GetTaskFailedEvent(
+		"taskid", t.taskInfo.TaskId,
+		"errormsg", err.Error(),
+	).ReportEvent()
+}
+
 func dispatchStopTask(taskInfo models.RunTaskInfo) {
 	log.GetLogger().WithFields(logrus.Fields{
 		"TaskId": taskInfo.TaskId,
@@ -207,6 +242,11 @@ func dispatchStopTask(taskInfo models.RunTaskInfo) {
 			cancelLogger.Info("Cancel task and invocation")
 			scheduledTask.Cancel()
 			cancelLogger.Info("Canceled task and invocation")
+		} else if cancelOrphanedTask(taskInfo.TaskId) {
+			response, err := sendStoppedOutput(taskInfo.TaskId, 0, 0, 0, 0, "", stopReasonKilled)
+			cancelLogger.WithFields(logrus.Fields{
+				"response": response,
+			}).WithError(err).Info("Canceled task started before agent restarted")
 		} else {
 			response, err := sendStoppedOutput(taskInfo.TaskId, 0, 0, 0, 0, "", stopReasonKilled)
 			cancelLogger.WithFields(logrus.Fields{
@@ -255,9 +295,14 @@ func dispatchTestTask(taskInfo models.RunTaskInfo) {
 
 		scheduleLogger.Info("Schedule testing task to be pre-checked")
 		pool := GetPrecheckPool()
-		pool.RunTask(func () {
+		err := pool.RunTaskWithPriority(func () {
 			t.PreCheck(true)
-		})
+		}, PriorityHigh, "")
+		if err != nil {
+			scheduleLogger.WithError(err).Errorln("Rejected by pre-checking task pool")
+			t.SendInvalidTask("TaskPoolFull", err.Error())
+			return
+		}
 		scheduleLogger.Info("Scheduled testing task to be pre-checked")
 	default:
 		scheduleLogger.WithFields(logrus.Fields{
@@ -285,7 +330,7 @@ func (s *PeriodicTaskSchedule) startExclusiveInvocation() {
 	// (2) Every time of invocation need to add itself into TaskFactory at first.
 	taskFactory.AddTask(s.reusableInvocation)
 	pool := GetPool()
-	pool.RunTask(func ()  {
+	err := pool.RunTaskWithPriority(func ()  {
 		code, err := s.reusableInvocation.Run()
 		if code != 0 || err != nil {
 			metrics.GetTaskFailedEvent(
@@ -296,7 +341,13 @@ func (s *PeriodicTaskSchedule) startExclusiveInvocation() {
 		}
 		taskFactory := GetTaskFactory()
 		taskFactory.RemoveTaskByName(s.reusableInvocation.taskInfo.TaskId)
-	})
+	}, taskPriority(s.reusableInvocation.taskInfo), s.reusableInvocation.taskInfo.CommandId)
+	if err != nil {
+		taskFactory.RemoveTaskByName(s.reusableInvocation.taskInfo.TaskId)
+		invocateLogger.WithError(err).Errorln("Invocation rejected by task pool")
+		reportRejectedTask(s.reusableInvocation, err)
+		return
+	}
 	invocateLogger.Info("Scheduled new pending or running invocation")
 }
 
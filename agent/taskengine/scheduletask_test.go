@@ -4,16 +4,27 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"reflect"
 	"testing"
 
 	"bou.ke/monkey"
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/models"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/timermanager"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 )
 
+// Metrics events are sent in goroutines which tests cannot wait for, thus no
+// sink is enabled to keep them off mocked HTTP transport of other tests
+func TestMain(m *testing.M) {
+	config.GetConfig().MetricsEvents.Sinks = nil
+	os.Exit(m.Run())
+}
+
 func TestEnableFetchingTask(t *testing.T) {
 	res := isEnabledFetchingTask()
 	assert.Equal(t, false, res)
@@ -125,17 +136,19 @@ func Test_fetchTasks(t *testing.T) {
 			if tt.name == "normal" {
 				monkey.Patch(FetchTaskList, func(reason FetchReason, taskId string, taskType int, isColdstart bool) *taskCollection {
 					return &taskCollection{
-						runInfos:     []RunTaskInfo{RunTaskInfo{}},
-						stopInfos:    []RunTaskInfo{RunTaskInfo{}},
-						testInfos:    []RunTaskInfo{RunTaskInfo{}},
-						sendFiles:    []SendFileTaskInfo{SendFileTaskInfo{}},
-						sessionInfos: []SessionTaskInfo{SessionTaskInfo{}},
+						runInfos:     []models.RunTaskInfo{models.RunTaskInfo{}},
+						stopInfos:    []models.RunTaskInfo{models.RunTaskInfo{}},
+						testInfos:    []models.RunTaskInfo{models.RunTaskInfo{}},
+						sendFiles:    []models.SendFileTaskInfo{models.SendFileTaskInfo{}},
+						sessionInfos: []models.SessionTaskInfo{models.SessionTaskInfo{}},
 					}
 				})
 			}
 			if got := fetchTasks(tt.args.reason, tt.args.taskId, tt.args.taskType, tt.args.isColdstart); got != tt.want {
 				t.Errorf("fetchTasks() = %v, want %v", got, tt.want)
 			}
+			waitPoolIdle(GetPool())
+			waitPoolIdle(GetPrecheckPool())
 		})
 	}
 }
@@ -145,7 +158,7 @@ func Test_dispatchRunTask(t *testing.T) {
 	defer util.NilRequest.Clear()
 	defer httpmock.DeactivateAndReset()
 	type args struct {
-		taskInfo RunTaskInfo
+		taskInfo models.RunTaskInfo
 	}
 	tests := []struct {
 		name string
@@ -154,7 +167,7 @@ func Test_dispatchRunTask(t *testing.T) {
 		{
 			name: "taskHasExist",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
 				},
 			},
@@ -162,27 +175,27 @@ func Test_dispatchRunTask(t *testing.T) {
 		{
 			name: "taskRepeatOnce",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskOnce,
+					Repeat: models.RunTaskOnce,
 				},
 			},
 		},
 		{
 			name: "taskPeriod",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskCron,
+					Repeat: models.RunTaskCron,
 				},
 			},
 		},
 		{
 			name: "taskUnknown",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskRepeatType("unknown"),
+					Repeat: models.RunTaskRepeatType("unknown"),
 				},
 			},
 		},
@@ -198,12 +211,13 @@ func Test_dispatchRunTask(t *testing.T) {
 				defer taskFactory.RemoveTaskByName(tt.args.taskInfo.TaskId)
 			} else if tt.name == "taskRepeatOnce" {
 				var t *Task
-				guard := monkey.PatchInstanceMethod(reflect.TypeOf(t), "Run", func(*Task) (presetWrapErrorCode, error) {
-					return wrapErrUnknownCommandType, errors.New("some error")
+				guard := monkey.PatchInstanceMethod(reflect.TypeOf(t), "Run", func(*Task) (taskerrors.ErrorCode, error) {
+					return taskerrors.WrapGeneralError, errors.New("some error")
 				})
 				defer guard.Unpatch()
 			}
 			dispatchRunTask(tt.args.taskInfo)
+			waitPoolIdle(GetPool())
 		})
 	}
 }
@@ -213,7 +227,7 @@ func Test_dispatchStopTask(t *testing.T) {
 	defer util.NilRequest.Clear()
 	defer httpmock.DeactivateAndReset()
 	type args struct {
-		taskInfo RunTaskInfo
+		taskInfo models.RunTaskInfo
 	}
 	tests := []struct {
 		name string
@@ -222,42 +236,46 @@ func Test_dispatchStopTask(t *testing.T) {
 		{
 			name: "taskHasExist",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskOnce,
+					Repeat: models.RunTaskOnce,
 				},
 			},
 		},
 		{
 			name: "taskRepeatOnce",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskOnce,
+					Repeat: models.RunTaskOnce,
 				},
 			},
 		},
 		{
 			name: "taskPeriod",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskCron,
+					Repeat: models.RunTaskCron,
 				},
 			},
 		},
 		{
 			name: "taskUnknown",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskRepeatType("unknown"),
+					Repeat: models.RunTaskRepeatType("unknown"),
 				},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			// Task created here has no process to cancel
+			var task *Task
+			guard := monkey.PatchInstanceMethod(reflect.TypeOf(task), "Cancel", func(*Task) {})
+			defer guard.Unpatch()
 			if tt.name == "taskHasExist" {
 				taskFactory := GetTaskFactory()
 				task := &Task{
@@ -265,10 +283,6 @@ func Test_dispatchStopTask(t *testing.T) {
 				}
 				taskFactory.AddTask(task)
 				defer taskFactory.RemoveTaskByName(tt.args.taskInfo.TaskId)
-			} else if tt.name == "taskRepeatOnce" {
-				var t *Task
-				guard := monkey.PatchInstanceMethod(reflect.TypeOf(t), "Cancel", func(*Task) {})
-				defer guard.Unpatch()
 			}
 			dispatchStopTask(tt.args.taskInfo)
 		})
@@ -280,7 +294,7 @@ func Test_dispatchTestTask(t *testing.T) {
 	defer util.NilRequest.Clear()
 	defer httpmock.DeactivateAndReset()
 	type args struct {
-		taskInfo RunTaskInfo
+		taskInfo models.RunTaskInfo
 	}
 	tests := []struct {
 		name string
@@ -288,27 +302,27 @@ func Test_dispatchTestTask(t *testing.T) {
 	}{{
 		name: "taskHasExist",
 		args: args{
-			taskInfo: RunTaskInfo{
+			taskInfo: models.RunTaskInfo{
 				TaskId: "abc",
-				Repeat: RunTaskOnce,
+				Repeat: models.RunTaskOnce,
 			},
 		},
 	},
 		{
 			name: "taskRepeatOnce",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskOnce,
+					Repeat: models.RunTaskOnce,
 				},
 			},
 		},
 		{
 			name: "taskUnknown",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
-					Repeat: RunTaskRepeatType("unknown"),
+					Repeat: models.RunTaskRepeatType("unknown"),
 				},
 			},
 		}, // TODO: Add test cases.
@@ -328,6 +342,7 @@ func Test_dispatchTestTask(t *testing.T) {
 				defer guard.Unpatch()
 			}
 			dispatchTestTask(tt.args.taskInfo)
+			waitPoolIdle(GetPrecheckPool())
 		})
 	}
 }
@@ -349,7 +364,7 @@ func TestPeriodicTaskSchedule_startExclusiveInvocation(t *testing.T) {
 			fields: fields{
 				timer: nil,
 				reusableInvocation: &Task{
-					taskInfo: RunTaskInfo{
+					taskInfo: models.RunTaskInfo{
 						TaskId: "abc",
 					},
 				},
@@ -360,7 +375,7 @@ func TestPeriodicTaskSchedule_startExclusiveInvocation(t *testing.T) {
 			fields: fields{
 				timer: nil,
 				reusableInvocation: &Task{
-					taskInfo: RunTaskInfo{
+					taskInfo: models.RunTaskInfo{
 						TaskId: "abc",
 					},
 				},
@@ -378,8 +393,8 @@ func TestPeriodicTaskSchedule_startExclusiveInvocation(t *testing.T) {
 				defer taskFactory.RemoveTaskByName(tt.fields.reusableInvocation.taskInfo.TaskId)
 			} else if tt.name == "normal" {
 				var t *Task
-				guard := monkey.PatchInstanceMethod(reflect.TypeOf(t), "Run", func(*Task) (presetWrapErrorCode, error) {
-					return wrapErrUnknownCommandType, errors.New("some error")
+				guard := monkey.PatchInstanceMethod(reflect.TypeOf(t), "Run", func(*Task) (taskerrors.ErrorCode, error) {
+					return taskerrors.WrapGeneralError, errors.New("some error")
 				})
 				defer guard.Unpatch()
 			}
@@ -388,6 +403,7 @@ func TestPeriodicTaskSchedule_startExclusiveInvocation(t *testing.T) {
 				reusableInvocation: tt.fields.reusableInvocation,
 			}
 			s.startExclusiveInvocation()
+			waitPoolIdle(GetPool())
 		})
 	}
 }
@@ -397,7 +413,7 @@ func Test_schedulePeriodicTask(t *testing.T) {
 	defer util.NilRequest.Clear()
 	defer httpmock.DeactivateAndReset()
 	type args struct {
-		taskInfo RunTaskInfo
+		taskInfo models.RunTaskInfo
 	}
 	tests := []struct {
 		name    string
@@ -411,7 +427,7 @@ func Test_schedulePeriodicTask(t *testing.T) {
 		{
 			name: "taskExist",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
 				},
 			},
@@ -420,7 +436,7 @@ func Test_schedulePeriodicTask(t *testing.T) {
 		{
 			name: "normal",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
 					Cronat: "0 0 0 1 1 1",
 				},
@@ -433,6 +449,9 @@ func Test_schedulePeriodicTask(t *testing.T) {
 			if tt.name == "TimerManagerNotInitialized" {
 				guard := monkey.Patch(timermanager.GetTimerManager, func() *timermanager.TimerManager { return nil })
 				defer guard.Unpatch()
+				if timermanager.GetTimerManager() != nil {
+					t.Skip("GetTimerManager is inlined, run with -gcflags=-l to patch it")
+				}
 			} else if tt.name == "taskExist" {
 				timermanager.InitTimerManager()
 				_periodicTaskSchedulesLock.Lock()
@@ -466,7 +485,7 @@ func Test_cancelPeriodicTask(t *testing.T) {
 	defer util.NilRequest.Clear()
 	defer httpmock.DeactivateAndReset()
 	type args struct {
-		taskInfo RunTaskInfo
+		taskInfo models.RunTaskInfo
 	}
 	tests := []struct {
 		name    string
@@ -480,16 +499,16 @@ func Test_cancelPeriodicTask(t *testing.T) {
 		{
 			name: "taskNotExist",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
 				},
 			},
-			wantErr: true,
+			wantErr: false,
 		},
 		{
 			name: "cancleTask",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
 				},
 			},
@@ -498,7 +517,7 @@ func Test_cancelPeriodicTask(t *testing.T) {
 		{
 			name: "noNeedCancelTask",
 			args: args{
-				taskInfo: RunTaskInfo{
+				taskInfo: models.RunTaskInfo{
 					TaskId: "abc",
 				},
 			},
@@ -510,6 +529,9 @@ func Test_cancelPeriodicTask(t *testing.T) {
 			if tt.name == "TimerManagerNotInitialized" {
 				guard := monkey.Patch(timermanager.GetTimerManager, func() *timermanager.TimerManager { return nil })
 				defer guard.Unpatch()
+				if timermanager.GetTimerManager() != nil {
+					t.Skip("GetTimerManager is inlined, run with -gcflags=-l to patch it")
+				}
 			} else if tt.name == "taskNotExist" {
 				timermanager.InitTimerManager()
 			} else if tt.name == "cancleTask" {
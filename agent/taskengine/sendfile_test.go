@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"bou.ke/monkey"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/models"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
 	"github.com/jarcoal/httpmock"
 )
@@ -18,7 +19,7 @@ func TestSendFileFinished(t *testing.T) {
 	})
 	defer guard.Unpatch()
 	type args struct {
-		sendFile SendFileTaskInfo
+		sendFile models.SendFileTaskInfo
 		status   int
 	}
 	tests := []struct {
@@ -28,7 +29,7 @@ func TestSendFileFinished(t *testing.T) {
 		{
 			name: "status-success",
 			args: args{
-				sendFile: SendFileTaskInfo{
+				sendFile: models.SendFileTaskInfo{
 					TaskID: "abc",
 				},
 				status: ESuccess,
@@ -37,7 +38,7 @@ func TestSendFileFinished(t *testing.T) {
 		{
 			name: "status-fail",
 			args: args{
-				sendFile: SendFileTaskInfo{
+				sendFile: models.SendFileTaskInfo{
 					TaskID: "abc",
 				},
 				status: EFileCreateFail,
@@ -60,7 +61,7 @@ func TestSendFileInvalid(t *testing.T) {
 	})
 	defer guard.Unpatch()
 	type args struct {
-		sendFile SendFileTaskInfo
+		sendFile models.SendFileTaskInfo
 		status   int
 	}
 	type TT struct {
@@ -68,7 +69,7 @@ func TestSendFileInvalid(t *testing.T) {
 		args args
 	}
 	tests := []TT{}
-	sendFile := SendFileTaskInfo{
+	sendFile := models.SendFileTaskInfo{
 		Name:      "abc",
 		Signature: "signature",
 		Mode:      "mode",
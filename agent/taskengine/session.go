@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/aliyun/aliyun_assist_client/agent/config"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/metrics"
 	"github.com/aliyun/aliyun_assist_client/agent/session/channel"
@@ -25,18 +26,23 @@ type SessionTask struct{
 	username   string
 	passwordName string
 	portNumber string
+	targetHost string
+	multiplex  bool
+	dynamic    bool
 	sessionChannel      *channel.SessionChannel
 	shellPlugin         *shell.ShellPlugin
 	portPlugin         *port.PortPlugin
 	cancelFlag         util.CancelFlag
 	flowLimit	int
+	idleTimeout	int
+	maxDuration	int
 }
 
 
 func NewSessionTask(sessionId string,
 	                websocketUrl string,
 	                taskId string, cmdContent string, username string, passwordName string,
-	                portNumber string, flowLimit int) *SessionTask{
+	                portNumber string, targetHost string, multiplex bool, dynamic bool, flowLimit int, idleTimeout int, maxDuration int) *SessionTask{
 	task := &SessionTask{
 		sessionId:sessionId,
 		taskId:taskId,
@@ -46,7 +52,12 @@ func NewSessionTask(sessionId string,
 		username:username,
 		cancelFlag: util.NewChanneledCancelFlag(),
 		portNumber:portNumber,
+		targetHost:targetHost,
+		multiplex:multiplex,
+		dynamic:dynamic,
 		flowLimit: flowLimit,
+		idleTimeout: idleTimeout,
+		maxDuration: maxDuration,
 	}
 	return task
 }
@@ -74,12 +85,40 @@ func ReportSessionResult(taskID string, status string) {
 }
 
 func (sessionTask *SessionTask) isPortForwardTask() bool {
-	if sessionTask.portNumber != "" {
+	if sessionTask.portNumber != "" || sessionTask.dynamic {
 		return true;
 	}
 	return false;
 }
 
+// limits returns limits in local configuration for the type of session, which
+// are tightened by those specified by server for the session. Server is not
+// allowed to loosen limits set by administrator of the instance.
+func (sessionTask *SessionTask) limits() channel.SessionLimits {
+	sessionConfig := config.GetConfig().Session
+	limitsConfig := sessionConfig.Shell
+	if sessionTask.isPortForwardTask() {
+		limitsConfig = sessionConfig.PortForward
+	}
+	idleTimeout := stricterLimit(limitsConfig.IdleTimeoutSeconds, sessionTask.idleTimeout)
+	maxDuration := stricterLimit(limitsConfig.MaxDurationSeconds, sessionTask.maxDuration)
+	return channel.SessionLimits{
+		IdleTimeout:   time.Duration(idleTimeout) * time.Second,
+		MaxDuration:   time.Duration(maxDuration) * time.Second,
+		WarningPeriod: time.Duration(sessionConfig.WarningPeriodSeconds) * time.Second,
+		WarnByStatus:  sessionTask.isPortForwardTask(),
+	}
+}
+
+// stricterLimit returns the smaller one of limits in seconds, where limit not
+// greater than 0 is not specified
+func stricterLimit(local int, server int) int {
+	if server > 0 && (local <= 0 || server < local) {
+		return server
+	}
+	return local
+}
+
 func (sessionTask *SessionTask) runTask() (string, error){
 	ret := GetSessionFactory().ContainsTask(sessionTask.sessionId)
 	if ret == true {
@@ -88,7 +127,7 @@ func (sessionTask *SessionTask) runTask() (string, error){
 	}
 	if sessionTask.isPortForwardTask() {
 		port_num,_ := strconv.Atoi(sessionTask.portNumber)
-		sessionTask.portPlugin= port.NewPortPlugin(sessionTask.sessionId, port_num, sessionTask.flowLimit)
+		sessionTask.portPlugin= port.NewPortPlugin(sessionTask.sessionId, sessionTask.targetHost, port_num, sessionTask.flowLimit, sessionTask.multiplex, sessionTask.dynamic)
 	} else {
 		sessionTask.shellPlugin = shell.NewShellPlugin(sessionTask.sessionId, sessionTask.cmdContent, sessionTask.username, sessionTask.passwordName, sessionTask.flowLimit)
 	}
@@ -103,11 +142,16 @@ func (sessionTask *SessionTask) runTask() (string, error){
 	log.GetLogger().Infoln("url: ", websocketUrl)
 	var err error;
 	var session_channel *channel.SessionChannel
+	limits := sessionTask.limits()
+	log.GetLogger().Infof("session %s idle timeout %v max duration %v", sessionTask.sessionId, limits.IdleTimeout, limits.MaxDuration)
 	if sessionTask.isPortForwardTask() {
-		session_channel, err = channel.NewSessionChannel(websocketUrl, sessionTask.sessionId, sessionTask.portPlugin.InputStreamMessageHandler, sessionTask.cancelFlag)
+		session_channel, err = channel.NewSessionChannel(websocketUrl, sessionTask.sessionId, sessionTask.portPlugin.InputStreamMessageHandler, sessionTask.cancelFlag, limits)
+		if err == nil && (sessionTask.multiplex || sessionTask.dynamic) {
+			session_channel.AcceptStreamMessages()
+		}
 
 	} else {
-		session_channel, err = channel.NewSessionChannel(websocketUrl, sessionTask.sessionId, sessionTask.shellPlugin.InputStreamMessageHandler, sessionTask.cancelFlag)
+		session_channel, err = channel.NewSessionChannel(websocketUrl, sessionTask.sessionId, sessionTask.shellPlugin.InputStreamMessageHandler, sessionTask.cancelFlag, limits)
 		sessionTask.sessionChannel = session_channel
 	}
 
@@ -139,13 +183,10 @@ func (sessionTask *SessionTask) runTask() (string, error){
 		done <- 1
 	}()
 
-	select {
-		case <-done:
-			log.GetLogger().Println("shell end", sessionTask.sessionId)
-		case <-time.After(time.Duration(3600*3) * time.Second):
-			log.GetLogger().Println("shell timeout", sessionTask.sessionId)
-			error_code = shell.Timeout
-	}
+	// Session channel cancels the plugin once idle timeout or max duration
+	// reached, which returns shell.Timeout then
+	<-done
+	log.GetLogger().Println("shell end", sessionTask.sessionId)
 
 	return error_code,nil
 }
@@ -160,7 +201,12 @@ func DoSessionTask(tasks [] models.SessionTaskInfo) {
 				s.Username,
 				s.Password,
 				s.PortNumber,
-				s.FlowLimit)
+				s.TargetServer,
+				s.Multiplex,
+				s.Dynamic,
+				s.FlowLimit,
+				s.IdleTimeout,
+				s.MaxDuration)
 			session.RunTask(s.SessionId)
 		}
 	}()
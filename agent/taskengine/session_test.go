@@ -0,0 +1,31 @@
+package taskengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+)
+
+func TestSessionLimits(t *testing.T) {
+	shellConfig := config.GetConfig().Session.Shell
+	localIdle := time.Duration(shellConfig.IdleTimeoutSeconds) * time.Second
+	localDuration := time.Duration(shellConfig.MaxDurationSeconds) * time.Second
+
+	// Local configuration is used if server specifies nothing
+	limits := (&SessionTask{}).limits()
+	assert.Equal(t, localIdle, limits.IdleTimeout)
+	assert.Equal(t, localDuration, limits.MaxDuration)
+
+	// Server may only tighten limits
+	limits = (&SessionTask{idleTimeout: 10, maxDuration: shellConfig.MaxDurationSeconds + 60}).limits()
+	assert.Equal(t, 10*time.Second, limits.IdleTimeout)
+	assert.Equal(t, localDuration, limits.MaxDuration)
+
+	assert.Equal(t, 30, stricterLimit(0, 30))
+	assert.Equal(t, 30, stricterLimit(30, 0))
+	assert.Equal(t, 20, stricterLimit(30, 20))
+	assert.Equal(t, 20, stricterLimit(20, 30))
+}
@@ -62,4 +62,14 @@ func (t *SessionFactory) IsAnyTaskRunning() bool {
 	return len(t.tasks) > 0
 }
 
+// GetAllTasks returns snapshot of sessions registered in SessionFactory
+func (t *SessionFactory) GetAllTasks() []*SessionTask {
+	t.m.Lock()
+	defer t.m.Unlock()
 
+	tasks := make([]*SessionTask, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
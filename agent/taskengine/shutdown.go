@@ -0,0 +1,94 @@
+package taskengine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util/atomicutil"
+)
+
+const (
+	drainCheckInterval = 200 * time.Millisecond
+	// sessionCancelTimeout bounds waiting for canceled sessions to report
+	// their final state
+	sessionCancelTimeout = 5 * time.Second
+)
+
+var _shuttingDown atomicutil.AtomicBoolean
+
+// IsShuttingDown returns true once agent starts to shut down
+func IsShuttingDown() bool {
+	return _shuttingDown.IsSet()
+}
+
+// Shutdown refuses fetching new tasks and waits for running tasks and sessions
+// to finish within gracePeriod. Those still running afterwards are canceled,
+// and their final states are reported before Shutdown returns.
+func Shutdown(gracePeriod time.Duration) {
+	shutdownLogger := log.GetLogger().WithField("Phase", "Shutdown")
+	_shuttingDown.Set()
+	DisableFetchingTask()
+	shutdownLogger.Info("Fetching tasks is disabled")
+
+	if waitUntil(gracePeriod, isIdle) {
+		shutdownLogger.Info("All tasks and sessions finished")
+		return
+	}
+
+	tasks := GetTaskFactory().GetAllTasks()
+	sessions := GetSessionFactory().GetAllTasks()
+	shutdownLogger.WithFields(logrus.Fields{
+		"tasks":    len(tasks),
+		"sessions": len(sessions),
+	}).Warningf("Cancel tasks and sessions still running after grace period %s", gracePeriod)
+
+	// Task.Cancel reports canceled state synchronously, thus tasks are
+	// canceled concurrently
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task *Task) {
+			defer wg.Done()
+			log.GetLogger().WithFields(logrus.Fields{
+				"TaskId": task.taskInfo.TaskId,
+				"Phase":  "Shutdown",
+			}).Info("Cancel task since agent is stopping")
+			task.Cancel()
+		}(task)
+	}
+	for _, session := range sessions {
+		session.StopTask()
+	}
+	wg.Wait()
+
+	// Canceled sessions report their final state and deregister themselves
+	if !waitUntil(sessionCancelTimeout, func() bool {
+		return !GetSessionFactory().IsAnyTaskRunning()
+	}) {
+		shutdownLogger.Warning("Some sessions did not finish after canceled")
+	}
+	shutdownLogger.Info("Canceled all tasks and sessions")
+}
+
+// isIdle returns true when no task is being fetched or running and no session
+// is open
+func isIdle() bool {
+	return FetchingTaskCounter.Load() == 0 &&
+		!GetTaskFactory().IsAnyTaskRunning() &&
+		!GetSessionFactory().IsAnyTaskRunning()
+}
+
+// waitUntil polls condition until it is satisfied or timeout
+func waitUntil(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(drainCheckInterval)
+	}
+	return true
+}
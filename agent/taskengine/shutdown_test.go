@@ -0,0 +1,27 @@
+package taskengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownWhenIdle(t *testing.T) {
+	EnableFetchingTask()
+	start := time.Now()
+	Shutdown(10 * time.Second)
+	assert.False(t, isEnabledFetchingTask())
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestWaitUntil(t *testing.T) {
+	assert.True(t, waitUntil(0, func() bool { return true }))
+	assert.False(t, waitUntil(0, func() bool { return false }))
+
+	deadline := time.Now().Add(500 * time.Millisecond)
+	assert.True(t, waitUntil(5*time.Second, func() bool {
+		return time.Now().After(deadline)
+	}))
+	assert.False(t, waitUntil(300*time.Millisecond, func() bool { return false }))
+}
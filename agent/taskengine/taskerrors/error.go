@@ -33,6 +33,10 @@ const (
 	wrapErrContainerNotFoundById
 	wrapErrManyContainersFoundById
 	wrapErrContainerNotRunning
+	// WrapErrTaskInterrupted is reported when the agent restarted during the
+	// invocation, thus exit code and remaining output are lost
+	WrapErrTaskInterrupted
+	wrapErrTaskPoolFull
 )
 
 func (c ErrorCode) String() string {
@@ -92,6 +96,15 @@ func NewSetExecutablePermissionError(cause error) ExecutionError {
 	}
 }
 
+func NewTaskPoolFullError(maxPendingTasks int) ExecutionError {
+	return &baseError{
+		categoryCode: wrapErrTaskPoolFull,
+		category: "TaskPoolFull",
+		Description: fmt.Sprintf("Too many tasks are pending, the limit is %d", maxPendingTasks),
+		cause: nil,
+	}
+}
+
 func NewExecuteScriptError(cause error) ExecutionError {
 	return &baseError{
 		categoryCode: WrapErrExecuteScriptFailed,
@@ -81,3 +81,15 @@ func (t *TaskFactory) IsAnyNonPeriodicTaskRunning() bool {
 	}
 	return false
 }
+
+// GetAllTasks returns snapshot of tasks registered in TaskFactory
+func (t *TaskFactory) GetAllTasks() []*Task {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	tasks := make([]*Task, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
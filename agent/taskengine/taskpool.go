@@ -1,17 +1,44 @@
 package taskengine
 
-import "sync"
+import (
+	"sync"
 
-const maxPendingTasks  = 50
-const maxRunningTasks  = 10
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/taskerrors"
+)
 
 type TaskFunction func()
 
+// TaskPriority decides the order of pending tasks to be run
+type TaskPriority int
+
+const (
+	PriorityNormal TaskPriority = iota
+	// PriorityHigh tasks are run before all pending normal priority tasks,
+	// and by reserved workers as well
+	PriorityHigh
+)
+
 var poolTask *taskPool
 var lockPool    sync.Mutex
 
+type pooledTask struct {
+	run TaskFunction
+	commandId string
+}
+
 type taskPool struct {
-	taskQueue   chan TaskFunction
+	lock sync.Mutex
+	cond *sync.Cond
+	// Pending tasks of each priority
+	highQueue []pooledTask
+	normalQueue []pooledTask
+	// Running tasks in total and of each command
+	running int
+	runningPerCommand map[string]int
+
+	maxPendingTasks int
+	maxConcurrencyPerCommand int
 }
 
 func GetPool() *taskPool {
@@ -19,36 +46,123 @@ func GetPool() *taskPool {
 	defer lockPool.Unlock()
 
 	if poolTask == nil {
-		poolTask = newTaskPool()
+		poolTask = newTaskPool(config.GetConfig().TaskPool)
 	}
 
 	return poolTask
 }
 
-func newTaskPool() *taskPool {
+func newTaskPool(poolConfig config.TaskPoolConfig) *taskPool {
 	pool := &taskPool {
-		taskQueue: make(chan TaskFunction, maxPendingTasks),
+		runningPerCommand: make(map[string]int),
+		maxPendingTasks: poolConfig.MaxPendingTasks,
+		maxConcurrencyPerCommand: poolConfig.MaxConcurrencyPerCommand,
 	}
-	pool.start()
+	pool.cond = sync.NewCond(&pool.lock)
+	pool.start(poolConfig.MaxRunningTasks, poolConfig.ReservedHighPriorityTasks)
 	return pool
 }
 
-func (p *taskPool) start() {
+func (p *taskPool) start(maxRunningTasks int, reservedHighPriorityTasks int) {
 	for i := 0; i < maxRunningTasks; i++ {
 		go func() {
-			p.slave()
+			p.slave(false)
 		}()
 	}
+	for i := 0; i < reservedHighPriorityTasks; i++ {
+		go func() {
+			p.slave(true)
+		}()
+	}
+}
+
+func (p *taskPool) slave(highPriorityOnly bool) {
+	for {
+		p.lock.Lock()
+		task, ok := p.next(highPriorityOnly)
+		for !ok {
+			p.cond.Wait()
+			task, ok = p.next(highPriorityOnly)
+		}
+		p.running++
+		if task.commandId != "" {
+			p.runningPerCommand[task.commandId]++
+		}
+		p.lock.Unlock()
+
+		task.run()
+
+		p.lock.Lock()
+		p.running--
+		if task.commandId != "" {
+			p.runningPerCommand[task.commandId]--
+			if p.runningPerCommand[task.commandId] <= 0 {
+				delete(p.runningPerCommand, task.commandId)
+			}
+		}
+		p.lock.Unlock()
+		// Pending tasks of the command may be able to run now
+		p.cond.Broadcast()
+	}
+}
+
+// next picks the first runnable pending task, high priority first. It MUST be
+// called with lock held.
+func (p *taskPool) next(highPriorityOnly bool) (pooledTask, bool) {
+	if task, ok := p.pick(&p.highQueue); ok {
+		return task, true
+	}
+	if highPriorityOnly {
+		return pooledTask{}, false
+	}
+	return p.pick(&p.normalQueue)
 }
 
-func (p *taskPool) slave() {
-	for task := range p.taskQueue {
-		task()
+func (p *taskPool) pick(queue *[]pooledTask) (pooledTask, bool) {
+	for i, task := range *queue {
+		if task.commandId != "" && p.maxConcurrencyPerCommand > 0 &&
+			p.runningPerCommand[task.commandId] >= p.maxConcurrencyPerCommand {
+			continue
+		}
+		*queue = append((*queue)[:i], (*queue)[i+1:]...)
+		return task, true
+	}
+	return pooledTask{}, false
+}
+
+// RunTask queues task of normal priority without concurrency limit of command
+func (pool *taskPool) RunTask(task TaskFunction) error {
+	return pool.RunTaskWithPriority(task, PriorityNormal, "")
+}
+
+// RunTaskWithPriority queues task to be run. Tasks of the same non-empty
+// commandId are limited by maxConcurrencyPerCommand. TaskPoolFull error is
+// returned without blocking when too many tasks are pending.
+func (pool *taskPool) RunTaskWithPriority(task TaskFunction, priority TaskPriority, commandId string) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if len(pool.highQueue) + len(pool.normalQueue) >= pool.maxPendingTasks {
+		return taskerrors.NewTaskPoolFullError(pool.maxPendingTasks)
+	}
+	pooled := pooledTask{
+		run: task,
+		commandId: commandId,
+	}
+	if priority == PriorityHigh {
+		pool.highQueue = append(pool.highQueue, pooled)
+	} else {
+		pool.normalQueue = append(pool.normalQueue, pooled)
 	}
+	pool.cond.Broadcast()
+	return nil
 }
 
-func (pool *taskPool) RunTask(task TaskFunction) {
-	pool.taskQueue <- task
+// PendingTasks returns the number of tasks waiting to be run
+func (pool *taskPool) PendingTasks() int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return len(pool.highQueue) + len(pool.normalQueue)
 }
 
 // Another global task pool for pre-checking tasks with limited concurrency
@@ -63,7 +177,7 @@ func GetPrecheckPool() *taskPool {
 		defer _precheckPoolLock.Unlock()
 
 		if _precheckPool == nil {
-			_precheckPool = newTaskPool()
+			_precheckPool = newTaskPool(config.GetConfig().TaskPool)
 		}
 	}
 
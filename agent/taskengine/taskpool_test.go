@@ -1,11 +1,129 @@
 package taskengine
 
-import "testing"
+import (
+	"sync"
+	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/agent/config"
+)
+
+// waitPoolIdle waits until all queued tasks of pool have finished, thus they
+// would not outlive mocks of the test
+func waitPoolIdle(pool *taskPool) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	for pool.running > 0 || len(pool.highQueue)+len(pool.normalQueue) > 0 {
+		pool.cond.Wait()
+	}
+}
 
 func TestAddTask(t *testing.T) {
  // pool := GetPool()
 
  // pool.RunTask()
 
-}
\ No newline at end of file
+}
+
+func TestTaskPoolRejectOverflow(t *testing.T) {
+	pool := newTaskPool(config.TaskPoolConfig{
+		MaxRunningTasks: 1,
+		MaxPendingTasks: 1,
+	})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	assert.NoError(t, pool.RunTask(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	assert.NoError(t, pool.RunTask(func() {}))
+	assert.Error(t, pool.RunTask(func() {}), "should be rejected when pending queue is full")
+	close(release)
+}
+
+func TestTaskPoolPriority(t *testing.T) {
+	pool := newTaskPool(config.TaskPoolConfig{
+		MaxRunningTasks: 1,
+		MaxPendingTasks: 10,
+	})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.RunTask(func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	var lock sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	record := func(name string) TaskFunction {
+		wg.Add(1)
+		return func() {
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			wg.Done()
+		}
+	}
+	pool.RunTaskWithPriority(record("normal"), PriorityNormal, "")
+	pool.RunTaskWithPriority(record("high"), PriorityHigh, "")
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []string{"high", "normal"}, order)
+}
+
+func TestTaskPoolReservedHighPriority(t *testing.T) {
+	pool := newTaskPool(config.TaskPoolConfig{
+		MaxRunningTasks:           1,
+		MaxPendingTasks:           10,
+		ReservedHighPriorityTasks: 1,
+	})
+	release := make(chan struct{})
+	defer close(release)
+	pool.RunTask(func() {
+		<-release
+	})
+
+	done := make(chan struct{})
+	pool.RunTaskWithPriority(func() {
+		close(done)
+	}, PriorityHigh, "")
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("high priority task should be run by reserved worker")
+	}
+}
+
+func TestTaskPoolConcurrencyPerCommand(t *testing.T) {
+	pool := newTaskPool(config.TaskPoolConfig{
+		MaxRunningTasks:          3,
+		MaxPendingTasks:          10,
+		MaxConcurrencyPerCommand: 1,
+	})
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		pool.RunTaskWithPriority(func() {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			wg.Done()
+		}, PriorityNormal, "c-1")
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxRunning)
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/aliyun/aliyun_assist_client/thirdparty/cronexpr"
 )
 
 func TestNewCronScheduled(t *testing.T) {
@@ -13,19 +15,20 @@ func TestNewCronScheduled(t *testing.T) {
 	scheduled, err := NewCronScheduled(CronExpression)
 	assert.NoError(t, err, "NewCronScheduled should correctly parse specified cron expression")
 
-	expectedSchedule, err := _cronExpressionParser.Parse(CronExpression)
-	assert.NoError(t, err, "_cronExpressionParser.Parse should not raise error")
+	// Wildcard year field is appended to classic style of cron expression
+	expectedSchedule, err := cronexpr.Parse(CronExpression + " *")
+	assert.NoError(t, err, "cronexpr.Parse should not raise error")
 
 	testTime := time.Now()
 	assert.Exactly(t, expectedSchedule.Next(testTime),
-		scheduled.schedule.Next(testTime),
+		scheduled.expression.Next(testTime),
 		"CronScheduled should generate same time of next schedule for same cron expression")
 }
 
 func TestNextRunFrom(t *testing.T) {
 	const CronExpression = "*/20 * * * * ?"
-	expectedSchedule, err := _cronExpressionParser.Parse(CronExpression)
-	assert.NoError(t, err, "_cronExpressionParser.Parse should not raise error")
+	expectedSchedule, err := cronexpr.Parse(CronExpression + " *")
+	assert.NoError(t, err, "cronexpr.Parse should not raise error")
 
 	scheduled, _ := NewCronScheduled(CronExpression)
 	testTime := time.Now()
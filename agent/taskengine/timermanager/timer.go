@@ -104,8 +104,13 @@ func (t *Timer) SkipWaiting() {
 	t.skipWait <- true
 }
 
+// Stop quits the timer. It never blocks even if the timer has been stopped or
+// not run yet.
 func (t *Timer) Stop() {
-	t.quit <- true
+	select {
+	case t.quit <- true:
+	default:
+	}
 }
 
 func (t *Timer) setRunning(state bool) {
@@ -45,16 +45,25 @@ func (m *TimerManager) Start() {
 	return
 }
 
+// Stop stops and removes all registered timers
 func (m *TimerManager) Stop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	for t := range m.timers {
 		t.Stop()
+		delete(m.timers, t)
 	}
+}
 
+// GetAllTimers returns snapshot of registered timers
+func (m *TimerManager) GetAllTimers() []*Timer {
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	timers := make([]*Timer, 0, len(m.timers))
 	for t := range m.timers {
-		delete(m.timers, t)
+		timers = append(timers, t)
 	}
+	return timers
 }
 
 func (m *TimerManager) CreateCronTimer(callback TimerCallback, cronat string) (*Timer, error) {
@@ -92,6 +92,22 @@ func GetCrossVersionConfigPath() (string, error) {
 	return crossVersionConfigDir, nil
 }
 
+// GetSessionRecordingPath returns directory of session recordings shared by
+// all installed versions
+func GetSessionRecordingPath() (string, error) {
+	crossVersionDir, err := getCrossVersionDir()
+	if err != nil {
+		return "", err
+	}
+
+	sessionRecordingDir := filepath.Join(crossVersionDir, "session_recordings")
+	if err := MakeSurePath(sessionRecordingDir); err != nil {
+		return "", err
+	}
+
+	return sessionRecordingDir, nil
+}
+
 func GetSelfhostedPath() (string, error) {
 	var cur string
 	var err error
@@ -5,9 +5,11 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"io"
 )
@@ -40,4 +42,28 @@ func RsaSignWithMD5(data []byte, keyBytes []byte) []byte {
 	}
 
 	return signature
-}
\ No newline at end of file
+}
+
+// RsaVerifyWithSHA256 verifies base64 encoded PKCS#1 v1.5 signature of data
+// signed by private key of the PEM encoded public key
+func RsaVerifyWithSHA256(data []byte, signatureBase64 string, publicKeyBytes []byte) error {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return errors.New("invalid public key")
+	}
+	parsedKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := parsedKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("public key is not RSA key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature)
+}
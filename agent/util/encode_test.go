@@ -1,6 +1,13 @@
 package util
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -40,4 +47,19 @@ func TestRsaSignWithSha256(t *testing.T) {
 func TestRsaSignWithMD5(t *testing.T) {
 	value := RsaSign("changfeng", test_pri_key)
 	assert.Equal(t, value, `dpafdQKSIKsZmpFS3V8Wm94N8YBCW14Zix2c4JH2tZ+mTnL1ZIW4kuH0xx68WQM1ETKww6zuDKzvLayjv6KWIcIHBMm5SJCL//MWWyt4ocEc22jdAdoRIL/WWT+4uI6r+Bi5bBE0liWVIBOzVqhxx0dAtBDPzHPgc67ekHsVvTQ=`)
-}
\ No newline at end of file
+}
+
+func TestRsaVerifyWithSHA256(t *testing.T) {
+	block, _ := pem.Decode([]byte(test_pri_key))
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	assert.NoError(t, err)
+	hashed := sha256.Sum256([]byte("kick_vm agent stop"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	assert.NoError(t, err)
+	signatureBase64 := base64.StdEncoding.EncodeToString(signature)
+
+	assert.NoError(t, RsaVerifyWithSHA256([]byte("kick_vm agent stop"), signatureBase64, []byte(test_pub_key)))
+	assert.Error(t, RsaVerifyWithSHA256([]byte("kick_vm agent remove"), signatureBase64, []byte(test_pub_key)))
+	assert.Error(t, RsaVerifyWithSHA256([]byte("kick_vm agent stop"), "invalid", []byte(test_pub_key)))
+	assert.Error(t, RsaVerifyWithSHA256([]byte("kick_vm agent stop"), signatureBase64, []byte("invalid")))
+}
@@ -13,11 +13,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/kirinlabs/HttpRequest"
 	"github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
@@ -25,7 +23,6 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/util/atomicutil"
 	"github.com/aliyun/aliyun_assist_client/agent/util/osutil"
-	"github.com/aliyun/aliyun_assist_client/agent/util/timetool"
 	"github.com/aliyun/aliyun_assist_client/agent/version"
 )
 
@@ -83,6 +80,13 @@ func (e *HttpErrorCode) GetCode() int {
 	return e.errorCode
 }
 
+// IsRetryable tells whether request may succeed later, i.e., server errors,
+// request timeout and rate limiting. Other client errors mean the request is
+// rejected and retrying would not help.
+func (e *HttpErrorCode) IsRetryable() bool {
+	return e.errorCode >= 500 || e.errorCode == http.StatusRequestTimeout || e.errorCode == http.StatusTooManyRequests
+}
+
 func InitUserAgentValue() {
 	UserAgentValue = fmt.Sprintf("%s_%s/%s", osutil.GetOsType(), osutil.GetOsArch(), version.AssistVersion)
 }
@@ -130,7 +134,10 @@ func HttpGetWithTimeout(url string, timeout time.Duration, noLog bool) (error, s
 	})
 
 	if IsHybrid() {
-		addHttpHeads(req)
+		if err := addHttpHeads(req); err != nil {
+			log.GetLogger().Infoln(url, err)
+			return err, ""
+		}
 	}
 
 	// Add user-agent header
@@ -160,31 +167,7 @@ func HttpGetWithTimeout(url string, timeout time.Duration, noLog bool) (error, s
 	return err, content
 }
 
-func addHttpHeads(req *HttpRequest.Request) {
-	u4 := uuid.New()
-	str_request_id := u4.String()
-
-	timestamp := timetool.GetAccurateTime()
-	str_timestamp := strconv.FormatInt(timestamp, 10)
-
-	var instance_id string
-	var path string
-	if IsSelfHosted() {
-		path, _ = GetSelfhostedPath()
-	} else {
-		path, _ = GetHybridPath()
-	}
-
-	content, _ := ioutil.ReadFile(path + "/instance-id")
-	instance_id = string(content)
-
-	mid, _ := GetMachineID()
-
-	input := instance_id + mid + str_timestamp + str_request_id
-	pri_key, _ := ioutil.ReadFile(path + "/pri-key")
-	output := RsaSign(input, string(pri_key))
-	log.GetLogger().Infoln(input, output)
-
+func addHttpHeads(req *HttpRequest.Request) error {
 	internal_ip, err := osutil.ExternalIP()
 	if err == nil {
 		req.SetHeaders(map[string]string{
@@ -192,18 +175,12 @@ func addHttpHeads(req *HttpRequest.Request) {
 		})
 	}
 
-	req.SetHeaders(map[string]string{
-		"x-acs-instance-id": instance_id,
-	})
-	req.SetHeaders(map[string]string{
-		"x-acs-timestamp": str_timestamp, //这也是HttpRequest包的默认设置
-	})
-	req.SetHeaders(map[string]string{
-		"x-acs-request-id": str_request_id, //这也是HttpRequest包的默认设置
-	})
-	req.SetHeaders(map[string]string{
-		"x-acs-signature": output, //这也是HttpRequest包的默认设置
-	})
+	authHeaders, err := HybridAuthHeaders()
+	if err != nil {
+		return err
+	}
+	req.SetHeaders(authHeaders)
+	return nil
 }
 
 func HttpPost(url string, data string, contentType string) (string, error) {
@@ -223,7 +200,10 @@ func HttpPostWithTimeout(url string, data string, contentType string, timeout ti
 	})
 	//excude Hybrid instance id
 	if IsHybrid() {
-		addHttpHeads(req)
+		if err := addHttpHeads(req); err != nil {
+			log.GetLogger().Infoln(url, err)
+			return "", err
+		}
 	} else {
 		instance_id := GetInstanceId()
 
@@ -0,0 +1,421 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/util/machineid"
+	"github.com/aliyun/aliyun_assist_client/agent/util/timetool"
+)
+
+const (
+	// Plain private key written by previous versions, which is encrypted and
+	// removed when loaded
+	HybridPrivateKeyFile = "pri-key"
+	// Private key encrypted with machine-bound key
+	HybridEncryptedPrivateKeyFile = "pri-key.enc"
+	// HybridKeyPassphraseEnv specifies passphrase protecting private key when
+	// the OS provides no machine-bound key storage, otherwise key derived from
+	// machine id is used. The key is decrypted each time the agent starts,
+	// thus the variable must be set in environment of the agent service as
+	// well, not only when registering.
+	HybridKeyPassphraseEnv = "ALIYUN_ASSIST_KEY_PASSPHRASE"
+
+	keyProtectorPassphrase  = "passphrase"
+	keyProtectorMachineId   = "machine-id"
+	encryptedKeyFileVersion = 1
+	// Parameters of scrypt recommended for interactive logins as of 2017
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptKeyLen  = 32
+	scryptSaltLen = 16
+)
+
+var (
+	ErrHybridKeyNotFound = errors.New("private key of hybrid instance not found")
+	// ErrNoKeyProtector means private key cannot be saved encrypted, which is
+	// refused rather than saving it in plain
+	ErrNoKeyProtector = fmt.Errorf("neither machine-bound key storage nor machine id is available to protect private key, set %s in environment of agent service", HybridKeyPassphraseEnv)
+
+	// machineIdOf reads machine id of the OS, never the one cached in
+	// directory of credentials next to the encrypted key
+	machineIdOf = machineid.GetMachineID
+
+	// Private key is decrypted once and kept in memory
+	_hybridKey     string
+	_hybridKeyLock sync.Mutex
+)
+
+// encryptedKey is content of encrypted private key file
+type encryptedKey struct {
+	Version   int    `json:"version"`
+	Protector string `json:"protector"`
+	Salt      []byte `json:"salt,omitempty"`
+	// Public and private part of data key sealed by TPM
+	SealedPublic  []byte `json:"sealedPublic,omitempty"`
+	SealedPrivate []byte `json:"sealedPrivate,omitempty"`
+	Nonce         []byte `json:"nonce,omitempty"`
+	Ciphertext    []byte `json:"ciphertext"`
+}
+
+// keyProtector encrypts data with key bound to the machine
+type keyProtector interface {
+	Name() string
+	Seal(plaintext []byte) (*encryptedKey, error)
+	Open(sealed *encryptedKey) ([]byte, error)
+}
+
+// GetHybridCredentialPath returns directory of credentials of hybrid instance
+func GetHybridCredentialPath() (string, error) {
+	if IsSelfHosted() {
+		return GetSelfhostedPath()
+	}
+	return GetHybridPath()
+}
+
+// defaultKeyProtector prefers key storage provided by the OS, then passphrase
+// given by environment variable, and key derived from machine id at last.
+// ErrNoKeyProtector is returned if none is available.
+func defaultKeyProtector() (keyProtector, error) {
+	if protector := platformKeyProtector(); protector != nil {
+		return protector, nil
+	}
+	if os.Getenv(HybridKeyPassphraseEnv) != "" {
+		return &passphraseProtector{}, nil
+	}
+	if mid, err := machineIdOf(); err == nil && mid != "" {
+		return &machineIdProtector{}, nil
+	}
+	return nil, ErrNoKeyProtector
+}
+
+// CheckHybridKeyProtector checks private key could be saved encrypted, which
+// should be called before registering
+func CheckHybridKeyProtector() error {
+	_, err := defaultKeyProtector()
+	return err
+}
+
+func keyProtectorByName(name string) (keyProtector, error) {
+	if name == keyProtectorPassphrase {
+		return &passphraseProtector{}, nil
+	}
+	if name == keyProtectorMachineId {
+		return &machineIdProtector{}, nil
+	}
+	if protector := platformKeyProtector(); protector != nil && protector.Name() == name {
+		return protector, nil
+	}
+	return nil, fmt.Errorf("private key protector %s is not available", name)
+}
+
+// GetHybridPrivateKey returns PEM encoded private key of hybrid instance
+func GetHybridPrivateKey() (string, error) {
+	_hybridKeyLock.Lock()
+	defer _hybridKeyLock.Unlock()
+	if _hybridKey != "" {
+		return _hybridKey, nil
+	}
+	dir, err := GetHybridCredentialPath()
+	if err != nil {
+		return "", err
+	}
+	privateKey, err := loadHybridPrivateKey(dir)
+	if err != nil {
+		return "", err
+	}
+	_hybridKey = string(privateKey)
+	return _hybridKey, nil
+}
+
+func loadHybridPrivateKey(dir string) ([]byte, error) {
+	encryptedPath := filepath.Join(dir, HybridEncryptedPrivateKeyFile)
+	content, err := ioutil.ReadFile(encryptedPath)
+	if err == nil {
+		var sealed encryptedKey
+		if err := json.Unmarshal(content, &sealed); err != nil {
+			return nil, fmt.Errorf("invalid encrypted private key: %w", err)
+		}
+		protector, err := keyProtectorByName(sealed.Protector)
+		if err != nil {
+			return nil, err
+		}
+		return protector.Open(&sealed)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Encrypt plain private key left by previous versions
+	plainPath := filepath.Join(dir, HybridPrivateKeyFile)
+	privateKey, err := ioutil.ReadFile(plainPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrHybridKeyNotFound
+		}
+		return nil, err
+	}
+	if err := writeHybridPrivateKey(dir, HybridEncryptedPrivateKeyFile, privateKey); err != nil {
+		log.GetLogger().WithError(err).Errorf("Private key of hybrid instance is still stored in plain at %s, failed to encrypt it", plainPath)
+		return privateKey, nil
+	}
+	if err := os.Remove(plainPath); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to remove plain private key of hybrid instance")
+	}
+	log.GetLogger().Infoln("Plain private key of hybrid instance has been encrypted")
+	return privateKey, nil
+}
+
+// SaveHybridPrivateKey encrypts private key into directory of credentials
+// and replaces the key kept in memory
+func SaveHybridPrivateKey(dir string, privateKey []byte) error {
+	_hybridKeyLock.Lock()
+	defer _hybridKeyLock.Unlock()
+	if err := writeHybridPrivateKey(dir, HybridEncryptedPrivateKeyFile, privateKey); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(dir, HybridPrivateKeyFile))
+	_hybridKey = string(privateKey)
+	return nil
+}
+
+// StageHybridPrivateKey encrypts private key into a file in directory of
+// credentials without using it, which is used by CommitStagedHybridPrivateKey
+// later. Thus a new key would not be lost between it is accepted by server and
+// saved.
+func StageHybridPrivateKey(dir string, privateKey []byte) error {
+	return writeHybridPrivateKey(dir, HybridEncryptedPrivateKeyFile+".new", privateKey)
+}
+
+// CommitStagedHybridPrivateKey replaces private key with the staged one
+func CommitStagedHybridPrivateKey(dir string) error {
+	_hybridKeyLock.Lock()
+	defer _hybridKeyLock.Unlock()
+	stagedPath := filepath.Join(dir, HybridEncryptedPrivateKeyFile+".new")
+	if err := os.Rename(stagedPath, filepath.Join(dir, HybridEncryptedPrivateKeyFile)); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(dir, HybridPrivateKeyFile))
+	_hybridKey = ""
+	return nil
+}
+
+// DiscardStagedHybridPrivateKey removes the staged private key
+func DiscardStagedHybridPrivateKey(dir string) {
+	os.Remove(filepath.Join(dir, HybridEncryptedPrivateKeyFile+".new"))
+}
+
+// ResetHybridPrivateKey forgets private key kept in memory, e.g., after
+// unregistered
+func ResetHybridPrivateKey() {
+	_hybridKeyLock.Lock()
+	defer _hybridKeyLock.Unlock()
+	_hybridKey = ""
+}
+
+func writeHybridPrivateKey(dir string, filename string, privateKey []byte) error {
+	protector, err := defaultKeyProtector()
+	if err != nil {
+		return err
+	}
+	sealed, err := protector.Seal(privateKey)
+	if err != nil {
+		return err
+	}
+	sealed.Version = encryptedKeyFileVersion
+	content, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filename)
+	tempPath := path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// HybridAuthHeaders returns headers authenticating request of hybrid instance
+// by signature of its private key, or error if the key cannot be loaded
+func HybridAuthHeaders() (map[string]string, error) {
+	requestId := uuid.New().String()
+	timestamp := strconv.FormatInt(timetool.GetAccurateTime(), 10)
+
+	path, _ := GetHybridCredentialPath()
+	content, _ := ioutil.ReadFile(path + "/instance-id")
+	instanceId := string(content)
+	mid, _ := GetMachineID()
+
+	privateKey, err := GetHybridPrivateKey()
+	if err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to load private key of hybrid instance")
+		return nil, fmt.Errorf("load private key of hybrid instance: %w", err)
+	}
+	signature := RsaSign(instanceId+mid+timestamp+requestId, privateKey)
+	return map[string]string{
+		"x-acs-instance-id": instanceId,
+		"x-acs-timestamp":   timestamp,
+		"x-acs-request-id":  requestId,
+		"x-acs-signature":   signature,
+	}, nil
+}
+
+// passphraseProtector encrypts data by AES-GCM with key derived by scrypt from
+// passphrase in environment variable. Nothing is derived from files next to
+// the encrypted key, thus the variable must be kept set for the agent service.
+type passphraseProtector struct{}
+
+func (p *passphraseProtector) Name() string {
+	return keyProtectorPassphrase
+}
+
+func (p *passphraseProtector) passphrase() ([]byte, error) {
+	passphrase := os.Getenv(HybridKeyPassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("private key is protected by passphrase, but %s is not set in environment of agent service", HybridKeyPassphraseEnv)
+	}
+	return []byte(passphrase), nil
+}
+
+func (p *passphraseProtector) key(salt []byte) ([]byte, error) {
+	passphrase, err := p.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+}
+
+func (p *passphraseProtector) Seal(plaintext []byte) (*encryptedKey, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := p.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealWithKey(key, p.Name(), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Salt = salt
+	return sealed, nil
+}
+
+func (p *passphraseProtector) Open(sealed *encryptedKey) ([]byte, error) {
+	key, err := p.key(sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openWithKey(key, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w, is passphrase changed?", err)
+	}
+	return plaintext, nil
+}
+
+// machineIdProtector encrypts data by AES-GCM with key derived by scrypt from
+// machine id, which is the fallback when neither key storage of the OS nor
+// passphrase is available. It only keeps the key from being copied to other
+// machines, since anyone able to read the key on the machine could read
+// machine id as well.
+type machineIdProtector struct{}
+
+func (p *machineIdProtector) Name() string {
+	return keyProtectorMachineId
+}
+
+func (p *machineIdProtector) key(salt []byte) ([]byte, error) {
+	mid, err := machineIdOf()
+	if err != nil {
+		return nil, err
+	}
+	if mid == "" {
+		return nil, errors.New("machine id is unavailable for deriving key")
+	}
+	return scrypt.Key([]byte(mid), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+}
+
+func (p *machineIdProtector) Seal(plaintext []byte) (*encryptedKey, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := p.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealWithKey(key, p.Name(), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Salt = salt
+	return sealed, nil
+}
+
+func (p *machineIdProtector) Open(sealed *encryptedKey) ([]byte, error) {
+	key, err := p.key(sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openWithKey(key, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w, is machine id changed?", err)
+	}
+	return plaintext, nil
+}
+
+// sealWithKey encrypts plaintext by AES-GCM, with name of protector as
+// additional data
+func sealWithKey(key []byte, protector string, plaintext []byte) (*encryptedKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return &encryptedKey{
+		Protector:  protector,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(protector)),
+	}, nil
+}
+
+func openWithKey(key []byte, sealed *encryptedKey) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce of encrypted private key")
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(sealed.Protector))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+	return plaintext, nil
+}
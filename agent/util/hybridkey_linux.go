@@ -0,0 +1,133 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const (
+	keyProtectorTPM = "tpm"
+	tpmDataKeyLen   = 32
+	tpmToolTimeout  = 30 * time.Second
+)
+
+var (
+	tpmDevices = []string{"/dev/tpmrm0", "/dev/tpm0"}
+	tpmTools   = []string{"tpm2_createprimary", "tpm2_create", "tpm2_load", "tpm2_unseal"}
+)
+
+// platformKeyProtector returns TPM protector when TPM 2.0 and tpm2-tools are
+// available. Kernel keyring is not used since its keys do not survive reboot.
+func platformKeyProtector() keyProtector {
+	available := false
+	for _, device := range tpmDevices {
+		if CheckFileIsExist(device) {
+			available = true
+			break
+		}
+	}
+	if !available {
+		return nil
+	}
+	for _, tool := range tpmTools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil
+		}
+	}
+	return &tpmProtector{}
+}
+
+// tpmProtector encrypts data by AES-GCM with a random data key, which is
+// sealed by TPM under primary key of owner hierarchy. Only the sealed blobs
+// are stored, and the data key can only be unsealed by the same TPM.
+type tpmProtector struct{}
+
+func (p *tpmProtector) Name() string {
+	return keyProtectorTPM
+}
+
+func (p *tpmProtector) Seal(plaintext []byte) (*encryptedKey, error) {
+	key := make([]byte, tpmDataKeyLen)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	primary := filepath.Join(dir, "primary.ctx")
+	public := filepath.Join(dir, "seal.pub")
+	private := filepath.Join(dir, "seal.priv")
+	if _, err := runTPMTool(nil, "tpm2_createprimary", "-C", "o", "-c", primary); err != nil {
+		return nil, err
+	}
+	if _, err := runTPMTool(key, "tpm2_create", "-C", primary, "-i", "-", "-u", public, "-r", private); err != nil {
+		return nil, err
+	}
+	sealed, err := sealWithKey(key, p.Name(), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if sealed.SealedPublic, err = ioutil.ReadFile(public); err != nil {
+		return nil, err
+	}
+	if sealed.SealedPrivate, err = ioutil.ReadFile(private); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+func (p *tpmProtector) Open(sealed *encryptedKey) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	primary := filepath.Join(dir, "primary.ctx")
+	public := filepath.Join(dir, "seal.pub")
+	private := filepath.Join(dir, "seal.priv")
+	object := filepath.Join(dir, "seal.ctx")
+	if err := ioutil.WriteFile(public, sealed.SealedPublic, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(private, sealed.SealedPrivate, 0600); err != nil {
+		return nil, err
+	}
+	// Primary key is derived from seed of the TPM, thus created again the same
+	if _, err := runTPMTool(nil, "tpm2_createprimary", "-C", "o", "-c", primary); err != nil {
+		return nil, err
+	}
+	if _, err := runTPMTool(nil, "tpm2_load", "-C", primary, "-u", public, "-r", private, "-c", object); err != nil {
+		return nil, err
+	}
+	key, err := runTPMTool(nil, "tpm2_unseal", "-c", object)
+	if err != nil {
+		return nil, err
+	}
+	return openWithKey(key, sealed)
+}
+
+func runTPMTool(input []byte, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tpmToolTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w, %s", name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
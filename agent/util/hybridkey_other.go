@@ -0,0 +1,11 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package util
+
+// platformKeyProtector returns nil since no machine-bound key storage is
+// generally available, and private key is protected by passphrase or machine
+// id
+func platformKeyProtector() keyProtector {
+	return nil
+}
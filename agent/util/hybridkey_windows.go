@@ -0,0 +1,75 @@
+package util
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	keyProtectorDPAPI = "dpapi"
+)
+
+var (
+	dpapiEntropy = []byte("aliyun-assist-hybrid-key")
+)
+
+// dpapiProtector encrypts data by DPAPI with key of local machine, which could
+// only be decrypted on the same machine
+type dpapiProtector struct{}
+
+func platformKeyProtector() keyProtector {
+	return &dpapiProtector{}
+}
+
+func (p *dpapiProtector) Name() string {
+	return keyProtectorDPAPI
+}
+
+func newDataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{
+		Size: uint32(len(data)),
+		Data: &data[0],
+	}
+}
+
+func blobBytes(blob *windows.DataBlob) []byte {
+	if blob.Data == nil || blob.Size == 0 {
+		return nil
+	}
+	data := make([]byte, blob.Size)
+	copy(data, unsafe.Slice(blob.Data, blob.Size))
+	return data
+}
+
+func (p *dpapiProtector) Seal(plaintext []byte) (*encryptedKey, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(newDataBlob(plaintext), nil, newDataBlob(dpapiEntropy), 0, nil,
+		windows.CRYPTPROTECT_LOCAL_MACHINE|windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return &encryptedKey{
+		Protector:  p.Name(),
+		Ciphertext: blobBytes(&out),
+	}, nil
+}
+
+func (p *dpapiProtector) Open(sealed *encryptedKey) ([]byte, error) {
+	if len(sealed.Ciphertext) == 0 {
+		return nil, errors.New("empty encrypted private key")
+	}
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(newDataBlob(sealed.Ciphertext), nil, newDataBlob(dpapiEntropy), 0, nil,
+		windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return blobBytes(&out), nil
+}
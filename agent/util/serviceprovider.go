@@ -146,3 +146,9 @@ func GetDeRegisterService() string {
 	url += "/luban/api/instance/register"
 	return url
 }
+
+func GetKickResultService() string {
+	url := "https://" + GetServerHost()
+	url += "/luban/api/v1/kick/report_result"
+	return url
+}
@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	bou.ke/monkey v1.0.2
+	github.com/Microsoft/go-winio v0.4.17
 	github.com/aliyun/alibaba-cloud-sdk-go v1.61.1491
 	github.com/aliyun/aliyun-cli v3.0.25+incompatible
 	github.com/bombsimon/logrusr/v3 v3.0.0
@@ -39,6 +40,7 @@ require (
 	golang.org/x/sys v0.0.0-20220422013727-9388b58f7150
 	golang.org/x/text v0.3.7
 	gopkg.in/ini.v1 v1.66.2
+	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/cri-api v0.24.3
 	k8s.io/klog/v2 v2.60.1
 	k8s.io/kubernetes v1.24.3
@@ -46,7 +48,6 @@ require (
 )
 
 require (
-	github.com/Microsoft/go-winio v0.4.17 // indirect
 	github.com/StackExchange/wmi v0.0.0-20210224194228-fe8f1750fd46 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/docker/distribution v2.8.1+incompatible // indirect
@@ -74,7 +75,6 @@ require (
 	google.golang.org/grpc v1.40.0 // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 	k8s.io/apimachinery v0.24.3 // indirect
 	k8s.io/apiserver v0.24.3 // indirect
@@ -24,6 +24,9 @@ func main() {
 	ctx.SetCompletion(cli.ParseCompletionForShell())
 
 	rootCmd.AddSubCommand(&listContainersCmd)
+	rootCmd.AddSubCommand(&stateDryRunCmd)
+	rootCmd.AddSubCommand(&statusCmd)
+	rootCmd.AddSubCommand(&listRecordingsCmd)
 
 	rootCmd.Execute(ctx, os.Args[1:])
 }
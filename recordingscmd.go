@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rodaine/table"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/session/recording"
+	"github.com/aliyun/aliyun_assist_client/thirdparty/aliyun-cli/cli"
+	"github.com/aliyun/aliyun_assist_client/thirdparty/aliyun-cli/i18n"
+)
+
+const (
+	ChannelIdFlagName = "channel-id"
+)
+
+var (
+	listRecordingsFlags = []cli.Flag{
+		{
+			Name:         JsonFlagName,
+			Short:        i18n.T(`print recording list in JSON format`, `以JSON格式打印录像列表`),
+			AssignedMode: cli.AssignedNone,
+			Category:     "caller",
+		},
+		{
+			Name:         ChannelIdFlagName,
+			Short:        i18n.T(`only show the recording of specified session`, `仅显示指定会话的录像`),
+			AssignedMode: cli.AssignedOnce,
+			Category:     "caller",
+		},
+	}
+
+	listRecordingsCmd = cli.Command{
+		Name: "list-recordings",
+		Short: i18n.T("List session recordings in asciicast v2 format, which could be uploaded by commands for audit",
+			"列出 asciicast v2 格式的会话录像，可通过命令上传以供审计"),
+		Usage:             "list-recordings [--channel-id <channelId>] [flags]",
+		Sample:            "",
+		EnableUnknownFlag: false,
+		Run:               runListRecordingsCmd,
+	}
+)
+
+func init() {
+	for j := range listRecordingsFlags {
+		listRecordingsCmd.Flags().Add(&listRecordingsFlags[j])
+	}
+}
+
+func runListRecordingsCmd(ctx *cli.Context, args []string) error {
+	// Extract value of persistent flags
+	logPath, _ := ctx.Flags().Get(LogPathFlagName).GetValue()
+	// Extract value of flags just for the command
+	useJsonFormat := ctx.Flags().Get(JsonFlagName).IsAssigned()
+	channelId, channelIdAssigned := ctx.Flags().Get(ChannelIdFlagName).GetValue()
+
+	// Necessary initialization work
+	log.InitLog("aliyun_assist_main.log", logPath)
+
+	recordings, err := recording.List()
+	if err != nil {
+		return printErrorOrReturn(fmt.Errorf("Failed to list session recordings: %w", err), useJsonFormat)
+	}
+	if channelIdAssigned {
+		filtered := make([]recording.Recording, 0, 1)
+		for _, r := range recordings {
+			if r.ChannelId == channelId {
+				filtered = append(filtered, r)
+			}
+		}
+		recordings = filtered
+	}
+
+	if useJsonFormat {
+		jsonBytes, err := json.Marshal(recordings)
+		if err != nil {
+			return printErrorOrReturn(err, useJsonFormat)
+		}
+		fmt.Println(string(jsonBytes))
+	} else {
+		tbl := table.New("Channel Id", "Size", "Modified At", "Path")
+		for _, r := range recordings {
+			tbl.AddRow(r.ChannelId, r.Size, r.ModifiedAt.Format(time.RFC3339), r.Path)
+		}
+		tbl.Print()
+	}
+	return nil
+}
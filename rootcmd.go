@@ -14,16 +14,21 @@ import (
 	"github.com/aliyun/aliyun_assist_client/agent/checkkdump"
 	"github.com/aliyun/aliyun_assist_client/agent/checkvirt"
 	"github.com/aliyun/aliyun_assist_client/agent/clientreport"
+	"github.com/aliyun/aliyun_assist_client/agent/config"
 	"github.com/aliyun/aliyun_assist_client/agent/flagging"
 	"github.com/aliyun/aliyun_assist_client/agent/heartbeat"
 	"github.com/aliyun/aliyun_assist_client/agent/hybrid"
 	"github.com/aliyun/aliyun_assist_client/agent/install"
+	"github.com/aliyun/aliyun_assist_client/agent/kickvmhandle"
+	"github.com/aliyun/aliyun_assist_client/agent/localapi"
 	"github.com/aliyun/aliyun_assist_client/agent/log"
 	"github.com/aliyun/aliyun_assist_client/agent/metrics"
+	"github.com/aliyun/aliyun_assist_client/agent/metrics/exporter"
 	"github.com/aliyun/aliyun_assist_client/agent/perfmon"
 	"github.com/aliyun/aliyun_assist_client/agent/pluginmanager"
 	"github.com/aliyun/aliyun_assist_client/agent/statemanager"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine"
+	"github.com/aliyun/aliyun_assist_client/agent/taskengine/outbox"
 	"github.com/aliyun/aliyun_assist_client/agent/taskengine/timermanager"
 	"github.com/aliyun/aliyun_assist_client/agent/update"
 	"github.com/aliyun/aliyun_assist_client/agent/util"
@@ -47,6 +52,7 @@ type Options struct {
 	Stop           bool
 	Register       bool
 	DeRegister     bool
+	RotateKey      bool
 	Region         string
 	ActivationCode string
 	ActivationId   string
@@ -72,6 +78,7 @@ const (
 
 	RegisterFlagName = "register"
 	DeRegisterFlagName = "deregister"
+	RotateKeyFlagName = "rotate-key"
 	RegionFlagName = "RegionId"
 	ActivationCodeFlagName = "ActivationCode"
 	ActivationIdFlagName = "ActivationId"
@@ -167,6 +174,12 @@ var (
 			AssignedMode: cli.AssignedNone,
 			Category: "caller",
 		},
+		{
+			Name: RotateKeyFlagName,
+			Short: i18n.T(`register a new key pair of aliyun managed instance with server`, `为云助手托管实例向服务端注册新的密钥对`),
+			AssignedMode: cli.AssignedNone,
+			Category: "caller",
+		},
 		{
 			Name: RegionFlagName,
 			Shorthand: 'R',
@@ -251,6 +264,10 @@ func (p *program) run() {
 	}
 	G_Running = true
 	G_StopEvent = make(chan struct{})
+	// Register with enrollment file in background, which may retry for long.
+	// Looking up server host below keeps waiting until registered on non-ECS
+	// instances. Retrying is given up once service is stopping.
+	go hybrid.Enroll(G_StopEvent)
 	channel.TryStartGshellChannel()
 
 	if runtime.GOOS == "windows" {
@@ -290,6 +307,11 @@ func (p *program) run() {
 		}
 	}()
 
+	// Spool metrics events locally and replay those left by previous process
+	if err := metrics.StartEventSpool(); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to start metrics event spool")
+	}
+
 	// Check in main goroutine and update as soon as possible, which use stricter
 	// timeout limitation. NOTE: The preparation phase timeout parameter should
 	// be considered as the whole timeout toleration minus minimum sleeping time
@@ -337,8 +359,23 @@ func (p *program) run() {
 		log.GetLogger().Infoln("Start StartKdumpCheckTimer")
 	}
 
+	// Deliver task results queued before last restart of agent, and report
+	// results of invocations interrupted by the restart
+	outbox.Start()
+	wrapgo.GoWithDefaultPanicHandler(taskengine.ReconcileTaskJournal)
+	// Also report results of kick_vm actions which stopped or updated agent
+	wrapgo.GoWithDefaultPanicHandler(kickvmhandle.ReportInterruptedActions)
+
 	// Finally, fetching tasks could be allowed and agent starts to run normally.
 	taskengine.EnableFetchingTask()
+	// Local API is for diagnosing agent on the instance, and failure of it
+	// should never stop agent from running
+	if err := localapi.Start(); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to start local API server")
+	}
+	if err := exporter.Start(); err != nil {
+		log.GetLogger().WithError(err).Errorln("Failed to start metrics exporter")
+	}
 	log.GetLogger().Infoln("Started successfully")
 	// And also log to stdout, which would be written to systemd-journal as well
 	// as console via systemd
@@ -375,12 +412,33 @@ func (p *program) run() {
 
 func (p *program) Stop(s service.Service) error {
 	log.GetLogger().Println("Stopping ......")
-	// channel.StopChannelMgr()
-	// //websocket.DisconnectWebsocketServer()
-	// G_Running = false
-	// close(G_StopEvent)
-	// SingleAppLock.TryUnlock()
-	// perfmon.StopSelfKillMon()
+	// Refuse new tasks, then wait for running tasks and sessions within grace
+	// period, and cancel them afterwards with final state reported
+	gracePeriod := time.Duration(config.GetConfig().Shutdown.GracePeriodSeconds) * time.Second
+	taskengine.Shutdown(gracePeriod)
+	if depth := outbox.Depth(); depth > 0 {
+		log.GetLogger().Warningf("%d task results not delivered yet would be delivered after restart", depth)
+	}
+	// Local API keeps serving status until tasks are drained, while actions
+	// requested during shutdown have been refused
+	localapi.Stop()
+	exporter.Stop()
+
+	if timerManager := timermanager.GetTimerManager(); timerManager != nil {
+		timerManager.Stop()
+	}
+	channel.StopChannelMgr()
+	perfmon.StopSelfKillMon()
+	G_Running = false
+	if G_StopEvent != nil {
+		close(G_StopEvent)
+		G_StopEvent = nil
+	}
+	if SingleAppLock != nil {
+		if err := SingleAppLock.TryUnlock(); err != nil {
+			log.GetLogger().WithError(err).Errorln("Failed to release single instance lock")
+		}
+	}
 	log.GetLogger().Println("Stopped")
 	return nil
 }
@@ -399,6 +457,7 @@ func parseOptions(ctx *cli.Context) Options {
 
 	options.Register = ctx.Flags().Get(RegisterFlagName).IsAssigned()
 	options.DeRegister = ctx.Flags().Get(DeRegisterFlagName).IsAssigned()
+	options.RotateKey = ctx.Flags().Get(RotateKeyFlagName).IsAssigned()
 	options.Region, _ = ctx.Flags().Get(RegionFlagName).GetValue()
 	options.ActivationCode, _ = ctx.Flags().Get(ActivationCodeFlagName).GetValue()
 	options.ActivationId, _ = ctx.Flags().Get(ActivationIdFlagName).GetValue()
@@ -458,6 +517,10 @@ func runRootCommand(ctx *cli.Context, args []string) error {
 		hybrid.UnRegister(true)
 		return nil
 	}
+	if options.RotateKey {
+		hybrid.RotateKey(true)
+		return nil
+	}
 
 	if options.RunAsDaemon {
 		// TODO: Check other options like --install, --remove, --start, --stop should not be passed
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/rodaine/table"
+
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/agent/statemanager"
+	"github.com/aliyun/aliyun_assist_client/thirdparty/aliyun-cli/cli"
+	"github.com/aliyun/aliyun_assist_client/thirdparty/aliyun-cli/i18n"
+)
+
+const (
+	TemplateFlagName       = "template"
+	ParametersFlagName     = "parameters"
+	ParametersFileFlagName = "parameters-file"
+	ValidateOnlyFlagName   = "validate-only"
+)
+
+var (
+	stateDryRunFlags = []cli.Flag{
+		{
+			Name:         TemplateFlagName,
+			Shorthand:    't',
+			Short:        i18n.T(`path of the state template file`, `状态模板文件路径`),
+			AssignedMode: cli.AssignedOnce,
+			Category:     "caller",
+		},
+		{
+			Name:         ParametersFlagName,
+			Shorthand:    'p',
+			Short:        i18n.T(`parameters of the template in JSON format`, `JSON格式的模板参数`),
+			AssignedMode: cli.AssignedOnce,
+			Category:     "caller",
+		},
+		{
+			Name:         ParametersFileFlagName,
+			Short:        i18n.T(`path of the file containing parameters in JSON format`, `包含JSON格式模板参数的文件路径`),
+			AssignedMode: cli.AssignedOnce,
+			Category:     "caller",
+		},
+		{
+			Name:         ValidateOnlyFlagName,
+			Short:        i18n.T(`only validate the template and parameters, do not examine the instance`, `仅校验模板和参数，不检查实例状态`),
+			AssignedMode: cli.AssignedNone,
+			Category:     "caller",
+		},
+		{
+			Name:         JsonFlagName,
+			Short:        i18n.T(`print result in JSON format`, `以JSON格式打印结果`),
+			AssignedMode: cli.AssignedNone,
+			Category:     "caller",
+		},
+	}
+
+	stateDryRunCmd = cli.Command{
+		Name:              "state-dryrun",
+		Short:             i18n.T("Validate a state template and show what applying it would change", "校验状态模板并显示应用后将产生的变更"),
+		Usage:             "state-dryrun --template <file> [--parameters <json> | --parameters-file <file>] [flags]",
+		Sample:            "",
+		EnableUnknownFlag: false,
+		Run:               runStateDryRunCmd,
+	}
+)
+
+func init() {
+	for j := range stateDryRunFlags {
+		stateDryRunCmd.Flags().Add(&stateDryRunFlags[j])
+	}
+}
+
+func runStateDryRunCmd(ctx *cli.Context, args []string) error {
+	// Extract value of persistent flags
+	logPath, _ := ctx.Flags().Get(LogPathFlagName).GetValue()
+	// Extract value of flags just for the command
+	templatePath, _ := ctx.Flags().Get(TemplateFlagName).GetValue()
+	parameters, parametersAssigned := ctx.Flags().Get(ParametersFlagName).GetValue()
+	parametersPath, parametersFileAssigned := ctx.Flags().Get(ParametersFileFlagName).GetValue()
+	validateOnly := ctx.Flags().Get(ValidateOnlyFlagName).IsAssigned()
+	useJsonFormat := ctx.Flags().Get(JsonFlagName).IsAssigned()
+
+	if templatePath == "" {
+		return errors.New("Template file must be specified by --template")
+	}
+	if parametersAssigned && parametersFileAssigned {
+		return errors.New("--parameters and --parameters-file can not be specified at the same time")
+	}
+
+	// Necessary initialization work
+	log.InitLog("aliyun_assist_main.log", logPath)
+
+	content, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return stateDryRunError(err, useJsonFormat)
+	}
+	if parametersFileAssigned {
+		data, err := ioutil.ReadFile(parametersPath)
+		if err != nil {
+			return stateDryRunError(err, useJsonFormat)
+		}
+		parameters = string(data)
+	}
+
+	nodes, err := statemanager.ValidateTemplate(content, parameters)
+	if err != nil {
+		return stateDryRunError(err, useJsonFormat)
+	}
+	if validateOnly {
+		if useJsonFormat {
+			fmt.Println(`{"valid":true}`)
+		} else {
+			fmt.Printf("Template is valid, %d states defined\n", len(nodes))
+		}
+		return nil
+	}
+
+	results := statemanager.DryRun(nodes)
+	if useJsonFormat {
+		jsonBytes, err := json.Marshal(results)
+		if err != nil {
+			return stateDryRunError(err, useJsonFormat)
+		}
+		fmt.Println(string(jsonBytes))
+	} else {
+		printStateResultsText(results)
+	}
+
+	for _, result := range results {
+		if result.Status == statemanager.Failed {
+			return fmt.Errorf("failed to examine state %s", result.Name)
+		}
+	}
+	return nil
+}
+
+// stateDryRunError prints err like printErrorOrReturn, but always exits with
+// non-zero code so that CI pipelines can detect invalid templates
+func stateDryRunError(err error, useJsonFormat bool) error {
+	if err = printErrorOrReturn(err, useJsonFormat); err != nil {
+		return err
+	}
+	cli.Exit(1)
+	return nil
+}
+
+func printStateResultsText(results []statemanager.StateResult) {
+	tbl := table.New("Name", "Resource Type", "Status", "Changes")
+	for _, r := range results {
+		tbl.AddRow(r.Name, r.ResourceType, r.Status, r.Message)
+	}
+	tbl.Print()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rodaine/table"
+
+	"github.com/aliyun/aliyun_assist_client/agent/localapi"
+	"github.com/aliyun/aliyun_assist_client/agent/log"
+	"github.com/aliyun/aliyun_assist_client/thirdparty/aliyun-cli/cli"
+	"github.com/aliyun/aliyun_assist_client/thirdparty/aliyun-cli/i18n"
+)
+
+const (
+	TriggerFlagName = "trigger"
+)
+
+var (
+	triggerActions = []string{
+		localapi.ActionFetchTasks,
+		localapi.ActionInventory,
+		localapi.ActionRefreshState,
+	}
+
+	statusFlags = []cli.Flag{
+		{
+			Name:         JsonFlagName,
+			Short:        i18n.T(`print status in JSON format`, `以JSON格式打印状态`),
+			AssignedMode: cli.AssignedNone,
+			Category:     "caller",
+		},
+		{
+			Name: TriggerFlagName,
+			Short: i18n.T(`ask the running agent to run an action before showing status. Possibles are "fetch-tasks", "inventory" or "refresh-state"`,
+				`在显示状态前请求运行中的 agent 执行指定动作，可选的值有 "fetch-tasks"、"inventory" 或者 "refresh-state"`),
+			AssignedMode: cli.AssignedOnce,
+			Category:     "caller",
+		},
+	}
+
+	statusCmd = cli.Command{
+		Name:              "status",
+		Short:             i18n.T("Show status of the running agent via local API", "通过本地接口显示运行中 agent 的状态"),
+		Usage:             "status [--trigger <action>] [flags]",
+		Sample:            "",
+		EnableUnknownFlag: false,
+		Run:               runStatusCmd,
+	}
+)
+
+func init() {
+	for j := range statusFlags {
+		statusCmd.Flags().Add(&statusFlags[j])
+	}
+}
+
+func runStatusCmd(ctx *cli.Context, args []string) error {
+	// Extract value of persistent flags
+	logPath, _ := ctx.Flags().Get(LogPathFlagName).GetValue()
+	// Extract value of flags just for the command
+	useJsonFormat := ctx.Flags().Get(JsonFlagName).IsAssigned()
+	action, triggerAssigned := ctx.Flags().Get(TriggerFlagName).GetValue()
+	if triggerAssigned && !isTriggerAction(action) {
+		return fmt.Errorf(`Specified action is none of "%s": %s`, strings.Join(triggerActions, `", "`), action)
+	}
+
+	// Necessary initialization work
+	log.InitLog("aliyun_assist_main.log", logPath)
+
+	if triggerAssigned {
+		result, err := localapi.TriggerAction(action, nil)
+		if err != nil {
+			return printErrorOrReturn(fmt.Errorf("Failed to run action %s: %w", action, err), useJsonFormat)
+		}
+		if !useJsonFormat {
+			fmt.Printf("Action %s finished: %s\n\n", result.Action, result.Message)
+		}
+	}
+
+	status, err := localapi.GetStatus()
+	if err != nil {
+		address, _ := localapi.Address()
+		return printErrorOrReturn(fmt.Errorf("Failed to query status via %s, is agent running? %w", address, err), useJsonFormat)
+	}
+	if useJsonFormat {
+		jsonBytes, err := json.Marshal(status)
+		if err != nil {
+			return printErrorOrReturn(err, useJsonFormat)
+		}
+		fmt.Println(string(jsonBytes))
+	} else {
+		printStatusText(status)
+	}
+	return nil
+}
+
+func isTriggerAction(action string) bool {
+	for _, a := range triggerActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func printStatusText(status *localapi.Status) {
+	heartbeat := "never"
+	if !status.Heartbeat.LastPingTime.IsZero() {
+		heartbeat = formatStatusTime(status.Heartbeat.LastPingTime)
+		if status.Heartbeat.Succeeded {
+			heartbeat += " succeeded"
+		} else {
+			heartbeat += " failed: " + status.Heartbeat.Error
+		}
+	}
+	netcheck := "not available"
+	if status.Netcheck != nil {
+		netcheck = fmt.Sprintf("result %d at %s", status.Netcheck.Result, formatStatusTime(status.Netcheck.FinishedTime))
+	}
+
+	tbl := table.New("Item", "Value")
+	tbl.AddRow("Version", status.Version)
+	tbl.AddRow("Pid", status.Pid)
+	tbl.AddRow("Channel", status.ChannelType)
+	tbl.AddRow("Shutting Down", status.ShuttingDown)
+	tbl.AddRow("Last Heartbeat", heartbeat)
+	tbl.AddRow("Netcheck", netcheck)
+	tbl.AddRow("Timers", fmt.Sprintf("%d registered, %d running", status.Timers.Registered, status.Timers.Running))
+	tbl.AddRow("State Manager Timer Running", status.Timers.StateManagerRunning)
+	tbl.AddRow("Undelivered Results", status.OutboxDepth)
+	tbl.AddRow("Plugins Checked", formatStatusTime(status.Plugins.CheckedTime))
+	tbl.Print()
+
+	fmt.Printf("\nTasks (%d):\n", len(status.Tasks))
+	if len(status.Tasks) > 0 {
+		tbl = table.New("Task Id", "Command Name", "Repeat", "Canceled")
+		for _, t := range status.Tasks {
+			tbl.AddRow(t.TaskId, t.CommandName, t.Repeat, t.Canceled)
+		}
+		tbl.Print()
+	}
+
+	fmt.Printf("\nSessions (%d):\n", len(status.Sessions))
+	if len(status.Sessions) > 0 {
+		tbl = table.New("Session Id", "Task Id", "Port")
+		for _, s := range status.Sessions {
+			tbl.AddRow(s.SessionId, s.TaskId, s.PortNumber)
+		}
+		tbl.Print()
+	}
+
+	fmt.Printf("\nPlugins (%d):\n", len(status.Plugins.Plugins))
+	if len(status.Plugins.Plugins) > 0 {
+		tbl = table.New("Name", "Version", "Status")
+		for _, p := range status.Plugins.Plugins {
+			tbl.AddRow(p.Name, p.Version, p.Status)
+		}
+		tbl.Print()
+	}
+}
@@ -21,6 +21,11 @@ import (
 
 const version = "windows-service"
 
+// stopWaitHint is reported to SCM repeatedly with increasing check point while
+// stopping, so that SCM waits for Interface.Stop taking longer than its stop
+// timeout, e.g., when running tasks are waited for.
+const stopWaitHint = 10 * time.Second
+
 type windowsService struct {
 	i Interface
 	*Config
@@ -177,8 +182,7 @@ loop:
 		case svc.Interrogate:
 			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
-			changes <- svc.Status{State: svc.StopPending}
-			if err := ws.i.Stop(ws); err != nil {
+			if err := ws.stopPending(changes); err != nil {
 				ws.setError(err)
 				return true, 2
 			}
@@ -191,6 +195,27 @@ loop:
 	return false, 0
 }
 
+// stopPending calls Interface.Stop while reporting progress to SCM
+func (ws *windowsService) stopPending(changes chan<- svc.Status) error {
+	status := svc.Status{State: svc.StopPending, WaitHint: uint32(stopWaitHint / time.Millisecond)}
+	changes <- status
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- ws.i.Stop(ws)
+	}()
+	ticker := time.NewTicker(stopWaitHint / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-stopped:
+			return err
+		case <-ticker.C:
+			status.CheckPoint++
+			changes <- status
+		}
+	}
+}
+
 func (ws *windowsService) Install() error {
 	exepath, err := ws.execPath()
 	if err != nil {